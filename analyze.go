@@ -0,0 +1,285 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"flare/pkg/flare"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+func newAnalyzeCmd(root *rootFlags) *cobra.Command {
+	cf := &checkFlags{}
+	var dir, snapshot string
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Run the checks against exported manifests instead of a cluster",
+		Long: `Analyze runs the checks offline against a directory of YAML or JSON manifests,
+e.g. the output of kubectl cluster-info dump --output-directory or an extracted
+flare collect bundle, or against an archive of flare snapshot, for clusters flare
+can not reach directly. Checks that ask the API server itself, e.g. for its
+version, are skipped.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (dir == "") == (snapshot == "") {
+				return fmt.Errorf("either --from-dir or --snapshot is required")
+			}
+			return runAnalyze(root, cf, dir, snapshot)
+		},
+	}
+	cmd.Flags().StringVar(&dir, "from-dir", "", "directory of *.yaml, *.yml and *.json manifests to analyze, read recursively")
+	cmd.Flags().StringVar(&snapshot, "snapshot", "", "archive written by flare snapshot to analyze")
+	addRunFlags(cmd.Flags(), cf)
+	cmd.Flags().StringVar(&cf.failOn, "fail-on", "error", "exit with a non-zero code when a check reports this severity or worse, one of: warn, error, none")
+	cmd.Flags().StringVarP(&cf.output, "output", "o", "text", "output format, one of: text, csv, json, junit, html, markdown, sarif")
+	cmd.Flags().StringVar(&cf.fieldList, "fields", "", "comma separated list of result fields to print, in order ("+strings.Join(resultFieldNames(), ",")+")")
+	cmd.Flags().StringVar(&cf.minSeverity, "min-severity", "info", "only print results of this severity or worse, one of: info, warn, error")
+	cmd.Flags().StringVar(&cf.outputFile, "output-file", "", "write the report to this file instead of stdout, colors are stripped")
+	cmd.Flags().BoolVar(&cf.tee, "tee", false, "with --output-file, also print the report to stdout")
+	cmd.Flags().StringVar(&cf.color, "color", "auto", "color the report on stdout, one of: auto, always, never. auto colors terminals unless NO_COLOR is set")
+	return cmd
+}
+
+// Run the selected checks against the manifests of dir or of the snapshot archive and write the report
+func runAnalyze(root *rootFlags, cf *checkFlags, dir string, snapshot string) error {
+	fields, err := parseFields(cf.fieldList)
+	if err != nil {
+		return err
+	}
+	if cf.output == "csv" && len(fields) == 0 {
+		fields = defaultCSVFields
+	}
+	failThreshold, err := parseFailOn(cf.failOn)
+	if err != nil {
+		return err
+	}
+	printThreshold, err := flare.ParseSeverity(cf.minSeverity)
+	if err != nil {
+		return err
+	}
+	if cf.color != "auto" && cf.color != "always" && cf.color != "never" {
+		return fmt.Errorf("--color must be one of: auto, always, never")
+	}
+	selected, err := prepareRun(root, cf)
+	if err != nil {
+		return err
+	}
+	var l *manifestLoader
+	if snapshot != "" {
+		var manifest *snapshotManifest
+		if l, manifest, err = loadSnapshot(snapshot); err != nil {
+			return err
+		}
+		if manifest != nil {
+			log.Infof("Analyzing the snapshot of %s taken %s by flare %s", manifest.Server, manifest.Created.Format(time.RFC3339), manifest.FlareVersion)
+		}
+	} else if l, err = loadManifests(dir); err != nil {
+		return err
+	}
+	log.Infof("Loaded %d objects, skipped %d of kinds flare does not know", len(l.objects), l.unknown)
+
+	ctx, stop := signalContext()
+	defer stop()
+	resultList, err := analyze(ctx, fake.NewSimpleClientset(l.objects...), cf, selected)
+	if err != nil {
+		return err
+	}
+	if err := report(cf, fields, printThreshold, resultList); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return interrupted(resultList)
+	}
+	return exitStatus(resultList, failThreshold)
+}
+
+// Run the selected checks against a clientset that serves exported objects. There is no API
+// server to review permissions with or to ask about itself, the Live checks are reported skipped.
+func analyze(ctx context.Context, clientset kubernetes.Interface, cf *checkFlags, selected []flare.Check) ([]flare.Result, error) {
+	offline := make([]flare.Check, len(selected))
+	for i, c := range selected {
+		offline[i] = c
+		if c.Live {
+			offline[i].Run = func(context.Context, kubernetes.Interface, *flare.Options) flare.Result {
+				return flare.Result{Skipped: true, Severity: flare.SeverityInfo, Details: "Skipped, needs a live API server\n"}
+			}
+		}
+	}
+	cf.skipPreflight = true
+	return runWithTimeout(ctx, clientset, nil, cf, offline)
+}
+
+// manifestLoader collects the objects of manifest files for a fake clientset
+type manifestLoader struct {
+	objects []runtime.Object
+	// unknown counts the objects of kinds flare does not know, e.g. custom resources, left out
+	unknown int
+	// index is the position of every object in objects, by kind, namespace and name
+	index map[manifestKey]int
+}
+
+type manifestKey struct {
+	gvk             schema.GroupVersionKind
+	namespace, name string
+}
+
+// Load the objects of every *.yaml, *.yml and *.json file below dir
+func loadManifests(dir string) (*manifestLoader, error) {
+	l := &manifestLoader{index: map[manifestKey]int{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isManifest(path) {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return l.load(path, f)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed reading manifests: %w", err)
+	}
+	return l, nil
+}
+
+// Load the objects of an archive written by flare snapshot, along with its manifest. The
+// manifest is nil for archives without one, e.g. a gzipped tar of a cluster-info dump.
+func loadSnapshot(file string) (*manifestLoader, *snapshotManifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading snapshot: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading snapshot %s: %w", file, err)
+	}
+	l := &manifestLoader{index: map[manifestKey]int{}}
+	var manifest *snapshotManifest
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return l, manifest, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed reading snapshot %s: %w", file, err)
+		}
+		if header.Typeflag != tar.TypeReg || !isManifest(header.Name) {
+			continue
+		}
+		if header.Name == snapshotManifestFile {
+			data, err := io.ReadAll(archive)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed reading snapshot %s: %w", file, err)
+			}
+			manifest = &snapshotManifest{}
+			if err := yaml.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid snapshot manifest %s: %w", file, err)
+			}
+			continue
+		}
+		if err := l.load(header.Name, archive); err != nil {
+			return nil, nil, fmt.Errorf("failed reading snapshot %s: %w", file, err)
+		}
+	}
+}
+
+// Whether the file is read for manifests, by its extension
+func isManifest(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// Load the objects of a manifest file. Files may hold several documents and Lists, as written by
+// kubectl get -o yaml and kubectl cluster-info dump, documents without a kind are not objects and
+// left out. When an object is found twice the last one read wins.
+func (l *manifestLoader) load(name string, r io.Reader) error {
+	items, err := readManifests(r)
+	if err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", name, err)
+	}
+	for _, item := range items {
+		gvk := item.GroupVersionKind()
+		obj, err := scheme.Scheme.New(gvk)
+		if err != nil {
+			l.unknown++
+			continue
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, obj); err != nil {
+			return fmt.Errorf("invalid manifest %s: %s %s/%s: %w", name, gvk.Kind, item.GetNamespace(), item.GetName(), err)
+		}
+		k := manifestKey{gvk, item.GetNamespace(), item.GetName()}
+		if i, ok := l.index[k]; ok {
+			l.objects[i] = obj
+			continue
+		}
+		l.index[k] = len(l.objects)
+		l.objects = append(l.objects, obj)
+	}
+	return nil
+}
+
+// Read the objects of a manifest file, with the items of Lists in place of the List. Items
+// without a kind, as in some dumps, get the one of the List, e.g. Pod for a PodList.
+func readManifests(r io.Reader) ([]unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	var objects []unstructured.Unstructured
+	for {
+		var doc map[string]interface{}
+		if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+			return objects, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if doc["kind"] == nil {
+			continue
+		}
+		obj := unstructured.Unstructured{Object: doc}
+		if !obj.IsList() {
+			objects = append(objects, obj)
+			continue
+		}
+		itemKind := strings.TrimSuffix(obj.GetKind(), "List")
+		err := obj.EachListItem(func(item runtime.Object) error {
+			u := item.(*unstructured.Unstructured)
+			if u.GetKind() == "" && itemKind != "" {
+				u.SetKind(itemKind)
+			}
+			if u.GetAPIVersion() == "" {
+				u.SetAPIVersion(obj.GetAPIVersion())
+			}
+			objects = append(objects, *u)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
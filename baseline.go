@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"flare/pkg/flare"
+)
+
+// Save the results to file in the json output format for a later --compare-baseline
+func saveBaseline(file string, results []flare.Result) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("failed creating baseline: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	err = writeJSON(w, results)
+	if err == nil {
+		err = w.Flush()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing baseline: %w", err)
+	}
+	return nil
+}
+
+// Load a baseline written by --save-baseline or -o json
+func loadBaseline(file string) ([]jsonResult, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading baseline: %w", err)
+	}
+	var baseline []jsonResult
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", file, err)
+	}
+	return baseline, nil
+}
+
+// The non-empty lines of the details of a result, each one is a finding
+func findingLines(details string) []string {
+	var lines []string
+	for _, line := range strings.Split(details, "\n") {
+		if line = strings.TrimRight(line, " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Replace the details of every result with its changes since the baseline: findings that are
+// new are prefixed with "+", resolved ones with "-" and the unchanged ones are only counted.
+// Results are matched by cluster and check ID, checks missing from the baseline only have new findings.
+// Errors are not compared, they are reported as usual.
+func compareBaseline(results []flare.Result, baseline []jsonResult) []flare.Result {
+	previous := map[string]string{}
+	for _, b := range baseline {
+		previous[b.Cluster+"/"+b.ID] = b.Details
+	}
+	compared := make([]flare.Result, len(results))
+	for i, r := range results {
+		// Findings may repeat, so they are counted rather than kept in a set
+		current := map[string]int{}
+		for _, line := range findingLines(r.Details) {
+			current[line]++
+		}
+		matched := map[string]int{}
+		details, resolved := "", ""
+		for _, line := range findingLines(previous[r.Cluster+"/"+r.ID]) {
+			if current[line] > 0 {
+				current[line]--
+				matched[line]++
+			} else {
+				resolved += "- " + line + "\n"
+			}
+		}
+		unchanged := 0
+		for _, line := range findingLines(r.Details) {
+			if matched[line] > 0 {
+				matched[line]--
+				unchanged++
+			} else {
+				details += "+ " + line + "\n"
+			}
+		}
+		details += resolved
+		if unchanged > 0 {
+			details += fmt.Sprintf("= %d unchanged findings\n", unchanged)
+		}
+		r.Details = details
+		compared[i] = r
+	}
+	return compared
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"flare/pkg/flare"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// collectFlags holds the flags of the collect command
+type collectFlags struct {
+	outputDir  string
+	namespaces string
+	since      time.Duration
+	tailLines  int64
+	redact     []string
+}
+
+func newCollectCmd(root *rootFlags) *cobra.Command {
+	cf := &collectFlags{}
+	cmd := &cobra.Command{
+		Use:   "collect",
+		Short: "Gather logs, manifests, events and node conditions into a support bundle",
+		Long: `Collect writes a timestamped tar.gz containing the YAML and container logs
+(current and previous) of every failing pod, recent events and the conditions of
+every node, so it can be attached to a support ticket.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := newRedactor(cf.redact)
+			if err != nil {
+				return err
+			}
+			clientset, _, err := clientsetFromFlags(root)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+			now := time.Now()
+			path := filepath.Join(cf.outputDir, "flare-collect-"+now.Format("20060102-150405")+".tar.gz")
+			f, err := os.Create(path)
+			if err != nil {
+				return fmt.Errorf("failed creating bundle: %w", err)
+			}
+			err = collectBundle(context.Background(), clientset, flare.ParseNamespaces(cf.namespaces), cf.since, cf.tailLines, r, now, f)
+			if errClose := f.Close(); err == nil {
+				err = errClose
+			}
+			if err != nil {
+				return fmt.Errorf("failed writing bundle: %w", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Wrote "+path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&cf.outputDir, "output-dir", "d", ".", "directory to write the bundle to")
+	cmd.Flags().StringVarP(&cf.namespaces, "namespace", "n", "", "comma separated list of namespaces to collect from, defaults to all namespaces")
+	cmd.Flags().DurationVar(&cf.since, "since", time.Hour, "only collect events newer than this")
+	cmd.Flags().Int64Var(&cf.tailLines, "tail", 1000, "number of log lines to collect per container")
+	cmd.Flags().StringArrayVar(&cf.redact, "redact", nil, "regular expression whose matches are replaced with "+redacted+" in the bundle, on top of keys, tokens and passwords (repeatable)")
+	return cmd
+}
+
+// bundle writes files into a gzipped tar archive, redacting the secrets of their objects and logs
+type bundle struct {
+	tar    *tar.Writer
+	now    time.Time
+	redact *redactor
+}
+
+func (b *bundle) add(name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: b.now}
+	if err := b.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := b.tar.Write(data)
+	return err
+}
+
+// Add a text file, e.g. a log, with its secrets redacted
+func (b *bundle) addText(name string, text string) error {
+	return b.add(name, []byte(b.redact.text(text)))
+}
+
+// Add an object as redacted YAML, kind and apiVersion are set since typed List items do not carry them
+func (b *bundle) addYAML(name string, obj runtime.Object, kind string, apiVersion string) error {
+	obj.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(apiVersion, kind))
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	b.redact.object(content)
+	data, err := yaml.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return b.add(name, data)
+}
+
+// Write the support bundle for the namespaces (all when empty) to w. The layout is:
+//
+//	pods/<namespace>/<pod>.yaml
+//	pods/<namespace>/<pod>/<container>.log
+//	pods/<namespace>/<pod>/<container>.previous.log
+//	events.yaml
+//	nodes.txt
+func collectBundle(ctx context.Context, clientset kubernetes.Interface, namespaces []string, since time.Duration, tailLines int64, r *redactor, now time.Time, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	b := &bundle{tar: tar.NewWriter(gz), now: now, redact: r}
+	if len(namespaces) == 0 {
+		namespaces = []string{v1.NamespaceAll}
+	}
+
+	var events []corev1.Event
+	for _, ns := range namespaces {
+		page := v1.ListOptions{Limit: flare.ListPageSize}
+		for {
+			pods, err := clientset.CoreV1().Pods(ns).List(ctx, page)
+			if err != nil {
+				return fmt.Errorf("failed getting pods: %w", err)
+			}
+			for i := range pods.Items {
+				pod := &pods.Items[i]
+				if !isFailingPod(pod) {
+					continue
+				}
+				dir := "pods/" + pod.Namespace + "/" + pod.Name
+				if err := b.addYAML(dir+".yaml", pod, "Pod", "v1"); err != nil {
+					return err
+				}
+				if err := collectLogs(ctx, clientset, b, dir, pod, tailLines); err != nil {
+					return err
+				}
+			}
+			if page.Continue = pods.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		page = v1.ListOptions{Limit: flare.ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return fmt.Errorf("failed getting events: %w", err)
+			}
+			for _, event := range list.Items {
+				if flare.EventTime(event).After(now.Add(-since)) {
+					events = append(events, event)
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	eventList := &corev1.EventList{Items: events}
+	if err := b.addYAML("events.yaml", eventList, "EventList", "v1"); err != nil {
+		return err
+	}
+
+	conditions := ""
+	page := v1.ListOptions{Limit: flare.ListPageSize}
+	for {
+		nodes, err := clientset.CoreV1().Nodes().List(ctx, page)
+		if err != nil {
+			return fmt.Errorf("failed getting nodes: %w", err)
+		}
+		for _, node := range nodes.Items {
+			conditions += fmt.Sprintf("%s\n", node.Name)
+			for _, c := range node.Status.Conditions {
+				conditions += fmt.Sprintf("  %s=%s %s %s\n", c.Type, c.Status, c.Reason, c.Message)
+			}
+		}
+		if page.Continue = nodes.Continue; page.Continue == "" {
+			break
+		}
+	}
+	if err := b.addText("nodes.txt", conditions); err != nil {
+		return err
+	}
+
+	if err := b.tar.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Add the current and, for restarted containers, previous logs of every container in pod
+// Logs that can not be fetched, e.g. for a container that never started, are recorded as the error
+func collectLogs(ctx context.Context, clientset kubernetes.Interface, b *bundle, dir string, pod *corev1.Pod, tailLines int64) error {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		previous := []bool{false}
+		if status.RestartCount > 0 {
+			previous = append(previous, true)
+		}
+		for _, p := range previous {
+			logOpts := &corev1.PodLogOptions{Container: status.Name, Previous: p, TailLines: &tailLines}
+			data, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, logOpts).Do(ctx).Raw()
+			if err != nil {
+				data = []byte("failed getting logs: " + err.Error() + "\n")
+			}
+			name := dir + "/" + status.Name + ".log"
+			if p {
+				name = dir + "/" + status.Name + ".previous.log"
+			}
+			if err := b.addText(name, string(data)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A pod is failing when it is not running or succeeded, or any of its containers restarted or is not ready
+func isFailingPod(pod *corev1.Pod) bool {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return false
+	case corev1.PodRunning:
+	default:
+		return true
+	}
+	for _, c := range pod.Status.ContainerStatuses {
+		if !c.Ready || c.RestartCount > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"flare/pkg/flare"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/homedir"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// rootFlags holds the flags shared by every subcommand
+type rootFlags struct {
+	config string
+	// kube are the standard kubectl flags selecting the cluster and the credentials, e.g. --kubeconfig and --context
+	kube      *genericclioptions.ConfigFlags
+	inCluster bool
+	rules     []string
+	// qps and burst set the client rate limit of the rest.Config
+	qps   float32
+	burst int
+	// retryAttempts and retryBackoff set the flare.RetryPolicy of the rest.Config
+	retryAttempts int
+	retryBackoff  time.Duration
+	// ignore are the ignore rules of the config file
+	ignore []flare.IgnoreRule
+	// profiles are the custom profiles of the config file, by name
+	profiles map[string][]string
+}
+
+// checkFlags holds the flags of the check command
+type checkFlags struct {
+	output       string
+	fieldList    string
+	only         string
+	profile      string
+	skip         string
+	namespaces   string
+	concurrency  int
+	timeout      time.Duration
+	checkTimeout time.Duration
+	failOn       string
+	minSeverity  string
+	outputFile   string
+	tee          bool
+	color        string
+	stream       bool
+	metricsAddr  string
+	watch        bool
+	interval     time.Duration
+
+	certExpiryWindow   time.Duration
+	criticalNamespaces string
+	restartWindow      time.Duration
+	security           bool
+	quotaThreshold     int
+	cronJobMissed      int
+	finishedPods       int
+	imageRegistries    string
+	imageNamespaces    string
+	podSecurityExempt  string
+	podSecurityLevel   string
+	terminatingTimeout time.Duration
+	clockSkew          time.Duration
+	nodeFlaps          int
+	hpaFlaps           int
+	churnWindow        time.Duration
+	eventRate          int
+	podChurn           int
+
+	overcommitCPUThreshold    int
+	overcommitMemoryThreshold int
+	utilizationThreshold      int
+
+	activeProbes   bool
+	probeImage     string
+	probeNamespace string
+
+	fix       bool
+	backupDir string
+
+	allContexts bool
+
+	recordEvents    bool
+	statusConfigMap string
+
+	saveBaseline    string
+	compareBaseline string
+	baseline        []jsonResult
+
+	notifyURL      string
+	notifyFormat   string
+	notifyTemplate string
+
+	eventsSince  time.Duration
+	eventIgnore  []string
+	eventFilters []*regexp.Regexp
+
+	ignoreFile  string
+	ignoreRules []flare.IgnoreRule
+
+	redact   []string
+	redactor *redactor
+
+	// skipPreflight runs the checks without reviewing their permissions, for clientsets that are not a cluster
+	skipPreflight bool
+
+	// onResult gets the results of a run as they come in with --stream, see flare.Runner.OnResult
+	onResult func(flare.Result)
+}
+
+// Ignore file loaded from the working directory when --ignore-file is not set
+const defaultIgnoreFile = ".flareignore"
+
+// Build the flare command tree. Running flare without a subcommand is the same as `flare check`.
+func newRootCmd() *cobra.Command {
+	root := &rootFlags{}
+	checkCmd, cf := newCheckCmd(root)
+	cmd := &cobra.Command{
+		Use:           "flare",
+		Short:         "Tool to help debug kubernetes deployments",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			file, explicit := root.config, root.config != ""
+			if home := homedir.HomeDir(); !explicit && home != "" {
+				file = filepath.Join(home, defaultConfigFile)
+			}
+			if file != "" {
+				settings, err := loadConfig(cmd, file, explicit)
+				if err != nil {
+					return err
+				}
+				root.ignore, root.profiles = settings.ignore, settings.profiles
+			}
+			rules, err := flare.LoadRules(root.rules)
+			if err != nil {
+				return err
+			}
+			return flare.Register(rules...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCheckCommand(root, cf)
+		},
+	}
+	// Errors are printed by main rather than by cobra so exitError stays quiet
+	cmd.SetFlagErrorFunc(func(c *cobra.Command, err error) error {
+		return fmt.Errorf("%w\nRun '%s --help' for usage", err, c.CommandPath())
+	})
+	cmd.PersistentFlags().StringVar(&root.config, "config", "", "YAML file of flag defaults, flags given on the command line override it (default ~/"+defaultConfigFile+" when it exists)")
+	// The kubectl flags, so flare behaves the same as a kubectl plugin. -n is flare's own, it takes a list
+	// of namespaces, and flare does not cache discovery.
+	root.kube = genericclioptions.NewConfigFlags(false)
+	root.kube.Namespace, root.kube.CacheDir = nil, nil
+	root.kube.AddFlags(cmd.PersistentFlags())
+	cmd.PersistentFlags().BoolVar(&root.inCluster, "in-cluster", false, "authenticate with the service account of the Pod flare is running in")
+	cmd.PersistentFlags().StringSliceVar(&root.rules, "rules", nil, "rules file, or directory of *.yaml rules files, defining extra checks (repeatable)")
+	cmd.PersistentFlags().Float32Var(&root.qps, "qps", 50, "maximum requests per second to the API server, -1 for no client side limit")
+	cmd.PersistentFlags().IntVar(&root.burst, "burst", 100, "maximum burst of requests to the API server above --qps")
+	cmd.PersistentFlags().IntVar(&root.retryAttempts, "retry-attempts", 3, "number of tries of API reads failing with a transient error, e.g. a timeout or a 503, 1 to disable retries")
+	cmd.PersistentFlags().DurationVar(&root.retryBackoff, "retry-backoff", 500*time.Millisecond, "wait before the first retry of an API read, doubled before every further retry")
+	addCheckFlags(cmd.Flags(), cf)
+
+	cmd.AddCommand(checkCmd, newListCmd(), newVersionCmd(), newCollectCmd(root), newTriageCmd(root), newOperatorCmd(root), newServeCmd(root), newAnalyzeCmd(root), newSnapshotCmd(root))
+	return cmd
+}
+
+// Register the check command flags on fs, they are shared by `flare` and `flare check`
+func addCheckFlags(fs *pflag.FlagSet, cf *checkFlags) {
+	addRunFlags(fs, cf)
+	fs.StringVar(&cf.failOn, "fail-on", "error", "exit with a non-zero code when a check reports this severity or worse, one of: warn, error, none")
+	fs.StringVarP(&cf.output, "output", "o", "text", "output format, one of: text, csv, json, junit, html, markdown, sarif")
+	fs.StringVar(&cf.fieldList, "fields", "", "comma separated list of result fields to print, in order ("+strings.Join(resultFieldNames(), ",")+")")
+	fs.StringVar(&cf.minSeverity, "min-severity", "info", "only print results of this severity or worse, one of: info, warn, error")
+	fs.StringVar(&cf.outputFile, "output-file", "", "write the report to this file instead of stdout, colors are stripped")
+	fs.BoolVar(&cf.tee, "tee", false, "with --output-file, also print the report to stdout")
+	fs.BoolVar(&cf.stream, "stream", false, "print every result of the text report as soon as its check and the checks before it finished")
+	fs.StringVar(&cf.color, "color", "auto", "color the report on stdout, one of: auto, always, never. auto colors terminals unless NO_COLOR is set")
+	fs.BoolVar(&cf.fix, "fix", false, "after the report, offer the fixes the checks found and apply the confirmed ones")
+	fs.StringVar(&cf.backupDir, "backup-dir", "", "directory the original manifests are saved to before --fix changes them (default flare-backup-<timestamp>)")
+	fs.StringVar(&cf.saveBaseline, "save-baseline", "", "save the results to this json file for a later --compare-baseline")
+	fs.StringVar(&cf.compareBaseline, "compare-baseline", "", "only report the findings that are new or resolved since the results saved with --save-baseline")
+	fs.StringVar(&cf.notifyURL, "notify-url", "", "post a summary to this webhook when a check reaches --fail-on, e.g. a Slack incoming webhook")
+	fs.StringVar(&cf.notifyFormat, "notify-format", "webhook", "payload posted to --notify-url, one of: webhook, slack")
+	fs.StringVar(&cf.notifyTemplate, "notify-template", "", "file with a Go text/template for the notification message, see the README for its fields")
+	fs.BoolVar(&cf.recordEvents, "record-events", false, "record an Event on the object of every finding of a failed or warning check, shown by kubectl describe")
+	fs.StringVar(&cf.statusConfigMap, "status-configmap", "", "<namespace>/<name> of a ConfigMap the latest report is written to for other tooling, created if needed")
+	fs.BoolVar(&cf.allContexts, "all-contexts", false, "run the checks against every context of the kubeconfig, one after the other")
+	fs.StringVar(&cf.metricsAddr, "serve-metrics", "", "run the checks every --interval and serve Prometheus metrics on this address, e.g. :9090")
+	fs.BoolVar(&cf.watch, "watch", false, "re-run the checks every --interval, redrawing the report and highlighting checks whose status changed")
+	fs.DurationVar(&cf.interval, "interval", 5*time.Minute, "time between runs with --serve-metrics or --watch")
+}
+
+// Register the flags that select the checks and set their Options, shared by every command running checks
+func addRunFlags(fs *pflag.FlagSet, cf *checkFlags) {
+	fs.StringVar(&cf.only, "checks", "", "comma separated list of checks to run, defaults to all, see 'flare list'")
+	fs.StringVar(&cf.profile, "profile", "", "run the checks of a profile, one of: "+strings.Join(flare.ProfileNames(), ", ")+", or a profile of the config file")
+	fs.StringVar(&cf.skip, "skip", "", "comma separated list of checks to skip")
+	fs.StringVarP(&cf.namespaces, "namespace", "n", "", "comma separated list of namespaces to limit namespaced checks to, defaults to all namespaces")
+	fs.IntVar(&cf.concurrency, "concurrency", 4, "number of checks to run in parallel")
+	fs.DurationVar(&cf.timeout, "timeout", 0, "maximum duration of the whole run, 0 for no limit")
+	fs.DurationVar(&cf.checkTimeout, "check-timeout", 30*time.Second, "maximum duration of a single check, 0 for no limit")
+	fs.DurationVar(&cf.certExpiryWindow, "cert-expiry-window", 30*24*time.Hour, "warn about certificates that expire within this duration")
+	fs.StringVar(&cf.criticalNamespaces, "critical-namespaces", "kube-system", "comma separated list of namespaces whose pods must be healthy and workloads stay available, e.g. kube-system,ingress-nginx,monitoring")
+	fs.DurationVar(&cf.restartWindow, "restart-window", time.Hour, "report container restarts in the critical namespaces that happened within this duration")
+	fs.BoolVar(&cf.security, "security", false, "also report findings that only matter for hardened clusters, e.g. namespaces without NetworkPolicies")
+	fs.IntVar(&cf.overcommitCPUThreshold, "overcommit-cpu-threshold", 100, "percentage of a node's allocatable CPU its pods may request or limit before the overcommit check reports it")
+	fs.IntVar(&cf.overcommitMemoryThreshold, "overcommit-memory-threshold", 100, "percentage of a node's allocatable memory its pods may request or limit before the overcommit check reports it")
+	fs.IntVar(&cf.utilizationThreshold, "utilization-threshold", 90, "percentage of a node's allocatable CPU or memory in actual use, as reported by metrics-server, before the utilization check reports it")
+	fs.DurationVar(&cf.terminatingTimeout, "terminating-timeout", 10*time.Minute, "report namespaces, pods and objects held by finalizers that are Terminating for longer than this duration")
+	fs.DurationVar(&cf.clockSkew, "clock-skew-threshold", 30*time.Second, "report nodes whose clock is off by more than this duration")
+	fs.IntVar(&cf.nodeFlaps, "node-flap-threshold", 3, "report nodes that changed between Ready and NotReady this many times within --events-since")
+	fs.IntVar(&cf.hpaFlaps, "hpa-flap-threshold", 3, "report HPAs that scaled up and down again this many times within --events-since")
+	fs.DurationVar(&cf.churnWindow, "churn-window", 10*time.Minute, "duration the churn check measures the rates of events and pod creations and deletions over")
+	fs.IntVar(&cf.eventRate, "event-rate-threshold", 1000, "report namespaces recording this many events per minute over --churn-window")
+	fs.IntVar(&cf.podChurn, "pod-churn-threshold", 10, "report controllers creating and deleting this many pods per minute over --churn-window")
+	fs.BoolVar(&cf.activeProbes, "active-probes", false, "also run the checks that create short lived pods in the cluster, e.g. dns-probe")
+	fs.StringVar(&cf.probeImage, "probe-image", "busybox:1.35", "image of the pods created by --active-probes")
+	fs.StringVar(&cf.probeNamespace, "probe-namespace", "default", "namespace of the pods created by --active-probes")
+	fs.IntVar(&cf.cronJobMissed, "cronjob-missed-schedules", 3, "report CronJobs that missed this many schedules in a row")
+	fs.IntVar(&cf.quotaThreshold, "quota-threshold", 90, "warn about ResourceQuotas whose usage reached this percentage of the hard limit")
+	fs.IntVar(&cf.finishedPods, "finished-pods-threshold", 50, "warn about namespaces with at least this many Failed or Succeeded pods left behind")
+	fs.StringVar(&cf.imageRegistries, "images-allow-registries", "", "comma separated list of registries whose images the images check does not report, e.g. registry.internal:5000")
+	fs.StringVar(&cf.imageNamespaces, "images-allow-namespaces", "", "comma separated list of namespaces whose pods the images check does not report")
+	fs.StringVar(&cf.podSecurityExempt, "pod-security-exempt", "kube-system", "comma separated list of namespaces whose pods the pod-security check does not report, empty to report all namespaces")
+	fs.StringVar(&cf.podSecurityLevel, "pod-security-level", "", "Pod Security Standards level namespaces are expected to enforce, one of: privileged, baseline, restricted")
+	fs.DurationVar(&cf.eventsSince, "events-since", time.Hour, "only report warning events seen within this duration, 0 for all events")
+	fs.StringArrayVar(&cf.eventIgnore, "events-ignore", nil, "regular expression for warning events to ignore, matched against \"<namespace> <Kind>/<name> <reason>: <message>\" (repeatable)")
+	fs.StringArrayVar(&cf.redact, "redact", nil, "regular expression whose matches are replaced with "+redacted+" in the report, on top of keys, tokens and passwords (repeatable)")
+	fs.StringVar(&cf.ignoreFile, "ignore-file", "", "file of \"<check> <object>\" glob pairs whose findings are suppressed (default "+defaultIgnoreFile+" when it exists)")
+}
+
+func newCheckCmd(root *rootFlags) (*cobra.Command, *checkFlags) {
+	cf := &checkFlags{}
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Run the checks against the cluster and print a report",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCheckCommand(root, cf)
+		},
+	}
+	addCheckFlags(cmd.Flags(), cf)
+	return cmd, cf
+}
+
+func newListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the available checks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tCATEGORY\tSEVERITY\tDESCRIPTION\tPERMISSIONS")
+			for _, c := range flare.Checks() {
+				permissions := make([]string, len(c.Permissions))
+				for i, p := range c.Permissions {
+					permissions[i] = p.String()
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.ID, c.Category, c.Severity, c.Description, strings.Join(permissions, ", "))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newVersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the flare version",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Fprintf(cmd.OutOrStdout(), "flare %s %s/%s %s\n", version, runtime.GOOS, runtime.GOARCH, runtime.Version())
+			return nil
+		},
+	}
+}
+
+// Setup auth the way the root flags ask for
+// Returns the client config along with the clientset for checks that inspect the connection itself
+func clientsetFromFlags(root *rootFlags) (*kubernetes.Clientset, *rest.Config, error) {
+	return clientsetForContext(root, "")
+}
+
+// Setup auth for the named kubeconfig context, "" for the one of the flags, by default the current context
+func clientsetForContext(root *rootFlags, kubeContext string) (*kubernetes.Clientset, *rest.Config, error) {
+	var config *rest.Config
+	var err error
+	if root.inCluster {
+		if kubeContext != "" || *root.kube.Context != "" {
+			return nil, nil, fmt.Errorf("--context can not be used with --in-cluster")
+		}
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = restConfig(root.kube, kubeContext)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := impersonate(config, root.kube); err != nil {
+		return nil, nil, err
+	}
+	config.QPS, config.Burst = root.qps, root.burst
+	config = flare.RetryPolicy{Attempts: root.retryAttempts, Backoff: root.retryBackoff}.Instrument(config)
+	clientset, err := newClientset(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientset, config, nil
+}
+
+// Impersonate the user of --as, with the groups of --as-group and the UID of --as-uid, whichever
+// way flare authenticated, so the checks and their permission preflight see what that user sees
+func impersonate(config *rest.Config, flags *genericclioptions.ConfigFlags) error {
+	user := *flags.Impersonate
+	groups := *flags.ImpersonateGroup
+	uid := *flags.ImpersonateUID
+	if user == "" {
+		if len(groups) > 0 || uid != "" {
+			return fmt.Errorf("--as-group and --as-uid can only be used with --as")
+		}
+		return nil
+	}
+	config.Impersonate = rest.ImpersonationConfig{UserName: user, Groups: groups, UID: uid}
+	return nil
+}
+
+// Run the selected checks and write the report, see checkFlags for the options
+func runCheckCommand(root *rootFlags, cf *checkFlags) error {
+	fields, err := parseFields(cf.fieldList)
+	if err != nil {
+		return err
+	}
+	if cf.output == "csv" && len(fields) == 0 {
+		fields = defaultCSVFields
+	}
+	failThreshold, err := parseFailOn(cf.failOn)
+	if err != nil {
+		return err
+	}
+	printThreshold, err := flare.ParseSeverity(cf.minSeverity)
+	if err != nil {
+		return err
+	}
+	if cf.color != "auto" && cf.color != "always" && cf.color != "never" {
+		return fmt.Errorf("--color must be one of: auto, always, never")
+	}
+	selected, err := prepareRun(root, cf)
+	if err != nil {
+		return err
+	}
+
+	if cf.allContexts && (*root.kube.Context != "" || root.inCluster || cf.metricsAddr != "" || cf.fix || cf.recordEvents || cf.statusConfigMap != "") {
+		return fmt.Errorf("--all-contexts can not be used with --context, --in-cluster, --serve-metrics, --fix, --record-events or --status-configmap")
+	}
+	configMapNamespace, configMapName, err := parseStatusConfigMap(cf.statusConfigMap)
+	if err != nil {
+		return err
+	}
+	if cf.watch && (cf.metricsAddr != "" || cf.fix || cf.outputFile != "" || cf.output != "text" || len(fields) > 0) {
+		return fmt.Errorf("--watch only prints the text report and can not be used with --serve-metrics, --fix, --output-file, --output or --fields")
+	}
+	if cf.stream && (cf.output != "text" || cf.outputFile != "" || cf.watch || cf.metricsAddr != "" || cf.allContexts || cf.compareBaseline != "") {
+		return fmt.Errorf("--stream only prints the text report to stdout and can not be used with --output, --output-file, --watch, --serve-metrics, --all-contexts or --compare-baseline")
+	}
+	if (cf.saveBaseline != "" || cf.compareBaseline != "") && (cf.watch || cf.metricsAddr != "") {
+		return fmt.Errorf("--save-baseline and --compare-baseline can not be used with --watch or --serve-metrics")
+	}
+	cf.baseline = nil
+	if cf.compareBaseline != "" {
+		if cf.baseline, err = loadBaseline(cf.compareBaseline); err != nil {
+			return err
+		}
+	}
+	var notifyTmpl *template.Template
+	if cf.notifyURL != "" {
+		if cf.watch || cf.metricsAddr != "" {
+			return fmt.Errorf("--notify-url can not be used with --watch or --serve-metrics")
+		}
+		if cf.notifyFormat != "webhook" && cf.notifyFormat != "slack" {
+			return fmt.Errorf("--notify-format must be one of: webhook, slack")
+		}
+		if notifyTmpl, err = loadNotifyTemplate(cf.notifyTemplate); err != nil {
+			return err
+		}
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	if cf.allContexts && cf.watch {
+		return watchChecks(ctx, os.Stdout, cf.interval, useColor(cf.color, os.Stdout), printThreshold, func() ([]flare.Result, error) {
+			return runAllContexts(ctx, root, cf, selected)
+		})
+	}
+	if cf.allContexts {
+		resultList, err := runAllContexts(ctx, root, cf, selected)
+		if err != nil {
+			return err
+		}
+		if err := report(cf, fields, printThreshold, resultList); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return interrupted(resultList)
+		}
+		status := exitStatus(resultList, failThreshold)
+		if status != nil && notifyTmpl != nil {
+			if err := notify(context.Background(), cf.notifyURL, cf.notifyFormat, notifyTmpl, resultList); err != nil {
+				return err
+			}
+		}
+		return status
+	}
+
+	// Setup auth for cluster
+	clientset, config, err := clientsetFromFlags(root)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+
+	// Record the findings of every run in the cluster with --record-events and --status-configmap
+	var pub *publisher
+	if cf.recordEvents || configMapName != "" {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+		pub = &publisher{clientset: clientset, dynamic: dynamicClient, events: cf.recordEvents, configMapNamespace: configMapNamespace, configMapName: configMapName}
+	}
+
+	// Run tests and collect the results
+	run := func() ([]flare.Result, error) {
+		results, err := runWithTimeout(ctx, clientset, config, cf, selected)
+		if err == nil && pub != nil && ctx.Err() == nil {
+			pub.publish(ctx, results, time.Now())
+		}
+		return results, err
+	}
+	if cf.watch {
+		return watchChecks(ctx, os.Stdout, cf.interval, useColor(cf.color, os.Stdout), printThreshold, run)
+	}
+	if cf.metricsAddr != "" {
+		if err := serveMetrics(ctx, cf.metricsAddr, cf.interval, run); err != nil {
+			return fmt.Errorf("metrics server failed: %w", err)
+		}
+		return nil
+	}
+	var streamErr error
+	if cf.stream {
+		out, color := bufio.NewWriter(os.Stdout), useColor(cf.color, os.Stdout)
+		cf.onResult = func(r flare.Result) {
+			if r.Severity >= printThreshold && streamErr == nil {
+				streamErr = writeResults(out, cf.output, fields, color, []flare.Result{r})
+			}
+		}
+	}
+	resultList, err := run()
+	if err != nil {
+		return err
+	}
+	if streamErr != nil {
+		return fmt.Errorf("failed writing report: %w", streamErr)
+	}
+	if err := report(cf, fields, printThreshold, resultList); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return interrupted(resultList)
+	}
+	if cf.fix {
+		backupDir := cf.backupDir
+		if backupDir == "" {
+			backupDir = "flare-backup-" + time.Now().Format("20060102-150405")
+		}
+		if err := applyFixes(context.Background(), clientset, resultList, backupDir, os.Stdin, os.Stdout); err != nil {
+			return err
+		}
+	}
+	status := exitStatus(resultList, failThreshold)
+	if status != nil && notifyTmpl != nil {
+		if err := notify(context.Background(), cf.notifyURL, cf.notifyFormat, notifyTmpl, resultList); err != nil {
+			return err
+		}
+	}
+	return status
+}
+
+// The names of the built-in profiles and the profiles of the config file, sorted
+func profileNames(root *rootFlags) []string {
+	names := flare.ProfileNames()
+	for name := range root.profiles {
+		if _, ok := flare.Profile(name); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse --fail-on, with "none" no severity reaches the returned threshold
+func parseFailOn(failOn string) (flare.Severity, error) {
+	if failOn == "none" {
+		return flare.SeverityFail + 1, nil
+	}
+	threshold, err := flare.ParseSeverity(failOn)
+	if err != nil || threshold == flare.SeverityInfo {
+		return 0, fmt.Errorf("--fail-on must be one of: warn, error, none")
+	}
+	return threshold, nil
+}
+
+// Select the checks to run and load the --events-ignore patterns and the ignore file, see addRunFlags.
+// The ignore rules of the config file are added to the ones of the ignore file.
+func prepareRun(root *rootFlags, cf *checkFlags) ([]flare.Check, error) {
+	only := cf.only
+	if cf.profile != "" {
+		if cf.only != "" {
+			return nil, fmt.Errorf("--profile can not be used with --checks")
+		}
+		ids, ok := root.profiles[cf.profile]
+		if !ok {
+			if ids, ok = flare.Profile(cf.profile); !ok {
+				return nil, fmt.Errorf("unknown profile %q, valid profiles are: %s", cf.profile, strings.Join(profileNames(root), ", "))
+			}
+		}
+		// The active checks of a profile are left out by the runner unless --active-probes is set
+		only = strings.Join(ids, ",")
+	}
+	selected, err := flare.SelectChecks(only, cf.skip)
+	if err != nil {
+		if cf.profile != "" {
+			return nil, fmt.Errorf("profile %s: %w", cf.profile, err)
+		}
+		return nil, err
+	}
+	if !cf.activeProbes && cf.only != "" {
+		for _, c := range selected {
+			if c.Active {
+				return nil, fmt.Errorf("check %s creates pods in the cluster and needs --active-probes", c.ID)
+			}
+		}
+	}
+	switch cf.podSecurityLevel {
+	case "", "privileged", "baseline", "restricted":
+	default:
+		return nil, fmt.Errorf("--pod-security-level must be one of: privileged, baseline, restricted")
+	}
+	if cf.redactor, err = newRedactor(cf.redact); err != nil {
+		return nil, err
+	}
+	cf.eventFilters = nil
+	for _, pattern := range cf.eventIgnore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --events-ignore pattern: %w", err)
+		}
+		cf.eventFilters = append(cf.eventFilters, re)
+	}
+	cf.ignoreRules = append([]flare.IgnoreRule(nil), root.ignore...)
+	ignoreFile := cf.ignoreFile
+	if ignoreFile == "" {
+		if _, err := os.Stat(defaultIgnoreFile); err == nil {
+			ignoreFile = defaultIgnoreFile
+		}
+	}
+	if ignoreFile != "" {
+		rules, err := flare.LoadIgnoreFile(ignoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ignore-file: %w", err)
+		}
+		cf.ignoreRules = append(cf.ignoreRules, rules...)
+	}
+	return selected, nil
+}
+
+// Run the selected checks once against the cluster of clientset, honouring --timeout.
+// config is nil when clientset is not connected to a cluster, e.g. for flare analyze.
+func runWithTimeout(ctx context.Context, clientset kubernetes.Interface, config *rest.Config, cf *checkFlags, selected []flare.Check) ([]flare.Result, error) {
+	opts := &flare.Options{
+		Namespaces:         flare.ParseNamespaces(cf.namespaces),
+		CertExpiryWindow:   cf.certExpiryWindow,
+		CriticalNamespaces: flare.ParseNamespaces(cf.criticalNamespaces),
+		RestartWindow:      cf.restartWindow,
+		Security:           cf.security,
+		QuotaThreshold:     cf.quotaThreshold,
+		TerminatingTimeout: cf.terminatingTimeout,
+		ClockSkewThreshold: cf.clockSkew,
+		NodeFlapThreshold:  cf.nodeFlaps,
+		HPAFlapThreshold:   cf.hpaFlaps,
+		ChurnWindow:        cf.churnWindow,
+		EventRateThreshold: cf.eventRate,
+		PodChurnThreshold:  cf.podChurn,
+
+		OvercommitCPUThreshold:    cf.overcommitCPUThreshold,
+		OvercommitMemoryThreshold: cf.overcommitMemoryThreshold,
+		UtilizationThreshold:      cf.utilizationThreshold,
+		CronJobMissedSchedules:    cf.cronJobMissed,
+		FinishedPodThreshold:      cf.finishedPods,
+		ImageAllowRegistries:      flare.ParseNamespaces(cf.imageRegistries),
+		ImageAllowNamespaces:      flare.ParseNamespaces(cf.imageNamespaces),
+		PodSecurityLevel:          cf.podSecurityLevel,
+		PodSecurityExempt:         append([]string{}, flare.ParseNamespaces(cf.podSecurityExempt)...), // never nil, "" exempts no namespace
+
+		ActiveProbes:   cf.activeProbes,
+		ProbeImage:     cf.probeImage,
+		ProbeNamespace: cf.probeNamespace,
+		ClusterCA:      clusterCA(config),
+		EventsSince:    cf.eventsSince,
+		EventIgnore:    cf.eventFilters,
+		Ignore:         cf.ignoreRules,
+	}
+	var dynamicClient dynamic.Interface
+	if config != nil {
+		// A clientset per run, so the api-throttling check only sees the requests of this run
+		opts.Throttling = &flare.Throttling{}
+		config = opts.Throttling.Instrument(config)
+		instrumented, err := newClientset(config)
+		if err != nil {
+			return nil, err
+		}
+		clientset = instrumented
+		if dynamicClient, err = dynamic.NewForConfig(config); err != nil {
+			return nil, err
+		}
+	}
+	if cf.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cf.timeout)
+		defer cancel()
+	}
+	// Every output of the results is redacted, including the streamed ones
+	var onResult func(flare.Result)
+	if cf.onResult != nil {
+		onResult = func(r flare.Result) { cf.onResult(cf.redactor.result(r)) }
+	}
+	runner := &flare.Runner{
+		Clientset:     clientset,
+		Dynamic:       dynamicClient,
+		Checks:        selected,
+		Concurrency:   cf.concurrency,
+		CheckTimeout:  cf.checkTimeout,
+		SkipPreflight: cf.skipPreflight,
+		OnResult:      onResult,
+	}
+	results, err := runner.Run(ctx, opts)
+	for i := range results {
+		results[i] = cf.redactor.result(results[i])
+	}
+	return results, err
+}
+
+// Run the selected checks against every context of the kubeconfig in turn, in context name order.
+// A context flare can not authenticate to is reported as a failed result instead of stopping the run.
+func runAllContexts(ctx context.Context, root *rootFlags, cf *checkFlags, selected []flare.Check) ([]flare.Result, error) {
+	kubeconfig, err := root.kube.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed loading kubeconfig: %w", err)
+	}
+	names := make([]string, 0, len(kubeconfig.Contexts))
+	for name := range kubeconfig.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var resultList []flare.Result
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		cluster := kubeconfig.Contexts[name].Cluster
+		if cluster == "" {
+			cluster = name
+		}
+		clientset, config, err := clientsetForContext(root, name)
+		if err != nil {
+			resultList = append(resultList, flare.Result{ID: "auth", Name: "Authentication", Cluster: cluster, Severity: flare.SeverityFail, Err: err})
+			continue
+		}
+		results, err := runWithTimeout(ctx, clientset, config, cf, selected)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range results {
+			r.Cluster = cluster
+			resultList = append(resultList, r)
+		}
+	}
+	return resultList, nil
+}
+
+// A context cancelled by the first SIGINT or SIGTERM, which cancels the API calls in flight so
+// the report of the finished checks can still be written. A second signal exits right away.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// The exitError of a run stopped by a signal, once its partial report is written
+func interrupted(resultList []flare.Result) error {
+	unfinished := 0
+	for _, r := range resultList {
+		if errors.Is(r.Err, flare.ErrInterrupted) {
+			unfinished++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Interrupted, %d of %d checks did not finish and the report is partial\n", unfinished, len(resultList))
+	return &exitError{code: exitInterrupted}
+}
+
+// Write the results at or above printThreshold to --output-file and/or stdout
+// With --compare-baseline only the changes since the baseline are written.
+func report(cf *checkFlags, fields []string, printThreshold flare.Severity, resultList []flare.Result) error {
+	if cf.saveBaseline != "" {
+		if err := saveBaseline(cf.saveBaseline, resultList); err != nil {
+			return err
+		}
+	}
+	if cf.baseline != nil {
+		resultList = compareBaseline(resultList, cf.baseline)
+	}
+	if cf.stream {
+		// The results were printed as they came in, only the summary is left
+		if len(fields) == 0 {
+			if err := writeSummary(bufio.NewWriter(os.Stdout), resultList); err != nil {
+				return fmt.Errorf("failed writing report: %w", err)
+			}
+		}
+		return nil
+	}
+	printed := filterResults(resultList, printThreshold)
+	// The summary counts every result, including the ones --min-severity leaves out
+	write := func(buffer *bufio.Writer, color bool) error {
+		if err := writeResults(buffer, cf.output, fields, color, printed); err != nil {
+			return err
+		}
+		if cf.output == "text" && len(fields) == 0 {
+			return writeSummary(buffer, resultList)
+		}
+		return nil
+	}
+	if cf.outputFile != "" {
+		f, err := os.Create(cf.outputFile)
+		if err != nil {
+			return fmt.Errorf("failed creating report file: %w", err)
+		}
+		err = write(bufio.NewWriter(f), false)
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			return fmt.Errorf("failed writing report: %w", err)
+		}
+	}
+	if cf.outputFile == "" || cf.tee {
+		if err := write(bufio.NewWriter(os.Stdout), useColor(cf.color, os.Stdout)); err != nil {
+			return fmt.Errorf("failed writing report: %w", err)
+		}
+	}
+	return nil
+}
+
+// The exitError for the worst result when it is at or above failThreshold, nil otherwise
+// Failures exit with exitFail and warnings with exitWarn. Checks that could not complete, e.g.
+// forbidden or timed out, are failures of flare rather than of the cluster and exit with
+// exitToolError unless another check failed.
+func exitStatus(resultList []flare.Result, failThreshold flare.Severity) error {
+	worst := flare.SeverityInfo
+	errored := false
+	for _, r := range resultList {
+		if r.Err != nil {
+			errored = true
+			continue
+		}
+		if r.Severity > worst {
+			worst = r.Severity
+		}
+	}
+	if worst == flare.SeverityFail && worst >= failThreshold {
+		return &exitError{code: exitFail}
+	}
+	if errored {
+		return &exitError{code: exitToolError}
+	}
+	if worst == flare.SeverityInfo || worst < failThreshold {
+		return nil
+	}
+	return &exitError{code: exitWarn}
+}
+
+// The PEM encoded cluster CA configured for the connection, nil if there is none
+func clusterCA(config *rest.Config) []byte {
+	if config == nil {
+		return nil
+	}
+	if len(config.CAData) > 0 {
+		return config.CAData
+	}
+	if config.CAFile != "" {
+		if data, err := os.ReadFile(config.CAFile); err == nil {
+			return data
+		}
+	}
+	return nil
+}
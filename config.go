@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"flare/pkg/flare"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// Config file loaded from the home directory when --config is not set
+const defaultConfigFile = ".flare.yaml"
+
+// The config file keys that are not flags: "<check> <object>" ignore rules, as in an ignore
+// file, and custom profiles mapping a name to a list of check IDs
+const (
+	configIgnoreKey   = "ignore"
+	configProfilesKey = "profiles"
+)
+
+// configSettings are the settings of the config file that are not flags
+type configSettings struct {
+	ignore   []flare.IgnoreRule
+	profiles map[string][]string
+}
+
+// Load the config file and set every flag of cmd it has a value for and that was not given on
+// the command line, so flags override the config file. Its keys are the long flag names, lists
+// set repeatable and comma separated flags, e.g.
+//
+//	checks: [nodes, pods, events]
+//	overcommit-cpu-threshold: 150
+//	output: json
+//	ignore:
+//	- pods Pod/batch/report-*
+//	profiles:
+//	  batch: [jobs, cronjobs, finished-pods]
+//
+// Keys of flags other commands have are skipped, unknown keys are an error. A missing file is
+// only an error when it was given with --config.
+func loadConfig(cmd *cobra.Command, file string, explicit bool) (configSettings, error) {
+	var loaded configSettings
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return loaded, nil
+	}
+	if err != nil {
+		return loaded, fmt.Errorf("failed reading config file: %w", err)
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return loaded, fmt.Errorf("invalid config file %s: %w", file, err)
+	}
+	if profiles, ok := settings[configProfilesKey]; ok {
+		if loaded.profiles, err = configProfiles(profiles); err != nil {
+			return loaded, fmt.Errorf("invalid config file %s: %s: %w", file, configProfilesKey, err)
+		}
+		delete(settings, configProfilesKey)
+	}
+
+	known := map[string]bool{}
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.Flags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		c.PersistentFlags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(cmd.Root())
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, err := configValues(settings[key])
+		if err != nil {
+			return loaded, fmt.Errorf("invalid config file %s: %s: %w", file, key, err)
+		}
+		if key == configIgnoreKey {
+			for _, line := range values {
+				rule, err := flare.ParseIgnoreRule(line)
+				if err != nil {
+					return loaded, fmt.Errorf("invalid config file %s: %s: %w", file, key, err)
+				}
+				loaded.ignore = append(loaded.ignore, rule)
+			}
+			continue
+		}
+		if key == "config" || !known[key] {
+			return loaded, fmt.Errorf("invalid config file %s: unknown setting %q", file, key)
+		}
+		f := cmd.Flags().Lookup(key)
+		if f == nil || f.Changed {
+			continue
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			err = slice.Replace(values)
+		} else {
+			err = f.Value.Set(strings.Join(values, ","))
+		}
+		if err != nil {
+			return loaded, fmt.Errorf("invalid config file %s: %s: %w", file, key, err)
+		}
+	}
+	return loaded, nil
+}
+
+// The custom profiles of the config file, a map of profile names to lists of check IDs.
+// The check IDs are validated when the profile is used, rules files may add checks.
+func configProfiles(value interface{}) (map[string][]string, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a map of profile names to lists of checks, got %v", value)
+	}
+	profiles := map[string][]string{}
+	for name, ids := range m {
+		values, err := configValues(ids)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%s: expected at least one check", name)
+		}
+		profiles[name] = values
+	}
+	return profiles, nil
+}
+
+// The flag values of a config file setting, a list for sequences
+func configValues(value interface{}) ([]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		list = []interface{}{value}
+	}
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		switch v := v.(type) {
+		case string:
+			values = append(values, v)
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return nil, fmt.Errorf("expected a string, number, boolean or a list of them, got %v", v)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flare/pkg/flare"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Write the object a fix changes as YAML into dir, returning the file name
+func backupObject(dir string, obj runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	name := strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind) + "_"
+	if accessor.GetNamespace() != "" {
+		name += accessor.GetNamespace() + "_"
+	}
+	name += accessor.GetName() + ".yaml"
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, data, 0600)
+}
+
+// Offer every fix found in results to the user and apply the confirmed ones.
+// Each fix is confirmed separately with y(es), n(o), a(ll remaining) or q(uit) read from in.
+// The object a fix changes is always backed up into backupDir before it is applied.
+func applyFixes(ctx context.Context, clientset kubernetes.Interface, results []flare.Result, backupDir string, in io.Reader, out io.Writer) error {
+	var fixes []flare.Fix
+	for _, r := range results {
+		fixes = append(fixes, r.Fixes...)
+	}
+	if len(fixes) == 0 {
+		fmt.Fprintln(out, "No fixes available")
+		return nil
+	}
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return fmt.Errorf("failed creating backup directory: %w", err)
+	}
+	fmt.Fprintf(out, "%d fixes available, original manifests are saved to %s\n", len(fixes), backupDir)
+
+	answers := bufio.NewReader(in)
+	all := false
+	failed := 0
+	for _, fix := range fixes {
+		if !all {
+			switch strings.ToLower(ask(answers, out, fix.Description+"? [y/N/a/q] ")) {
+			case "y", "yes":
+			case "a", "all":
+				all = true
+			case "q", "quit":
+				return nil
+			default:
+				continue
+			}
+		}
+		path, err := backupObject(backupDir, fix.Backup)
+		if err != nil {
+			// Never mutate anything that could not be backed up
+			fmt.Fprintf(out, "Skipped, backup failed: %s\n", err)
+			failed++
+			continue
+		}
+		if err := fix.Apply(ctx, clientset); err != nil {
+			fmt.Fprintf(out, "Failed: %s (backup %s)\n", err, path)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "Done (backup %s)\n", path)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d fixes failed", failed)
+	}
+	return nil
+}
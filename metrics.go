@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"flare/pkg/flare"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// exporter holds the Prometheus metrics updated after every run in -serve-metrics mode
+type exporter struct {
+	registry      *prometheus.Registry
+	checkStatus   *prometheus.GaugeVec
+	checkDuration *prometheus.HistogramVec
+	runDuration   prometheus.Histogram
+	lastRun       prometheus.Gauge
+}
+
+func newExporter() *exporter {
+	e := &exporter{
+		registry: prometheus.NewRegistry(),
+		checkStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "flare_check_status",
+			Help: "Severity of the last result of a check: 0 passed, 1 warning, 2 failed.",
+		}, []string{"check"}),
+		checkDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "flare_check_duration_seconds",
+			Help:    "Duration of a single check.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"check"}),
+		runDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "flare_run_duration_seconds",
+			Help:    "Duration of a full run of all selected checks.",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+		}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "flare_last_run_timestamp_seconds",
+			Help: "Unix time the last run finished.",
+		}),
+	}
+	e.registry.MustRegister(e.checkStatus, e.checkDuration, e.runDuration, e.lastRun)
+	return e
+}
+
+// Update the metrics with the results of a run that took `elapsed`
+func (e *exporter) observe(results []flare.Result, elapsed time.Duration) {
+	for _, r := range results {
+		e.checkStatus.WithLabelValues(r.ID).Set(float64(r.Severity))
+		e.checkDuration.WithLabelValues(r.ID).Observe(r.Duration.Seconds())
+	}
+	e.runDuration.Observe(elapsed.Seconds())
+	e.lastRun.SetToCurrentTime()
+}
+
+// Serve /metrics on addr and call run every interval until ctx is cancelled or the server fails
+func serveMetrics(ctx context.Context, addr string, interval time.Duration, run func() ([]flare.Result, error)) error {
+	e := newExporter()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	log.Infof("Serving metrics on %s/metrics, running checks every %s", addr, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if results, err := run(); err != nil {
+			log.Errorf("Failed running checks: %v", err)
+		} else {
+			e.observe(results, time.Since(start))
+		}
+		select {
+		case err := <-serverErr:
+			return err
+		case <-ctx.Done():
+			return server.Shutdown(context.Background())
+		case <-ticker.C:
+		}
+	}
+}
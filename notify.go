@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"flare/pkg/flare"
+)
+
+// Message posted by --notify-url when --notify-template is not given
+const defaultNotifyTemplate = `flare: {{.Failed}} failed, {{.Warnings}} warnings, {{.Passed}} passed{{if .Skipped}}, {{.Skipped}} skipped{{end}}
+{{range .Results}}[{{.Severity}}] {{if .Cluster}}[{{.Cluster}}] {{end}}{{.Name}}
+{{end}}`
+
+// summary holds the counts of a run, it is the data --notify-template is executed with
+type summary struct {
+	Failed   int
+	Warnings int
+	Passed   int
+	Skipped  int
+	// Severity is the worst severity of the run
+	Severity flare.Severity
+	// Results are the failed and warning results, in report order
+	Results []flare.Result
+}
+
+// webhookPayload is the json posted with --notify-format webhook
+type webhookPayload struct {
+	Text     string         `json:"text"`
+	Severity flare.Severity `json:"severity"`
+	Failed   int            `json:"failed"`
+	Warnings int            `json:"warnings"`
+	Passed   int            `json:"passed"`
+	Skipped  int            `json:"skipped"`
+	Results  []jsonResult   `json:"results"`
+}
+
+// slackPayload is the json posted to a Slack incoming webhook with --notify-format slack
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+// Count the results by outcome and collect the failed and warning ones
+func summarize(results []flare.Result) summary {
+	var n summary
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			n.Skipped++
+		case r.Severity == flare.SeverityFail:
+			n.Failed++
+		case r.Severity == flare.SeverityWarn:
+			n.Warnings++
+		default:
+			n.Passed++
+		}
+		if r.Severity > n.Severity {
+			n.Severity = r.Severity
+		}
+		if !r.Skipped && r.Severity > flare.SeverityInfo {
+			n.Results = append(n.Results, r)
+		}
+	}
+	return n
+}
+
+// Load the message template from file, "" for defaultNotifyTemplate
+func loadNotifyTemplate(file string) (*template.Template, error) {
+	text := defaultNotifyTemplate
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading --notify-template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("notify").Funcs(template.FuncMap{"trim": strings.TrimSpace}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --notify-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Build the body posted for results in format, one of "webhook" or "slack"
+func notifyPayload(format string, tmpl *template.Template, results []flare.Result) ([]byte, error) {
+	n := summarize(results)
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, n); err != nil {
+		return nil, fmt.Errorf("failed executing --notify-template: %w", err)
+	}
+	switch format {
+	case "slack":
+		return json.Marshal(slackPayload{Text: text.String()})
+	case "webhook":
+		payload := webhookPayload{
+			Text:     text.String(),
+			Severity: n.Severity,
+			Failed:   n.Failed,
+			Warnings: n.Warnings,
+			Passed:   n.Passed,
+			Skipped:  n.Skipped,
+			Results:  make([]jsonResult, 0, len(n.Results)),
+		}
+		for _, r := range n.Results {
+			payload.Results = append(payload.Results, newJSONResult(r))
+		}
+		return json.Marshal(payload)
+	}
+	return nil, fmt.Errorf("unknown notify format %q, valid formats are: webhook, slack", format)
+}
+
+// Post the summary of results to url
+func notify(ctx context.Context, url string, format string, tmpl *template.Template, results []flare.Result) error {
+	body, err := notifyPayload(format, tmpl, results)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed creating notification: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed sending notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notification rejected with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
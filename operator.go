@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"flare/pkg/flare"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The API group of the operator resources, see deploy/operator.yaml
+var (
+	checkRunResource = schema.GroupVersionResource{Group: "flare.jaykayy.io", Version: "v1alpha1", Resource: "flarecheckruns"}
+	reportResource   = schema.GroupVersionResource{Group: "flare.jaykayy.io", Version: "v1alpha1", Resource: "flarereports"}
+)
+
+// checkRun is a FlareCheckRun, it selects the checks to run and when to run them
+type checkRun struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata"`
+	Spec          checkRunSpec   `json:"spec"`
+	Status        checkRunStatus `json:"status"`
+}
+
+type checkRunSpec struct {
+	// Checks are the IDs of the checks to run, empty for all, see `flare list`
+	Checks []string `json:"checks,omitempty"`
+	Skip   []string `json:"skip,omitempty"`
+	// Namespaces limits the namespaced checks, empty for all namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Schedule is a cron expression as used by CronJobs, empty runs the checks once per spec change
+	Schedule     string `json:"schedule,omitempty"`
+	ActiveProbes bool   `json:"activeProbes,omitempty"`
+}
+
+type checkRunStatus struct {
+	// ObservedGeneration is the generation of the spec the last run used
+	ObservedGeneration int64    `json:"observedGeneration,omitempty"`
+	LastRunTime        *v1.Time `json:"lastRunTime,omitempty"`
+	NextRunTime        *v1.Time `json:"nextRunTime,omitempty"`
+	Severity           string   `json:"severity,omitempty"`
+	Report             string   `json:"report,omitempty"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// reportStatus is the status of a FlareReport, the outcome of the last run of its FlareCheckRun
+type reportStatus struct {
+	RunTime  v1.Time        `json:"runTime"`
+	Severity flare.Severity `json:"severity"`
+	Failed   int            `json:"failed"`
+	Warnings int            `json:"warnings"`
+	Passed   int            `json:"passed"`
+	Skipped  int            `json:"skipped"`
+	Results  []jsonResult   `json:"results"`
+}
+
+// operator runs the FlareCheckRuns of the cluster and writes their FlareReports
+type operator struct {
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+	// namespace limits the FlareCheckRuns reconciled, "" for all namespaces
+	namespace    string
+	concurrency  int
+	checkTimeout time.Duration
+	// redactor scrubs the results before they are written to FlareReports
+	redactor *redactor
+}
+
+func newOperatorCmd(root *rootFlags) *cobra.Command {
+	op := &operator{}
+	var resync time.Duration
+	var redact []string
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Run the checks of FlareCheckRun resources and write the results to FlareReports",
+		Long: `Operator keeps running in the cluster and reconciles the FlareCheckRun resources,
+see deploy/operator.yaml. Every FlareCheckRun selects checks and a cron schedule, its
+results are written to the status of the FlareReport of the same name.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clientset, config, err := clientsetFromFlags(root)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+			if op.dynamic, err = dynamic.NewForConfig(config); err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+			op.clientset = clientset
+			if op.redactor, err = newRedactor(redact); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return op.run(ctx, resync)
+		},
+	}
+	cmd.Flags().StringVarP(&op.namespace, "namespace", "n", "", "only reconcile the FlareCheckRuns of this namespace, defaults to all namespaces")
+	cmd.Flags().DurationVar(&resync, "resync", 30*time.Second, "time between looking for FlareCheckRuns that are due")
+	cmd.Flags().IntVar(&op.concurrency, "concurrency", 4, "number of checks to run in parallel")
+	cmd.Flags().DurationVar(&op.checkTimeout, "check-timeout", 30*time.Second, "maximum duration of a single check, 0 for no limit")
+	cmd.Flags().StringArrayVar(&redact, "redact", nil, "regular expression whose matches are replaced with "+redacted+" in the FlareReports, on top of keys, tokens and passwords (repeatable)")
+	return cmd
+}
+
+// Reconcile every resync until ctx is cancelled
+func (op *operator) run(ctx context.Context, resync time.Duration) error {
+	log.Infof("Reconciling FlareCheckRuns every %s", resync)
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+	for {
+		if err := op.reconcile(ctx, time.Now()); err != nil {
+			log.Errorf("Failed reconciling FlareCheckRuns: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run the FlareCheckRuns that are due at now. A run that fails is recorded in its status
+// and does not stop the others.
+func (op *operator) reconcile(ctx context.Context, now time.Time) error {
+	list, err := op.dynamic.Resource(checkRunResource).Namespace(op.namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed listing flarecheckruns: %w", err)
+	}
+	for i := range list.Items {
+		var cr checkRun
+		if err := fromUnstructured(&list.Items[i], &cr); err != nil {
+			log.Errorf("Invalid FlareCheckRun %s/%s: %v", list.Items[i].GetNamespace(), list.Items[i].GetName(), err)
+			continue
+		}
+		if !due(&cr, now) {
+			continue
+		}
+		log.Infof("Running FlareCheckRun %s/%s", cr.Namespace, cr.Name)
+		cr.Status.Error = ""
+		if err := op.runCheckRun(ctx, &cr, now); err != nil {
+			log.Errorf("FlareCheckRun %s/%s failed: %v", cr.Namespace, cr.Name, err)
+			cr.Status.Error = err.Error()
+		}
+		cr.Status.ObservedGeneration = cr.Generation
+		cr.Status.LastRunTime = &v1.Time{Time: now}
+		cr.Status.NextRunTime = nil
+		if next, err := nextRun(&cr, now); err == nil && !next.IsZero() {
+			cr.Status.NextRunTime = &v1.Time{Time: next}
+		}
+		if err := op.updateStatus(ctx, checkRunResource, &list.Items[i], cr.Status); err != nil {
+			log.Errorf("Failed updating FlareCheckRun %s/%s: %v", cr.Namespace, cr.Name, err)
+		}
+	}
+	return nil
+}
+
+// Whether a FlareCheckRun should run at now: it never ran, its spec changed or its schedule is due
+func due(cr *checkRun, now time.Time) bool {
+	if cr.Status.LastRunTime == nil || cr.Status.ObservedGeneration != cr.Generation {
+		return true
+	}
+	next, err := nextRun(cr, cr.Status.LastRunTime.Time)
+	return err == nil && !next.IsZero() && !next.After(now)
+}
+
+// The next scheduled run after t, zero without a schedule
+func nextRun(cr *checkRun, t time.Time) (time.Time, error) {
+	if cr.Spec.Schedule == "" {
+		return time.Time{}, nil
+	}
+	return flare.NextSchedule(cr.Spec.Schedule, t)
+}
+
+// Run the checks of a FlareCheckRun and write their results to its FlareReport
+func (op *operator) runCheckRun(ctx context.Context, cr *checkRun, now time.Time) error {
+	if _, err := nextRun(cr, now); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", cr.Spec.Schedule, err)
+	}
+	selected, err := flare.SelectChecks(strings.Join(cr.Spec.Checks, ","), strings.Join(cr.Spec.Skip, ","))
+	if err != nil {
+		return err
+	}
+	runner := &flare.Runner{Clientset: op.clientset, Checks: selected, Concurrency: op.concurrency, CheckTimeout: op.checkTimeout, Dynamic: op.dynamic}
+	results, err := runner.Run(ctx, &flare.Options{Namespaces: cr.Spec.Namespaces, ActiveProbes: cr.Spec.ActiveProbes})
+	if err != nil {
+		return err
+	}
+	n := summarize(results)
+	status := reportStatus{
+		RunTime:  v1.Time{Time: now},
+		Severity: n.Severity,
+		Failed:   n.Failed,
+		Warnings: n.Warnings,
+		Passed:   n.Passed,
+		Skipped:  n.Skipped,
+		Results:  make([]jsonResult, 0, len(results)),
+	}
+	for _, r := range results {
+		// FlareReports are stored in etcd and readable by anyone allowed to get them
+		status.Results = append(status.Results, newJSONResult(op.redactor.result(r)))
+	}
+	cr.Status.Severity = n.Severity.String()
+	cr.Status.Report = cr.Name
+	return op.writeReport(ctx, cr, status)
+}
+
+// Create the FlareReport of a FlareCheckRun if needed, owned by it, and set its status
+func (op *operator) writeReport(ctx context.Context, cr *checkRun, status reportStatus) error {
+	reports := op.dynamic.Resource(reportResource).Namespace(cr.Namespace)
+	report, err := reports.Get(ctx, cr.Name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		report = &unstructured.Unstructured{}
+		report.SetAPIVersion(reportResource.GroupVersion().String())
+		report.SetKind("FlareReport")
+		report.SetNamespace(cr.Namespace)
+		report.SetName(cr.Name)
+		controller := true
+		report.SetOwnerReferences([]v1.OwnerReference{{
+			APIVersion: checkRunResource.GroupVersion().String(),
+			Kind:       "FlareCheckRun",
+			Name:       cr.Name,
+			UID:        cr.UID,
+			Controller: &controller,
+		}})
+		report, err = reports.Create(ctx, report, v1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed getting flarereport: %w", err)
+	}
+	return op.updateStatus(ctx, reportResource, report, status)
+}
+
+// Replace the status of obj with status
+func (op *operator) updateStatus(ctx context.Context, resource schema.GroupVersionResource, obj *unstructured.Unstructured, status interface{}) error {
+	// utiljson decodes whole numbers as int64 like the unstructured decoder does
+	var fields map[string]interface{}
+	data, err := json.Marshal(status)
+	if err == nil {
+		err = utiljson.Unmarshal(data, &fields)
+	}
+	if err != nil {
+		return err
+	}
+	obj = obj.DeepCopy()
+	obj.Object["status"] = fields
+	if _, err := op.dynamic.Resource(resource).Namespace(obj.GetNamespace()).UpdateStatus(ctx, obj, v1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed updating %s status: %w", resource.Resource, err)
+	}
+	return nil
+}
+
+// Decode an unstructured object into a typed one through its json form
+func fromUnstructured(obj *unstructured.Unstructured, into interface{}) error {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
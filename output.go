@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"flare/pkg/flare"
+	"golang.org/x/term"
+)
+
+// jsonResult is the serialized form of a Result for json output
+type jsonResult struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Cluster  string         `json:"cluster,omitempty"`
+	Pass     bool           `json:"pass"`
+	Skipped  bool           `json:"skipped,omitempty"`
+	Severity flare.Severity `json:"severity"`
+	Details  string         `json:"details"`
+	Error    string         `json:"error,omitempty"`
+	// Started is the RFC 3339 time the check started, empty for skipped checks
+	Started string `json:"started,omitempty"`
+	// Duration of the check in seconds
+	Duration   float64       `json:"duration"`
+	Findings   []jsonFinding `json:"findings,omitempty"`
+	Suppressed int           `json:"suppressed,omitempty"`
+}
+
+// jsonFinding is the json representation of a flare.Finding
+type jsonFinding struct {
+	Group       string         `json:"group,omitempty"`
+	Version     string         `json:"version,omitempty"`
+	Kind        string         `json:"kind,omitempty"`
+	Namespace   string         `json:"namespace,omitempty"`
+	Name        string         `json:"name,omitempty"`
+	Reason      string         `json:"reason"`
+	Severity    flare.Severity `json:"severity"`
+	Message     string         `json:"message"`
+	Remediation string         `json:"remediation,omitempty"`
+}
+
+// resultFields maps the names accepted by -fields to the value printed for a Result
+var resultFields = map[string]func(flare.Result) string{
+	"id":          func(r flare.Result) string { return r.ID },
+	"name":        func(r flare.Result) string { return r.Name },
+	"cluster":     func(r flare.Result) string { return r.Cluster },
+	"pass":        func(r flare.Result) string { return strconv.FormatBool(r.Pass) },
+	"skipped":     func(r flare.Result) string { return strconv.FormatBool(r.Skipped) },
+	"severity":    func(r flare.Result) string { return r.Severity.String() },
+	"details":     func(r flare.Result) string { return strings.TrimSpace(r.Details) },
+	"remediation": func(r flare.Result) string { return strings.Join(r.Remediations(), "\n") },
+	"error":       func(r flare.Result) string { return r.ErrorString() },
+	"duration":    func(r flare.Result) string { return r.Duration.String() },
+	"started":     startedTime,
+}
+
+// The start time of the check of r in RFC 3339 format, "" if it did not run
+func startedTime(r flare.Result) string {
+	if r.Started.IsZero() {
+		return ""
+	}
+	return r.Started.Format(time.RFC3339Nano)
+}
+
+// Columns used for csv output when -fields is not given
+var defaultCSVFields = []string{"name", "pass", "severity", "details"}
+
+// Sorted list of the valid field names, used for help and error messages
+func resultFieldNames() []string {
+	names := make([]string, 0, len(resultFields))
+	for name := range resultFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse a comma separated field list such as "name,pass,details".
+// An empty list returns nil, an unknown field name returns an error.
+func parseFields(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(list, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if _, ok := resultFields[f]; !ok {
+			return nil, fmt.Errorf("unknown field %q, valid fields are: %s", f, strings.Join(resultFieldNames(), ", "))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Write the results of the checks to the buffer in the requested format.
+//
+// buffer - A writeBuffer to a file that is where results will be written.
+// format - One of "text", "csv", "json", "junit", "html", "markdown" or "sarif".
+// fields - The Result fields to print, in order. An empty list means the default report for text.
+// color - Whether the text report may use ANSI colors, false when writing to a file.
+// results - The results of the checks that were run.
+//
+// returns an error if the format is unknown or the write failed
+func writeResults(buffer *bufio.Writer, format string, fields []string, color bool, results []flare.Result) error {
+	var err error
+	switch format {
+	case "text":
+		if len(fields) == 0 {
+			err = writeText(buffer, color, results)
+		} else {
+			err = writeFields(buffer, fields, results)
+		}
+	case "csv":
+		err = writeCSV(buffer, fields, results)
+	case "json":
+		err = writeJSON(buffer, results)
+	case "junit":
+		err = writeJUnit(buffer, results)
+	case "html":
+		err = writeHTML(buffer, time.Now(), results)
+	case "markdown":
+		err = writeMarkdown(buffer, results)
+	case "sarif":
+		err = writeSARIF(buffer, results)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	return buffer.Flush()
+}
+
+// Whether to color the report written to out for a --color mode of auto, always or never.
+// auto colors terminals unless the NO_COLOR environment variable is set, see https://no-color.org
+func useColor(mode string, out *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(int(out.Fd()))
+}
+
+// The remediations of the findings of a result as a "Next steps:" list, "" when it has none
+func nextSteps(r flare.Result) string {
+	remediations := r.Remediations()
+	if len(remediations) == 0 {
+		return ""
+	}
+	steps := "Next steps:\n"
+	for _, remediation := range remediations {
+		steps += "  - " + remediation + "\n"
+	}
+	return steps
+}
+
+// Write each result as a ✓/⚠/✗ line, or - for skipped checks, followed by the details and the
+// next steps of its findings. The symbols are colored unless color is false
+func writeText(buffer *bufio.Writer, color bool, results []flare.Result) error {
+	// symbol  ✓
+	// symbol  ⚠
+	// symbol  ✗
+	colorReset := "\033[0m"
+	colorGreen := "\033[32m"
+	colorYellow := "\033[33m"
+	colorRed := "\033[31m"
+	if !color {
+		colorReset, colorGreen, colorYellow, colorRed = "", "", "", ""
+	}
+	for _, r := range results {
+		symbol := fmt.Sprintf("%s%s%s", string(colorGreen), "✓", string(colorReset))
+		switch {
+		case r.Skipped:
+			symbol = "-"
+		case r.Severity == flare.SeverityWarn:
+			symbol = fmt.Sprintf("%s%s%s", string(colorYellow), "⚠", string(colorReset))
+		case r.Severity == flare.SeverityFail:
+			symbol = fmt.Sprintf("%s%s%s", string(colorRed), "✗", string(colorReset))
+		}
+		details := r.Details + nextSteps(r)
+		if r.Err != nil {
+			details += "Error: " + r.Err.Error() + "\n"
+		}
+		name := r.Name
+		if r.Cluster != "" {
+			name = "[" + r.Cluster + "] " + name
+		}
+		if r.Suppressed > 0 {
+			name += fmt.Sprintf(" (%d suppressed)", r.Suppressed)
+		}
+		var err error
+		if details != "" {
+			_, err = fmt.Fprintf(buffer, "%s - %s\n%s", symbol, name, details)
+		} else {
+			_, err = fmt.Fprintf(buffer, "%s - %s\n", symbol, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write a footer with the outcome counts of the run, its wall time and its slowest check
+// The wall time spans from the first check starting to the last one finishing.
+func writeSummary(buffer *bufio.Writer, results []flare.Result) error {
+	n := summarize(results)
+	var first, last time.Time
+	var slowest flare.Result
+	for _, r := range results {
+		if r.Started.IsZero() {
+			continue
+		}
+		if first.IsZero() || r.Started.Before(first) {
+			first = r.Started
+		}
+		if end := r.Started.Add(r.Duration); end.After(last) {
+			last = end
+		}
+		if r.Duration > slowest.Duration {
+			slowest = r
+		}
+	}
+	fmt.Fprintf(buffer, "\n%d checks in %s: %d passed, %d warned, %d failed, %d skipped\n", len(results), last.Sub(first).Round(time.Millisecond), n.Passed, n.Warnings, n.Failed, n.Skipped)
+	if slowest.Name != "" {
+		fmt.Fprintf(buffer, "Slowest check: %s (%s)\n", slowest.Name, slowest.Duration.Round(time.Millisecond))
+	}
+	return buffer.Flush()
+}
+
+// Write one tab separated line per result containing only the selected fields.
+// Multi-line details are joined with "; " so every result stays on one line for awk.
+func writeFields(buffer *bufio.Writer, fields []string, results []flare.Result) error {
+	for _, r := range results {
+		values := make([]string, len(fields))
+		for i, f := range fields {
+			values[i] = strings.Join(strings.Split(resultFields[f](r), "\n"), "; ")
+		}
+		if _, err := fmt.Fprintln(buffer, strings.Join(values, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keep only the results at or above the given severity
+func filterResults(results []flare.Result, min flare.Severity) []flare.Result {
+	var filtered []flare.Result
+	for _, r := range results {
+		if r.Severity >= min {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// Write a csv header of the selected fields followed by one row per result
+func writeCSV(buffer *bufio.Writer, fields []string, results []flare.Result) error {
+	w := csv.NewWriter(buffer)
+	if err := w.Write(fields); err != nil {
+		return err
+	}
+	for _, r := range results {
+		row := make([]string, len(fields))
+		for i, f := range fields {
+			row[i] = resultFields[f](r)
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// The serialized form of r for json output
+func newJSONResult(r flare.Result) jsonResult {
+	return jsonResult{
+		ID:         r.ID,
+		Name:       r.Name,
+		Cluster:    r.Cluster,
+		Pass:       r.Pass,
+		Skipped:    r.Skipped,
+		Severity:   r.Severity,
+		Details:    r.Details,
+		Error:      r.ErrorString(),
+		Started:    startedTime(r),
+		Duration:   r.Duration.Seconds(),
+		Findings:   jsonFindings(r.Findings),
+		Suppressed: r.Suppressed,
+	}
+}
+
+func jsonFindings(findings []flare.Finding) []jsonFinding {
+	var out []jsonFinding
+	for _, f := range findings {
+		out = append(out, jsonFinding{
+			Group:       f.Object.Group,
+			Version:     f.Object.Version,
+			Kind:        f.Object.Kind,
+			Namespace:   f.Object.Namespace,
+			Name:        f.Object.Name,
+			Reason:      f.Reason,
+			Severity:    f.Severity,
+			Message:     f.Message,
+			Remediation: f.Remediation,
+		})
+	}
+	return out
+}
+
+// Write the results as an indented json array
+func writeJSON(buffer *bufio.Writer, results []flare.Result) error {
+	out := make([]jsonResult, 0, len(results))
+	for _, r := range results {
+		out = append(out, newJSONResult(r))
+	}
+	enc := json.NewEncoder(buffer)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// JUnit XML report types, see https://llg.cubic.org/docs/junit/
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// Write the results as a JUnit XML report with one test case per check.
+// Failed checks are reported as failures, checks that could not run as errors.
+// JUnit has no notion of warnings, so those pass with their details in system-out.
+// Checks skipped by the preflight are reported as skipped.
+func writeJUnit(buffer *bufio.Writer, results []flare.Result) error {
+	suite := junitTestSuite{Name: "flare", Tests: len(results)}
+	var total time.Duration
+	for _, r := range results {
+		total += r.Duration
+		className := "flare"
+		if r.Cluster != "" {
+			className += "." + r.Cluster
+		}
+		tc := junitTestCase{
+			Name:      r.Name,
+			ClassName: className,
+			Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+		}
+		if r.Skipped {
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: strings.TrimSpace(r.Details)}
+		} else if r.Err != nil {
+			suite.Errors++
+			tc.Error = &junitMessage{Message: r.Err.Error(), Body: r.Details}
+		} else if r.Severity == flare.SeverityFail {
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: r.Name + " check failed", Body: r.Details + nextSteps(r)}
+		} else if r.Severity == flare.SeverityWarn {
+			tc.SystemOut = r.Details + nextSteps(r)
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	if _, err := buffer.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(buffer)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := buffer.WriteString("\n")
+	return err
+}
+
+// htmlCheck is a result as shown in the html report
+type htmlCheck struct {
+	flare.Result
+	// Status is the css class and label of the result: pass, warn, fail or skipped
+	Status string
+}
+
+// htmlReport is the data htmlTemplate is executed with
+type htmlReport struct {
+	summary
+	Generated time.Time
+	Checks    []htmlCheck
+}
+
+// Standalone html report, the styles are inlined so the file can be mailed as is
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>flare report {{.Generated.Format "2006-01-02 15:04:05 MST"}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292f; }
+.cards { display: flex; gap: 1em; margin: 1.5em 0; }
+.card { border-radius: 6px; padding: 1em 1.5em; min-width: 6em; text-align: center; color: #fff; }
+.card b { display: block; font-size: 2em; }
+.card.pass { background: #2da44e; } .card.warn { background: #bf8700; } .card.fail { background: #cf222e; } .card.skipped { background: #6e7781; }
+details { border: 1px solid #d0d7de; border-left-width: 6px; border-radius: 6px; margin: 0.5em 0; padding: 0.5em 1em; }
+details.pass { border-left-color: #2da44e; } details.warn { border-left-color: #bf8700; } details.fail { border-left-color: #cf222e; } details.skipped { border-left-color: #6e7781; }
+summary { cursor: pointer; }
+summary .status { font-weight: bold; text-transform: uppercase; margin-right: 0.5em; }
+summary .duration { color: #6e7781; float: right; }
+pre { background: #f6f8fa; padding: 0.75em; overflow-x: auto; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>flare report</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}</p>
+<div class="cards">
+<div class="card pass"><b>{{.Passed}}</b>passed</div>
+<div class="card warn"><b>{{.Warnings}}</b>warnings</div>
+<div class="card fail"><b>{{.Failed}}</b>failed</div>
+<div class="card skipped"><b>{{.Skipped}}</b>skipped</div>
+</div>
+{{range .Checks}}<details class="{{.Status}}"{{if or (eq .Status "fail") (eq .Status "warn")}} open{{end}}>
+<summary><span class="status">{{.Status}}</span>{{if .Cluster}}[{{.Cluster}}] {{end}}{{.Name}}<span class="duration">{{printf "%.3fs" .Duration.Seconds}}</span></summary>
+{{if .Details}}<pre>{{.Details}}</pre>
+{{end}}{{with .Remediations}}<p>Next steps:</p>
+<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .Err}}<pre>Error: {{.ErrorString}}</pre>
+{{end}}{{if not (or .Details .Err)}}<p>Nothing found.</p>
+{{end}}</details>
+{{end}}</body>
+</html>
+`))
+
+// The status of a result as shown in the markdown report
+func markdownStatus(r flare.Result) string {
+	switch {
+	case r.Skipped:
+		return "➖ skipped"
+	case r.Severity == flare.SeverityFail:
+		return "❌ fail"
+	case r.Severity == flare.SeverityWarn:
+		return "⚠️ warn"
+	}
+	return "✅ pass"
+}
+
+// Write the results as a Markdown table with a details section per check that failed, warned or
+// errored, for pasting into GitHub issues and Jira tickets. Details are fenced so they are shown verbatim.
+func writeMarkdown(buffer *bufio.Writer, results []flare.Result) error {
+	n := summarize(results)
+	fmt.Fprintf(buffer, "## flare report\n\n%d passed, %d warned, %d failed, %d skipped\n\n", n.Passed, n.Warnings, n.Failed, n.Skipped)
+	fmt.Fprintf(buffer, "| Check | Status | Findings | Duration |\n| --- | --- | --- | --- |\n")
+	name := func(r flare.Result) string {
+		name := r.Name
+		if r.Cluster != "" {
+			name = "[" + r.Cluster + "] " + name
+		}
+		return strings.ReplaceAll(name, "|", "\\|")
+	}
+	for _, r := range results {
+		fmt.Fprintf(buffer, "| %s | %s | %d | %.3fs |\n", name(r), markdownStatus(r), len(r.Findings), r.Duration.Seconds())
+	}
+	for _, r := range results {
+		if r.Skipped || r.Severity < flare.SeverityWarn {
+			continue
+		}
+		details := r.Details + nextSteps(r)
+		if r.Err != nil {
+			details += "Error: " + r.Err.Error() + "\n"
+		}
+		fmt.Fprintf(buffer, "\n### %s (%s)\n\n```\n%s```\n", name(r), markdownStatus(r), details)
+	}
+	// bufio.Writer errors are sticky, writeResults reports them when it flushes
+	return nil
+}
+
+// Write the results as a standalone html report with summary cards and a collapsible
+// section per check, failures and warnings are expanded. generated is the time shown in the report.
+func writeHTML(buffer *bufio.Writer, generated time.Time, results []flare.Result) error {
+	report := htmlReport{summary: summarize(results), Generated: generated}
+	for _, r := range results {
+		status := "pass"
+		switch {
+		case r.Skipped:
+			status = "skipped"
+		case r.Severity == flare.SeverityFail:
+			status = "fail"
+		case r.Severity == flare.SeverityWarn:
+			status = "warn"
+		}
+		report.Checks = append(report.Checks, htmlCheck{Result: r, Status: status})
+	}
+	return htmlTemplate.Execute(buffer, report)
+}
+
+// SARIF 2.1.0 log types, see https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+// Only the properties flare fills in are declared.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      *sarifMessage      `json:"fullDescription,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           *sarifProperties   `json:"properties,omitempty"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifProperties struct {
+	Tags []string `json:"tags"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Descriptor sarifDescriptor `json:"descriptor"`
+}
+
+type sarifDescriptor struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// The SARIF level of a severity: failures are errors, warnings are warnings
+func sarifLevel(s flare.Severity) string {
+	switch s {
+	case flare.SeverityFail:
+		return "error"
+	case flare.SeverityWarn:
+		return "warning"
+	}
+	return "note"
+}
+
+// The location of the object of a finding. SARIF consumers such as GitHub code scanning want a
+// file, objects get a path relative to the CLUSTER base: [cluster/][namespace/]kind/name
+func sarifObjectLocation(cluster string, o flare.ObjectRef) sarifLocation {
+	var path []string
+	for _, segment := range []string{cluster, o.Namespace, strings.ToLower(o.Kind), o.Name} {
+		if segment != "" {
+			path = append(path, url.PathEscape(segment))
+		}
+	}
+	name := o.String()
+	if cluster != "" {
+		name = cluster + ": " + name
+	}
+	return sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: strings.Join(path, "/"), URIBaseID: "CLUSTER"}},
+		LogicalLocations: []sarifLogicalLocation{{Name: o.Name, FullyQualifiedName: name, Kind: "resource"}},
+	}
+}
+
+// A fingerprint of a finding that stays the same across runs, so code scanning tracks it as one alert
+func sarifFingerprint(parts ...string) map[string]string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return map[string]string{"flare/v1": hex.EncodeToString(sum[:])}
+}
+
+// Write the results as a SARIF 2.1.0 log for GitHub code scanning and other SARIF consumers.
+// Every check that reported something is a rule with the check id as rule id, every finding a
+// result located at its object. Checks without findings report their details as one result
+// without a location, checks that could not run are tool execution notifications.
+func writeSARIF(buffer *bufio.Writer, results []flare.Result) error {
+	registered := map[string]flare.Check{}
+	for _, c := range flare.Checks() {
+		registered[c.ID] = c
+	}
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "flare", Version: version, Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+	invocation := sarifInvocation{ExecutionSuccessful: true}
+	rules := map[string]bool{}
+	for _, r := range results {
+		if r.Err != nil {
+			invocation.ExecutionSuccessful = false
+			message := r.Name + ": " + r.Err.Error()
+			if r.Cluster != "" {
+				message = "[" + r.Cluster + "] " + message
+			}
+			invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, sarifNotification{Level: "error", Message: sarifMessage{Text: message}, Descriptor: sarifDescriptor{ID: r.ID}})
+		}
+		if r.Skipped || r.Severity < flare.SeverityWarn || len(r.Findings) == 0 && strings.TrimSpace(r.Details) == "" {
+			continue
+		}
+		if !rules[r.ID] {
+			rules[r.ID] = true
+			rule := sarifRule{ID: r.ID, Name: r.Name, ShortDescription: sarifMessage{Text: r.Name}, DefaultConfiguration: sarifConfiguration{Level: sarifLevel(r.Severity)}}
+			if c, ok := registered[r.ID]; ok {
+				rule.DefaultConfiguration.Level = sarifLevel(c.Severity)
+				if c.Description != "" {
+					rule.FullDescription = &sarifMessage{Text: c.Description}
+				}
+				if c.Category != "" {
+					rule.Properties = &sarifProperties{Tags: []string{c.Category}}
+				}
+			}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+		}
+		if len(r.Findings) == 0 {
+			details := strings.TrimSpace(r.Details)
+			run.Results = append(run.Results, sarifResult{RuleID: r.ID, Level: sarifLevel(r.Severity), Message: sarifMessage{Text: details}, PartialFingerprints: sarifFingerprint(r.ID, r.Cluster, details)})
+			continue
+		}
+		for _, f := range r.Findings {
+			result := sarifResult{RuleID: r.ID, Level: sarifLevel(f.Severity), Message: sarifMessage{Text: f.Message}, PartialFingerprints: sarifFingerprint(r.ID, r.Cluster, f.Object.String(), f.Reason)}
+			if f.Object.Name != "" {
+				result.Locations = []sarifLocation{sarifObjectLocation(r.Cluster, f.Object)}
+			}
+			run.Results = append(run.Results, result)
+		}
+	}
+	run.Invocations = []sarifInvocation{invocation}
+	enc := json.NewEncoder(buffer)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Schema: "https://json.schemastore.org/sarif-2.1.0.json", Version: "2.1.0", Runs: []sarifRun{run}})
+}
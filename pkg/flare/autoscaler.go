@@ -0,0 +1,212 @@
+package flare
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The ConfigMap cluster-autoscaler writes its status to, in kube-system
+const autoscalerStatusConfigMap = "cluster-autoscaler-status"
+
+// Karpenter NodeClaims that are not Ready after this long are stuck provisioning, the time
+// Karpenter itself gives a node to register
+const nodeClaimTimeout = 15 * time.Minute
+
+// The NodeClaim versions of Karpenter, newest first
+var nodeClaimResources = []schema.GroupVersionResource{
+	{Group: "karpenter.sh", Version: "v1", Resource: "nodeclaims"},
+	{Group: "karpenter.sh", Version: "v1beta1", Resource: "nodeclaims"},
+}
+
+// The Karpenter NodeClaim kind, for findings
+var nodeClaimKind = schema.GroupVersionKind{Group: "karpenter.sh", Version: "v1", Kind: "NodeClaim"}
+
+// Check the node autoscaler of the cluster: the node groups cluster-autoscaler reports as
+// unhealthy, backing off scale-ups or with nodes that did not start or register, the Karpenter
+// NodeClaims that are not Ready long after they were created, and the pending pods either
+// autoscaler says it can not help. Karpenter is only looked at when the Runner has a dynamic
+// client. Clusters without either autoscaler pass. Runs limited to namespaces only read the
+// cluster-autoscaler status when kube-system is one of them and do not look at NodeClaims.
+func checkNodeAutoscaler(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	detected := false
+	if opts.inScope(v1.NamespaceSystem) {
+		status, err := clientset.CoreV1().ConfigMaps(v1.NamespaceSystem).Get(ctx, autoscalerStatusConfigMap, v1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errorResult(fmt.Errorf("failed getting the cluster-autoscaler status: %w", err))
+		}
+		if err == nil {
+			detected = true
+			autoscalerStatusFindings(&found, objectRef(configMapKind, status), status.Data["status"])
+		}
+	}
+
+	if opts.dynamic != nil && len(opts.Namespaces) == 0 {
+		for _, resource := range nodeClaimResources {
+			claims, err := listUnstructured(ctx, opts.dynamic, resource, v1.NamespaceAll)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting nodeclaims: %w", err))
+			}
+			detected = true
+			for i := range claims {
+				nodeClaimFindings(&found, &claims[i])
+			}
+			break
+		}
+	}
+	if !detected {
+		return found.result()
+	}
+
+	// The latest autoscaler event of every pod, "ns/name"
+	events := map[string]corev1.Event{}
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{FieldSelector: "involvedObject.kind=Pod", Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			for _, e := range list.Items {
+				// cluster-autoscaler records NotTriggerScaleUp, Karpenter FailedScheduling as component karpenter
+				if e.Reason != "NotTriggerScaleUp" && !(e.Reason == "FailedScheduling" && e.Source.Component == "karpenter") {
+					continue
+				}
+				key := e.InvolvedObject.Namespace + "/" + e.InvolvedObject.Name
+				if latest, ok := events[key]; !ok || EventTime(e).After(EventTime(latest)) {
+					events[key] = e
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for i := range pods {
+			pod := &pods[i]
+			e, ok := events[pod.Namespace+"/"+pod.Name]
+			if !ok || pod.Status.Phase != corev1.PodPending || pod.Spec.NodeName != "" {
+				continue
+			}
+			found.add(SeverityWarn, objectRef(podKind, pod), "NoScaleUp", "Pod %s/%s is pending and the autoscaler will not add a node for it: %s", pod.Namespace, pod.Name, e.Message)
+			found.remedy("Check that a node group or NodePool can fit the requests, selectors and tolerations of the pod, see %s", kubectl("describe", objectRef(podKind, pod)))
+		}
+	}
+	return found.result()
+}
+
+// Record the node groups of the human readable cluster-autoscaler status that are unhealthy,
+// back off scaling up or have nodes that did not start or register for a long time, e.g.
+//
+//	NodeGroups:
+//	  Name:        ng-1
+//	  Health:      Healthy (ready=1 unready=0 notStarted=0 longNotStarted=1 registered=2 longUnregistered=0 ...)
+//	  ScaleUp:     Backoff (ready=1 cloudProviderTarget=2)
+func autoscalerStatusFindings(found *findings, object ObjectRef, status string) {
+	subject := "the cluster"
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], strings.TrimSpace(line[i+1:])
+		switch key {
+		case "Name":
+			subject = "node group " + value
+		case "Health":
+			if !strings.HasPrefix(value, "Healthy") {
+				found.add(SeverityFail, object, "AutoscalerUnhealthy", "Cluster autoscaler: %s is %s", subject, value)
+				found.remedy("kubectl logs -n kube-system deployment/cluster-autoscaler")
+			}
+			counts := autoscalerCounts(value)
+			if counts["longNotStarted"] > 0 || counts["longUnregistered"] > 0 {
+				found.add(SeverityFail, object, "NodesNotProvisioned", "Cluster autoscaler: %s has %d nodes that did not start and %d that did not register for a long time", subject, counts["longNotStarted"], counts["longUnregistered"])
+				found.remedy("Check the cloud provider for instances that failed to boot or join, e.g. quota or a broken node image, and kubectl logs -n kube-system deployment/cluster-autoscaler")
+			}
+		case "ScaleUp":
+			if strings.HasPrefix(value, "Backoff") {
+				found.add(SeverityFail, object, "ScaleUpBackoff", "Cluster autoscaler: %s backs off scaling up after failed scale-ups", subject)
+				found.remedy("Check the cloud provider quota and capacity of %s, kubectl logs -n kube-system deployment/cluster-autoscaler tells why scale-ups failed", subject)
+			}
+		}
+	}
+}
+
+// The key=value counts of a cluster-autoscaler status line
+func autoscalerCounts(value string) map[string]int {
+	counts := map[string]int{}
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '(' || r == ')' || r == ',' }) {
+		if i := strings.Index(field, "="); i > 0 {
+			if n, err := strconv.Atoi(field[i+1:]); err == nil {
+				counts[field[:i]] = n
+			}
+		}
+	}
+	return counts
+}
+
+// Record a Karpenter NodeClaim that is not Ready long after it was created, with the conditions
+// that are not True, e.g. Launched=False InsufficientCapacityError
+func nodeClaimFindings(found *findings, claim *unstructured.Unstructured) {
+	age := time.Since(claim.GetCreationTimestamp().Time)
+	if age < nodeClaimTimeout {
+		return
+	}
+	conditions, _, _ := unstructured.NestedSlice(claim.Object, "status", "conditions")
+	var failing []string
+	ready := false
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		kind, _ := condition["type"].(string)
+		status, _ := condition["status"].(string)
+		if kind == "Ready" && status == "True" {
+			ready = true
+		}
+		if status != "True" {
+			reason, _ := condition["reason"].(string)
+			message, _ := condition["message"].(string)
+			line := kind + "=" + status
+			if reason != "" {
+				line += " " + reason
+			}
+			if message != "" {
+				line += ": " + message
+			}
+			failing = append(failing, line)
+		}
+	}
+	if ready {
+		return
+	}
+	found.add(SeverityFail, ObjectRef{GroupVersionKind: nodeClaimKind, Name: claim.GetName()}, "NodeClaimNotReady", "Karpenter NodeClaim %s is not Ready %s after it was created", claim.GetName(), age.Round(time.Minute))
+	found.remedy("%s, then check the capacity and quota of the instance types of its NodePool", kubectl("describe", ObjectRef{GroupVersionKind: nodeClaimKind, Name: claim.GetName()}))
+	sort.Strings(failing)
+	for _, f := range failing {
+		found.detail(f)
+	}
+}
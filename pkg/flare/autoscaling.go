@@ -0,0 +1,196 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// hpaStatus is the part of a HorizontalPodAutoscaler the hpa check looks at,
+// common to autoscaling/v2 and autoscaling/v2beta2
+type hpaStatus struct {
+	namespace string
+	name      string
+	current   int32
+	max       int32
+	// notScaling is the ScalingActive=False condition, nil when the HPA is scaling
+	notScaling *hpaCondition
+	// scaleDownWindow is spec.behavior.scaleDown.stabilizationWindowSeconds, nil for the default of 5m
+	scaleDownWindow *int32
+}
+
+type hpaCondition struct {
+	reason  string
+	message string
+}
+
+// Used by the hpa check when Options.HPAFlapThreshold is not set
+const defaultHPAFlapThreshold = 3
+
+// The scale down stabilization window of HPAs that do not set one
+const defaultScaleDownWindow = 300
+
+// Check HorizontalPodAutoscalers stuck at maxReplicas or unable to scale, e.g. because metrics can not be fetched,
+// and HPAs that thrash, scaling up and down again Options.HPAFlapThreshold times within Options.EventsSince
+func checkHPAs(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	flapThreshold := opts.HPAFlapThreshold
+	if flapThreshold <= 0 {
+		flapThreshold = defaultHPAFlapThreshold
+	}
+	var found findings
+	for _, ns := range opts.namespaces() {
+		hpas, err := listHPAs(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting horizontalpodautoscalers: %w", err))
+		}
+		var rescales map[string]hpaRescales
+		if len(hpas) > 0 {
+			if rescales, err = listHPARescales(ctx, clientset, ns, opts.EventsSince); err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+		}
+		for _, h := range hpas {
+			object := ObjectRef{GroupVersionKind: hpaKind, Namespace: h.namespace, Name: h.name}
+			if c := h.notScaling; c != nil {
+				if strings.HasPrefix(c.reason, "FailedGet") {
+					found.add(SeverityFail, object, "MetricsUnavailable", "HorizontalPodAutoscaler %s/%s can not fetch metrics, %s: %s", h.namespace, h.name, c.reason, c.message)
+					found.remedy("Check that metrics-server or the adapter serving the metrics runs, kubectl get apiservices | grep metrics")
+				} else {
+					found.add(SeverityFail, object, "ScalingInactive", "HorizontalPodAutoscaler %s/%s is not scaling, %s: %s", h.namespace, h.name, c.reason, c.message)
+					found.remedy("%s", kubectl("describe", object))
+				}
+				continue
+			}
+			if h.max > 0 && h.current >= h.max {
+				found.add(SeverityWarn, object, "AtMaxReplicas", "HorizontalPodAutoscaler %s/%s is pinned at its maximum of %d replicas", h.namespace, h.name, h.max)
+				found.remedy("Raise spec.maxReplicas of %s or make the workload use fewer resources per replica", object)
+			}
+			r := rescales[h.namespace+"/"+h.name]
+			cycles := r.up
+			if r.down < cycles {
+				cycles = r.down
+			}
+			if cycles < int32(flapThreshold) {
+				continue
+			}
+			window := "in its events"
+			if opts.EventsSince > 0 {
+				window = "within " + opts.EventsSince.String()
+			}
+			found.add(SeverityWarn, object, "Flapping", "HorizontalPodAutoscaler %s/%s scaled up %d and down %d times %s", h.namespace, h.name, r.up, r.down, window)
+			found.remedy("Set spec.behavior.scaleDown.stabilizationWindowSeconds of %s to 300 or more, and review its metric targets with %s", object, kubectl("describe", object))
+			if h.scaleDownWindow != nil && *h.scaleDownWindow < defaultScaleDownWindow {
+				found.detail(fmt.Sprintf("its scale down stabilization window is only %ds, raise spec.behavior.scaleDown.stabilizationWindowSeconds", *h.scaleDownWindow))
+			} else {
+				found.detail("the target of its metrics is likely too close to the usual load, or the metrics are too noisy to scale on")
+			}
+		}
+	}
+	return found.result()
+}
+
+// hpaRescales counts the scale ups and downs of an HPA
+type hpaRescales struct {
+	up, down int32
+}
+
+// Count the scale ups and downs of the HPAs of ns, "namespace/name", by the SuccessfulRescale events
+// seen within since, 0 for all events. Their message says "New size: 4; reason: cpu resource
+// utilization (percentage of request) above target" for a scale up and "below target" for a scale down.
+func listHPARescales(ctx context.Context, clientset kubernetes.Interface, ns string, since time.Duration) (map[string]hpaRescales, error) {
+	rescales := map[string]hpaRescales{}
+	page := v1.ListOptions{FieldSelector: "involvedObject.kind=HorizontalPodAutoscaler", Limit: ListPageSize}
+	for {
+		list, err := clientset.CoreV1().Events(ns).List(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list.Items {
+			if e.InvolvedObject.Kind != "HorizontalPodAutoscaler" || e.Reason != "SuccessfulRescale" {
+				continue
+			}
+			if since > 0 && EventTime(e).Before(time.Now().Add(-since)) {
+				continue
+			}
+			key := e.InvolvedObject.Namespace + "/" + e.InvolvedObject.Name
+			r := rescales[key]
+			switch {
+			case strings.Contains(e.Message, "above target"):
+				r.up += eventCount(e)
+			case strings.Contains(e.Message, "below target"):
+				r.down += eventCount(e)
+			}
+			rescales[key] = r
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return rescales, nil
+}
+
+// List the HPAs of ns with autoscaling/v2, falling back to autoscaling/v2beta2 on clusters older than 1.23
+func listHPAs(ctx context.Context, clientset kubernetes.Interface, ns string) ([]hpaStatus, error) {
+	var hpas []hpaStatus
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(ns).List(ctx, page)
+		if apierrors.IsNotFound(err) {
+			return listHPAsV2beta2(ctx, clientset, ns)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range list.Items {
+			s := hpaStatus{namespace: h.Namespace, name: h.Name, current: h.Status.CurrentReplicas, max: h.Spec.MaxReplicas}
+			if b := h.Spec.Behavior; b != nil && b.ScaleDown != nil {
+				s.scaleDownWindow = b.ScaleDown.StabilizationWindowSeconds
+			}
+			for _, c := range h.Status.Conditions {
+				if c.Type == autoscalingv2.ScalingActive && c.Status == corev1.ConditionFalse {
+					s.notScaling = &hpaCondition{reason: c.Reason, message: c.Message}
+				}
+			}
+			hpas = append(hpas, s)
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return hpas, nil
+}
+
+func listHPAsV2beta2(ctx context.Context, clientset kubernetes.Interface, ns string) ([]hpaStatus, error) {
+	var hpas []hpaStatus
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.AutoscalingV2beta2().HorizontalPodAutoscalers(ns).List(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range list.Items {
+			s := hpaStatus{namespace: h.Namespace, name: h.Name, current: h.Status.CurrentReplicas, max: h.Spec.MaxReplicas}
+			if b := h.Spec.Behavior; b != nil && b.ScaleDown != nil {
+				s.scaleDownWindow = b.ScaleDown.StabilizationWindowSeconds
+			}
+			for _, c := range h.Status.Conditions {
+				if c.Type == autoscalingv2beta2.ScalingActive && c.Status == corev1.ConditionFalse {
+					s.notScaling = &hpaCondition{reason: c.Reason, message: c.Message}
+				}
+			}
+			hpas = append(hpas, s)
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return hpas, nil
+}
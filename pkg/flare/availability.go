@@ -0,0 +1,136 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// replicatedWorkload is a Deployment or StatefulSet with its desired replicas and pod selector
+type replicatedWorkload struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+	replicas  int32
+	selector  labels.Selector
+}
+
+// Check for single points of failure: single replica Deployments and StatefulSets in the critical
+// namespaces, and workloads whose replicas all run on the same node or in the same zone
+func checkAvailability(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	critical := map[string]bool{}
+	for _, ns := range opts.criticalNamespaces() {
+		critical[ns] = true
+	}
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	zones := map[string]string{}
+	zoneCount := map[string]bool{}
+	for _, n := range nodes {
+		if zone := n.Labels[corev1.LabelTopologyZone]; zone != "" {
+			zones[n.Name] = zone
+			zoneCount[zone] = true
+		}
+	}
+
+	var found findings
+	for _, ns := range opts.namespaces() {
+		workloads, err := listReplicatedWorkloads(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(err)
+		}
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for _, w := range workloads {
+			object := ObjectRef{GroupVersionKind: w.gvk, Namespace: w.namespace, Name: w.name}
+			if w.replicas == 1 && critical[w.namespace] {
+				found.add(SeverityWarn, object, "SingleReplica", "%s %s/%s has a single replica", w.gvk.Kind, w.namespace, w.name)
+				found.remedy("%s", kubectl("scale", object, "--replicas=2"))
+			}
+			if w.replicas < 2 {
+				continue
+			}
+			onNodes, inZones := map[string]bool{}, map[string]bool{}
+			for _, pod := range pods {
+				if pod.Namespace != w.namespace || pod.Spec.NodeName == "" || pod.Status.Phase != corev1.PodRunning || !w.selector.Matches(labels.Set(pod.Labels)) {
+					continue
+				}
+				onNodes[pod.Spec.NodeName] = true
+				if zone, ok := zones[pod.Spec.NodeName]; ok {
+					inZones[zone] = true
+				}
+			}
+			switch {
+			case len(onNodes) == 1 && len(nodes) > 1:
+				for node := range onNodes {
+					found.add(SeverityWarn, object, "ReplicasOnOneNode", "%s %s/%s runs all %d replicas on node %s", w.gvk.Kind, w.namespace, w.name, w.replicas, node)
+					found.remedy("Add a podAntiAffinity or topologySpreadConstraints on kubernetes.io/hostname to the pod template of %s", object)
+				}
+			case len(inZones) == 1 && len(zoneCount) > 1:
+				for zone := range inZones {
+					found.add(SeverityWarn, object, "ReplicasInOneZone", "%s %s/%s runs all %d replicas in zone %s", w.gvk.Kind, w.namespace, w.name, w.replicas, zone)
+					found.remedy("Add topologySpreadConstraints on topology.kubernetes.io/zone to the pod template of %s", object)
+				}
+			}
+		}
+	}
+	return found.result()
+}
+
+// List the Deployments and StatefulSets of ns with their replicas and selectors
+func listReplicatedWorkloads(ctx context.Context, clientset kubernetes.Interface, ns string) ([]replicatedWorkload, error) {
+	var workloads []replicatedWorkload
+	add := func(gvk schema.GroupVersionKind, namespace string, name string, replicas *int32, selector *v1.LabelSelector) error {
+		s, err := v1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return fmt.Errorf("invalid selector of %s %s/%s: %w", gvk.Kind, namespace, name, err)
+		}
+		desired := int32(1)
+		if replicas != nil {
+			desired = *replicas
+		}
+		workloads = append(workloads, replicatedWorkload{gvk: gvk, namespace: namespace, name: name, replicas: desired, selector: s})
+		return nil
+	}
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		deployments, err := clientset.AppsV1().Deployments(ns).List(ctx, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting deployments: %w", err)
+		}
+		for _, d := range deployments.Items {
+			if err := add(deploymentKind, d.Namespace, d.Name, d.Spec.Replicas, d.Spec.Selector); err != nil {
+				return nil, err
+			}
+		}
+		if page.Continue = deployments.Continue; page.Continue == "" {
+			break
+		}
+	}
+
+	page = v1.ListOptions{Limit: ListPageSize}
+	for {
+		statefulSets, err := clientset.AppsV1().StatefulSets(ns).List(ctx, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting statefulsets: %w", err)
+		}
+		for _, s := range statefulSets.Items {
+			if err := add(statefulSetKind, s.Namespace, s.Name, s.Spec.Replicas, s.Spec.Selector); err != nil {
+				return nil, err
+			}
+		}
+		if page.Continue = statefulSets.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return workloads, nil
+}
@@ -0,0 +1,109 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Nodes running this percentage of the pods or pod IPs they can hold are reported
+const podCapacityThreshold = 90
+
+// Nodes running more than podDensityFactor times the median pod count of the nodes are reported,
+// when they run at least minDensityPods pods and there are at least minDensityNodes nodes to compare to
+const (
+	podDensityFactor = 2
+	minDensityPods   = 20
+	minDensityNodes  = 3
+)
+
+// Check nodes close to their max-pods allocatable count, nodes whose podCIDR is running out of
+// pod IPs and nodes running far more pods than the rest of the fleet. The podCIDR is only
+// set by CNIs that allocate pod IPs from a per node range, e.g. kubenet, Calico host-local or Flannel.
+func checkNodeCapacity(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	pods, err := opts.snapshot.pods(ctx, clientset, v1.NamespaceAll)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting pods: %w", err))
+	}
+	// Running pods per node, and the ones of them that take an IP of the podCIDR
+	running, podIPs := map[string]int{}, map[string]int{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		running[pod.Spec.NodeName]++
+		if !pod.Spec.HostNetwork {
+			podIPs[pod.Spec.NodeName]++
+		}
+	}
+
+	var found findings
+	var counts []int
+	for _, n := range nodes {
+		object := objectRef(nodeKind, &n)
+		counts = append(counts, running[n.Name])
+		if allocatable := n.Status.Allocatable.Pods().Value(); allocatable > 0 {
+			percent := int64(running[n.Name]) * 100 / allocatable
+			switch {
+			case int64(running[n.Name]) >= allocatable:
+				found.add(SeverityFail, object, "PodCapacityReached", "Node %s runs %d of its %d allocatable pods, new pods can not be scheduled on it", n.Name, running[n.Name], allocatable)
+				found.remedy("Add nodes or raise maxPods in the kubelet configuration of node %s", n.Name)
+			case percent >= podCapacityThreshold:
+				found.add(SeverityWarn, object, "PodCapacityNearlyReached", "Node %s runs %d of its %d allocatable pods (%d%%)", n.Name, running[n.Name], allocatable, percent)
+				found.remedy("Add nodes or raise maxPods in the kubelet configuration of node %s", n.Name)
+			}
+		}
+		if cidr, ips := podCIDRSize(n); ips > 0 {
+			percent := int64(podIPs[n.Name]) * 100 / ips
+			switch {
+			case int64(podIPs[n.Name]) >= ips:
+				found.add(SeverityFail, object, "PodIPsExhausted", "Node %s uses all %d pod IPs of its podCIDR %s, new pods on it fail to get an IP", n.Name, ips, cidr)
+				found.remedy("Add nodes, or give new nodes a larger podCIDR with --node-cidr-mask-size of kube-controller-manager")
+			case percent >= podCapacityThreshold:
+				found.add(SeverityWarn, object, "PodIPsNearlyExhausted", "Node %s uses %d of the %d pod IPs of its podCIDR %s (%d%%)", n.Name, podIPs[n.Name], ips, cidr, percent)
+				found.remedy("Add nodes, or give new nodes a larger podCIDR with --node-cidr-mask-size of kube-controller-manager")
+			}
+		}
+	}
+
+	if len(counts) >= minDensityNodes {
+		sort.Ints(counts)
+		median := counts[len(counts)/2]
+		for _, n := range nodes {
+			if count := running[n.Name]; count >= minDensityPods && count > podDensityFactor*median {
+				found.add(SeverityWarn, objectRef(nodeKind, &n), "HighPodDensity", "Node %s runs %d pods, more than %d times the median of %d pods per node", n.Name, count, podDensityFactor, median)
+				found.remedy("Spread the pods with topologySpreadConstraints, or cordon and drain node %s to rebalance them", n.Name)
+			}
+		}
+	}
+	return found.result()
+}
+
+// The podCIDR of a node and the number of pod IPs it holds, without the network, gateway and
+// broadcast addresses. IPv6 ranges are too large to run out and 0 is returned, as for nodes without a podCIDR.
+func podCIDRSize(n corev1.Node) (string, int64) {
+	cidrs := n.Spec.PodCIDRs
+	if len(cidrs) == 0 && n.Spec.PodCIDR != "" {
+		cidrs = []string{n.Spec.PodCIDR}
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil || network.IP.To4() == nil {
+			continue
+		}
+		ones, bits := network.Mask.Size()
+		if ips := int64(1)<<(bits-ones) - 3; ips > 0 {
+			return cidr, ips
+		}
+	}
+	return "", 0
+}
@@ -0,0 +1,218 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Certificates, CertificateRequests and Challenges get this long to be issued before they are reported
+const certManagerGrace = 15 * time.Minute
+
+// The cert-manager resources
+var (
+	certificateResource        = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	certificateRequestResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificaterequests"}
+	issuerResource             = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "issuers"}
+	clusterIssuerResource      = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+	challengeResource          = schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "challenges"}
+
+	certificateKind        = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+	certificateRequestKind = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "CertificateRequest"}
+	issuerKind             = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Issuer"}
+	clusterIssuerKind      = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "ClusterIssuer"}
+	challengeKind          = schema.GroupVersionKind{Group: "acme.cert-manager.io", Version: "v1", Kind: "Challenge"}
+)
+
+// Check the cert-manager resources: Issuers and ClusterIssuers that are not Ready, expired Certificates
+// and Certificates not Ready for a while, CertificateRequests stuck pending or failed for a Certificate
+// that is still not Ready, and ACME Challenges that failed or stay pending. Clusters without
+// cert-manager pass, cert-manager is only looked at when the Runner has a dynamic client.
+func checkCertManager(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	if opts.dynamic == nil {
+		return found.result()
+	}
+	now := time.Now()
+	for _, ns := range opts.namespaces() {
+		certificates, err := listUnstructured(ctx, opts.dynamic, certificateResource, ns)
+		if apierrors.IsNotFound(err) {
+			return found.result()
+		}
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting certificates: %w", err))
+		}
+		// Certificates that are not Ready, by "ns/name"
+		notReady := map[string]bool{}
+		for i := range certificates {
+			if c := &certificates[i]; !certificateFindings(&found, c, now) {
+				notReady[c.GetNamespace()+"/"+c.GetName()] = true
+			}
+		}
+
+		requests, err := listUnstructured(ctx, opts.dynamic, certificateRequestResource, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting certificaterequests: %w", err))
+		}
+		for i := range requests {
+			request := &requests[i]
+			owner := ""
+			for _, ref := range request.GetOwnerReferences() {
+				if ref.Kind == "Certificate" {
+					owner = request.GetNamespace() + "/" + ref.Name
+				}
+			}
+			certificateRequestFindings(&found, request, owner == "" || notReady[owner], now)
+		}
+
+		issuers, err := listUnstructured(ctx, opts.dynamic, issuerResource, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting issuers: %w", err))
+		}
+		for i := range issuers {
+			issuerFindings(&found, issuerKind, &issuers[i])
+		}
+
+		// Challenges are only installed with ACME support
+		challenges, err := listUnstructured(ctx, opts.dynamic, challengeResource, ns)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errorResult(fmt.Errorf("failed getting challenges: %w", err))
+		}
+		for i := range challenges {
+			challengeFindings(&found, &challenges[i], now)
+		}
+	}
+
+	if len(opts.Namespaces) == 0 {
+		issuers, err := listUnstructured(ctx, opts.dynamic, clusterIssuerResource, v1.NamespaceAll)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting clusterissuers: %w", err))
+		}
+		for i := range issuers {
+			issuerFindings(&found, clusterIssuerKind, &issuers[i])
+		}
+	}
+	return found.result()
+}
+
+// Record a Certificate that expired or has not been Ready for a while, returns whether it is Ready
+func certificateFindings(found *findings, certificate *unstructured.Unstructured, now time.Time) bool {
+	ready := statusCondition(certificate.Object, "Ready")
+	status := ""
+	if ready != nil {
+		status, _ = ready["status"].(string)
+	}
+	if status == "True" {
+		return true
+	}
+	object := objectRef(certificateKind, certificate)
+	notAfter, _, _ := unstructured.NestedString(certificate.Object, "status", "notAfter")
+	if expiry, err := time.Parse(time.RFC3339, notAfter); err == nil && now.After(expiry) {
+		found.add(SeverityFail, object, "CertificateExpired", "Certificate %s/%s expired %s ago and was not renewed", certificate.GetNamespace(), certificate.GetName(), now.Sub(expiry).Round(time.Minute))
+		found.remedy("%s, then cmctl renew %s -n %s once the cause is fixed", kubectl("describe", object), certificate.GetName(), certificate.GetNamespace())
+	} else if since := conditionSince(ready, certificate); now.Sub(since) >= certManagerGrace {
+		found.add(SeverityWarn, object, "CertificateNotReady", "Certificate %s/%s has not been Ready for %s", certificate.GetNamespace(), certificate.GetName(), now.Sub(since).Round(time.Minute))
+		found.remedy("%s", kubectl("describe", object))
+	} else {
+		return false
+	}
+	if ready != nil {
+		found.detail(conditionLine(ready, status))
+	}
+	if issuing := statusCondition(certificate.Object, "Issuing"); issuing != nil {
+		if status, _ := issuing["status"].(string); status == "False" {
+			found.detail(conditionLine(issuing, status))
+		}
+	}
+	return false
+}
+
+// Record a CertificateRequest stuck pending, or denied or failed when its Certificate is still not Ready
+func certificateRequestFindings(found *findings, request *unstructured.Unstructured, certificateNotReady bool, now time.Time) {
+	object := objectRef(certificateRequestKind, request)
+	if denied := statusCondition(request.Object, "Denied"); denied != nil && denied["status"] == "True" {
+		if certificateNotReady {
+			found.add(SeverityWarn, object, "CertificateRequestDenied", "CertificateRequest %s/%s was denied", request.GetNamespace(), request.GetName())
+			found.remedy("Check the approver policy that denied it, then cmctl renew the Certificate of %s", object)
+			found.detail(conditionLine(denied, "True"))
+		}
+		return
+	}
+	ready := statusCondition(request.Object, "Ready")
+	if ready == nil || ready["status"] == "True" {
+		return
+	}
+	status, _ := ready["status"].(string)
+	switch ready["reason"] {
+	case "Failed":
+		if certificateNotReady {
+			found.add(SeverityWarn, object, "CertificateRequestFailed", "CertificateRequest %s/%s failed", request.GetNamespace(), request.GetName())
+			found.remedy("%s", kubectl("describe", object))
+			found.detail(conditionLine(ready, status))
+		}
+	case "Pending":
+		if age := now.Sub(request.GetCreationTimestamp().Time); age >= certManagerGrace {
+			found.add(SeverityWarn, object, "CertificateRequestPending", "CertificateRequest %s/%s is pending for %s", request.GetNamespace(), request.GetName(), age.Round(time.Minute))
+			found.remedy("%s, an unapproved request needs cmctl approve %s -n %s", kubectl("describe", object), request.GetName(), request.GetNamespace())
+			found.detail(conditionLine(ready, status))
+		}
+	}
+}
+
+// Record an Issuer or ClusterIssuer that is not Ready, the certificates it signs can not be issued or renewed
+func issuerFindings(found *findings, kind schema.GroupVersionKind, issuer *unstructured.Unstructured) {
+	ready := statusCondition(issuer.Object, "Ready")
+	status := "Unknown"
+	if ready != nil {
+		status, _ = ready["status"].(string)
+	}
+	if status == "True" {
+		return
+	}
+	object := objectRef(kind, issuer)
+	found.add(SeverityFail, object, "IssuerNotReady", "%s is not Ready, the certificates it signs can not be issued or renewed", object)
+	found.remedy("%s", kubectl("describe", object))
+	if ready != nil {
+		found.detail(conditionLine(ready, status))
+	}
+}
+
+// Record an ACME Challenge that failed, or is still pending after the grace period, e.g. an http-01
+// challenge the ACME server can not reach or a dns-01 record that does not propagate
+func challengeFindings(found *findings, challenge *unstructured.Unstructured, now time.Time) {
+	state, _, _ := unstructured.NestedString(challenge.Object, "status", "state")
+	reason, _, _ := unstructured.NestedString(challenge.Object, "status", "reason")
+	solver, _, _ := unstructured.NestedString(challenge.Object, "spec", "type")
+	domain, _, _ := unstructured.NestedString(challenge.Object, "spec", "dnsName")
+	object := objectRef(challengeKind, challenge)
+	switch state {
+	case "valid", "ready":
+		return
+	case "invalid", "errored", "expired":
+		found.add(SeverityWarn, object, "ChallengeFailed", "Challenge %s/%s (%s for %s) is %s%s", challenge.GetNamespace(), challenge.GetName(), solver, domain, state, suffix(reason))
+		found.remedy("Check that the %s challenge of %s can be solved, e.g. reachable over http or its DNS record propagated, see %s", solver, domain, kubectl("describe", object))
+	default:
+		if age := now.Sub(challenge.GetCreationTimestamp().Time); age >= certManagerGrace {
+			found.add(SeverityWarn, object, "ChallengePending", "Challenge %s/%s (%s for %s) is pending for %s%s", challenge.GetNamespace(), challenge.GetName(), solver, domain, age.Round(time.Minute), suffix(reason))
+			found.remedy("Check that the %s challenge of %s can be solved, e.g. reachable over http or its DNS record propagated, see %s", solver, domain, kubectl("describe", object))
+		}
+	}
+}
+
+// When a condition last changed, the creation of the object when it does not report the condition
+func conditionSince(condition map[string]interface{}, object *unstructured.Unstructured) time.Time {
+	if condition != nil {
+		if changed, ok := condition["lastTransitionTime"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, changed); err == nil {
+				return t
+			}
+		}
+	}
+	return object.GetCreationTimestamp().Time
+}
@@ -0,0 +1,117 @@
+package flare
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Check for expired or soon to expire certificates used by Ingresses, webhooks and the apiserver CA
+// Expired certificates fail the check, those expiring within Options.CertExpiryWindow are warnings
+func checkCertExpiry(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	now := time.Now()
+	var found findings
+	report := func(object ObjectRef, source string, data []byte) {
+		certExpiry(&found, object, source, data, now, opts.CertExpiryWindow)
+	}
+
+	if len(opts.ClusterCA) > 0 {
+		report(ObjectRef{}, "Kubeconfig cluster CA", opts.ClusterCA)
+	}
+
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			ingresses, err := clientset.NetworkingV1().Ingresses(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting ingresses: %w", err))
+			}
+			for _, ingress := range ingresses.Items {
+				for _, tls := range ingress.Spec.TLS {
+					if tls.SecretName == "" {
+						continue
+					}
+					secret, err := clientset.CoreV1().Secrets(ingress.Namespace).Get(ctx, tls.SecretName, v1.GetOptions{})
+					if errors.IsNotFound(err) {
+						// Missing secrets are not an expiry problem
+						continue
+					}
+					if err != nil {
+						return errorResult(fmt.Errorf("failed getting secret %s/%s: %w", ingress.Namespace, tls.SecretName, err))
+					}
+					report(objectRef(secretKind, secret), fmt.Sprintf("Ingress %s/%s TLS secret %s", ingress.Namespace, ingress.Name, tls.SecretName), secret.Data["tls.crt"])
+				}
+			}
+			if page.Continue = ingresses.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	if len(opts.Namespaces) == 0 {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			mutating, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting mutatingwebhooks: %w", err))
+			}
+			for _, config := range mutating.Items {
+				for _, webhook := range config.Webhooks {
+					report(objectRef(mutatingKind, &config), "Mutating Webhook "+webhook.Name+" caBundle", webhook.ClientConfig.CABundle)
+				}
+			}
+			if page.Continue = mutating.Continue; page.Continue == "" {
+				break
+			}
+		}
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			validating, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting validatingwebhooks: %w", err))
+			}
+			for _, config := range validating.Items {
+				for _, webhook := range config.Webhooks {
+					report(objectRef(validatingKind, &config), "Validating Webhook "+webhook.Name+" caBundle", webhook.ClientConfig.CABundle)
+				}
+			}
+			if page.Continue = validating.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
+
+// Record the certificates in a PEM bundle of object that are expired (failures) or expire within window (warnings)
+// Data that is not PEM encoded certificates is ignored
+func certExpiry(found *findings, object ObjectRef, source string, data []byte, now time.Time, window time.Duration) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		expiry := cert.NotAfter.UTC().Format(time.RFC3339)
+		if now.After(cert.NotAfter) {
+			found.add(SeverityFail, object, "CertificateExpired", "%s: certificate %q expired on %s", source, cert.Subject.CommonName, expiry)
+			found.remedy("Renew the certificate and update %s, or let cert-manager manage it", object)
+		} else if cert.NotAfter.Sub(now) < window {
+			found.add(SeverityWarn, object, "CertificateExpiring", "%s: certificate %q expires on %s", source, cert.Subject.CommonName, expiry)
+			found.remedy("Renew the certificate and update %s before it expires, or let cert-manager manage it", object)
+		}
+	}
+}
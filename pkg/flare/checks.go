@@ -0,0 +1,1308 @@
+// Package flare runs checks against a Kubernetes cluster that look for common problems,
+// such as crash looping pods, services without endpoints or expiring certificates.
+//
+//	runner := &flare.Runner{Clientset: clientset}
+//	results, err := runner.Run(ctx, &flare.Options{Namespaces: []string{"shop"}})
+package flare
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Check describes a registered check and the function that runs it
+type Check struct {
+	// ID selects the check on the command line, e.g. --checks endpoints
+	ID string
+	// Name is the title the check is reported under
+	Name        string
+	Description string
+	// Category groups related checks, e.g. nodes or networking
+	Category string
+	// Permissions are the RBAC permissions the check needs to run
+	Permissions []Permission
+	// Severity is what the check reports when it finds a problem
+	Severity Severity
+	// Active checks change the cluster, e.g. by creating a pod, and only run with Options.ActiveProbes
+	Active bool
+	// After checks only start once all other checks of the run finished, e.g. to report on the run itself
+	After bool
+	// Live checks ask the API server itself rather than reading objects, e.g. for its version, and
+	// can not run against exported manifests
+	Live bool
+	Run  func(context.Context, kubernetes.Interface, *Options) Result
+}
+
+// Permission is an RBAC verb on an API resource, as used in a Role rule
+type Permission struct {
+	Verb string
+	// Group is the API group of the resource, "" for the core group
+	Group    string
+	Resource string
+	// Unscoped permissions are only needed when the run is not limited to namespaces
+	Unscoped bool
+	// ClusterScoped permissions are on resources that are not namespaced and always reviewed cluster
+	// wide, for resources missing from clusterScopedResources such as the custom resources of rules
+	ClusterScoped bool
+}
+
+func (p Permission) String() string {
+	if p.Group == "" {
+		return p.Verb + " " + p.Resource
+	}
+	return p.Verb + " " + p.Resource + "." + p.Group
+}
+
+// Shorthand for a list permission on a resource
+func list(group string, resource string) Permission {
+	return Permission{Verb: "list", Group: group, Resource: resource}
+}
+
+// Mark a permission as only needed when the run is not limited to namespaces
+func unscoped(p Permission) Permission {
+	p.Unscoped = true
+	return p
+}
+
+// Options holds the settings shared by all checks
+type Options struct {
+	// Namespaces limits the namespaced checks to these namespaces, empty means all namespaces.
+	// Cluster scoped checks (nodes, overcommit, webhooks) are not affected.
+	Namespaces []string
+	// CertExpiryWindow is how far ahead the certs check warns about expiring certificates
+	CertExpiryWindow time.Duration
+	// ClusterCA is the PEM encoded CA of the apiserver from the kubeconfig, if any
+	ClusterCA []byte
+	// EventsSince limits the events check to events seen within this duration, 0 for all events
+	EventsSince time.Duration
+	// EventIgnore drops events whose "<namespace> <Kind>/<name> <reason>: <message>" line matches
+	EventIgnore []*regexp.Regexp
+	// OvercommitCPUThreshold and OvercommitMemoryThreshold are the percentages of a node's
+	// allocatable resources its pods may request or limit before the overcommit check reports it, default 100
+	OvercommitCPUThreshold    int
+	OvercommitMemoryThreshold int
+	// UtilizationThreshold is the percentage of a node's allocatable CPU or memory in actual use
+	// the utilization check reports, default 90
+	UtilizationThreshold int
+	// ActiveProbes allows the Active checks to run
+	ActiveProbes bool
+	// ProbeImage and ProbeNamespace are the image and namespace of the pods created by active
+	// checks, default busybox:1.35 in the default namespace
+	ProbeImage     string
+	ProbeNamespace string
+	// TerminatingTimeout is how long namespaces, pods past their grace period and objects held by
+	// finalizers may be Terminating before they are reported, defaults to 10 minutes
+	TerminatingTimeout time.Duration
+	// CronJobMissedSchedules is how many schedules in a row a CronJob may miss before the cronjobs
+	// check reports it, defaults to 3
+	CronJobMissedSchedules int
+	// QuotaThreshold is the percentage of a ResourceQuota's hard limit the quota check warns at, defaults to 90
+	QuotaThreshold int
+	// FinishedPodThreshold is the number of Failed and Succeeded pods left behind in a namespace the
+	// finished-pods check warns at, defaults to 50
+	FinishedPodThreshold int
+	// ImageAllowRegistries are the registries whose images the images check does not report, e.g. an
+	// internal registry whose latest tags are immutable
+	ImageAllowRegistries []string
+	// ImageAllowNamespaces are the namespaces whose pods the images check does not report
+	ImageAllowNamespaces []string
+	// PodSecurityExempt are the namespaces whose pods the pod-security check does not report, nil
+	// exempts kube-system and an empty list no namespace
+	PodSecurityExempt []string
+	// PodSecurityLevel is the Pod Security Standards level namespaces are expected to enforce, one of
+	// privileged, baseline or restricted, "" only reports namespaces without any level
+	PodSecurityLevel string
+	// ChurnWindow is how far back the churn check looks, defaults to 10 minutes. EventRateThreshold
+	// is the events per minute of a namespace and PodChurnThreshold the pods a controller creates
+	// and deletes per minute it reports, default 1000 and 10.
+	ChurnWindow        time.Duration
+	EventRateThreshold int
+	PodChurnThreshold  int
+	// HPAFlapThreshold is how many times an HPA may scale up and down again within EventsSince before
+	// the hpa check reports it, defaults to 3
+	HPAFlapThreshold int
+	// NodeFlapThreshold is how many times a node may change between Ready and NotReady within
+	// EventsSince before the node-flapping check reports it, defaults to 3
+	NodeFlapThreshold int
+	// ClockSkewThreshold is how far the clock of a node may be off before the clock-skew check reports
+	// it, defaults to 30s
+	ClockSkewThreshold time.Duration
+	// RestartWindow is how recent a container restart must be for the infra check to report it, defaults to 1h
+	RestartWindow time.Duration
+	// CriticalNamespaces hold the infrastructure pods that must be healthy and the workloads that must
+	// stay available, e.g. kube-system and the ingress controller, CNI and monitoring namespaces.
+	// Defaults to kube-system.
+	CriticalNamespaces []string
+	// Security also reports findings that only matter for hardened clusters, e.g. namespaces without NetworkPolicies
+	Security bool
+	// Ignore suppresses the matching findings, on top of the objects annotated with IgnoreAnnotation
+	Ignore []IgnoreRule
+	// Throttling holds the throttling of the requests of the run, nil when the clientset is not
+	// built from a config instrumented by it
+	Throttling *Throttling
+
+	// snapshot shares pod and node lists between the checks of a run, nil lists every time
+	snapshot *snapshot
+	// dynamic is the Runner's dynamic client for custom resources, nil when it has none
+	dynamic dynamic.Interface
+}
+
+// The namespaces to list namespaced resources from, [""] (all namespaces) when not scoped
+func (o *Options) namespaces() []string {
+	if o == nil || len(o.Namespaces) == 0 {
+		return []string{v1.NamespaceAll}
+	}
+	return o.Namespaces
+}
+
+// The critical namespaces, [kube-system] when none are set
+func (o *Options) criticalNamespaces() []string {
+	if o == nil || len(o.CriticalNamespaces) == 0 {
+		return []string{v1.NamespaceSystem}
+	}
+	return o.CriticalNamespaces
+}
+
+// The namespaces exempt from the pod-security check, [kube-system] when none are set
+func (o *Options) podSecurityExempt() []string {
+	if o == nil || o.PodSecurityExempt == nil {
+		return []string{v1.NamespaceSystem}
+	}
+	return o.PodSecurityExempt
+}
+
+// Whether namespace is one of the namespaces the run is limited to
+func (o *Options) inScope(namespace string) bool {
+	if o == nil || len(o.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range o.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNamespaces splits a comma separated namespace list, ignoring empty entries
+func ParseNamespaces(list string) []string {
+	var namespaces []string
+	for _, ns := range strings.Split(list, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// checks is the registry of all available checks, in the order they are run
+var checks = []Check{
+	{
+		ID:          "api",
+		Name:        "API Responsive",
+		Description: "The control plane apiserver responds to requests",
+		Category:    "control-plane",
+		Severity:    SeverityFail,
+		Live:        true,
+		Run:         checkMasterComponents,
+	},
+	{
+		ID:          "infra",
+		Name:        "Infrastructure Pods Health",
+		Description: "Pods in the critical namespaces, kube-system by default, have no recent container restarts and are ready",
+		Category:    "control-plane",
+		Permissions: []Permission{list("", "pods")},
+		Severity:    SeverityFail,
+		Run:         checkInfraHealth,
+	},
+	{
+		ID:          "nodes",
+		Name:        "Node Healthchecks",
+		Description: "All nodes are Ready without memory, disk, PID or network pressure, cordoned nodes are warnings",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes")},
+		Severity:    SeverityFail,
+		Run:         checkNodes,
+	},
+	{
+		ID:          "node-leases",
+		Name:        "Node Heartbeats",
+		Description: "Ready nodes whose kubelet stopped renewing its Lease in kube-node-lease",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes"), list("coordination.k8s.io", "leases")},
+		Severity:    SeverityFail,
+		Run:         checkNodeLeases,
+	},
+	{
+		ID:          "clock-skew",
+		Name:        "Node Clock Skew",
+		Description: "Ready nodes whose clock is off, by the Lease renew and heartbeat times their kubelet writes, compared with flare's clock and each other",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes"), list("coordination.k8s.io", "leases")},
+		Severity:    SeverityWarn,
+		Run:         checkClockSkew,
+	},
+	{
+		ID:          "node-flapping",
+		Name:        "Node Ready Flapping",
+		Description: "Nodes that turned Ready and NotReady again and again within --events-since, by their events and Ready condition",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes"), list("", "events")},
+		Severity:    SeverityWarn,
+		Run:         checkNodeFlapping,
+	},
+	{
+		ID:          "churn",
+		Name:        "Event Storms and Pod Churn",
+		Description: "Namespaces recording thousands of events per minute and controllers creating and deleting pods in a loop, starving the API server",
+		Category:    "control-plane",
+		Permissions: []Permission{list("", "events"), list("", "pods")},
+		Severity:    SeverityWarn,
+		Run:         checkChurn,
+	},
+	{
+		ID:          "node-autoscaler",
+		Name:        "Node Autoscaler",
+		Description: "Failing scale-ups and nodes stuck provisioning according to cluster-autoscaler or Karpenter, and the pending pods they can not help",
+		Category:    "nodes",
+		Permissions: []Permission{
+			{Verb: "get", Resource: "configmaps"},
+			unscoped(list("karpenter.sh", "nodeclaims")),
+			list("", "events"),
+			list("", "pods"),
+		},
+		Severity: SeverityFail,
+		Run:      checkNodeAutoscaler,
+	},
+	{
+		ID:          "versions",
+		Name:        "Version Skew",
+		Description: "Kubelets newer than the apiserver or more than 3 minor versions behind it and mixed container runtime versions",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes")},
+		Severity:    SeverityFail,
+		Live:        true,
+		Run:         checkVersionSkew,
+	},
+	{
+		ID:          "overcommit",
+		Name:        "Node Overcommit",
+		Description: "Container requests and limits on each node fit in the node's allocatable resources",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes"), list("", "pods")},
+		Severity:    SeverityFail,
+		Run:         checkOverCommit,
+	},
+	{
+		ID:          "capacity",
+		Name:        "Node Pod Capacity",
+		Description: "Nodes close to their max-pods allocatable count or out of podCIDR IPs, and nodes with far more pods than the rest",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes"), list("", "pods")},
+		Severity:    SeverityFail,
+		Run:         checkNodeCapacity,
+	},
+	{
+		ID:          "deprecated-apis",
+		Name:        "Deprecated APIs",
+		Description: "Objects written through API versions that the next Kubernetes releases remove",
+		Category:    "control-plane",
+		// The deprecated resources are listed when readable and ignored otherwise
+		Severity: SeverityFail,
+		Live:     true,
+		Run:      checkDeprecatedAPIs,
+	},
+	{
+		ID:          "api-throttling",
+		Name:        "API Throttling",
+		Description: "Requests of the run throttled by 429 responses or the client rate limiter, and API Priority and Fairness levels rejecting or queueing requests",
+		Category:    "control-plane",
+		// The apiserver metrics are read when flare may get /metrics and ignored otherwise
+		Severity: SeverityWarn,
+		After:    true,
+		Live:     true,
+		Run:      checkThrottling,
+	},
+	{
+		ID:          "etcd",
+		Name:        "etcd Pressure",
+		Description: "Large object counts, ConfigMaps and Secrets close to the 1MiB limit and event floods that grow etcd",
+		Category:    "control-plane",
+		Permissions: []Permission{list("", "pods"), list("", "events"), list("", "secrets"), list("", "configmaps")},
+		Severity:    SeverityWarn,
+		Run:         checkEtcdPressure,
+	},
+	{
+		ID:          "utilization",
+		Name:        "Live Utilization",
+		Description: "Nodes above --utilization-threshold of their allocatable CPU or memory and pods using more memory than requested, from metrics-server",
+		Category:    "nodes",
+		Permissions: []Permission{list("", "nodes"), list("", "pods"), list("metrics.k8s.io", "nodes"), list("metrics.k8s.io", "pods")},
+		Severity:    SeverityWarn,
+		Live:        true,
+		Run:         checkUtilization,
+	},
+	{
+		ID:          "webhooks",
+		Name:        "Webhooks",
+		Description: "Admission webhooks failing closed for kube-system or their own namespace, with long timeouts or a backing Service without ready endpoints",
+		Category:    "admission",
+		Permissions: []Permission{
+			list("admissionregistration.k8s.io", "mutatingwebhookconfigurations"),
+			list("admissionregistration.k8s.io", "validatingwebhookconfigurations"),
+			{Verb: "get", Resource: "services"},
+			{Verb: "get", Resource: "endpoints"},
+			unscoped(list("", "namespaces")),
+		},
+		Severity: SeverityFail,
+		Run:      checkWebhooks,
+	},
+	{
+		ID:          "cni",
+		Name:        "CNI and kube-proxy",
+		Description: "The DaemonSets of the CNI plugin (Calico, Cilium, Flannel, AWS VPC CNI, Weave Net) and kube-proxy are ready and run on every node",
+		Category:    "networking",
+		Permissions: []Permission{list("apps", "daemonsets"), list("", "nodes"), list("", "pods")},
+		Severity:    SeverityFail,
+		Run:         checkCNI,
+	},
+	{
+		ID:          "dns",
+		Name:        "Cluster DNS",
+		Description: "The kube-dns Service has endpoints, CoreDNS pods are Ready without recent restarts and the Corefile is valid",
+		Category:    "networking",
+		Permissions: []Permission{
+			{Verb: "get", Resource: "endpoints"},
+			list("", "pods"),
+			{Verb: "get", Resource: "configmaps"},
+		},
+		Severity: SeverityFail,
+		Run:      checkDNS,
+	},
+	{
+		ID:          "dns-probe",
+		Name:        "DNS Resolution Probe",
+		Description: "A short lived pod resolves a cluster and an external name, needs --active-probes",
+		Category:    "networking",
+		Permissions: []Permission{{Verb: "create", Resource: "pods"}, {Verb: "get", Resource: "pods"}, {Verb: "delete", Resource: "pods"}},
+		Severity:    SeverityFail,
+		Active:      true,
+		Live:        true,
+		Run:         checkDNSProbe,
+	},
+	{
+		ID:          "endpoints",
+		Name:        "Endpoints",
+		Description: "Every service has at least one active endpoint, otherwise whether its selector matches no pods, no Ready pods or a missing targetPort",
+		Category:    "networking",
+		Permissions: []Permission{list("", "endpoints"), {Verb: "get", Resource: "services"}, list("", "pods")},
+		Severity:    SeverityFail,
+		Run:         checkEndpoints,
+	},
+	{
+		ID:          "ingress",
+		Name:        "Load Balancers and Ingresses",
+		Description: "LoadBalancer Services without an address and Ingresses referencing missing Services, IngressClasses or TLS secrets",
+		Category:    "networking",
+		Permissions: []Permission{
+			list("", "services"),
+			list("networking.k8s.io", "ingresses"),
+			list("networking.k8s.io", "ingressclasses"),
+			{Verb: "get", Resource: "services"},
+			{Verb: "get", Resource: "secrets"},
+		},
+		Severity: SeverityFail,
+		Run:      checkIngress,
+	},
+	{
+		ID:          "netpol",
+		Name:        "Network Policies",
+		Description: "Default-deny egress policies that block DNS and the policies isolating pods that are not Ready",
+		Category:    "networking",
+		Permissions: []Permission{list("networking.k8s.io", "networkpolicies"), list("", "pods"), unscoped(list("", "namespaces"))},
+		Severity:    SeverityWarn,
+		Run:         checkNetworkPolicies,
+	},
+	{
+		ID:          "pods",
+		Name:        "Pod Container States",
+		Description: "Containers in CrashLoopBackOff, ImagePullBackOff, ErrImagePull or CreateContainerConfigError",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "pods"), {Verb: "get", Group: "apps", Resource: "replicasets"}, {Verb: "get", Group: "apps", Resource: "deployments"}},
+		Severity:    SeverityFail,
+		Run:         checkPodWaiting,
+	},
+	{
+		ID:          "references",
+		Name:        "Missing Secrets and ConfigMaps",
+		Description: "Pods referencing Secrets, ConfigMaps or keys of them that do not exist, including imagePullSecrets",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "pods"), {Verb: "get", Resource: "secrets"}, {Verb: "get", Resource: "configmaps"}},
+		Severity:    SeverityFail,
+		Run:         checkReferences,
+	},
+	{
+		ID:          "pending",
+		Name:        "Pending Pods",
+		Description: "Pods the scheduler could not place, grouped by the scheduler's reason",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "pods"), list("", "events")},
+		Severity:    SeverityFail,
+		Run:         checkPendingPods,
+	},
+	{
+		ID:          "rollouts",
+		Name:        "Workload Rollouts",
+		Description: "Deployments, StatefulSets and DaemonSets with fewer ready pods than desired or a stalled rollout",
+		Category:    "workloads",
+		Permissions: []Permission{list("apps", "deployments"), list("apps", "statefulsets"), list("apps", "daemonsets")},
+		Severity:    SeverityFail,
+		Run:         checkRollouts,
+	},
+	{
+		ID:          "gitops",
+		Name:        "GitOps Sync Status",
+		Description: "Argo CD Applications that are OutOfSync, Degraded or failed to sync and Flux Kustomizations and HelmReleases failing to reconcile",
+		Category:    "workloads",
+		Permissions: []Permission{
+			list("argoproj.io", "applications"),
+			list("kustomize.toolkit.fluxcd.io", "kustomizations"),
+			list("helm.toolkit.fluxcd.io", "helmreleases"),
+		},
+		Severity: SeverityFail,
+		Run:      checkGitOps,
+	},
+	{
+		ID:          "helm",
+		Name:        "Helm Releases",
+		Description: "Helm v3 releases stuck in a pending state, which block later upgrades, and failed releases",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "secrets")},
+		Severity:    SeverityFail,
+		Run:         checkHelmReleases,
+	},
+	{
+		ID:          "jobs",
+		Name:        "Failed Jobs",
+		Description: "Jobs that failed or exceeded their backoffLimit and Jobs running past their activeDeadlineSeconds, with their failed pods",
+		Category:    "workloads",
+		Permissions: []Permission{list("batch", "jobs"), list("", "pods")},
+		Severity:    SeverityFail,
+		Run:         checkJobs,
+	},
+	{
+		ID:          "cronjobs",
+		Name:        "CronJobs",
+		Description: "CronJobs that missed --cronjob-missed-schedules schedules in a row, are suspended or keep piling up finished Jobs",
+		Category:    "workloads",
+		Permissions: []Permission{list("batch", "cronjobs"), list("batch", "jobs")},
+		Severity:    SeverityFail,
+		Run:         checkCronJobs,
+	},
+	{
+		ID:          "hpa",
+		Name:        "Horizontal Pod Autoscalers",
+		Description: "HPAs pinned at maxReplicas, with ScalingActive=False, failing to fetch metrics or scaling up and down again and again",
+		Category:    "workloads",
+		Permissions: []Permission{list("autoscaling", "horizontalpodautoscalers"), list("", "events")},
+		Severity:    SeverityFail,
+		Run:         checkHPAs,
+	},
+	{
+		ID:          "namespaces",
+		Name:        "Terminating Namespaces",
+		Description: "Namespaces stuck in Terminating and the resources or finalizers blocking their deletion",
+		Category:    "workloads",
+		Permissions: []Permission{unscoped(list("", "namespaces"))},
+		Severity:    SeverityFail,
+		Run:         checkNamespaces,
+	},
+	{
+		ID:          "finalizers",
+		Name:        "Stuck Finalizers",
+		Description: "Pods stuck Terminating past their grace period and objects held by finalizers long after deletion",
+		Category:    "workloads",
+		Permissions: []Permission{
+			list("", "pods"),
+			list("", "persistentvolumeclaims"),
+			unscoped(list("", "persistentvolumes")),
+			list("", "services"),
+			list("", "configmaps"),
+			list("apps", "deployments"),
+			list("batch", "jobs"),
+		},
+		Severity: SeverityWarn,
+		Run:      checkFinalizers,
+	},
+	{
+		ID:          "pdb",
+		Name:        "Pod Disruption Budgets",
+		Description: "PDBs that block node drains or match no pods and critical namespace workloads without a PDB",
+		Category:    "workloads",
+		Permissions: []Permission{list("policy", "poddisruptionbudgets"), list("", "pods"), list("apps", "deployments"), list("apps", "statefulsets")},
+		Severity:    SeverityWarn,
+		Run:         checkPDBs,
+	},
+	{
+		ID:          "availability",
+		Name:        "Single Points of Failure",
+		Description: "Single replica workloads in the critical namespaces and workloads with all replicas on one node or in one zone",
+		Category:    "workloads",
+		Permissions: []Permission{list("apps", "deployments"), list("apps", "statefulsets"), list("", "pods"), list("", "nodes")},
+		Severity:    SeverityWarn,
+		Run:         checkAvailability,
+	},
+	{
+		ID:          "probe-config",
+		Name:        "Health Probe Configuration",
+		Description: "Deployment and StatefulSet containers without readiness or liveness probes, or with liveness probes that restart healthy containers",
+		Category:    "workloads",
+		Permissions: []Permission{list("apps", "deployments"), list("apps", "statefulsets")},
+		Severity:    SeverityWarn,
+		Run:         checkProbeConfig,
+	},
+	{
+		ID:          "quota",
+		Name:        "Resource Quotas",
+		Description: "ResourceQuotas close to their hard limits and compute quotas without LimitRange defaults",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "resourcequotas"), list("", "limitranges")},
+		Severity:    SeverityWarn,
+		Run:         checkQuotas,
+	},
+	{
+		ID:          "certs",
+		Name:        "Certificate Expiry",
+		Description: "Ingress TLS secrets, webhook CA bundles and the kubeconfig cluster CA that are expired or expire soon",
+		Category:    "security",
+		Permissions: []Permission{
+			list("networking.k8s.io", "ingresses"),
+			{Verb: "get", Resource: "secrets"},
+			unscoped(list("admissionregistration.k8s.io", "mutatingwebhookconfigurations")),
+			unscoped(list("admissionregistration.k8s.io", "validatingwebhookconfigurations")),
+		},
+		Severity: SeverityWarn,
+		Run:      checkCertExpiry,
+	},
+	{
+		ID:          "cert-manager",
+		Name:        "cert-manager",
+		Description: "cert-manager Issuers that are not Ready, expired or unready Certificates, stuck CertificateRequests and failing ACME challenges",
+		Category:    "security",
+		Permissions: []Permission{
+			list("cert-manager.io", "certificates"),
+			list("cert-manager.io", "certificaterequests"),
+			list("cert-manager.io", "issuers"),
+			unscoped(list("cert-manager.io", "clusterissuers")),
+			list("acme.cert-manager.io", "challenges"),
+		},
+		Severity: SeverityFail,
+		Run:      checkCertManager,
+	},
+	{
+		ID:          "pod-security",
+		Name:        "Pod Security",
+		Description: "Privileged containers, pods sharing the node's network, PID or IPC namespace, hostPath volumes and containers running as root",
+		Category:    "security",
+		Permissions: []Permission{list("", "pods")},
+		Severity:    SeverityWarn,
+		Run:         checkPodSecurity,
+	},
+	{
+		ID:          "psa-labels",
+		Name:        "Pod Security Admission Labels",
+		Description: "Namespaces without pod-security.kubernetes.io labels, or with levels below the expected one",
+		Category:    "security",
+		Permissions: []Permission{unscoped(list("", "namespaces"))},
+		Severity:    SeverityWarn,
+		Run:         checkPodSecurityLabels,
+	},
+	{
+		ID:          "serviceaccounts",
+		Name:        "ServiceAccount Tokens",
+		Description: "Pods using missing ServiceAccounts or needlessly automounting the default token, and long-lived legacy tokens in use",
+		Category:    "security",
+		Permissions: []Permission{
+			list("", "serviceaccounts"),
+			list("", "pods"),
+			list("", "secrets"),
+			list("rbac.authorization.k8s.io", "rolebindings"),
+			unscoped(list("rbac.authorization.k8s.io", "clusterrolebindings")),
+		},
+		Severity: SeverityFail,
+		Run:      checkServiceAccounts,
+	},
+	{
+		ID:          "rbac",
+		Name:        "RBAC Bindings",
+		Description: "Bindings to missing roles or deleted ServiceAccounts and non-system subjects granted cluster-admin",
+		Category:    "security",
+		Permissions: []Permission{
+			list("rbac.authorization.k8s.io", "clusterroles"),
+			unscoped(list("rbac.authorization.k8s.io", "clusterrolebindings")),
+			list("rbac.authorization.k8s.io", "roles"),
+			list("rbac.authorization.k8s.io", "rolebindings"),
+			list("", "serviceaccounts"),
+		},
+		Severity: SeverityWarn,
+		Run:      checkRBAC,
+	},
+	{
+		ID:          "leftovers",
+		Name:        "Leftover Pods and Jobs",
+		Description: "Evicted pods and completed Jobs that are never cleaned up",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "pods"), list("batch", "jobs")},
+		Severity:    SeverityWarn,
+		Run:         checkLeftovers,
+	},
+	{
+		ID:          "orphans",
+		Name:        "Orphaned Resources",
+		Description: "ReplicaSets, Endpoints and EndpointSlices whose owner is gone, Services selecting no workload, piled up ReplicaSets and Released PVs retaining their storage",
+		Category:    "workloads",
+		Permissions: []Permission{
+			list("", "services"),
+			list("apps", "replicasets"),
+			list("apps", "deployments"),
+			list("apps", "statefulsets"),
+			list("apps", "daemonsets"),
+			list("", "pods"),
+			list("", "endpoints"),
+			list("discovery.k8s.io", "endpointslices"),
+			unscoped(list("", "persistentvolumes")),
+		},
+		Severity: SeverityWarn,
+		Run:      checkOrphans,
+	},
+	{
+		ID:          "finished-pods",
+		Name:        "Finished Pod Accumulation",
+		Description: "Namespaces with many Failed or Succeeded pods left behind, Evicted pods are reported by leftovers",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "pods")},
+		Severity:    SeverityWarn,
+		Run:         checkFinishedPods,
+	},
+	{
+		ID:          "images",
+		Name:        "Image Tags",
+		Description: "Containers using the latest tag or no tag, and controllers always pulling large images",
+		Category:    "workloads",
+		Permissions: []Permission{list("", "pods"), list("", "nodes")},
+		Severity:    SeverityWarn,
+		Run:         checkImages,
+	},
+	{
+		ID:          "storage",
+		Name:        "Storage",
+		Description: "PVCs stuck Pending, PVs Failed or Released and pods failing to mount volumes",
+		Category:    "storage",
+		Permissions: []Permission{list("", "persistentvolumeclaims"), unscoped(list("", "persistentvolumes")), list("", "events")},
+		Severity:    SeverityFail,
+		Run:         checkStorage,
+	},
+	{
+		ID:          "events",
+		Name:        "Events",
+		Description: "Warning events recorded in the cluster",
+		Category:    "events",
+		Permissions: []Permission{list("", "events")},
+		Severity:    SeverityWarn,
+		Run:         checkEvents,
+	},
+	{
+		ID:          "registries",
+		Name:        "Image Registries",
+		Description: "Registries failing the image pulls of many pods, or pulling slowly, according to the pull events",
+		Category:    "events",
+		Permissions: []Permission{list("", "events")},
+		Severity:    SeverityFail,
+		Run:         checkRegistries,
+	},
+}
+
+// Checks returns the registered checks, in the order they are run
+func Checks() []Check {
+	return append([]Check(nil), checks...)
+}
+
+// CheckIDs returns the ids of all registered checks
+func CheckIDs() []string {
+	ids := make([]string, len(checks))
+	for i, c := range checks {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+// Register adds checks to the registry, erroring on ids that are already registered.
+// It is not safe to call while checks are running.
+func Register(extra ...Check) error {
+	for _, c := range extra {
+		for _, existing := range checks {
+			if existing.ID == c.ID {
+				return fmt.Errorf("check %q is already registered", c.ID)
+			}
+		}
+		checks = append(checks, c)
+	}
+	return nil
+}
+
+// Split a comma separated list of check ids, erroring on ids that are not registered
+func parseCheckIDs(list string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	if strings.TrimSpace(list) == "" {
+		return ids, nil
+	}
+	known := map[string]bool{}
+	for _, c := range checks {
+		known[c.ID] = true
+	}
+	for _, id := range strings.Split(list, ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if !known[id] {
+			return nil, fmt.Errorf("unknown check %q, valid checks are: %s", id, strings.Join(CheckIDs(), ", "))
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// SelectChecks selects the checks to run from comma separated lists of ids. An empty
+// `only` list selects every check, ids in `skip` are then removed. The registry order is kept.
+func SelectChecks(only string, skip string) ([]Check, error) {
+	onlyIDs, err := parseCheckIDs(only)
+	if err != nil {
+		return nil, err
+	}
+	skipIDs, err := parseCheckIDs(skip)
+	if err != nil {
+		return nil, err
+	}
+	var selected []Check
+	for _, c := range checks {
+		if len(onlyIDs) > 0 && !onlyIDs[c.ID] {
+			continue
+		}
+		if skipIDs[c.ID] {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no checks selected")
+	}
+	return selected, nil
+}
+
+// findings collects the findings of a check, result builds its Result from them
+type findings []Finding
+
+// Record a finding about object, the message is formatted like fmt.Sprintf
+func (f *findings) add(severity Severity, object ObjectRef, reason string, format string, args ...interface{}) {
+	*f = append(*f, Finding{
+		Object:   object,
+		Reason:   reason,
+		Severity: severity,
+		Message:  strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"),
+	})
+}
+
+// Append an indented line, e.g. a probable cause, to the message of the last finding
+func (f findings) detail(line string) {
+	if len(f) > 0 {
+		f[len(f)-1].Message += "\n  " + line
+	}
+}
+
+// Set the next step to fix the last finding, formatted like fmt.Sprintf, e.g. a kubectl command to run
+func (f findings) remedy(format string, args ...interface{}) {
+	if len(f) > 0 {
+		f[len(f)-1].Remediation = fmt.Sprintf(format, args...)
+	}
+}
+
+// The kubectl command running verb on the object of a finding, e.g. "kubectl describe pod api -n shop".
+// Kinds of custom resources, whose group is not a Kubernetes one, are qualified with their group.
+func kubectl(verb string, o ObjectRef, flags ...string) string {
+	kind := strings.ToLower(o.Kind)
+	if strings.Contains(o.Group, ".") && !strings.HasSuffix(o.Group, ".k8s.io") {
+		kind += "." + o.Group
+	}
+	command := "kubectl " + verb + " " + kind + " " + o.Name
+	if o.Namespace != "" {
+		command += " -n " + o.Namespace
+	}
+	for _, flag := range flags {
+		command += " " + flag
+	}
+	return command
+}
+
+// Build the Result of a check from its findings, failures are listed before warnings and
+// fail the check, no findings is a pass
+func (f findings) result() Result {
+	var failures, warnings []Finding
+	for _, finding := range f {
+		if finding.Severity == SeverityFail {
+			failures = append(failures, finding)
+		} else {
+			warnings = append(warnings, finding)
+		}
+	}
+	ordered := append(failures, warnings...)
+	details := ""
+	for _, finding := range ordered {
+		details += finding.Message + "\n"
+	}
+	severity := SeverityWarn
+	if len(failures) > 0 {
+		severity = SeverityFail
+	}
+	r := findingsResult(details, severity)
+	r.Findings = ordered
+	return r
+}
+
+// The ObjectRef of a typed object, whose kind is not set when it comes from a List
+func objectRef(gvk schema.GroupVersionKind, obj v1.Object) ObjectRef {
+	return ObjectRef{GroupVersionKind: gvk, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
+
+// The kinds findings refer to
+var (
+	podKind            = corev1.SchemeGroupVersion.WithKind("Pod")
+	nodeKind           = corev1.SchemeGroupVersion.WithKind("Node")
+	serviceKind        = corev1.SchemeGroupVersion.WithKind("Service")
+	namespaceKind      = corev1.SchemeGroupVersion.WithKind("Namespace")
+	secretKind         = corev1.SchemeGroupVersion.WithKind("Secret")
+	configMapKind      = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	pvcKind            = corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim")
+	pvKind             = corev1.SchemeGroupVersion.WithKind("PersistentVolume")
+	quotaKind          = corev1.SchemeGroupVersion.WithKind("ResourceQuota")
+	bindingKind        = rbacv1.SchemeGroupVersion.WithKind("RoleBinding")
+	clusterBindingKind = rbacv1.SchemeGroupVersion.WithKind("ClusterRoleBinding")
+	deploymentKind     = appsv1.SchemeGroupVersion.WithKind("Deployment")
+	replicaSetKind     = appsv1.SchemeGroupVersion.WithKind("ReplicaSet")
+	statefulSetKind    = appsv1.SchemeGroupVersion.WithKind("StatefulSet")
+	daemonSetKind      = appsv1.SchemeGroupVersion.WithKind("DaemonSet")
+	jobKind            = batchv1.SchemeGroupVersion.WithKind("Job")
+	cronJobKind        = batchv1.SchemeGroupVersion.WithKind("CronJob")
+	ingressKind        = networkingv1.SchemeGroupVersion.WithKind("Ingress")
+	netpolKind         = networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy")
+	pdbKind            = policyv1.SchemeGroupVersion.WithKind("PodDisruptionBudget")
+	hpaKind            = autoscalingv2.SchemeGroupVersion.WithKind("HorizontalPodAutoscaler")
+	mutatingKind       = admissionv1.SchemeGroupVersion.WithKind("MutatingWebhookConfiguration")
+	validatingKind     = admissionv1.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration")
+)
+
+/* These check functions accept a context, an authenticated clientset object and the run Options
+and look for specific issues in the cluster. The context carries the check's deadline and must be
+passed to every API call. They all follow the same argument and return signatures and return a
+Result, the Name and Duration are filled in by the runner:
+
+ Issues are recorded with findings.add, one Finding per problem object with a reason code and
+ a SeverityWarn or SeverityFail severity, and the check returns findings.result(). No findings is a pass.
+ If the check could not be completed, e.g. an API call failed, they return errorResult(err).
+*/
+
+// Build the Result of a check from its findings, no findings is a pass
+func findingsResult(info string, severity Severity) Result {
+	if info == "" {
+		return Result{Pass: true, Severity: SeverityInfo}
+	}
+	return Result{Pass: false, Severity: severity, Details: info}
+}
+
+// Build the Result of a check that could not be completed
+func errorResult(err error) Result {
+	return Result{Pass: false, Severity: SeverityFail, Err: err}
+}
+
+// Build the Result of a check with nothing to look at in the namespaces the run is limited to,
+// reported as skipped like the checks missing permissions
+func skippedResult(format string, args ...interface{}) Result {
+	return Result{Skipped: true, Severity: SeverityInfo, Details: "Skipped, " + fmt.Sprintf(format, args...) + "\n"}
+}
+
+// Used by the overcommit check when the Options thresholds are not set
+const defaultOvercommitThreshold = 100
+
+// Check nodes whose pods request or limit more CPU or memory than the thresholds allow,
+// as percentages of the node's allocatable resources. Requests above the threshold fail the
+// check since the node can not honour them, limits above it only warn.
+func checkOverCommit(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	cpuThreshold, memThreshold := int64(opts.OvercommitCPUThreshold), int64(opts.OvercommitMemoryThreshold)
+	if cpuThreshold <= 0 {
+		cpuThreshold = defaultOvercommitThreshold
+	}
+	if memThreshold <= 0 {
+		memThreshold = defaultOvercommitThreshold
+	}
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	pods, err := opts.snapshot.pods(ctx, clientset, v1.NamespaceAll)
+	if err != nil {
+		return errorResult(fmt.Errorf("failure to get pod list: %w", err))
+	}
+	podsByNode := map[string][]corev1.Pod{}
+	for _, pod := range pods {
+		// Finished pods no longer hold their resources
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
+	}
+	var found findings
+	for _, n := range nodes {
+		requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+		for _, pod := range podsByNode[n.Name] {
+			addResources(requests, podResources(pod, func(r corev1.ResourceRequirements) corev1.ResourceList { return r.Requests }))
+			addResources(limits, podResources(pod, func(r corev1.ResourceRequirements) corev1.ResourceList { return r.Limits }))
+		}
+		for _, c := range []struct {
+			name      corev1.ResourceName
+			label     string
+			threshold int64
+		}{{corev1.ResourceCPU, "CPU", cpuThreshold}, {corev1.ResourceMemory, "memory", memThreshold}} {
+			allocatable := n.Status.Allocatable[c.name]
+			if allocatable.IsZero() {
+				continue
+			}
+			if used := requests[c.name]; percentOf(used, allocatable) > c.threshold {
+				found.add(SeverityFail, objectRef(nodeKind, &n), "RequestsOvercommitted", "Node %s is overcommitted on %s requests: %s of %s allocatable (%d%%)", n.Name, c.label, used.String(), allocatable.String(), percentOf(used, allocatable))
+				found.remedy("Lower the %s requests of the pods on node %s or add nodes, kubectl describe node %s lists them", c.label, n.Name, n.Name)
+			}
+			if used := limits[c.name]; percentOf(used, allocatable) > c.threshold {
+				found.add(SeverityWarn, objectRef(nodeKind, &n), "LimitsOvercommitted", "Node %s is overcommitted on %s limits: %s of %s allocatable (%d%%)", n.Name, c.label, used.String(), allocatable.String(), percentOf(used, allocatable))
+				found.remedy("Lower the %s limits of the pods on node %s closer to their requests, kubectl describe node %s lists them", c.label, n.Name, n.Name)
+			}
+		}
+	}
+	return found.result()
+}
+
+// The resources of a pod as the scheduler counts them: the sum over its containers, or the
+// largest init container when that is higher. get picks requests or limits.
+func podResources(pod corev1.Pod, get func(corev1.ResourceRequirements) corev1.ResourceList) corev1.ResourceList {
+	total := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResources(total, get(container.Resources))
+	}
+	for _, container := range pod.Spec.InitContainers {
+		for name, q := range get(container.Resources) {
+			if current, ok := total[name]; !ok || q.Cmp(current) > 0 {
+				total[name] = q.DeepCopy()
+			}
+		}
+	}
+	return total
+}
+
+// Add the quantities of add to total
+func addResources(total corev1.ResourceList, add corev1.ResourceList) {
+	for name, q := range add {
+		current := total[name]
+		current.Add(q)
+		total[name] = current
+	}
+}
+
+// used as a percentage of allocatable
+func percentOf(used resource.Quantity, allocatable resource.Quantity) int64 {
+	return used.MilliValue() * 100 / allocatable.MilliValue()
+}
+
+// Check if any services have no endpoints
+// The selector and ports of those services are compared with the pods of their namespace to say why.
+func checkEndpoints(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			endpoints, err := clientset.CoreV1().Endpoints(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failure to get endpoints: %w", err))
+			}
+			for _, e := range endpoints.Items {
+				if len(e.Subsets) < 1 {
+					found.add(SeverityFail, ObjectRef{GroupVersionKind: serviceKind, Namespace: e.Namespace, Name: e.Name}, "NoEndpoints", "Service %s has no active endpoints!", e.Name)
+					found.remedy("Check that the selector of the service matches the labels of Ready pods, kubectl describe service %s -n %s", e.Name, e.Namespace)
+					causes, err := endpointCauses(ctx, clientset, opts, e.Namespace, e.Name)
+					if err != nil {
+						return errorResult(err)
+					}
+					for _, cause := range causes {
+						found.detail(cause)
+					}
+				}
+			}
+			if page.Continue = endpoints.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	return found.result()
+}
+
+// Why the service of an empty Endpoints object has no endpoints, nothing for Endpoints without a Service
+func endpointCauses(ctx context.Context, clientset kubernetes.Interface, opts *Options, namespace string, name string) ([]string, error) {
+	svc, err := clientset.CoreV1().Services(namespace).Get(ctx, name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed getting service %s/%s: %w", namespace, name, err)
+	}
+	pods, err := opts.podsIn(ctx, clientset, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failure to get pod list: %w", err)
+	}
+	return ServiceEndpointCauses(svc, pods), nil
+}
+
+// Check if any events are showing warnings
+// Events are grouped by namespace, object and reason with the total number of occurrences and the latest message
+func checkEvents(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	type eventKey struct {
+		namespace, object, reason string
+	}
+	objects := map[eventKey]ObjectRef{}
+	var order []eventKey
+	counts := map[eventKey]int32{}
+	messages := map[eventKey]string{}
+	latest := map[eventKey]time.Time{}
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{FieldSelector: "type=Warning", Limit: ListPageSize}
+		for {
+			output, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			for _, event := range output.Items {
+				if event.Type != "Warning" {
+					continue
+				}
+				seen := EventTime(event)
+				if opts.EventsSince > 0 && seen.Before(time.Now().Add(-opts.EventsSince)) {
+					continue
+				}
+				key := eventKey{event.Namespace, event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name, event.Reason}
+				if ignoredEvent(opts.EventIgnore, fmt.Sprintf("%s %s %s: %s", key.namespace, key.object, key.reason, event.Message)) {
+					continue
+				}
+				if _, ok := counts[key]; !ok {
+					order = append(order, key)
+					involved := event.InvolvedObject
+					objects[key] = ObjectRef{GroupVersionKind: schema.FromAPIVersionAndKind(involved.APIVersion, involved.Kind), Namespace: involved.Namespace, Name: involved.Name}
+				}
+				counts[key] += eventCount(event)
+				if !seen.Before(latest[key]) {
+					latest[key] = seen
+					messages[key] = event.Message
+				}
+			}
+			if page.Continue = output.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	var found findings
+	for _, key := range order {
+		found.add(SeverityWarn, objects[key], key.reason, "%s %s %s (x%d): %s", key.namespace, key.object, key.reason, counts[key], messages[key])
+		if objects[key].Name != "" {
+			found.remedy("%s", kubectl("describe", objects[key]))
+		}
+	}
+	return found.result()
+}
+
+// EventTime is the last time an event was seen, falling back to older fields that are not always set
+func EventTime(event corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// How many times an event occurred, events that were recorded once have no count
+func eventCount(event corev1.Event) int32 {
+	if event.Series != nil && event.Series.Count > event.Count {
+		return event.Series.Count
+	}
+	if event.Count < 1 {
+		return 1
+	}
+	return event.Count
+}
+
+// Whether an event line matches any of the ignore patterns
+func ignoredEvent(patterns []*regexp.Regexp, line string) bool {
+	for _, p := range patterns {
+		if p.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Node conditions that are a problem when True
+var nodePressureConditions = []corev1.NodeConditionType{
+	corev1.NodeMemoryPressure,
+	corev1.NodeDiskPressure,
+	corev1.NodePIDPressure,
+	corev1.NodeNetworkUnavailable,
+}
+
+// What to do about each of nodePressureConditions
+var nodePressureRemedies = map[corev1.NodeConditionType]string{
+	corev1.NodeMemoryPressure:     "Lower the memory limits of the pods on the node or move some of them away",
+	corev1.NodeDiskPressure:       "Free disk space on the node, e.g. remove unused images with crictl rmi --prune or rotate large logs",
+	corev1.NodePIDPressure:        "Find the pods forking too many processes on the node and set a pod PID limit",
+	corev1.NodeNetworkUnavailable: "Check the network plugin pods on the node",
+}
+
+// Check for nodes in UnReady status or under pressure, and warn about cordoned nodes
+func checkNodes(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	for _, node := range nodes {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == "Ready" {
+				if condition.Status == "False" {
+					found.add(SeverityFail, objectRef(nodeKind, &node), "NodeNotReady", "Node: %s is NotReady", node.Name)
+					found.remedy("kubectl describe node %s, then check the kubelet and container runtime on it, e.g. journalctl -u kubelet", node.Name)
+				}
+			}
+		}
+		for _, t := range nodePressureConditions {
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == t && condition.Status == corev1.ConditionTrue {
+					found.add(SeverityFail, objectRef(nodeKind, &node), string(t), "Node: %s has %s: %s", node.Name, t, condition.Message)
+					found.remedy("%s, see kubectl describe node %s", nodePressureRemedies[t], node.Name)
+				}
+			}
+		}
+		if node.Spec.Unschedulable {
+			found.add(SeverityWarn, objectRef(nodeKind, &node), "NodeCordoned", "Node: %s is cordoned%s", node.Name, cordonedFor(node))
+			found.remedy("kubectl uncordon %s once its maintenance is done", node.Name)
+		}
+	}
+	return found.result()
+}
+
+// " for <duration>" since the node was cordoned, from the time its unschedulable taint was added
+func cordonedFor(node corev1.Node) string {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == corev1.TaintNodeUnschedulable && taint.TimeAdded != nil {
+			return " for " + time.Since(taint.TimeAdded.Time).Round(time.Minute).String()
+		}
+	}
+	return ""
+}
+
+// Used by the infra check when Options.RestartWindow is not set
+const defaultRestartWindow = time.Hour
+
+// Check whether there are pods with unready containers or containers that restarted within
+// Options.RestartWindow in the critical namespaces. Restarts are reported as a rate over the life
+// of the pod, so a container restarting once a week is told apart from one crash looping. Runs
+// limited to namespaces only look at the critical namespaces among them.
+func checkInfraHealth(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	window := opts.RestartWindow
+	if window <= 0 {
+		window = defaultRestartWindow
+	}
+	now := time.Now()
+	var found findings
+	checked := 0
+	for _, ns := range opts.criticalNamespaces() {
+		if !opts.inScope(ns) {
+			continue
+		}
+		checked++
+		pods, err := opts.podsIn(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting %s pods: %w", ns, err))
+		}
+		for _, pod := range pods {
+			for _, container := range pod.Status.ContainerStatuses {
+				if last, ok := lastRestart(container); ok && now.Sub(last) <= window {
+					found.add(SeverityFail, objectRef(podKind, &pod), "ContainerRestarted", "Container restarts Detected! Pod: %s/%s  container: %s restarted %s ago, %s", pod.Namespace, pod.GetName(), container.Name, now.Sub(last).Round(time.Minute), restartRate(&pod, container, now))
+					found.remedy("kubectl logs %s -n %s -c %s --previous", pod.Name, pod.Namespace, container.Name)
+				}
+				if !container.Ready {
+					found.add(SeverityFail, objectRef(podKind, &pod), "ContainerNotReady", "Container 'Not Ready' Detected! Pod: %s/%s  in container: %s", pod.Namespace, pod.GetName(), container.Name)
+					found.remedy("%s", kubectl("describe", objectRef(podKind, &pod)))
+				}
+			}
+		}
+	}
+	if checked == 0 {
+		return skippedResult("none of the critical namespaces %s is one of the namespaces of the run", strings.Join(opts.criticalNamespaces(), ", "))
+	}
+	return found.result()
+}
+
+// When a restarted container last restarted: when its previous instance finished, or when the
+// current one started if the kubelet no longer knows the previous one
+func lastRestart(c corev1.ContainerStatus) (time.Time, bool) {
+	if c.RestartCount == 0 {
+		return time.Time{}, false
+	}
+	if t := c.LastTerminationState.Terminated; t != nil && !t.FinishedAt.IsZero() {
+		return t.FinishedAt.Time, true
+	}
+	if r := c.State.Running; r != nil && !r.StartedAt.IsZero() {
+		return r.StartedAt.Time, true
+	}
+	return time.Time{}, false
+}
+
+// The restarts of a container per hour since its pod started, at least over an hour, e.g. "4 restarts, 0.5/h"
+func restartRate(pod *corev1.Pod, c corev1.ContainerStatus, now time.Time) string {
+	hours := 1.0
+	if start := pod.Status.StartTime; start != nil && now.Sub(start.Time) > time.Hour {
+		hours = now.Sub(start.Time).Hours()
+	}
+	return fmt.Sprintf("%d restarts, %.1f/h", c.RestartCount, float64(c.RestartCount)/hours)
+}
+
+// Check that the apiserver responds
+// The version endpoint is readable by every authenticated user, so this also works without cluster wide RBAC
+func checkMasterComponents(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	_, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return errorResult(fmt.Errorf("connectivity failure: %w", err))
+	}
+	return findingsResult("", SeverityFail)
+}
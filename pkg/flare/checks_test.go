@@ -0,0 +1,2233 @@
+package flare
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	restfake "k8s.io/client-go/rest/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStorage(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "db"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "logs", Namespace: "db"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-old"},
+			Status:     corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "web.1", Namespace: "db"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web", Namespace: "db"},
+			Type:           "Warning",
+			Reason:         "FailedMount",
+			Message:        "Unable to attach or mount volumes",
+		},
+	)
+	r := checkStorage(context.Background(), clientset, &Options{})
+	if r.Pass || r.Err != nil {
+		t.Fatalf("Expected storage findings but got %+v", r)
+	}
+	for _, expected := range []string{"PVC db/data is Pending", "PV pv-old is Released", "Pod db/web FailedMount"} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "logs") {
+		t.Errorf("Bound PVC should not be reported: %q", r.Details)
+	}
+	expected := []Finding{
+		{Object: ObjectRef{GroupVersionKind: pvcKind, Namespace: "db", Name: "data"}, Reason: "Pending", Severity: SeverityFail, Message: "PVC db/data is Pending",
+			Remediation: "kubectl describe pvc data -n db, check that its StorageClass exists and its provisioner runs"},
+		{Object: ObjectRef{GroupVersionKind: pvKind, Name: "pv-old"}, Reason: "Released", Severity: SeverityFail, Message: "PV pv-old is Released ",
+			Remediation: "kubectl describe pv pv-old, a Released volume needs its claimRef removed or the volume deleted"},
+		{Object: ObjectRef{GroupVersionKind: podKind, Namespace: "db", Name: "web"}, Reason: "FailedMount", Severity: SeverityFail, Message: "Pod db/web FailedMount: Unable to attach or mount volumes",
+			Remediation: "kubectl describe pod web -n db, then check the CSI driver of the volume and the node it runs on"},
+	}
+	if !reflect.DeepEqual(r.Findings, expected) {
+		t.Errorf("Expected findings %+v but got %+v", expected, r.Findings)
+	}
+}
+
+func TestPodWaiting(t *testing.T) {
+	waiting := func(name string, reason string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{Name: name, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waiting("app", "CrashLoopBackOff"), waiting("proxy", "ImagePullBackOff")}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "batch"},
+			Status:     corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{waiting("migrate", "CreateContainerConfigError")}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "starting", Namespace: "shop"},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waiting("app", "ContainerCreating")}},
+		},
+	)
+	r := checkPodWaiting(context.Background(), clientset, &Options{})
+	if r.Pass {
+		t.Fatalf("Expected failing containers to be reported")
+	}
+	for _, expected := range []string{"Namespace batch: 1 failing containers", "Namespace shop: 2 failing containers", "Pod shop/api container proxy is in ImagePullBackOff"} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "starting") {
+		t.Errorf("ContainerCreating should not be reported: %q", r.Details)
+	}
+}
+
+func TestPendingPods(t *testing.T) {
+	unschedulable := func(name string, message string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodPending,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable", Message: message}},
+			},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		unschedulable("api-1", "0/3 nodes are available: 3 Insufficient cpu."),
+		unschedulable("api-2", "0/3 nodes are available: 3 Insufficient cpu."),
+		unschedulable("db-0", ""),
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "db-0.1", Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "db-0", Namespace: "shop"},
+			Reason:         "FailedScheduling",
+			Message:        "0/3 nodes are available: 3 node(s) had untolerated taint {dedicated: db}.",
+			LastTimestamp:  metav1.NewTime(time.Now().Add(-5 * time.Minute)),
+		},
+		// The fake clientset ignores the reason field selector, older and other events are left out
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "db-0.2", Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "db-0", Namespace: "shop"},
+			Reason:         "Scheduled",
+			Message:        "Successfully assigned shop/db-0 to node-1",
+			LastTimestamp:  metav1.NewTime(time.Now()),
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "db-0.3", Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "db-0", Namespace: "shop"},
+			Reason:         "FailedScheduling",
+			Message:        "0/3 nodes are available: 3 Insufficient memory.",
+			LastTimestamp:  metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+	)
+	r := checkPendingPods(context.Background(), clientset, &Options{})
+	if r.Pass {
+		t.Fatalf("Expected unschedulable pods to be reported")
+	}
+	for _, expected := range []string{
+		"2 unschedulable pods: 0/3 nodes are available: 3 Insufficient cpu.\n    api-1, api-2",
+		"1 unschedulable pods: 0/3 nodes are available: 3 node(s) had untolerated taint {dedicated: db}.",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+}
+
+func TestRollouts(t *testing.T) {
+	three := int32(3)
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &three},
+			Status: appsv1.DeploymentStatus{
+				AvailableReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{{
+					Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse,
+					Reason: "ProgressDeadlineExceeded", Message: `ReplicaSet "web-5d4" has timed out progressing.`,
+				}},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "healthy", Namespace: "shop"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &three},
+			Status:     appsv1.DeploymentStatus{AvailableReplicas: 3},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "shop"},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &three},
+			Status:     appsv1.StatefulSetStatus{ReadyReplicas: 2},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "monitoring"},
+			Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 5, NumberReady: 4},
+		},
+	)
+	r := checkRollouts(context.Background(), clientset, &Options{})
+	for _, expected := range []string{
+		"Deployment shop/web has 1/3 available replicas",
+		"Deployment shop/web rollout is stalled",
+		"StatefulSet shop/db has 2/3 ready replicas",
+		"DaemonSet monitoring/agent has 4/5 ready pods",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "healthy") {
+		t.Errorf("Healthy deployment should not be reported: %q", r.Details)
+	}
+}
+
+// Generate a PEM encoded self signed certificate valid until notAfter
+func testCert(t *testing.T, commonName string, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed generating key " + err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed creating certificate " + err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertExpiry(t *testing.T) {
+	now := time.Now()
+	clientset := fake.NewSimpleClientset(
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "web"},
+			Spec:       networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{{SecretName: "shop-tls"}, {SecretName: "missing"}}},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop-tls", Namespace: "web"},
+			Data:       map[string][]byte{"tls.crt": testCert(t, "shop.example.com", now.Add(10*24*time.Hour))},
+		},
+	)
+	opts := &Options{CertExpiryWindow: 30 * 24 * time.Hour}
+	r := checkCertExpiry(context.Background(), clientset, opts)
+	if r.Severity != SeverityWarn || !strings.Contains(r.Details, `Ingress web/shop TLS secret shop-tls: certificate "shop.example.com" expires on`) {
+		t.Errorf("Expected an expiry warning but got %+v", r)
+	}
+
+	opts.ClusterCA = testCert(t, "kubernetes", now.Add(-time.Hour))
+	r = checkCertExpiry(context.Background(), clientset, opts)
+	if r.Severity != SeverityFail || !strings.Contains(r.Details, `Kubeconfig cluster CA: certificate "kubernetes" expired on`) {
+		t.Errorf("Expected an expired CA failure but got %+v", r)
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "ci"}},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "admins"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "cluster-admin"},
+			Subjects: []rbacv1.Subject{
+				{Kind: "Group", Name: "system:masters"},
+				{Kind: "User", Name: "alice"},
+				{Kind: "ServiceAccount", Name: "deployer", Namespace: "ci"},
+			},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "old-operator"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "operator"},
+			Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: "operator", Namespace: "ops"}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "ci"},
+			RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "reader"},
+		},
+	)
+	r := checkRBAC(context.Background(), clientset, &Options{})
+	for _, expected := range []string{
+		"ClusterRoleBinding admins grants cluster-admin to User alice",
+		"ClusterRoleBinding admins grants cluster-admin to ServiceAccount ci/deployer",
+		"ClusterRoleBinding old-operator is bound to missing ClusterRole operator",
+		"ClusterRoleBinding old-operator references deleted ServiceAccount ops/operator",
+		"RoleBinding ci/reader is bound to missing Role reader",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "system:masters") {
+		t.Errorf("System subjects should not be reported: %q", r.Details)
+	}
+}
+
+func TestLeftovers(t *testing.T) {
+	finished := func(name string, age time.Duration, owners ...metav1.OwnerReference) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "batch", OwnerReferences: owners},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(time.Now().Add(-age))},
+			}},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "shop"},
+			Status:     corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted", Message: "The node was low on resource: memory."},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-2", Namespace: "shop"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		finished("migrate", 72*time.Hour),
+		finished("recent", time.Hour),
+		finished("nightly-123", 72*time.Hour, metav1.OwnerReference{Kind: "CronJob", Name: "nightly"}),
+	)
+	r := checkLeftovers(context.Background(), clientset, &Options{})
+	if r.Pass || r.Severity != SeverityWarn {
+		t.Fatalf("Expected leftovers to be a warning, got %+v", r)
+	}
+	for _, expected := range []string{"Pod shop/api-1 was evicted", "Job batch/migrate completed"} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	for _, unexpected := range []string{"api-2", "recent", "nightly"} {
+		if strings.Contains(r.Details, unexpected) {
+			t.Errorf("%s should not be reported: %q", unexpected, r.Details)
+		}
+	}
+	if len(r.Fixes) != 2 {
+		t.Fatalf("Expected a fix per finding, got %d", len(r.Fixes))
+	}
+}
+
+func TestFinishedPods(t *testing.T) {
+	pod := func(namespace, name string, phase corev1.PodPhase, reason string, owners ...metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, OwnerReferences: owners},
+			Status:     corev1.PodStatus{Phase: phase, Reason: reason},
+		}
+	}
+	job := metav1.OwnerReference{Kind: "Job", Name: "migrate"}
+	clientset := fake.NewSimpleClientset(
+		pod("shop", "api-1", corev1.PodFailed, "Evicted"),
+		pod("shop", "api-2", corev1.PodFailed, "Evicted"),
+		pod("shop", "api-3", corev1.PodFailed, "Error"),
+		pod("shop", "api-5", corev1.PodFailed, "Error"),
+		pod("shop", "debug", corev1.PodSucceeded, ""),
+		pod("shop", "api-4", corev1.PodRunning, ""),
+		pod("batch", "migrate-1", corev1.PodFailed, "", job),
+		pod("batch", "migrate-2", corev1.PodFailed, "", job),
+		pod("batch", "migrate-3", corev1.PodFailed, "", job),
+		pod("web", "debug", corev1.PodSucceeded, ""),
+	)
+	r := checkFinishedPods(context.Background(), clientset, &Options{FinishedPodThreshold: 3})
+	// Evicted pods are left to the leftovers check
+	expected := "Namespace shop has 3 finished pods left behind: 2 failed, 1 succeeded\n  2 evicted pods are not counted, the leftovers check reports them\n"
+	if r.Pass || r.Severity != SeverityWarn || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+	if remedy := r.Findings[0].Remediation; remedy != "kubectl delete pods -n shop api-3 api-5 debug, or flare check --fix" {
+		t.Errorf("Expected the remediation to name the counted pods, got %q", remedy)
+	}
+	if len(r.Fixes) != 1 || r.Fixes[0].Description != "Delete 3 finished pods in namespace shop" {
+		t.Fatalf("Expected a fix for the namespace, got %+v", r.Fixes)
+	}
+	if kind := r.Fixes[0].Backup.GetObjectKind().GroupVersionKind().Kind; kind != "List" {
+		t.Errorf("Expected the pods to be backed up as a List, got %s", kind)
+	}
+	if err := r.Fixes[0].Apply(context.Background(), clientset); err != nil {
+		t.Fatal(err)
+	}
+	pods, _ := clientset.CoreV1().Pods("shop").List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 3 {
+		t.Errorf("Expected the evicted and running pods to be left, got %+v", pods.Items)
+	}
+	if r := checkFinishedPods(context.Background(), clientset, &Options{FinishedPodThreshold: 3}); !r.Pass {
+		t.Errorf("Expected the check to pass after the fix, got %+v", r)
+	}
+}
+
+func TestImages(t *testing.T) {
+	pod := func(namespace, name string, owner *metav1.OwnerReference, containers ...corev1.Container) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.PodSpec{Containers: containers},
+		}
+		if owner != nil {
+			p.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return p
+	}
+	controller := true
+	rs := &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", Controller: &controller}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{Images: []corev1.ContainerImage{
+			{Names: []string{"docker.io/library/python:3.10"}, SizeBytes: 900 * 1024 * 1024},
+		}},
+	}
+	clientset := fake.NewSimpleClientset(node,
+		pod("shop", "api-7d9f-a", rs,
+			corev1.Container{Name: "api", Image: "python:3.10", ImagePullPolicy: corev1.PullAlways},
+			corev1.Container{Name: "proxy", Image: "envoyproxy/envoy"}),
+		pod("shop", "api-7d9f-b", rs,
+			corev1.Container{Name: "api", Image: "python:3.10", ImagePullPolicy: corev1.PullAlways},
+			corev1.Container{Name: "proxy", Image: "envoyproxy/envoy"}),
+		pod("shop", "debug", nil, corev1.Container{Name: "shell", Image: "busybox:latest", ImagePullPolicy: corev1.PullAlways}),
+		pod("shop", "pinned", nil, corev1.Container{Name: "app", Image: "nginx@sha256:0123"}),
+		pod("shop", "internal", nil, corev1.Container{Name: "app", Image: "registry.internal:5000/app:latest"}),
+		pod("sandbox", "scratch", nil, corev1.Container{Name: "app", Image: "alpine"}),
+	)
+	r := checkImages(context.Background(), clientset, &Options{ImageAllowRegistries: []string{"registry.internal:5000"}, ImageAllowNamespaces: []string{"sandbox"}})
+	expected := "ReplicaSet shop/api-7d9f container api pulls the 900MiB image python:3.10 on every pod start, use imagePullPolicy IfNotPresent with a pinned tag\n" +
+		"ReplicaSet shop/api-7d9f container proxy uses envoyproxy/envoy without a tag, which pulls latest, pin a version\n" +
+		"Pod shop/debug container shell uses busybox:latest, pin a version so restarts do not pick up a different image\n"
+	if r.Pass || r.Severity != SeverityWarn || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+	if tag := imageTag("localhost:5000/app"); tag != "" {
+		t.Errorf("Expected the registry port not to count as a tag, got %q", tag)
+	}
+}
+
+func TestPodSecurity(t *testing.T) {
+	privileged, root, user := true, int64(0), int64(1000)
+	controller := true
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent-x1", Namespace: "monitoring", OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent", Controller: &controller}}},
+			Spec: corev1.PodSpec{
+				HostNetwork: true,
+				HostPID:     true,
+				Volumes:     []corev1.Volume{{Name: "proc", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/proc"}}}},
+				Containers:  []corev1.Container{{Name: "agent", SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent-x2", Namespace: "monitoring", OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent", Controller: &controller}}},
+			Spec:       corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{{Name: "agent"}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+			Spec: corev1.PodSpec{
+				SecurityContext: &corev1.PodSecurityContext{},
+				Containers: []corev1.Container{
+					{Name: "app"},
+					{Name: "sidecar", SecurityContext: &corev1.SecurityContext{RunAsUser: &root}},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec:       corev1.PodSpec{SecurityContext: &corev1.PodSecurityContext{RunAsUser: &user}, Containers: []corev1.Container{{Name: "app"}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy-x1", Namespace: "kube-system"},
+			Spec:       corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{{Name: "kube-proxy", SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}}},
+		},
+	)
+	r := checkPodSecurity(context.Background(), clientset, &Options{})
+	expected := "DaemonSet monitoring/agent shares the network and PID namespaces of its node\n" +
+		"DaemonSet monitoring/agent mounts the host paths /proc\n" +
+		"DaemonSet monitoring/agent container agent is privileged, it has full access to its node\n" +
+		"Pod shop/api container app runs as root, set runAsUser or runAsNonRoot\n" +
+		"Pod shop/api container sidecar runs as root, set runAsUser or runAsNonRoot\n"
+	if r.Pass || r.Severity != SeverityWarn || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+	if r := checkPodSecurity(context.Background(), clientset, &Options{Namespaces: []string{"kube-system"}, PodSecurityExempt: []string{}}); r.Pass {
+		t.Errorf("Expected kube-system to be reported without exemptions, got %+v", r)
+	}
+}
+
+func TestPodSecurityLabels(t *testing.T) {
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	clientset := fake.NewSimpleClientset(
+		namespace("kube-system", nil),
+		namespace("legacy", nil),
+		namespace("shop", map[string]string{"pod-security.kubernetes.io/enforce": "restricted"}),
+		namespace("tools", map[string]string{"pod-security.kubernetes.io/enforce": "privileged", "pod-security.kubernetes.io/warn": "baseline"}),
+		namespace("web", map[string]string{"pod-security.kubernetes.io/warn": "restricted", "pod-security.kubernetes.io/audit": "strict"}),
+	)
+	r := checkPodSecurityLabels(context.Background(), clientset, &Options{})
+	expected := "Namespace legacy has no pod-security.kubernetes.io labels, only the cluster default applies\n" +
+		"Namespace web has the unknown audit level \"strict\"\n"
+	if r.Pass || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+	r = checkPodSecurityLabels(context.Background(), clientset, &Options{PodSecurityLevel: "baseline"})
+	expected = "Namespace legacy has no pod-security.kubernetes.io labels, only the cluster default applies\n" +
+		"Namespace tools has the enforce level privileged, less restrictive than the expected baseline\n" +
+		"Namespace web does not enforce a pod security level, expected baseline\n" +
+		"Namespace web has the unknown audit level \"strict\"\n"
+	if r.Pass || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+	if r := checkPodSecurityLabels(context.Background(), clientset, &Options{PodSecurityLevel: "strict"}); r.Err == nil {
+		t.Errorf("Expected an error for an unknown level, got %+v", r)
+	}
+}
+
+func TestServiceAccounts(t *testing.T) {
+	controller, no := true, false
+	today := time.Now().Format("2006-01-02")
+	clientset := fake.NewSimpleClientset(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "shop"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "shop"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "ci"}},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "ci-default", Namespace: "ci"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "ci", Name: "default"}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "shop", OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", Controller: &controller}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-2", Namespace: "shop", OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", Controller: &controller}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "quiet", Namespace: "shop"},
+			Spec:       corev1.PodSpec{AutomountServiceAccountToken: &no},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "shop"},
+			Spec:       corev1.PodSpec{ServiceAccountName: "worker"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "deploy", Namespace: "shop"},
+			Spec: corev1.PodSpec{
+				ServiceAccountName: "deployer",
+				Volumes:            []corev1.Volume{{Name: "token", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "deployer-token-x7k2"}}}},
+			},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "runner", Namespace: "ci"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "deployer-token-x7k2", Namespace: "shop", Annotations: map[string]string{corev1.ServiceAccountNameKey: "deployer"}},
+			Type:       corev1.SecretTypeServiceAccountToken,
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ci-token", Namespace: "ci", Annotations: map[string]string{corev1.ServiceAccountNameKey: "default"}, Labels: map[string]string{legacyTokenLastUsedLabel: today}},
+			Type:       corev1.SecretTypeServiceAccountToken,
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "old-token", Namespace: "ci", Annotations: map[string]string{corev1.ServiceAccountNameKey: "default"}, Labels: map[string]string{legacyTokenLastUsedLabel: "2021-03-01"}},
+			Type:       corev1.SecretTypeServiceAccountToken,
+		},
+	)
+	r := checkServiceAccounts(context.Background(), clientset, &Options{})
+	expected := "Pod shop/worker uses ServiceAccount worker which does not exist, its new pods are rejected\n" +
+		"ReplicaSet shop/api-7d9f mounts the token of the default ServiceAccount, which no binding grants anything, set automountServiceAccountToken: false\n" +
+		"Secret ci/ci-token is a long-lived token of ServiceAccount default last used on " + today + ", move its clients to short-lived tokens\n" +
+		"Secret shop/deployer-token-x7k2 is a long-lived token of ServiceAccount deployer mounted by Pod shop/deploy, use a projected token instead\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestProbeConfig(t *testing.T) {
+	httpGet := func(path string) corev1.ProbeHandler {
+		return corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromInt(8080)}}
+	}
+	deployment := func(name string, containers ...corev1.Container) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: containers}}},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		deployment("api", corev1.Container{
+			Name:           "app",
+			ReadinessProbe: &corev1.Probe{ProbeHandler: httpGet("/ready")},
+			LivenessProbe:  &corev1.Probe{ProbeHandler: httpGet("/healthz"), PeriodSeconds: 2, FailureThreshold: 3},
+		}),
+		deployment("web", corev1.Container{
+			Name:           "app",
+			ReadinessProbe: &corev1.Probe{ProbeHandler: httpGet("/status")},
+			LivenessProbe:  &corev1.Probe{ProbeHandler: httpGet("/status"), InitialDelaySeconds: 30},
+		}, corev1.Container{Name: "proxy", ReadinessProbe: &corev1.Probe{ProbeHandler: httpGet("/ready")}}),
+		deployment("worker", corev1.Container{Name: "app"}),
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "shop"},
+			Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:           "postgres",
+				ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(5432)}}},
+				LivenessProbe:  &corev1.Probe{ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(5432)}}},
+				StartupProbe:   &corev1.Probe{ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(5432)}}},
+			}}}}},
+		},
+	)
+	r := checkProbeConfig(context.Background(), clientset, &Options{})
+	expected := "Deployment shop/api container app is restarted after failing its liveness probe for 6s from the start, add a startupProbe or an initialDelaySeconds\n" +
+		"Deployment shop/web container app uses its readiness check as liveness probe, a slow endpoint restarts the container instead of only marking it unready\n" +
+		"Deployment shop/web container proxy has no liveness probe\n" +
+		"Deployment shop/worker container app has no readiness or liveness probe\n"
+	if r.Pass || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	fail, ignore := admissionv1.Fail, admissionv1.Ignore
+	timeout := int32(30)
+	rules := func(group, resource string) []admissionv1.RuleWithOperations {
+		return []admissionv1.RuleWithOperations{{
+			Operations: []admissionv1.OperationType{admissionv1.Create},
+			Rule:       admissionv1.Rule{APIGroups: []string{group}, APIVersions: []string{"*"}, Resources: []string{resource}},
+		}}
+	}
+	excludeSystem := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"kube-system", "policy"}},
+	}}
+	service := func(namespace, name string) admissionv1.WebhookClientConfig {
+		return admissionv1.WebhookClientConfig{Service: &admissionv1.ServiceReference{Namespace: namespace, Name: name}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+			Webhooks: []admissionv1.ValidatingWebhook{
+				{Name: "validate.policy.example.com", FailurePolicy: &fail, TimeoutSeconds: &timeout, ClientConfig: service("policy", "webhook"), Rules: rules("", "pods")},
+				{Name: "deployments.policy.example.com", FailurePolicy: &fail, ClientConfig: service("policy", "webhook"), Rules: rules("apps", "deployments"), NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "shop"}}},
+				{Name: "scoped.policy.example.com", FailurePolicy: &fail, ClientConfig: service("policy", "webhook"), Rules: rules("", "*"), NamespaceSelector: excludeSystem},
+				{Name: "audit.policy.example.com", FailurePolicy: &ignore, ClientConfig: service("policy", "gone")},
+			},
+		},
+		&admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "inject"},
+			Webhooks:   []admissionv1.MutatingWebhook{{Name: "inject.example.com", FailurePolicy: &ignore, ClientConfig: service("mesh", "injector")}},
+		},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "policy"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "shop"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "policy"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "policy"}, Subsets: []corev1.EndpointSubset{{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "injector", Namespace: "mesh"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "injector", Namespace: "mesh"}, Subsets: []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}}}}},
+	)
+	r := checkWebhooks(context.Background(), clientset, &Options{})
+	expected := "Validating Webhook: validate.policy.example.com fails closed for pods in its own namespace policy, when its backend is down it can block its own pods from being recreated\n" +
+		"Validating Webhook: validate.policy.example.com calls Service policy/webhook which has no ready endpoints, the requests it matches are rejected\n" +
+		"Validating Webhook: deployments.policy.example.com calls Service policy/webhook which has no ready endpoints, the requests it matches are rejected\n" +
+		"Validating Webhook: scoped.policy.example.com calls Service policy/webhook which has no ready endpoints, the requests it matches are rejected\n" +
+		"Validating Webhook: validate.policy.example.com fails closed for pods in kube-system, when its backend is down the cluster add-ons can not recover\n" +
+		"Validating Webhook: validate.policy.example.com fails closed after waiting up to 30s, a hanging backend stalls every request it matches\n" +
+		"Validating Webhook: audit.policy.example.com calls Service policy/gone which does not exist, the requests it matches are admitted without it\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestNodeLeases(t *testing.T) {
+	node := func(name string, ready corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}}},
+		}
+	}
+	lease := func(name string, renewed time.Duration) *coordinationv1.Lease {
+		duration := int32(40)
+		renewTime := metav1.NewMicroTime(time.Now().Add(-renewed))
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-node-lease"},
+			Spec:       coordinationv1.LeaseSpec{LeaseDurationSeconds: &duration, RenewTime: &renewTime},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		node("node-1", corev1.ConditionTrue), lease("node-1", 5*time.Second),
+		node("node-2", corev1.ConditionTrue), lease("node-2", 2*time.Minute),
+		node("node-3", corev1.ConditionFalse), lease("node-3", time.Hour),
+		node("node-4", corev1.ConditionTrue),
+	)
+	r := checkNodeLeases(context.Background(), clientset, &Options{})
+	expected := "Node node-2 is Ready but its kubelet last renewed its Lease 2m0s ago, over its 40s lease duration, it is about to turn NotReady\n" +
+		"Node node-4 has no Lease in kube-node-lease, its kubelet does not send heartbeats\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	now := time.Now()
+	node := func(name string, heartbeat time.Time) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue, LastHeartbeatTime: metav1.NewTime(heartbeat)}}},
+		}
+	}
+	lease := func(name string, renewed time.Time) *coordinationv1.Lease {
+		duration := int32(40)
+		renewTime := metav1.NewMicroTime(renewed)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-node-lease"},
+			Spec:       coordinationv1.LeaseSpec{LeaseDurationSeconds: &duration, RenewTime: &renewTime},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		node("node-1", now.Add(-time.Minute)), lease("node-1", now.Add(-5*time.Second)),
+		node("node-2", now), lease("node-2", now.Add(2*time.Minute)),
+		node("node-3", now.Add(-time.Minute)), lease("node-3", now.Add(-5*time.Minute)),
+		// Without a Lease only heartbeats from the future tell
+		node("node-4", now.Add(time.Hour)),
+		node("node-5", now.Add(-time.Hour)),
+	)
+	r := checkClockSkew(context.Background(), clientset, &Options{})
+	if r.Pass || r.Severity != SeverityWarn || len(r.Findings) != 3 {
+		t.Fatalf("Expected 3 skewed nodes, got %+v", r)
+	}
+	for i, expected := range []string{"Node node-2 clock is 2m0s ahead", "Node node-3 clock is about 4m50s behind", "Node node-4 clock is 1h0m0s ahead"} {
+		if f := r.Findings[i]; f.Object.Name != fmt.Sprintf("node-%d", i+2) || f.Reason != "ClockSkewed" || !strings.HasPrefix(f.Message, expected) {
+			t.Errorf("Expected %q, got %+v", expected, f)
+		}
+	}
+
+	// A snapshot analyzed an hour later, every node is behind flare's clock by as much
+	old := now.Add(-time.Hour)
+	clientset = fake.NewSimpleClientset(
+		node("node-1", old), lease("node-1", old),
+		node("node-2", old), lease("node-2", old.Add(-2*time.Second)),
+		node("node-3", old), lease("node-3", old.Add(-3*time.Minute)),
+	)
+	r = checkClockSkew(context.Background(), clientset, &Options{ClockSkewThreshold: time.Minute})
+	if len(r.Findings) != 2 || r.Findings[0].Reason != "ClientClockSkewed" || !strings.Contains(r.Findings[0].Message, "off by 1h0m2s") || r.Findings[1].Object.Name != "node-3" {
+		t.Errorf("Expected flare's clock and node-3 to be reported, got %+v", r)
+	}
+}
+
+func TestNodeFlapping(t *testing.T) {
+	now := time.Now()
+	node := func(name string, ready corev1.ConditionStatus, transition time.Time) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready, LastTransitionTime: metav1.NewTime(transition)}}},
+		}
+	}
+	event := func(name string, node string, reason string, count int32, seen time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: node},
+			Reason:         reason,
+			Count:          count,
+			LastTimestamp:  metav1.NewTime(seen),
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		node("node-1", corev1.ConditionTrue, now.Add(-5*time.Minute)),
+		event("node-1.1", "node-1", "NodeNotReady", 2, now.Add(-10*time.Minute)),
+		event("node-1.2", "node-1", "NodeReady", 2, now.Add(-5*time.Minute)),
+		// Down for good is left to the nodes check
+		node("node-2", corev1.ConditionFalse, now.Add(-30*time.Minute)),
+		event("node-2.1", "node-2", "NodeNotReady", 1, now.Add(-30*time.Minute)),
+		// Flapped before the window
+		node("node-3", corev1.ConditionTrue, now.Add(-3*time.Hour)),
+		event("node-3.1", "node-3", "NodeNotReady", 5, now.Add(-3*time.Hour)),
+		event("node-3.2", "node-3", "NodeReady", 5, now.Add(-3*time.Hour)),
+	)
+	r := checkNodeFlapping(context.Background(), clientset, &Options{EventsSince: time.Hour})
+	if r.Pass || r.Severity != SeverityWarn || len(r.Findings) != 1 {
+		t.Fatalf("Expected node-1 to be flapping, got %+v", r)
+	}
+	expected := "Node node-1 changed between Ready and NotReady 4 times within 1h0m0s, last at " + now.Add(-5*time.Minute).UTC().Format(time.RFC3339) + ", and is Ready now"
+	if f := r.Findings[0]; f.Object.Name != "node-1" || f.Reason != "NodeFlapping" || !strings.HasPrefix(f.Message, expected) {
+		t.Errorf("Expected %q, got %+v", expected, f)
+	}
+
+	// Without a window every event counts
+	r = checkNodeFlapping(context.Background(), clientset, &Options{NodeFlapThreshold: 10})
+	if len(r.Findings) != 1 || r.Findings[0].Object.Name != "node-3" {
+		t.Errorf("Expected node-3 to reach a threshold of 10 in all events, got %+v", r)
+	}
+}
+
+func TestChurn(t *testing.T) {
+	now := time.Now()
+	event := func(name string, kind string, object string, reason string, count int32, first, last time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: kind, APIVersion: "apps/v1", Namespace: "shop", Name: object},
+			Reason:         reason,
+			Count:          count,
+			FirstTimestamp: metav1.NewTime(first),
+			LastTimestamp:  metav1.NewTime(last),
+		}
+	}
+	controller := true
+	pod := func(name string, owner string, created time.Time) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "batch",
+			CreationTimestamp: metav1.NewTime(created),
+			OwnerReferences:   []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: owner, Controller: &controller}},
+		}}
+	}
+	objects := []runtime.Object{
+		// 20000 BackOff events over the last 10m, half of the 40000 seen over 20m, and the 290 of the ReplicaSet
+		event("api.1", "Pod", "api", "BackOff", 40000, now.Add(-20*time.Minute), now),
+		event("api-5d4.1", "ReplicaSet", "api-5d4", "SuccessfulCreate", 150, now.Add(-10*time.Minute), now),
+		event("api-5d4.2", "ReplicaSet", "api-5d4", "SuccessfulDelete", 140, now.Add(-10*time.Minute), now),
+		// A rollout an hour ago
+		event("web-7f8.1", "ReplicaSet", "web-7f8", "SuccessfulCreate", 300, now.Add(-time.Hour), now.Add(-50*time.Minute)),
+	}
+	for i := 0; i < 120; i++ {
+		objects = append(objects, pod(fmt.Sprintf("report-%d", i), "report", now.Add(-time.Duration(i)*time.Second)))
+	}
+	objects = append(objects, pod("nightly-1", "nightly", now.Add(-time.Minute)))
+	clientset := fake.NewSimpleClientset(objects...)
+
+	r := checkChurn(context.Background(), clientset, &Options{})
+	expected := "Namespace shop recorded 2029 events per minute over the last 10m0s, mostly BackOff of Pod api\n" +
+		"ReplicaSet shop/api-5d4 created 150 and deleted 140 pods over the last 10m0s, 29.0 per minute, it is likely stuck in a create, crash and delete loop\n" +
+		"Job batch/report created 120 and deleted 0 pods over the last 10m0s, 12.0 per minute, it is likely stuck in a create, crash and delete loop\n"
+	if r.Pass || r.Severity != SeverityWarn || r.Details != expected {
+		t.Fatalf("Expected %q, got %+v", expected, r)
+	}
+	if reasons := []string{r.Findings[0].Reason, r.Findings[1].Reason, r.Findings[2].Reason}; strings.Join(reasons, ",") != "EventStorm,PodChurn,PodChurn" {
+		t.Errorf("Unexpected reasons %v", reasons)
+	}
+
+	r = checkChurn(context.Background(), clientset, &Options{EventRateThreshold: 5000, PodChurnThreshold: 50})
+	if !r.Pass {
+		t.Errorf("Expected no findings with higher thresholds, got %+v", r)
+	}
+}
+
+func TestNodeAutoscaler(t *testing.T) {
+	status := `Cluster-autoscaler status at 2022-03-01 10:00:00 +0000 UTC:
+Cluster-wide:
+  Health:      Healthy (ready=4 unready=0 notStarted=0 longNotStarted=0 registered=4 longUnregistered=0)
+  ScaleUp:     InProgress (ready=4 registered=4)
+
+NodeGroups:
+  Name:        general
+  Health:      Healthy (ready=3 unready=0 notStarted=0 longNotStarted=0 registered=3 longUnregistered=0 cloudProviderTarget=3 (minSize=1, maxSize=10))
+  ScaleUp:     NoActivity (ready=3 cloudProviderTarget=3)
+
+  Name:        gpu
+  Health:      Unhealthy (ready=1 unready=0 notStarted=0 longNotStarted=2 registered=1 longUnregistered=0 cloudProviderTarget=3 (minSize=0, maxSize=3))
+  ScaleUp:     Backoff (ready=1 cloudProviderTarget=3)
+`
+	old := metav1.NewTime(time.Now().Add(-time.Hour))
+	clientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cluster-autoscaler-status", Namespace: "kube-system"}, Data: map[string]string{"status": status}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "train", Namespace: "ml"}, Status: corev1.PodStatus{Phase: corev1.PodPending}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}, Spec: corev1.PodSpec{NodeName: "node-1"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "train.1", Namespace: "ml"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "ml", Name: "train"},
+			Reason:         "NotTriggerScaleUp",
+			Message:        "pod didn't trigger scale-up: 1 max node group size reached",
+			LastTimestamp:  metav1.Now(),
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "api.1", Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "api"},
+			Reason:         "NotTriggerScaleUp",
+			Message:        "pod didn't trigger scale-up: 1 node(s) didn't match Pod's node affinity",
+			LastTimestamp:  old,
+		},
+	)
+	claim := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "karpenter.sh/v1",
+		"kind":       "NodeClaim",
+		"metadata":   map[string]interface{}{"name": "default-x7k2", "creationTimestamp": old.UTC().Format(time.RFC3339)},
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Launched", "status": "False", "reason": "InsufficientCapacityError", "message": "all offerings are unavailable"},
+			map[string]interface{}{"type": "Ready", "status": "Unknown"},
+		}},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "karpenter.sh", Version: "v1", Resource: "nodeclaims"}: "NodeClaimList",
+	}, claim)
+	r := checkNodeAutoscaler(context.Background(), clientset, &Options{dynamic: dynamicClient})
+	expected := "Cluster autoscaler: node group gpu is Unhealthy (ready=1 unready=0 notStarted=0 longNotStarted=2 registered=1 longUnregistered=0 cloudProviderTarget=3 (minSize=0, maxSize=3))\n" +
+		"Cluster autoscaler: node group gpu has 2 nodes that did not start and 0 that did not register for a long time\n" +
+		"Cluster autoscaler: node group gpu backs off scaling up after failed scale-ups\n" +
+		"Karpenter NodeClaim default-x7k2 is not Ready 1h0m0s after it was created\n" +
+		"  Launched=False InsufficientCapacityError: all offerings are unavailable\n" +
+		"  Ready=Unknown\n" +
+		"Pod ml/train is pending and the autoscaler will not add a node for it: pod didn't trigger scale-up: 1 max node group size reached\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+	if r := checkNodeAutoscaler(context.Background(), fake.NewSimpleClientset(), &Options{}); !r.Pass {
+		t.Errorf("Expected clusters without an autoscaler to pass, got %+v", r)
+	}
+}
+
+func TestRegistries(t *testing.T) {
+	n := 0
+	event := func(pod, reason, message string) *corev1.Event {
+		n++
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: fmt.Sprintf("e%d", n), Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: pod},
+			Reason:         reason,
+			Message:        message,
+			LastTimestamp:  metav1.Now(),
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		event("api-1", "Failed", `Failed to pull image "registry.example.com/api:1.2": rpc error: code = Unknown desc = dial tcp 10.0.0.9:443: i/o timeout`),
+		event("web-1", "Failed", `Failed to pull image "registry.example.com/web:3.0": rpc error: code = Unknown desc = dial tcp 10.0.0.9:443: i/o timeout`),
+		event("web-1", "Failed", "Error: ErrImagePull"),
+		event("web-1", "BackOff", `Back-off pulling image "registry.example.com/web:3.0"`),
+		event("db-1", "Failed", `Failed to pull image "postgres:14.x": rpc error: code = NotFound desc = failed to pull and unpack image "docker.io/library/postgres:14.x": not found`),
+		event("cache-1", "Pulled", `Successfully pulled image "redis:7" in 3m12.5s (3m12.5s including waiting)`),
+		event("cache-2", "Pulled", `Successfully pulled image "redis:7" in 1.2s`),
+		event("cache-3", "Pulled", `Container image "redis:7" already present on machine`),
+	)
+	r := checkRegistries(context.Background(), clientset, &Options{})
+	expected := "Registry registry.example.com failed pulls of 2 images for 2 pods, the registry looks down or unreachable\n" +
+		`  Failed to pull image "registry.example.com/web:3.0": rpc error: code = Unknown desc = dial tcp 10.0.0.9:443: i/o timeout` + "\n" +
+		"Registry docker.io: 1 of 2 pulls took over 2m0s, the slowest 3m13s for redis:7\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Fatalf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestEtcdPressure(t *testing.T) {
+	objects := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "shop"}, Data: map[string]string{"bundle.js": strings.Repeat("x", 900*1024)}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "small", Namespace: "shop"}, Data: map[string]string{"a": "b"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "certs", Namespace: "shop"}, Data: map[string][]byte{"ca.crt": make([]byte, 800*1024)}},
+	}
+	for i := 0; i < eventFloodThreshold; i++ {
+		reason := "BackOff"
+		if i%10 == 0 {
+			reason = "Unhealthy"
+		}
+		objects = append(objects, &corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("api.%d", i), Namespace: "shop"}, Reason: reason})
+	}
+	objects = append(objects, &corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "web.1", Namespace: "web"}, Reason: "BackOff"})
+
+	r := checkEtcdPressure(context.Background(), fake.NewSimpleClientset(objects...), &Options{})
+	expected := "Secret shop/certs is 800KiB, close to the 1MiB limit\n" +
+		"ConfigMap shop/big is 900KiB, close to the 1MiB limit\n" +
+		"Namespace shop holds 5000 events, 4500 of them BackOff\n"
+	if r.Pass || r.Severity != SeverityWarn || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestThrottling(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/metrics":
+			fmt.Fprint(w, `# TYPE apiserver_flowcontrol_rejected_requests_total counter
+apiserver_flowcontrol_rejected_requests_total{flow_schema="service-accounts",priority_level="workload-low",reason="queue-full"} 12
+apiserver_flowcontrol_rejected_requests_total{flow_schema="service-accounts",priority_level="workload-low",reason="time-out"} 3
+apiserver_flowcontrol_rejected_requests_total{flow_schema="system-nodes",priority_level="system",reason="queue-full"} 0
+apiserver_flowcontrol_current_inqueue_requests{flow_schema="global-default",priority_level="global-default"} 4
+`)
+		case atomic.AddInt32(&requests, 1) == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"kind":"NamespaceList","apiVersion":"v1","items":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	throttling := &Throttling{}
+	clientset, err := kubernetes.NewForConfig(throttling.Instrument(&rest.Config{Host: server.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	r := checkThrottling(context.Background(), clientset, &Options{Throttling: throttling})
+	expected := "1 of the 2 requests of this run were answered with 429 Too Many Requests, the API server is shedding load\n" +
+		"Priority level workload-low rejected 15 requests since the API server started, controllers and automation using it may be degraded\n" +
+		"Priority level global-default has 4 requests waiting in its queues\n"
+	if r.Pass || r.Severity != SeverityWarn || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		switch {
+		case req.URL.Path == "/api/v1/namespaces/missing":
+			http.Error(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`, http.StatusNotFound)
+		case n <= 2:
+			http.Error(w, "etcdserver: request timed out", http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"kind":"NamespaceList","apiVersion":"v1","items":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	clientset, err := kubernetes.NewForConfig(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}.Instrument(&rest.Config{Host: server.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{}); err != nil || requests != 3 {
+		t.Errorf("Expected the list to succeed on the third try, got %v after %d requests", err, requests)
+	}
+	atomic.StoreInt32(&requests, 0)
+	if _, err := clientset.CoreV1().Namespaces().Get(context.Background(), "missing", metav1.GetOptions{}); err == nil || requests != 1 {
+		t.Errorf("Expected a 404 to be returned without retries, got %v after %d requests", err, requests)
+	}
+	atomic.StoreInt32(&requests, 0)
+	if _, err := clientset.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}, metav1.CreateOptions{}); err == nil || requests != 1 {
+		t.Errorf("Expected writes not to be retried, got %v after %d requests", err, requests)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	now := time.Now()
+	warning := func(name string, object string, reason string, message string, count int32, age time.Duration) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: object, Namespace: "shop"},
+			Type:           "Warning",
+			Reason:         reason,
+			Message:        message,
+			Count:          count,
+			LastTimestamp:  metav1.NewTime(now.Add(-age)),
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		warning("api.1", "api", "BackOff", "Back-off restarting failed container", 5, 10*time.Minute),
+		warning("api.2", "api", "BackOff", "Back-off restarting failed container app", 3, time.Minute),
+		warning("api.3", "api", "Unhealthy", "Readiness probe failed", 0, time.Minute),
+		warning("web.1", "web", "BackOff", "Back-off pulling image", 1, 3*time.Hour),
+		warning("db.1", "db", "FailedMount", "MountVolume.SetUp failed", 2, time.Minute),
+	)
+	opts := &Options{EventsSince: time.Hour, EventIgnore: []*regexp.Regexp{regexp.MustCompile(`Pod/db FailedMount`)}}
+	r := checkEvents(context.Background(), clientset, opts)
+	expected := "shop Pod/api BackOff (x8): Back-off restarting failed container app\nshop Pod/api Unhealthy (x1): Readiness probe failed\n"
+	if r.Details != expected {
+		t.Errorf("Expected %q but got %q", expected, r.Details)
+	}
+}
+
+func TestHPAs(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{MaxReplicas: 10},
+			Status:     autoscalingv2.HorizontalPodAutoscalerStatus{CurrentReplicas: 10},
+		},
+		&autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+			Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{MaxReplicas: 10},
+			Status: autoscalingv2.HorizontalPodAutoscalerStatus{CurrentReplicas: 2, Conditions: []autoscalingv2.HorizontalPodAutoscalerCondition{{
+				Type:    autoscalingv2.ScalingActive,
+				Status:  corev1.ConditionFalse,
+				Reason:  "FailedGetResourceMetric",
+				Message: "the HPA was unable to compute the replica count",
+			}}},
+		},
+		&autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "shop"},
+			Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{MaxReplicas: 10},
+			Status:     autoscalingv2.HorizontalPodAutoscalerStatus{CurrentReplicas: 3},
+		},
+	)
+	r := checkHPAs(context.Background(), clientset, &Options{})
+	if r.Pass || r.Severity != SeverityFail {
+		t.Fatalf("Expected the metrics failure to fail the check, got %+v", r)
+	}
+	for _, expected := range []string{"shop/api can not fetch metrics, FailedGetResourceMetric", "shop/web is pinned at its maximum of 10 replicas"} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "worker") {
+		t.Errorf("HPA with room to scale should not be reported: %q", r.Details)
+	}
+}
+
+func TestHPAFlapping(t *testing.T) {
+	now := time.Now()
+	window := int32(0)
+	hpa := func(name string, behavior *autoscalingv2.HorizontalPodAutoscalerBehavior) *autoscalingv2.HorizontalPodAutoscaler {
+		return &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{MaxReplicas: 10, Behavior: behavior},
+			Status:     autoscalingv2.HorizontalPodAutoscalerStatus{CurrentReplicas: 3},
+		}
+	}
+	rescale := func(name string, hpa string, message string, count int32, seen time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			InvolvedObject: corev1.ObjectReference{Kind: "HorizontalPodAutoscaler", Namespace: "shop", Name: hpa},
+			Reason:         "SuccessfulRescale",
+			Message:        message,
+			Count:          count,
+			LastTimestamp:  metav1.NewTime(seen),
+		}
+	}
+	up, down := "New size: 4; reason: cpu resource utilization (percentage of request) above target", "New size: 3; reason: All metrics below target"
+	clientset := fake.NewSimpleClientset(
+		hpa("web", &autoscalingv2.HorizontalPodAutoscalerBehavior{ScaleDown: &autoscalingv2.HPAScalingRules{StabilizationWindowSeconds: &window}}),
+		rescale("web.1", "web", up, 4, now.Add(-10*time.Minute)),
+		rescale("web.2", "web", down, 3, now.Add(-5*time.Minute)),
+		hpa("api", nil),
+		rescale("api.1", "api", up, 5, now.Add(-10*time.Minute)),
+		rescale("api.2", "api", down, 5, now.Add(-5*time.Minute)),
+		// Only scaling up is growing, not thrashing
+		hpa("worker", nil),
+		rescale("worker.1", "worker", up, 6, now.Add(-10*time.Minute)),
+		// Thrashed before the window
+		hpa("cron", nil),
+		rescale("cron.1", "cron", up, 5, now.Add(-3*time.Hour)),
+		rescale("cron.2", "cron", down, 5, now.Add(-3*time.Hour)),
+	)
+	r := checkHPAs(context.Background(), clientset, &Options{EventsSince: time.Hour})
+	if r.Pass || r.Severity != SeverityWarn || len(r.Findings) != 2 {
+		t.Fatalf("Expected web and api to be flapping, got %+v", r)
+	}
+	for _, expected := range []string{
+		"HorizontalPodAutoscaler shop/api scaled up 5 and down 5 times within 1h0m0s",
+		"HorizontalPodAutoscaler shop/web scaled up 4 and down 3 times within 1h0m0s",
+		"its scale down stabilization window is only 0s",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+
+	r = checkHPAs(context.Background(), clientset, &Options{EventsSince: time.Hour, HPAFlapThreshold: 5})
+	if len(r.Findings) != 1 || r.Findings[0].Object.Name != "api" || r.Findings[0].Reason != "Flapping" {
+		t.Errorf("Expected only api to reach a threshold of 5, got %+v", r)
+	}
+}
+
+func TestPDBs(t *testing.T) {
+	selector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+	template := func(app string) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": app}}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "coredns-1", Namespace: "kube-system", Labels: map[string]string{"app": "coredns"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "shop", Labels: map[string]string{"app": "db"}}},
+		&policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector("coredns")},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 1},
+		},
+		&policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "shop"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector("db")},
+		},
+		&policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "shop"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector("gone")},
+		},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}, Spec: appsv1.DeploymentSpec{Template: template("coredns")}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "metrics-server", Namespace: "kube-system"}, Spec: appsv1.DeploymentSpec{Template: template("metrics-server")}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"}, Spec: appsv1.DeploymentSpec{Template: template("web")}},
+	)
+	r := checkPDBs(context.Background(), clientset, &Options{})
+	if r.Pass || r.Severity != SeverityWarn {
+		t.Fatalf("Expected pdb warnings but got %+v", r)
+	}
+	for _, expected := range []string{
+		"PodDisruptionBudget shop/db allows 0 disruptions",
+		"PodDisruptionBudget shop/old matches no pods",
+		"Deployment kube-system/metrics-server has no PodDisruptionBudget",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "coredns") || strings.Contains(r.Details, "shop/web") {
+		t.Errorf("Covered and non-critical workloads should not be reported: %q", r.Details)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "shop"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("4")}},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("4"), corev1.ResourcePods: resource.MustParse("20")},
+				Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("3800m"), corev1.ResourcePods: resource.MustParse("5")},
+			},
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "blog"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceLimitsMemory: resource.MustParse("8Gi")}},
+		},
+		&corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: "blog"},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type:    corev1.LimitTypeContainer,
+				Default: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+			}}},
+		},
+	)
+	r := checkQuotas(context.Background(), clientset, &Options{})
+	if r.Pass || r.Severity != SeverityWarn {
+		t.Fatalf("Expected quota warnings but got %+v", r)
+	}
+	for _, expected := range []string{
+		"ResourceQuota shop/compute is nearly exhausted: requests.cpu 3800m/4 (95%)\n",
+		"Namespace shop has ResourceQuota compute on compute resources but no LimitRange defaults",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "blog") {
+		t.Errorf("Namespace with LimitRange defaults should not be reported: %q", r.Details)
+	}
+	if r := checkQuotas(context.Background(), clientset, &Options{QuotaThreshold: 99, Namespaces: []string{"blog"}}); !r.Pass {
+		t.Errorf("Expected no findings below the threshold, got %q", r.Details)
+	}
+}
+
+func TestOvercommit(t *testing.T) {
+	resources := func(cpuRequest, cpuLimit string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpuRequest)},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpuLimit)},
+		}
+	}
+	pod := func(name, node string, phase corev1.PodPhase, r corev1.ResourceRequirements) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Spec:       corev1.PodSpec{NodeName: node, Containers: []corev1.Container{{Name: "app", Resources: r}}},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	node := func(name string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			}},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		node("node-1"), node("node-2"), node("node-3"),
+		pod("a", "node-1", corev1.PodRunning, resources("1", "2")),
+		pod("b", "node-1", corev1.PodRunning, resources("500m", "1")),
+		pod("c", "node-2", corev1.PodRunning, resources("1500m", "2")),
+		pod("d", "node-2", corev1.PodRunning, resources("1", "1")),
+		pod("e", "node-3", corev1.PodRunning, resources("1", "1")),
+		pod("f", "node-3", corev1.PodSucceeded, resources("2", "2")),
+	)
+	r := checkOverCommit(context.Background(), clientset, &Options{})
+	if r.Pass || r.Severity != SeverityFail {
+		t.Fatalf("Expected the request overcommit to fail, got %+v", r)
+	}
+	for _, expected := range []string{
+		"Node node-1 is overcommitted on CPU limits: 3 of 2 allocatable (150%)",
+		"Node node-2 is overcommitted on CPU requests: 2500m of 2 allocatable (125%)",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "node-1 is overcommitted on CPU requests") || strings.Contains(r.Details, "node-3") || strings.Contains(r.Details, "memory") {
+		t.Errorf("Unexpected findings in %q", r.Details)
+	}
+
+	r = checkOverCommit(context.Background(), clientset, &Options{OvercommitCPUThreshold: 140})
+	if r.Pass || r.Severity != SeverityWarn || strings.Contains(r.Details, "requests") {
+		t.Errorf("Expected only limit warnings above 140%%, got %+v", r)
+	}
+}
+
+func TestNodeCapacity(t *testing.T) {
+	node := func(name, maxPods, podCIDR string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{PodCIDR: podCIDR},
+			Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourcePods: resource.MustParse(maxPods)}},
+		}
+	}
+	objects := []runtime.Object{
+		node("full", "10", ""), node("busy", "110", "10.0.1.0/28"), node("dense", "110", "10.0.2.0/24"),
+		node("idle-1", "110", ""), node("idle-2", "110", ""),
+	}
+	pod := func(name, node string, hostNetwork bool) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Spec:       corev1.PodSpec{NodeName: node, HostNetwork: hostNetwork},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	add := func(node string, n int, hostNetwork bool) {
+		for i := 0; i < n; i++ {
+			objects = append(objects, pod(fmt.Sprintf("%s-%d-%t", node, i, hostNetwork), node, hostNetwork))
+		}
+	}
+	add("full", 10, false)
+	add("busy", 12, false)
+	add("busy", 2, true)
+	add("dense", 30, false)
+	add("idle-1", 5, false)
+	add("idle-2", 6, false)
+	objects = append(objects, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "shop"},
+		Spec:       corev1.PodSpec{NodeName: "idle-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+	})
+
+	r := checkNodeCapacity(context.Background(), fake.NewSimpleClientset(objects...), &Options{})
+	expected := []string{
+		"Node full runs 10 of its 10 allocatable pods, new pods can not be scheduled on it",
+		"Node busy uses 12 of the 13 pod IPs of its podCIDR 10.0.1.0/28 (92%)",
+		"Node dense runs 30 pods, more than 2 times the median of 10 pods per node",
+	}
+	if r.Pass || r.Severity != SeverityFail || r.Details != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+	var reasons []string
+	for _, f := range r.Findings {
+		reasons = append(reasons, f.Reason)
+	}
+	if !reflect.DeepEqual(reasons, []string{"PodCapacityReached", "PodIPsNearlyExhausted", "HighPodDensity"}) {
+		t.Errorf("Unexpected reasons %v", reasons)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	namespace := func(name string, deleted time.Duration, conditions ...corev1.NamespaceCondition) *corev1.Namespace {
+		since := metav1.NewTime(time.Now().Add(-deleted))
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, DeletionTimestamp: &since},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating, Conditions: conditions},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		namespace("old", time.Hour,
+			corev1.NamespaceCondition{Type: corev1.NamespaceDeletionDiscoveryFailure, Status: corev1.ConditionFalse, Reason: "ResourcesDiscovered"},
+			corev1.NamespaceCondition{Type: corev1.NamespaceFinalizersRemaining, Status: corev1.ConditionTrue, Reason: "SomeFinalizersRemain", Message: "Some content in the namespace has finalizers remaining: example.com/cleanup in 1 resource instances"},
+		),
+		namespace("new", time.Minute),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive}},
+	)
+	r := checkNamespaces(context.Background(), clientset, &Options{})
+	expected := "Namespace old is Terminating for 1h0m0s\n  SomeFinalizersRemain: Some content in the namespace has finalizers remaining: example.com/cleanup in 1 resource instances\n"
+	if r.Pass || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestFinalizers(t *testing.T) {
+	deleted := func(ago time.Duration) *metav1.Time {
+		since := metav1.NewTime(time.Now().Add(-ago))
+		return &since
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "stuck", Namespace: "shop", DeletionTimestamp: deleted(time.Hour)}, Spec: corev1.PodSpec{NodeName: "node-1"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "going", Namespace: "shop", DeletionTimestamp: deleted(time.Minute), Finalizers: []string{"example.com/cleanup"}}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "shop", DeletionTimestamp: deleted(2 * time.Hour), Finalizers: []string{"example.com/backup"}}},
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1", Finalizers: []string{"kubernetes.io/pv-protection"}}},
+	)
+	r := checkFinalizers(context.Background(), clientset, &Options{})
+	if r.Pass || r.Severity != SeverityWarn {
+		t.Fatalf("Expected stuck objects but got %+v", r)
+	}
+	for _, expected := range []string{
+		"Pod shop/stuck is Terminating for 1h0m0s past its grace period, check the kubelet of node node-1\n",
+		"PersistentVolumeClaim shop/data is Terminating for 2h0m0s, held by finalizers: example.com/backup\n",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "going") || strings.Contains(r.Details, "pv-1") {
+		t.Errorf("Recently deleted and live objects should not be reported: %q", r.Details)
+	}
+
+	if len(r.Fixes) != 1 || r.Fixes[0].Description != "Remove finalizers example.com/backup of persistentvolumeclaim shop/data" {
+		t.Fatalf("Expected a fix for the PVC, got %+v", r.Fixes)
+	}
+	if err := r.Fixes[0].Apply(context.Background(), clientset); err != nil {
+		t.Fatalf("Unexpected error applying fix " + err.Error())
+	}
+	pvc, _ := clientset.CoreV1().PersistentVolumeClaims("shop").Get(context.Background(), "data", metav1.GetOptions{})
+	if len(pvc.Finalizers) != 0 {
+		t.Errorf("Expected the finalizers to be removed, got %v", pvc.Finalizers)
+	}
+}
+
+func TestCNI(t *testing.T) {
+	daemonSet := func(name, namespace string, ready, desired int32, nodeSelector map[string]string) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name)},
+			Spec:       appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{NodeSelector: nodeSelector}}},
+			Status:     appsv1.DaemonSetStatus{NumberReady: ready, DesiredNumberScheduled: desired},
+		}
+	}
+	pod := func(namespace, daemonSet, node string) *corev1.Pod {
+		controller := true
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            daemonSet + "-" + node,
+				Namespace:       namespace,
+				OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: daemonSet, UID: types.UID(daemonSet), Controller: &controller}},
+			},
+			Spec: corev1.PodSpec{NodeName: node},
+		}
+	}
+	node := func(name, os string, taints ...corev1.Taint) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"kubernetes.io/os": os}}, Spec: corev1.NodeSpec{Taints: taints}}
+	}
+	clientset := fake.NewSimpleClientset(
+		daemonSet("calico-node", "calico-system", 2, 2, map[string]string{"kubernetes.io/os": "linux"}),
+		daemonSet("kube-proxy", "kube-system", 2, 3, nil),
+		daemonSet("log-shipper", "logging", 0, 3, nil),
+		node("node-1", "linux"), node("node-2", "linux"), node("node-3", "linux"), node("win-1", "windows"),
+		node("tainted", "linux", corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}),
+		pod("calico-system", "calico-node", "node-1"), pod("calico-system", "calico-node", "node-2"),
+		pod("kube-system", "kube-proxy", "node-1"), pod("kube-system", "kube-proxy", "node-2"),
+		pod("kube-system", "kube-proxy", "node-3"), pod("kube-system", "kube-proxy", "win-1"),
+	)
+	r := checkCNI(context.Background(), clientset, &Options{})
+	expected := "Node node-3 has no pod of Calico DaemonSet calico-system/calico-node, its pods are stuck in ContainerCreating\n" +
+		"kube-proxy DaemonSet kube-system/kube-proxy has 2/3 ready pods\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+
+	if r := checkCNI(context.Background(), fake.NewSimpleClientset(node("node-1", "linux")), &Options{}); !r.Pass {
+		t.Errorf("Expected clusters without a known CNI DaemonSet to pass, got %+v", r)
+	}
+}
+
+func TestDNS(t *testing.T) {
+	corefile := `.:53 {
+    errors
+    health
+    kubernetes cluster.local in-addr.arpa ip6.arpa {
+       pods insecure
+    }
+    forward . /etc/resolv.conf
+    cache 30
+}
+`
+	clientset := fake.NewSimpleClientset(
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"}, Subsets: []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.10"}}}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}, Data: map[string]string{"Corefile": corefile}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns-1", Namespace: "kube-system", Labels: map[string]string{"k8s-app": "kube-dns"}},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "coredns", Ready: true}}},
+		},
+	)
+	if r := checkDNS(context.Background(), clientset, &Options{}); !r.Pass {
+		t.Fatalf("Expected healthy DNS to pass, got %+v", r)
+	}
+	if r := checkDNS(context.Background(), clientset, &Options{Namespaces: []string{"shop"}}); !r.Skipped || r.Details != "Skipped, kube-system is not one of the namespaces of the run\n" {
+		t.Errorf("Expected DNS to be skipped when kube-system is out of scope, got %+v", r)
+	}
+
+	clientset = fake.NewSimpleClientset(
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}, Data: map[string]string{"Corefile": ".:53 {\n    errors\n    forward . 8.8.8.8\n\nexample.com:53 {\n}\n"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns-1", Namespace: "kube-system", Labels: map[string]string{"k8s-app": "kube-dns"}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "coredns",
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137, FinishedAt: metav1.NewTime(time.Now().Add(-10 * time.Minute))}},
+			}}},
+		},
+	)
+	r := checkDNS(context.Background(), clientset, &Options{})
+	if r.Pass {
+		t.Fatalf("Expected DNS findings")
+	}
+	for _, expected := range []string{
+		"Service kube-system/kube-dns has no ready endpoints",
+		"Pod kube-system/coredns-1 container coredns is not ready\n",
+		"Pod kube-system/coredns-1 container coredns restarted 10m0s ago, OOMKilled (exit code 137)\n",
+		"ConfigMap kube-system/coredns: Corefile has a block that is never closed\n",
+		"ConfigMap kube-system/coredns: Corefile has no kubernetes plugin",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+}
+
+func TestProbeFindings(t *testing.T) {
+	logs := "lookup kubernetes.default\nreal\t0m 0.01s\nuser\t0m 0.00s\nsys\t0m 0.00s\nlookup example.com\nnslookup: can't resolve 'example.com'\nreal\t0m 2.50s\nuser\t0m 0.00s\nsys\t0m 0.00s\nfailed\n"
+	expected := "DNS lookup of example.com took 2.5s from a pod\nDNS lookup of example.com failed from a pod\n"
+	var found findings
+	if probeFindings(&found, logs); found.result().Details != expected {
+		t.Errorf("Expected %q but got %q", expected, found.result().Details)
+	}
+	found = nil
+	if probeFindings(&found, "sh: nslookup: not found\n"); !strings.Contains(found.result().Details, "did not run") {
+		t.Errorf("Expected missing lookups to be reported, got %q", found.result().Details)
+	}
+}
+
+func TestDNSProbeCleansUp(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	// The fake apiserver has no kubelet, finish the probe pod as soon as it is read back
+	clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get, ok := action.(k8stesting.GetAction)
+		if !ok {
+			return false, nil, nil
+		}
+		name := get.GetName()
+		return true, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}, nil
+	})
+	r := checkDNSProbe(context.Background(), clientset, &Options{})
+	if r.Err != nil {
+		t.Fatalf("Unexpected error " + r.Err.Error())
+	}
+	var created, deleted bool
+	for _, action := range clientset.Actions() {
+		if action.Matches("create", "pods") {
+			pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+			created = pod.Spec.Containers[0].Image == "busybox:1.35" && action.GetNamespace() == "default"
+		}
+		deleted = deleted || action.Matches("delete", "pods")
+	}
+	if !created || !deleted {
+		t.Errorf("Expected the probe pod to be created and deleted, got %v", clientset.Actions())
+	}
+}
+
+func TestEndpointCauses(t *testing.T) {
+	service := func(name string, targetPort intstr.IntOrString) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": name}, Ports: []corev1.ServicePort{{Port: 80, TargetPort: targetPort}}},
+		}
+	}
+	pod := func(name string, app string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Labels: map[string]string{"app": app}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Ports: []corev1.ContainerPort{{Name: "web", ContainerPort: 8080}}}}},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		service("web", intstr.FromString("http")),
+		service("api", intstr.FromInt(8080)),
+		service("cart", intstr.FromInt(8080)),
+		pod("web-1", "web", corev1.ConditionTrue),
+		pod("api-1", "api", corev1.ConditionFalse),
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "cart", Namespace: "shop"}},
+	)
+	r := checkEndpoints(context.Background(), clientset, &Options{})
+	if r.Err != nil {
+		t.Fatalf("Unexpected error " + r.Err.Error())
+	}
+	for _, expected := range []string{
+		"Service web has no active endpoints!\n  Service port 80 targets http, which no container of the matching pods exposes\n",
+		"Service api has no active endpoints!\n  1 pods match the selector app=api but none of them are Ready\n",
+		"Service cart has no active endpoints!\n  No pods match the selector app=cart, check the pod labels\n",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+}
+
+func TestIngress(t *testing.T) {
+	old := metav1.NewTime(time.Now().Add(-time.Hour))
+	className := "nginx"
+	clientset := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: "shop", CreationTimestamp: old},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "lb-new", Namespace: "shop", CreationTimestamp: metav1.Now()},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"}},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec: networkingv1.IngressSpec{
+				IngressClassName: &className,
+				TLS:              []networkingv1.IngressTLS{{SecretName: "web-tls"}},
+				Rules: []networkingv1.IngressRule{{IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{Path: "/", Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "web"}}},
+						{Path: "/api", Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "api"}}},
+					},
+				}}}},
+			},
+		},
+	)
+	r := checkIngress(context.Background(), clientset, &Options{})
+	if r.Pass || r.Err != nil {
+		t.Fatalf("Expected ingress findings but got %+v", r)
+	}
+	for _, expected := range []string{
+		"Service shop/lb of type LoadBalancer has no external IP or hostname after 1h0m0s",
+		"Ingress shop/web uses IngressClass nginx, which does not exist",
+		"Ingress shop/web routes to Service api, which does not exist",
+		"Ingress shop/web TLS secret web-tls does not exist",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "lb-new") || strings.Contains(r.Details, "Service web,") {
+		t.Errorf("Expected new load balancers and existing backends to pass, got %q", r.Details)
+	}
+}
+
+func TestNetworkPolicies(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "open"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "default-deny", Namespace: "shop"},
+			Spec:       networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop", Labels: map[string]string{"app": "api"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}}},
+		},
+	)
+	r := checkNetworkPolicies(context.Background(), clientset, &Options{})
+	expected := "Namespace shop: NetworkPolicy default-deny denies all egress and no policy allows DNS on port 53\nPod shop/api is not Ready and is isolated by NetworkPolicies default-deny\n"
+	if r.Err != nil || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+	r = checkNetworkPolicies(context.Background(), clientset, &Options{Security: true})
+	if !strings.Contains(r.Details, "Namespace open has no NetworkPolicy") || strings.Contains(r.Details, "kube-system") {
+		t.Errorf("Expected only namespace open to be reported without policies, got %q", r.Details)
+	}
+
+	dns := intstr.FromInt(53)
+	clientset.NetworkingV1().NetworkPolicies("shop").Create(context.Background(), &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-dns", Namespace: "shop"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      []networkingv1.NetworkPolicyEgressRule{{Ports: []networkingv1.NetworkPolicyPort{{Port: &dns}}}},
+		},
+	}, metav1.CreateOptions{})
+	r = checkNetworkPolicies(context.Background(), clientset, &Options{Namespaces: []string{"shop"}})
+	if strings.Contains(r.Details, "denies all egress") {
+		t.Errorf("Expected DNS to be allowed, got %q", r.Details)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	cordoned := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	clientset := fake.NewSimpleClientset(
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+				{Type: corev1.NodeDiskPressure, Status: corev1.ConditionTrue, Message: "kubelet has disk pressure"},
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+			}},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+			Spec:       corev1.NodeSpec{Unschedulable: true, Taints: []corev1.Taint{{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule, TimeAdded: &cordoned}}},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}},
+		},
+	)
+	r := checkNodes(context.Background(), clientset, &Options{})
+	expected := "Node: node-1 has DiskPressure: kubelet has disk pressure\nNode: node-2 is NotReady\nNode: node-2 is cordoned for 2h0m0s\n"
+	if r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestVersionSkew(t *testing.T) {
+	node := func(name string, kubelet string, runtime string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: kubelet, ContainerRuntimeVersion: runtime}},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		node("node-1", "v1.23.4", "containerd://1.6.1"),
+		node("node-2", "v1.19.0", "containerd://1.6.1"),
+		node("node-3", "v1.24.0", "containerd://1.5.9"),
+	)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.23.4"}
+	r := checkVersionSkew(context.Background(), clientset, &Options{})
+	expected := "Node node-2 kubelet v1.19.0 is more than 3 minor versions behind the apiserver v1.23.4\n" +
+		"Node node-3 kubelet v1.24.0 is newer than the apiserver v1.23.4\n" +
+		"Nodes run different container runtime versions:\n  containerd://1.5.9: node-3\n  containerd://1.6.1: node-1, node-2\n"
+	if r.Err != nil || r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestDeprecatedObjects(t *testing.T) {
+	list := `{"kind":"PartialObjectMetadataList","apiVersion":"meta.k8s.io/v1","metadata":{},"items":[
+		{"metadata":{"name":"nightly","namespace":"batch","managedFields":[{"manager":"kubectl-client-side-apply","apiVersion":"batch/v1beta1"}]}},
+		{"metadata":{"name":"hourly","namespace":"batch","managedFields":[{"manager":"helm","apiVersion":"batch/v1"}]}},
+		{"metadata":{"name":"cleanup","namespace":"other","managedFields":[{"manager":"kubectl","apiVersion":"batch/v1beta1"}]}}]}`
+	var path string
+	client := &restfake.RESTClient{
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		Client: restfake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			path = req.URL.Path
+			header := http.Header{}
+			header.Add("Warning", `299 - "batch/v1beta1 CronJob is deprecated in v1.21+, unavailable in v1.25+; use batch/v1 CronJob"`)
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(list))}, nil
+		}),
+	}
+	objects, warnings, err := deprecatedObjects(context.Background(), client, deprecatedAPI{"batch/v1beta1", "cronjobs", 25, "batch/v1"}, &Options{Namespaces: []string{"batch"}})
+	if err != nil {
+		t.Fatalf("Unexpected error " + err.Error())
+	}
+	if path != "/apis/batch/v1beta1/cronjobs" {
+		t.Errorf("Expected the deprecated version to be listed, got %s", path)
+	}
+	if len(objects) != 1 || objects[0] != "batch/nightly written by kubectl-client-side-apply" {
+		t.Errorf("Expected only batch/nightly, got %v", objects)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "unavailable in v1.25+") {
+		t.Errorf("Expected the apiserver warning, got %v", warnings)
+	}
+}
+
+func TestDeprecatedAPIsNotServed(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{GroupVersion: "batch/v1"}}
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.24.0"}
+	if r := checkDeprecatedAPIs(context.Background(), clientset, &Options{}); !r.Pass {
+		t.Errorf("Expected a pass without deprecated APIs, got %+v", r)
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	responses := map[string]string{
+		"/apis/metrics.k8s.io/v1beta1/nodes":                `{"items":[{"metadata":{"name":"node-1"},"usage":{"cpu":"3800m","memory":"2Gi"}}]}`,
+		"/apis/metrics.k8s.io/v1beta1/namespaces/shop/pods": `{"items":[{"metadata":{"name":"api","namespace":"shop"},"containers":[{"usage":{"cpu":"10m","memory":"300Mi"}}]}]}`,
+	}
+	client := &restfake.RESTClient{
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		Client: restfake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(responses[req.URL.Path]))}, nil
+		}),
+	}
+	nodes := []corev1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourceMemory: resource.MustParse("8Gi")}},
+	}}
+	var found findings
+	err := nodeUtilization(context.Background(), client, &found, nodes, &Options{})
+	if expected := "Node node-1 uses 3800m of 4 allocatable CPU (95%)\n"; err != nil || found.result().Details != expected {
+		t.Errorf("Expected %q but got %q, %v", expected, found.result().Details, err)
+	}
+	pods := []corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		}}}},
+	}}
+	found = nil
+	err = podUtilization(context.Background(), client, &found, "shop", pods)
+	if expected := "Pod shop/api uses 300Mi of memory but requests 256Mi\n"; err != nil || found.result().Details != expected {
+		t.Errorf("Expected %q but got %q, %v", expected, found.result().Details, err)
+	}
+
+	// Without metrics-server the check only warns
+	r := checkUtilization(context.Background(), fake.NewSimpleClientset(), &Options{})
+	if r.Pass || r.Severity != SeverityWarn || !strings.Contains(r.Details, "install metrics-server") {
+		t.Errorf("Expected a warning about metrics-server, got %+v", r)
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	one, three := int32(1), int32(3)
+	selector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+	pod := func(name string, app string, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Labels: map[string]string{"app": app}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	node := func(name string, zone string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelTopologyZone: zone}}}
+	}
+	clientset := fake.NewSimpleClientset(
+		node("node-1", "a"), node("node-2", "a"), node("node-3", "b"),
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}, Spec: appsv1.DeploymentSpec{Replicas: &one, Selector: selector("api")}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"}, Spec: appsv1.DeploymentSpec{Replicas: &three, Selector: selector("web")}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "shop"}, Spec: appsv1.StatefulSetSpec{Replicas: &three, Selector: selector("db")}},
+		pod("web-1", "web", "node-1"), pod("web-2", "web", "node-1"), pod("web-3", "web", "node-1"),
+		pod("db-0", "db", "node-1"), pod("db-1", "db", "node-2"),
+	)
+	r := checkAvailability(context.Background(), clientset, &Options{CriticalNamespaces: []string{"shop"}})
+	expected := "Deployment shop/api has a single replica\nDeployment shop/web runs all 3 replicas on node node-1\nStatefulSet shop/db runs all 3 replicas in zone a\n"
+	if r.Err != nil || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2022, 3, 1, 10, 17, 30, 0, time.UTC) // a Tuesday
+	for _, c := range []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2022, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2022, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2022, 3, 2, 2, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", time.Date(2022, 3, 2, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * sun", time.Date(2022, 3, 6, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", c.expr, err)
+			continue
+		}
+		if next := s.next(from); !next.Equal(c.expected) {
+			t.Errorf("Expected %q to run next at %s, got %s", c.expr, c.expected, next)
+		}
+	}
+	if _, err := parseCron("61 * * * *"); err == nil {
+		t.Errorf("Expected an error for an out of range minute")
+	}
+}
+
+func TestCronJobs(t *testing.T) {
+	suspend := true
+	lastRun := metav1.NewTime(time.Now().Add(-5 * time.Hour))
+	recent := metav1.NewTime(time.Now().Add(-30 * time.Minute))
+	objects := []runtime.Object{
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "shop"},
+			Spec:       batchv1.CronJobSpec{Schedule: "0 * * * *"},
+			Status:     batchv1.CronJobStatus{LastScheduleTime: &lastRun},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "shop"},
+			Spec:       batchv1.CronJobSpec{Schedule: "0 * * * *"},
+			Status:     batchv1.CronJobStatus{LastScheduleTime: &recent},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "shop"},
+			Spec:       batchv1.CronJobSpec{Schedule: "0 * * * *", Suspend: &suspend},
+		},
+	}
+	for i := 0; i < cronJobHistoryLimit+1; i++ {
+		objects = append(objects, &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("backup-%d", i), Namespace: "shop", OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "backup"}}},
+			Status:     batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}},
+		})
+	}
+	r := checkCronJobs(context.Background(), fake.NewSimpleClientset(objects...), &Options{})
+	if r.Severity != SeverityFail {
+		t.Errorf("Expected the missed schedules to fail the check, got %+v", r)
+	}
+	for _, expected := range []string{
+		"CronJob shop/report was last scheduled 5h0m0s ago and missed at least 3 schedules",
+		"CronJob shop/old is suspended",
+		"CronJob shop/backup keeps 21 finished Jobs",
+	} {
+		if !strings.Contains(r.Details, expected) {
+			t.Errorf("Expected %q in %q", expected, r.Details)
+		}
+	}
+	if strings.Contains(r.Details, "backup was") {
+		t.Errorf("Expected the recently scheduled CronJob not to be reported as missed, got %q", r.Details)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	deadline := int64(600)
+	started := metav1.NewTime(time.Now().Add(-time.Hour))
+	clientset := fake.NewSimpleClientset(
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "shop"},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"},
+			}},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "export", Namespace: "shop"},
+			Spec:       batchv1.JobSpec{ActiveDeadlineSeconds: &deadline},
+			Status:     batchv1.JobStatus{StartTime: &started, Active: 1},
+		},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ok", Namespace: "shop"}, Status: batchv1.JobStatus{StartTime: &started}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "migrate-x1", Namespace: "shop", OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "migrate"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodFailed},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "migrate-a2", Namespace: "shop", OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "migrate"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodFailed},
+		},
+	)
+	r := checkJobs(context.Background(), clientset, &Options{})
+	expected := "Job shop/export is still running 50m0s past its activeDeadlineSeconds of 600s\n" +
+		"Job shop/migrate failed, BackoffLimitExceeded: Job has reached the specified backoff limit\n  failed pods: migrate-a2, migrate-x1\n"
+	if r.Err != nil || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	optional := true
+	clientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "shop"}, Data: map[string]string{"mode": "prod"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name: "app",
+					Env: []corev1.EnvVar{
+						{Name: "MODE", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}, Key: "mode"}}},
+						{Name: "LEVEL", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}, Key: "level"}}},
+						{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "api-token"}, Key: "token", Optional: &optional}}},
+					},
+					EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "db-credentials"}}}},
+				}},
+				Volumes:          []corev1.Volume{{Name: "certs", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "api-tls"}}}},
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+			},
+		},
+	)
+	r := checkReferences(context.Background(), clientset, &Options{})
+	expected := "Pod shop/api container app env LEVEL references key level of ConfigMap settings, which does not exist\n" +
+		"Pod shop/api container app envFrom references Secret db-credentials, which does not exist\n" +
+		"Pod shop/api volume certs references Secret api-tls, which does not exist\n" +
+		"Pod shop/api imagePullSecrets references Secret registry, which does not exist\n"
+	if r.Err != nil || r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestCertManager(t *testing.T) {
+	hourAgo := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	object := func(apiVersion, kind, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: fields}
+		u.SetAPIVersion(apiVersion)
+		u.SetKind(kind)
+		u.SetNamespace(namespace)
+		u.SetName(name)
+		u.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-time.Hour)))
+		return u
+	}
+	conditions := func(c ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"status": map[string]interface{}{"conditions": c}}
+	}
+	expired := conditions(map[string]interface{}{"type": "Ready", "status": "False", "reason": "Expired", "lastTransitionTime": hourAgo})
+	expired["status"].(map[string]interface{})["notAfter"] = hourAgo
+	request := object("cert-manager.io/v1", "CertificateRequest", "shop", "api-tls-1", conditions(map[string]interface{}{"type": "Ready", "status": "False", "reason": "Pending", "message": "Waiting on certificate issuance from order shop/api-tls-1-123"}))
+	request.SetOwnerReferences([]metav1.OwnerReference{{Kind: "Certificate", Name: "api-tls"}})
+	failed := object("cert-manager.io/v1", "CertificateRequest", "shop", "web-tls-1", conditions(map[string]interface{}{"type": "Ready", "status": "False", "reason": "Failed"}))
+	failed.SetOwnerReferences([]metav1.OwnerReference{{Kind: "Certificate", Name: "web-tls"}})
+
+	listKinds := map[schema.GroupVersionResource]string{
+		certificateResource:        "CertificateList",
+		certificateRequestResource: "CertificateRequestList",
+		issuerResource:             "IssuerList",
+		clusterIssuerResource:      "ClusterIssuerList",
+		challengeResource:          "ChallengeList",
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		object("cert-manager.io/v1", "Certificate", "shop", "api-tls", conditions(
+			map[string]interface{}{"type": "Ready", "status": "False", "reason": "DoesNotExist", "message": "Issuing certificate as Secret does not exist", "lastTransitionTime": hourAgo},
+			map[string]interface{}{"type": "Issuing", "status": "True"},
+		)),
+		object("cert-manager.io/v1", "Certificate", "shop", "old-tls", expired),
+		object("cert-manager.io/v1", "Certificate", "shop", "web-tls", conditions(map[string]interface{}{"type": "Ready", "status": "True"})),
+		request,
+		failed,
+		object("cert-manager.io/v1", "ClusterIssuer", "", "letsencrypt", conditions(map[string]interface{}{"type": "Ready", "status": "False", "reason": "ErrRegisterACMEAccount", "message": "Failed to register ACME account"})),
+		object("cert-manager.io/v1", "Issuer", "shop", "ca", conditions(map[string]interface{}{"type": "Ready", "status": "True"})),
+		object("acme.cert-manager.io/v1", "Challenge", "shop", "api-tls-1-123-0", map[string]interface{}{
+			"spec":   map[string]interface{}{"type": "HTTP-01", "dnsName": "api.example.com"},
+			"status": map[string]interface{}{"state": "pending", "reason": "Waiting for HTTP-01 challenge propagation: wrong status code '404', expected '200'"},
+		}),
+	)
+	r := checkCertManager(context.Background(), fake.NewSimpleClientset(), &Options{dynamic: dynamicClient})
+	expected := "Certificate shop/old-tls expired 1h0m0s ago and was not renewed\n" +
+		"  Ready=False Expired\n" +
+		"ClusterIssuer letsencrypt is not Ready, the certificates it signs can not be issued or renewed\n" +
+		"  Ready=False ErrRegisterACMEAccount: Failed to register ACME account\n" +
+		"Certificate shop/api-tls has not been Ready for 1h0m0s\n" +
+		"  Ready=False DoesNotExist: Issuing certificate as Secret does not exist\n" +
+		"CertificateRequest shop/api-tls-1 is pending for 1h0m0s\n" +
+		"  Ready=False Pending: Waiting on certificate issuance from order shop/api-tls-1-123\n" +
+		"Challenge shop/api-tls-1-123-0 (HTTP-01 for api.example.com) is pending for 1h0m0s: Waiting for HTTP-01 challenge propagation: wrong status code '404', expected '200'\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+
+	// Without cert-manager installed
+	missing := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	missing.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), "")
+	})
+	r = checkCertManager(context.Background(), fake.NewSimpleClientset(), &Options{dynamic: missing})
+	if !r.Pass {
+		t.Errorf("Expected a pass without cert-manager, got %+v", r)
+	}
+}
+
+func TestGitOps(t *testing.T) {
+	hourAgo := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	object := func(apiVersion, kind, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: fields}
+		u.SetAPIVersion(apiVersion)
+		u.SetKind(kind)
+		u.SetNamespace(namespace)
+		u.SetName(name)
+		return u
+	}
+	listKinds := map[schema.GroupVersionResource]string{
+		argoApplicationResources[0]:   "ApplicationList",
+		fluxKustomizationResources[0]: "KustomizationList",
+		fluxKustomizationResources[1]: "KustomizationList",
+		fluxHelmReleaseResources[0]:   "HelmReleaseList",
+		fluxHelmReleaseResources[1]:   "HelmReleaseList",
+		fluxHelmReleaseResources[2]:   "HelmReleaseList",
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		object("argoproj.io/v1alpha1", "Application", "argocd", "shop", map[string]interface{}{"status": map[string]interface{}{
+			"sync":       map[string]interface{}{"status": "OutOfSync"},
+			"health":     map[string]interface{}{"status": "Degraded", "message": "Deployment shop/api has 0 ready replicas"},
+			"conditions": []interface{}{map[string]interface{}{"type": "SyncError", "message": "one or more objects failed to apply"}},
+		}}),
+		object("argoproj.io/v1alpha1", "Application", "argocd", "web", map[string]interface{}{"status": map[string]interface{}{
+			"sync":   map[string]interface{}{"status": "OutOfSync"},
+			"health": map[string]interface{}{"status": "Healthy"},
+		}}),
+		object("argoproj.io/v1alpha1", "Application", "argocd", "infra", map[string]interface{}{"status": map[string]interface{}{
+			"sync":   map[string]interface{}{"status": "Synced"},
+			"health": map[string]interface{}{"status": "Healthy"},
+		}}),
+		object("kustomize.toolkit.fluxcd.io/v1", "Kustomization", "flux-system", "apps", map[string]interface{}{"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "False", "reason": "BuildFailed", "message": "kustomize build failed: accumulating resources"},
+			map[string]interface{}{"type": "Stalled", "status": "True", "reason": "BuildFailed"},
+		}}}),
+		object("kustomize.toolkit.fluxcd.io/v1", "Kustomization", "flux-system", "paused", map[string]interface{}{
+			"spec":   map[string]interface{}{"suspend": true},
+			"status": map[string]interface{}{"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "False"}}},
+		}),
+		object("helm.toolkit.fluxcd.io/v2", "HelmRelease", "flux-system", "ingress", map[string]interface{}{"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "Unknown", "reason": "Progressing", "message": "Running 'upgrade' action", "lastTransitionTime": hourAgo},
+		}}}),
+	)
+	r := checkGitOps(context.Background(), fake.NewSimpleClientset(), &Options{dynamic: dynamicClient})
+	expected := "Argo CD Application argocd/shop is Degraded: Deployment shop/api has 0 ready replicas\n" +
+		"  SyncError=True: one or more objects failed to apply\n" +
+		"Flux Kustomization flux-system/apps reconciliation fails\n" +
+		"  Ready=False BuildFailed: kustomize build failed: accumulating resources\n" +
+		"  Stalled=True BuildFailed\n" +
+		"Argo CD Application argocd/shop is OutOfSync with its source\n" +
+		"Argo CD Application argocd/web is OutOfSync with its source\n" +
+		"Flux HelmRelease flux-system/ingress has been reconciling for 1h0m0s\n" +
+		"  Ready=Unknown Progressing: Running 'upgrade' action\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestHelmReleases(t *testing.T) {
+	release := func(name string, revision int, status string, deployed time.Time) *corev1.Secret {
+		record, _ := json.Marshal(map[string]interface{}{
+			"name": name, "namespace": "shop", "version": revision,
+			"info":  map[string]interface{}{"status": status, "description": "Upgrade \"" + name + "\" failed: timed out waiting for the condition", "last_deployed": deployed},
+			"chart": map[string]interface{}{"metadata": map[string]interface{}{"name": name, "version": "1.2.3"}},
+		})
+		var zipped bytes.Buffer
+		w := gzip.NewWriter(&zipped)
+		w.Write(record)
+		w.Close()
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, revision),
+				Namespace: "shop",
+				Labels:    map[string]string{"owner": "helm", "name": name, "status": status, "version": fmt.Sprint(revision)},
+			},
+			Type: helmReleaseSecretType,
+			Data: map[string][]byte{"release": []byte(base64.StdEncoding.EncodeToString(zipped.Bytes()))},
+		}
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	clientset := fake.NewSimpleClientset(
+		release("api", 4, "superseded", hourAgo),
+		release("api", 5, "pending-upgrade", hourAgo),
+		release("web", 2, "failed", hourAgo),
+		release("worker", 1, "pending-install", time.Now()),
+		release("db", 3, "deployed", hourAgo),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "sh.helm.release.v1.cache.v7", Namespace: "shop", CreationTimestamp: metav1.NewTime(hourAgo), Labels: map[string]string{"owner": "helm", "name": "cache", "status": "pending-rollback", "version": "7"}},
+			Type:       helmReleaseSecretType,
+			Data:       map[string][]byte{"release": []byte("not a release")},
+		},
+	)
+	r := checkHelmReleases(context.Background(), clientset, &Options{})
+	expected := "Helm release shop/api (chart api-1.2.3) revision 5 is pending-upgrade for 1h0m0s, helm upgrades fail until it is rolled back\n" +
+		"Helm release shop/cache (unknown chart) revision 7 is pending-rollback for 1h0m0s, helm upgrades fail until it is rolled back\n" +
+		"Helm release shop/web (chart web-1.2.3) revision 2 failed: Upgrade \"web\" failed: timed out waiting for the condition\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	zero := int32(0)
+	objects := []runtime.Object{
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}, Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "api"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "shop"}, Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "legacy"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "external", Namespace: "shop"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}, Spec: appsv1.DeploymentSpec{
+			Replicas: &zero,
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "api"}}},
+		}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "worker-5d4", Namespace: "shop", Labels: map[string]string{"pod-template-hash": "5d4"}}, Spec: appsv1.ReplicaSetSpec{Replicas: &zero}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: "shop"}, Spec: appsv1.ReplicaSetSpec{Replicas: &zero}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "shop"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "kube-scheduler", Namespace: "kube-system", Annotations: map[string]string{"control-plane.alpha.kubernetes.io/leader": "{}"}}},
+		&discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "old-x7k2", Namespace: "shop", Labels: map[string]string{discoveryv1.LabelServiceName: "old"}}},
+		&discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "api-b9f1", Namespace: "shop", Labels: map[string]string{discoveryv1.LabelServiceName: "api"}}},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-data"},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity:                      corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+				PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+				ClaimRef:                      &corev1.ObjectReference{Namespace: "db", Name: "data"},
+			},
+			Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+		},
+	}
+	owner := []metav1.OwnerReference{{Kind: "Deployment", Name: "api", Controller: &[]bool{true}[0]}}
+	for i := 0; i < 12; i++ {
+		objects = append(objects, &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("api-%d", i), Namespace: "shop", OwnerReferences: owner},
+			Spec:       appsv1.ReplicaSetSpec{Replicas: &zero},
+		})
+	}
+	r := checkOrphans(context.Background(), fake.NewSimpleClientset(objects...), &Options{})
+	expected := "ReplicaSet shop/worker-5d4 is scaled to zero and no Deployment owns it anymore\n" +
+		"Deployment shop/api keeps 12 old ReplicaSets scaled to zero, lower its revisionHistoryLimit\n" +
+		"Service shop/legacy selects app=legacy, which matches no pod nor workload, the workload behind it was probably deleted\n" +
+		"Endpoints shop/old have no Service\n" +
+		"EndpointSlice shop/old-x7k2 belongs to Service old, which does not exist\n" +
+		"PersistentVolume pv-data (100Gi) of deleted claim db/data is Released and still holds its storage, its Retain reclaim policy leaves the cleanup to you\n"
+	if r.Pass || r.Severity != SeverityWarn || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+	var fixes []string
+	for _, f := range r.Fixes {
+		fixes = append(fixes, f.Description)
+	}
+	if want := []string{"Delete orphaned replicaset shop/worker-5d4", "Delete orphaned service shop/legacy", "Delete orphaned endpoints shop/old", "Delete orphaned endpointslice shop/old-x7k2"}; !reflect.DeepEqual(fixes, want) {
+		t.Errorf("Expected fixes %v, got %v", want, fixes)
+	}
+}
+
+func TestInfraCriticalNamespaces(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Hour))
+	status := func(restarts int32, ready bool, lastRestart time.Duration) corev1.PodStatus {
+		c := corev1.ContainerStatus{Name: "app", RestartCount: restarts, Ready: ready}
+		if restarts > 0 {
+			c.LastTerminationState.Terminated = &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(time.Now().Add(-lastRestart))}
+		}
+		return corev1.PodStatus{StartTime: &started, ContainerStatuses: []corev1.ContainerStatus{c}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}, Status: status(1, true, 3*time.Hour)},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "controller", Namespace: "ingress-nginx"}, Status: status(5, true, 20*time.Minute)},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "monitoring"}, Status: status(0, false, 0)},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}, Status: status(5, false, time.Minute)},
+	)
+	r := checkInfraHealth(context.Background(), clientset, &Options{CriticalNamespaces: []string{"kube-system", "ingress-nginx", "monitoring"}})
+	expected := "Container restarts Detected! Pod: ingress-nginx/controller  container: app restarted 20m0s ago, 5 restarts, 0.5/h\n" +
+		"Container 'Not Ready' Detected! Pod: monitoring/prometheus  in container: app\n"
+	if r.Pass || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+	if r := checkInfraHealth(context.Background(), clientset, &Options{}); !r.Pass {
+		t.Errorf("Expected only kube-system by default, got %+v", r)
+	}
+	if r := checkInfraHealth(context.Background(), clientset, &Options{RestartWindow: 4 * time.Hour}); r.Pass || !strings.Contains(r.Details, "kube-system/coredns") {
+		t.Errorf("Expected the restart within --restart-window to be reported, got %+v", r)
+	}
+	critical := []string{"kube-system", "ingress-nginx", "monitoring"}
+	r = checkInfraHealth(context.Background(), clientset, &Options{CriticalNamespaces: critical, Namespaces: []string{"monitoring", "shop"}})
+	if r.Pass || r.Details != "Container 'Not Ready' Detected! Pod: monitoring/prometheus  in container: app\n" {
+		t.Errorf("Expected only the critical namespaces of the run to be checked, got %+v", r)
+	}
+	if r := checkInfraHealth(context.Background(), clientset, &Options{Namespaces: []string{"shop"}}); !r.Skipped {
+		t.Errorf("Expected the check to be skipped without critical namespaces in scope, got %+v", r)
+	}
+}
+
+func TestProfilesSelectRegisteredChecks(t *testing.T) {
+	for _, name := range ProfileNames() {
+		ids, _ := Profile(name)
+		if _, err := SelectChecks(strings.Join(ids, ","), ""); err != nil {
+			t.Errorf("Profile %s: %v", name, err)
+		}
+	}
+}
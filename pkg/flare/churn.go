@@ -0,0 +1,179 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Used by the churn check when Options.ChurnWindow, Options.EventRateThreshold or
+// Options.PodChurnThreshold are not set
+const (
+	defaultChurnWindow        = 10 * time.Minute
+	defaultEventRateThreshold = 1000
+	defaultPodChurnThreshold  = 10
+)
+
+// Check for namespaces recording events faster than Options.EventRateThreshold per minute and for
+// controllers creating and deleting pods faster than Options.PodChurnThreshold per minute, over the
+// last Options.ChurnWindow. Either writes to etcd and the API server at a rate that starves other
+// clients, and churning controllers are usually stuck in a create, crash and delete loop.
+// Pod churn is counted from the SuccessfulCreate and SuccessfulDelete events of the controllers,
+// or from the pods they created within the window when their events are gone.
+func checkChurn(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	window := opts.ChurnWindow
+	if window <= 0 {
+		window = defaultChurnWindow
+	}
+	eventThreshold := opts.EventRateThreshold
+	if eventThreshold <= 0 {
+		eventThreshold = defaultEventRateThreshold
+	}
+	churnThreshold := opts.PodChurnThreshold
+	if churnThreshold <= 0 {
+		churnThreshold = defaultPodChurnThreshold
+	}
+	since := time.Now().Add(-window)
+	minutes := window.Minutes()
+
+	type storm struct {
+		events float64
+		// top is the reason and object recording the most events
+		top      string
+		topCount float64
+	}
+	type churn struct {
+		object            ObjectRef
+		created, deleted  float64
+		createdFromEvents bool
+	}
+	var found findings
+	for _, ns := range opts.namespaces() {
+		storms := map[string]*storm{}
+		var nsOrder []string
+		churns := map[string]*churn{}
+		var order []string
+		// The events of every namespace by reason and object
+		bySource := map[string]float64{}
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			for _, e := range list.Items {
+				n := occurrencesSince(e, since)
+				if n == 0 {
+					continue
+				}
+				s := storms[e.Namespace]
+				if s == nil {
+					s = &storm{}
+					storms[e.Namespace] = s
+					nsOrder = append(nsOrder, e.Namespace)
+				}
+				s.events += n
+				involved := e.InvolvedObject
+				source := e.Reason + " of " + involved.Kind + " " + involved.Name
+				bySource[e.Namespace+"/"+source] += n
+				if bySource[e.Namespace+"/"+source] > s.topCount {
+					s.top, s.topCount = source, bySource[e.Namespace+"/"+source]
+				}
+
+				if e.Reason != "SuccessfulCreate" && e.Reason != "SuccessfulDelete" {
+					continue
+				}
+				ref := ObjectRef{GroupVersionKind: schema.FromAPIVersionAndKind(involved.APIVersion, involved.Kind), Namespace: involved.Namespace, Name: involved.Name}
+				c := churns[ref.String()]
+				if c == nil {
+					c = &churn{object: ref}
+					churns[ref.String()] = c
+					order = append(order, ref.String())
+				}
+				if e.Reason == "SuccessfulCreate" {
+					c.created += n
+					c.createdFromEvents = true
+				} else {
+					c.deleted += n
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		created := map[string]float64{}
+		owners := map[string]ObjectRef{}
+		for i := range pods {
+			pod := &pods[i]
+			owner := v1.GetControllerOf(pod)
+			if owner == nil || pod.CreationTimestamp.Time.Before(since) {
+				continue
+			}
+			ref := ObjectRef{GroupVersionKind: schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind), Namespace: pod.Namespace, Name: owner.Name}
+			created[ref.String()]++
+			owners[ref.String()] = ref
+		}
+		keys := make([]string, 0, len(created))
+		for key := range created {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			n := created[key]
+			c := churns[key]
+			if c == nil {
+				c = &churn{object: owners[key]}
+				churns[key] = c
+				order = append(order, key)
+			}
+			if !c.createdFromEvents && n > c.created {
+				c.created = n
+			}
+		}
+
+		for _, name := range nsOrder {
+			s := storms[name]
+			if rate := s.events / minutes; rate >= float64(eventThreshold) {
+				found.add(SeverityWarn, ObjectRef{GroupVersionKind: namespaceKind, Name: name}, "EventStorm", "Namespace %s recorded %.0f events per minute over the last %s, mostly %s", name, rate, window, s.top)
+				found.remedy("Fix the cause of the %s events, which record the most", s.top)
+			}
+		}
+		for _, key := range order {
+			c := churns[key]
+			if rate := (c.created + c.deleted) / minutes; rate >= float64(churnThreshold) {
+				found.add(SeverityWarn, c.object, "PodChurn", "%s created %.0f and deleted %.0f pods over the last %s, %.1f per minute, it is likely stuck in a create, crash and delete loop", c.object, c.created, c.deleted, window, rate)
+				found.remedy("%s, then kubectl logs --previous of its newest pod tells why they crash", kubectl("describe", c.object))
+			}
+		}
+	}
+	return found.result()
+}
+
+// The occurrences of an event since a time. The occurrences of an event seen both before and
+// after are estimated from its count, assuming they were evenly spread.
+func occurrencesSince(e corev1.Event, since time.Time) float64 {
+	last := EventTime(e)
+	if last.Before(since) {
+		return 0
+	}
+	count := float64(eventCount(e))
+	first := e.FirstTimestamp.Time
+	if first.IsZero() {
+		first = e.EventTime.Time
+	}
+	if first.IsZero() || !first.Before(since) || !last.After(first) {
+		return count
+	}
+	return count * last.Sub(since).Seconds() / last.Sub(first).Seconds()
+}
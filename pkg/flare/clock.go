@@ -0,0 +1,118 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Used by the clock-skew check when Options.ClockSkewThreshold is not set
+const defaultClockSkewThreshold = 30 * time.Second
+
+// The number of nodes with a Lease it takes to compare their clocks with each other rather than
+// with flare's clock, when flare's clock is the one that is off
+const clockSkewQuorum = 3
+
+// Check the clocks of the Ready nodes by the times their kubelets write, the renew time of their
+// Lease and the heartbeats of their conditions. A kubelet renews its Lease every quarter of its
+// lease duration, so a renew time in the future or older than that, by more than
+// Options.ClockSkewThreshold, is a clock that is off. When the clocks of most nodes are off by the
+// same amount it is flare's clock that is off, then the nodes are compared with their median.
+func checkClockSkew(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	threshold := opts.ClockSkewThreshold
+	if threshold <= 0 {
+		threshold = defaultClockSkewThreshold
+	}
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	renewed := map[string]time.Time{}
+	renewInterval := map[string]time.Duration{}
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.CoordinationV1().Leases(nodeLeaseNamespace).List(ctx, page)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting node leases: %w", err))
+		}
+		for _, lease := range list.Items {
+			if lease.Spec.RenewTime == nil {
+				continue
+			}
+			duration := defaultNodeLeaseDuration
+			if lease.Spec.LeaseDurationSeconds != nil {
+				duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+			}
+			renewed[lease.Name] = lease.Spec.RenewTime.Time
+			renewInterval[lease.Name] = duration / 4
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+
+	var found findings
+	now := time.Now()
+	var ready []*corev1.Node
+	var offsets []time.Duration
+	for i := range nodes {
+		n := &nodes[i]
+		if !nodeReady(n) {
+			continue
+		}
+		ready = append(ready, n)
+		if t, ok := renewed[n.Name]; ok {
+			offsets = append(offsets, t.Sub(now))
+		}
+	}
+	// Leases are renewed every few seconds, the median of a healthy cluster is just behind flare's clock
+	if len(offsets) >= clockSkewQuorum {
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		median := offsets[len(offsets)/2]
+		if median > threshold || median < -threshold-defaultNodeLeaseDuration/4 {
+			off := median
+			if off < 0 {
+				off = -off
+			}
+			found.add(SeverityWarn, ObjectRef{}, "ClientClockSkewed", "The clock flare runs with is off by %s from the median clock of the %d Ready nodes, comparing the nodes with each other", off.Round(time.Second), len(offsets))
+			found.remedy("Sync the clock of the machine running flare, e.g. with NTP")
+			now = now.Add(median)
+		}
+	}
+
+	for _, n := range ready {
+		// Heartbeats are only written every few minutes, only ones from the future are telling
+		var heartbeat time.Time
+		for _, c := range n.Status.Conditions {
+			if c.LastHeartbeatTime.After(heartbeat) {
+				heartbeat = c.LastHeartbeatTime.Time
+			}
+		}
+		renew, ok := renewed[n.Name]
+		switch {
+		case ok && renew.Sub(now) > threshold:
+			found.add(SeverityWarn, objectRef(nodeKind, n), "ClockSkewed", "Node %s clock is %s, its kubelet renewed its Lease at %s", n.Name, skewString(renew.Sub(now)), renew.UTC().Format(time.RFC3339))
+			found.remedy("Check that NTP or chrony runs and syncs on node %s, e.g. chronyc tracking or timedatectl", n.Name)
+		case ok && now.Sub(renew) > threshold+renewInterval[n.Name]:
+			found.add(SeverityWarn, objectRef(nodeKind, n), "ClockSkewed", "Node %s clock is about %s, its kubelet renewed its Lease at %s while it renews every %s", n.Name, skewString(renew.Sub(now)+renewInterval[n.Name]), renew.UTC().Format(time.RFC3339), renewInterval[n.Name])
+			found.remedy("Check that NTP or chrony runs and syncs on node %s, e.g. chronyc tracking or timedatectl", n.Name)
+		case heartbeat.Sub(now) > threshold:
+			found.add(SeverityWarn, objectRef(nodeKind, n), "ClockSkewed", "Node %s clock is %s, its kubelet sent a heartbeat at %s", n.Name, skewString(heartbeat.Sub(now)), heartbeat.UTC().Format(time.RFC3339))
+			found.remedy("Check that NTP or chrony runs and syncs on node %s, e.g. chronyc tracking or timedatectl", n.Name)
+		}
+	}
+	return found.result()
+}
+
+// Describe a clock offset, e.g. "2m0s ahead" for a positive one
+func skewString(offset time.Duration) string {
+	if offset < 0 {
+		return (-offset).Round(time.Second).String() + " behind"
+	}
+	return offset.Round(time.Second).String() + " ahead"
+}
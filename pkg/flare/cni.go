@@ -0,0 +1,116 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The DaemonSets of the CNI plugins the cni check detects, by DaemonSet name, and kube-proxy.
+// Their namespace differs between installs, e.g. kube-system or calico-system.
+var networkDaemonSets = map[string]string{
+	"calico-node":     "Calico",
+	"cilium":          "Cilium",
+	"kube-flannel-ds": "Flannel",
+	"kube-flannel":    "Flannel",
+	"aws-node":        "AWS VPC CNI",
+	"weave-net":       "Weave Net",
+	"kube-proxy":      "kube-proxy",
+}
+
+// Check that the DaemonSets of the CNI plugin in use and kube-proxy are fully scheduled and
+// ready, and that every node they should run on has one of their pods. Without a CNI pod the
+// pods of a node are stuck in ContainerCreating. Clusters without a known CNI DaemonSet pass, runs
+// limited to namespaces only look for the DaemonSets in those.
+func checkCNI(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var daemonSets []appsv1.DaemonSet
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.AppsV1().DaemonSets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting daemonsets: %w", err))
+			}
+			for _, d := range list.Items {
+				if _, ok := networkDaemonSets[d.Name]; ok {
+					daemonSets = append(daemonSets, d)
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	if len(daemonSets) == 0 {
+		return findings(nil).result()
+	}
+	sort.Slice(daemonSets, func(i, j int) bool {
+		return daemonSets[i].Namespace+"/"+daemonSets[i].Name < daemonSets[j].Namespace+"/"+daemonSets[j].Name
+	})
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+
+	var found findings
+	for _, d := range daemonSets {
+		object := objectRef(daemonSetKind, &d)
+		plugin := networkDaemonSets[d.Name]
+		effect := "its pods are stuck in ContainerCreating"
+		if d.Name == "kube-proxy" {
+			effect = "Service IPs do not work from its pods"
+		}
+		if d.Status.NumberReady < d.Status.DesiredNumberScheduled {
+			found.add(SeverityFail, object, "PodsNotReady", "%s DaemonSet %s/%s has %d/%d ready pods", plugin, d.Namespace, d.Name, d.Status.NumberReady, d.Status.DesiredNumberScheduled)
+			found.remedy("kubectl logs -n %s daemonset/%s", d.Namespace, d.Name)
+		}
+		pods, err := opts.snapshot.pods(ctx, clientset, d.Namespace)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting %s pods: %w", d.Namespace, err))
+		}
+		scheduled := map[string]bool{}
+		for _, pod := range pods {
+			if owner := v1.GetControllerOf(&pod); owner != nil && owner.UID == d.UID && pod.Spec.NodeName != "" {
+				scheduled[pod.Spec.NodeName] = true
+			}
+		}
+		for _, n := range nodes {
+			if !scheduled[n.Name] && runsOn(d, n) {
+				found.add(SeverityFail, objectRef(nodeKind, &n), "NetworkPodMissing", "Node %s has no pod of %s DaemonSet %s/%s, %s", n.Name, plugin, d.Namespace, d.Name, effect)
+				found.remedy("Check the taints of node %s against the tolerations of DaemonSet %s/%s, kubectl describe daemonset %s -n %s", n.Name, d.Namespace, d.Name, d.Name, d.Namespace)
+			}
+		}
+	}
+	return found.result()
+}
+
+// Whether the pods of a DaemonSet should run on a node: the node matches its nodeSelector and
+// it tolerates the NoSchedule and NoExecute taints of the node
+func runsOn(d appsv1.DaemonSet, n corev1.Node) bool {
+	if !labels.SelectorFromSet(d.Spec.Template.Spec.NodeSelector).Matches(labels.Set(n.Labels)) {
+		return false
+	}
+	for i := range n.Spec.Taints {
+		taint := &n.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for _, toleration := range d.Spec.Template.Spec.Tolerations {
+			if toleration.ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package flare
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed standard 5 field cron expression, as used by CronJobs
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// domAny and dowAny record a "*" day field; when both day fields are restricted either may match
+	domAny, dowAny bool
+	location       *time.Location
+}
+
+// The @ shorthands CronJobs accept
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Parse a cron expression with an optional CRON_TZ= or TZ= prefix, times are UTC otherwise
+func parseCron(expr string) (*cronSchedule, error) {
+	s := &cronSchedule{location: time.UTC}
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		i := strings.Index(expr, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("missing schedule after %q", expr)
+		}
+		loc, err := time.LoadLocation(expr[strings.Index(expr, "=")+1 : i])
+		if err != nil {
+			return nil, err
+		}
+		s.location = loc
+		expr = strings.TrimSpace(expr[i:])
+	}
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in %q", expr)
+	}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, err
+	}
+	// 7 is Sunday as well
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domAny = fields[2] == "*" || fields[2] == "?"
+	s.dowAny = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+// Parse a comma separated list of values, ranges and steps between min and max.
+// names are accepted in place of numbers, the first name being min.
+func parseCronField(field string, min int, max int, names []string) (map[int]bool, error) {
+	value := func(v string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(v, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid value %q in cron field %q", v, field)
+		}
+		return n, nil
+	}
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = value(bounds[0]); err != nil {
+				return nil, err
+			}
+			if high, err = value(bounds[1]); err != nil {
+				return nil, err
+			}
+		default:
+			n, err := value(part)
+			if err != nil {
+				return nil, err
+			}
+			low = n
+			if step == 1 {
+				high = n
+			}
+		}
+		for n := low; n <= high; n += step {
+			set[n] = true
+		}
+	}
+	return set, nil
+}
+
+// NextSchedule returns the first activation of the cron expression expr strictly after t, zero if
+// there is none within 5 years. expr is a CronJob schedule, e.g. "*/30 * * * *" or "@hourly".
+func NextSchedule(expr string, t time.Time) (time.Time, error) {
+	s, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.next(t), nil
+}
+
+// The first activation of the schedule strictly after t, zero if there is none within 5 years
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Whether the day of t matches, either day field matches when both are restricted
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,108 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Used by the cronjobs check when Options.CronJobMissedSchedules is not set
+const defaultCronJobMissedSchedules = 3
+
+// CronJobs keeping more finished Jobs than this are reported by the cronjobs check
+const cronJobHistoryLimit = 20
+
+// Check CronJobs that missed Options.CronJobMissedSchedules schedules in a row, suspended CronJobs
+// and CronJobs whose finished Jobs pile up because of large history limits
+func checkCronJobs(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	missed := opts.CronJobMissedSchedules
+	if missed <= 0 {
+		missed = defaultCronJobMissedSchedules
+	}
+	var found findings
+	for _, ns := range opts.namespaces() {
+		// Finished Jobs per CronJob, by "<namespace>/<name>"
+		finished := map[string]int{}
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			jobs, err := clientset.BatchV1().Jobs(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting jobs: %w", err))
+			}
+			for i := range jobs.Items {
+				job := &jobs.Items[i]
+				if jobCondition(job, batchv1.JobComplete) == nil && jobCondition(job, batchv1.JobFailed) == nil {
+					continue
+				}
+				for _, o := range job.OwnerReferences {
+					if o.Kind == "CronJob" {
+						finished[job.Namespace+"/"+o.Name]++
+					}
+				}
+			}
+			if page.Continue = jobs.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			cronJobs, err := clientset.BatchV1().CronJobs(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting cronjobs: %w", err))
+			}
+			for _, c := range cronJobs.Items {
+				object := objectRef(cronJobKind, &c)
+				if c.Spec.Suspend != nil && *c.Spec.Suspend {
+					found.add(SeverityWarn, object, "Suspended", "CronJob %s/%s is suspended", c.Namespace, c.Name)
+					found.remedy("Resume it if it should run: kubectl patch cronjob %s -n %s -p '{\"spec\":{\"suspend\":false}}'", c.Name, c.Namespace)
+				} else if reason, message := missedSchedules(c, missed, time.Now()); message != "" {
+					found.add(SeverityFail, object, reason, "%s", message)
+					if reason == "InvalidSchedule" {
+						found.remedy("Fix spec.schedule of %s, see https://kubernetes.io/docs/concepts/workloads/controllers/cron-jobs/#schedule-syntax", object)
+					} else {
+						found.remedy("Check that kube-controller-manager runs, and set spec.startingDeadlineSeconds of %s so it is scheduled again after more than 100 missed schedules", object)
+					}
+				}
+				if n := finished[c.Namespace+"/"+c.Name]; n > cronJobHistoryLimit {
+					found.add(SeverityWarn, object, "JobHistoryTooLarge", "CronJob %s/%s keeps %d finished Jobs, lower its successfulJobsHistoryLimit and failedJobsHistoryLimit", c.Namespace, c.Name, n)
+					found.remedy("kubectl patch cronjob %s -n %s -p '{\"spec\":{\"successfulJobsHistoryLimit\":3,\"failedJobsHistoryLimit\":1}}'", c.Name, c.Namespace)
+				}
+			}
+			if page.Continue = cronJobs.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
+
+// The reason and description of a CronJob that should have run at least `missed` times since it
+// was last scheduled, or since it was created if it never was. The description is "" otherwise.
+func missedSchedules(c batchv1.CronJob, missed int, now time.Time) (string, string) {
+	schedule, err := parseCron(c.Spec.Schedule)
+	if err != nil {
+		return "InvalidSchedule", fmt.Sprintf("CronJob %s/%s has an invalid schedule %q: %s", c.Namespace, c.Name, c.Spec.Schedule, err)
+	}
+	since := c.CreationTimestamp.Time
+	if c.Status.LastScheduleTime != nil {
+		since = c.Status.LastScheduleTime.Time
+	}
+	due := since
+	for i := 0; i < missed; i++ {
+		if due = schedule.next(due); due.IsZero() {
+			return "", ""
+		}
+	}
+	if due.After(now) {
+		return "", ""
+	}
+	if c.Status.LastScheduleTime == nil {
+		return "NeverScheduled", fmt.Sprintf("CronJob %s/%s was never scheduled in the %s since it was created, schedule %q", c.Namespace, c.Name, now.Sub(since).Round(time.Minute), c.Spec.Schedule)
+	}
+	return "MissedSchedules", fmt.Sprintf("CronJob %s/%s was last scheduled %s ago and missed at least %d schedules of %q", c.Namespace, c.Name, now.Sub(since).Round(time.Minute), missed, c.Spec.Schedule)
+}
@@ -0,0 +1,168 @@
+package flare
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// deprecatedAPI is a beta API version that a later Kubernetes release stops serving
+type deprecatedAPI struct {
+	groupVersion string
+	resource     string
+	// removedIn is the 1.x minor version that no longer serves the API
+	removedIn   uint
+	replacement string
+}
+
+// The deprecated APIs the deprecated-apis check looks for, from the Kubernetes deprecation guide
+var deprecatedAPIs = []deprecatedAPI{
+	{"extensions/v1beta1", "ingresses", 22, "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "ingresses", 22, "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "ingressclasses", 22, "networking.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "mutatingwebhookconfigurations", 22, "admissionregistration.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "validatingwebhookconfigurations", 22, "admissionregistration.k8s.io/v1"},
+	{"apiextensions.k8s.io/v1beta1", "customresourcedefinitions", 22, "apiextensions.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "clusterroles", 22, "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "clusterrolebindings", 22, "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "roles", 22, "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "rolebindings", 22, "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io/v1beta1", "priorityclasses", 22, "scheduling.k8s.io/v1"},
+	{"batch/v1beta1", "cronjobs", 25, "batch/v1"},
+	{"discovery.k8s.io/v1beta1", "endpointslices", 25, "discovery.k8s.io/v1"},
+	{"autoscaling/v2beta1", "horizontalpodautoscalers", 25, "autoscaling/v2"},
+	{"policy/v1beta1", "poddisruptionbudgets", 25, "policy/v1"},
+	{"policy/v1beta1", "podsecuritypolicies", 25, "Pod Security Admission"},
+	{"node.k8s.io/v1beta1", "runtimeclasses", 25, "node.k8s.io/v1"},
+	{"autoscaling/v2beta2", "horizontalpodautoscalers", 26, "autoscaling/v2"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "flowschemas", 26, "flowcontrol.apiserver.k8s.io/v1beta2"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "prioritylevelconfigurations", 26, "flowcontrol.apiserver.k8s.io/v1beta2"},
+	{"storage.k8s.io/v1beta1", "csistoragecapacities", 27, "storage.k8s.io/v1"},
+}
+
+// Check for objects last written through API versions that the cluster still serves but a later
+// release removes, from the apiVersion of their managedFields. APIs removed by the next minor
+// release fail the check, later removals are warnings. The deprecation warning the apiserver
+// returns for the API is shown along with the objects.
+func checkDeprecatedAPIs(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting the apiserver version: %w", err))
+	}
+	server, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed parsing the apiserver version %q: %w", info.GitVersion, err))
+	}
+	groups, err := clientset.Discovery().ServerGroups()
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting the served API groups: %w", err))
+	}
+	served := map[string]bool{}
+	for _, g := range groups.Groups {
+		for _, gv := range g.Versions {
+			served[gv.GroupVersion] = true
+		}
+	}
+	client := clientset.Discovery().RESTClient()
+	var found findings
+	for _, api := range deprecatedAPIs {
+		if !served[api.groupVersion] {
+			continue
+		}
+		if client == nil {
+			return errorResult(errors.New("the discovery client can not make requests"))
+		}
+		objects, serverWarnings, err := deprecatedObjects(ctx, client, api, opts)
+		if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) {
+			// Resources flare may not read, or that the version does not serve, can not hold findings
+			continue
+		}
+		if err != nil {
+			return errorResult(fmt.Errorf("failed listing %s %s: %w", api.groupVersion, api.resource, err))
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		severity := SeverityWarn
+		if api.removedIn <= server.Minor()+1 {
+			severity = SeverityFail
+		}
+		// The finding is about the API version rather than a single object, the objects are listed below it
+		object := ObjectRef{GroupVersionKind: schema.FromAPIVersionAndKind(api.groupVersion, "")}
+		found.add(severity, object, "DeprecatedAPI", "%s %s is removed in v1.%d, use %s", api.groupVersion, api.resource, api.removedIn, api.replacement)
+		found.remedy("Migrate the manifests and Helm charts of the objects below to %s, e.g. with kubectl convert, before upgrading to v1.%d", api.replacement, api.removedIn)
+		for _, w := range serverWarnings {
+			found.detail("apiserver: " + w)
+		}
+		for _, o := range objects {
+			found.detail(o)
+		}
+	}
+	return found.result()
+}
+
+// warningRecorder collects the Warning headers of the responses to a request
+type warningRecorder []string
+
+func (w *warningRecorder) HandleWarningHeader(code int, agent string, text string) {
+	for _, seen := range *w {
+		if seen == text {
+			return
+		}
+	}
+	*w = append(*w, text)
+}
+
+// List the objects of a deprecated API whose managedFields were written with its version, as
+// "<namespace>/<name> written by <manager>", and the deprecation warnings the apiserver returned.
+// Only the object metadata is requested.
+func deprecatedObjects(ctx context.Context, client rest.Interface, api deprecatedAPI, opts *Options) ([]string, []string, error) {
+	var objects []string
+	warnings := &warningRecorder{}
+	cont := ""
+	for {
+		request := client.Get().AbsPath("/apis/"+api.groupVersion, api.resource).
+			SetHeader("Accept", "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1,application/json").
+			Param("limit", fmt.Sprint(ListPageSize)).
+			WarningHandler(warnings)
+		if cont != "" {
+			request = request.Param("continue", cont)
+		}
+		data, err := request.Do(ctx).Raw()
+		if err != nil {
+			return nil, nil, err
+		}
+		var list v1.PartialObjectMetadataList
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, nil, fmt.Errorf("failed decoding the list: %w", err)
+		}
+		for _, item := range list.Items {
+			if item.Namespace != "" && !opts.inScope(item.Namespace) {
+				continue
+			}
+			for _, field := range item.ManagedFields {
+				if field.APIVersion != api.groupVersion {
+					continue
+				}
+				name := item.Name
+				if item.Namespace != "" {
+					name = item.Namespace + "/" + item.Name
+				}
+				objects = append(objects, fmt.Sprintf("%s written by %s", name, field.Manager))
+				break
+			}
+		}
+		if cont = list.Continue; cont == "" {
+			break
+		}
+	}
+	return objects, *warnings, nil
+}
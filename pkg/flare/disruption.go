@@ -0,0 +1,174 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pdbStatus is the part of a PodDisruptionBudget the pdb check looks at, common to policy/v1 and policy/v1beta1
+type pdbStatus struct {
+	namespace          string
+	name               string
+	selector           labels.Selector
+	disruptionsAllowed int32
+}
+
+// Check PodDisruptionBudgets that block node drains or match no pods, and workloads of the
+// critical namespaces that have no PodDisruptionBudget
+func checkPDBs(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	pdbs := map[string][]pdbStatus{}
+	for _, ns := range opts.namespaces() {
+		list, err := listPDBs(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting poddisruptionbudgets: %w", err))
+		}
+		for _, pdb := range list {
+			pdbs[pdb.namespace] = append(pdbs[pdb.namespace], pdb)
+			pods, err := opts.podsIn(ctx, clientset, pdb.namespace)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting pods: %w", err))
+			}
+			if !selectsPod(pdb.selector, pods) {
+				found.add(SeverityWarn, ObjectRef{GroupVersionKind: pdbKind, Namespace: pdb.namespace, Name: pdb.name}, "NoMatchingPods", "PodDisruptionBudget %s/%s matches no pods", pdb.namespace, pdb.name)
+				found.remedy("Fix spec.selector of the PodDisruptionBudget to match the labels of its pods, or delete it: kubectl delete pdb %s -n %s", pdb.name, pdb.namespace)
+			} else if pdb.disruptionsAllowed == 0 {
+				found.add(SeverityWarn, ObjectRef{GroupVersionKind: pdbKind, Namespace: pdb.namespace, Name: pdb.name}, "NoDisruptionsAllowed", "PodDisruptionBudget %s/%s allows 0 disruptions and blocks node drains", pdb.namespace, pdb.name)
+				found.remedy("Add replicas to the pods of PodDisruptionBudget %s/%s or set maxUnavailable: 1 instead of its minAvailable", pdb.namespace, pdb.name)
+			}
+		}
+	}
+
+	for _, ns := range opts.criticalNamespaces() {
+		if !opts.inScope(ns) {
+			continue
+		}
+		workloads, err := listWorkloadTemplates(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(err)
+		}
+		for _, w := range workloads {
+			covered := false
+			for _, pdb := range pdbs[ns] {
+				if pdb.selector.Matches(labels.Set(w.labels)) {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				found.add(SeverityWarn, ObjectRef{GroupVersionKind: w.gvk, Namespace: ns, Name: w.name}, "NoPodDisruptionBudget", "%s %s/%s has no PodDisruptionBudget", w.gvk.Kind, ns, w.name)
+				found.remedy("Add a PodDisruptionBudget with maxUnavailable: 1 selecting the pods of %s %s/%s", w.gvk.Kind, ns, w.name)
+			}
+		}
+	}
+	return found.result()
+}
+
+// Whether selector matches any of the pods
+func selectsPod(selector labels.Selector, pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// List the PDBs of ns with policy/v1, falling back to policy/v1beta1 on clusters older than 1.21
+func listPDBs(ctx context.Context, clientset kubernetes.Interface, ns string) ([]pdbStatus, error) {
+	var pdbs []pdbStatus
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.PolicyV1().PodDisruptionBudgets(ns).List(ctx, page)
+		if apierrors.IsNotFound(err) {
+			return listPDBsV1beta1(ctx, clientset, ns)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list.Items {
+			selector, err := v1.LabelSelectorAsSelector(p.Spec.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector of %s/%s: %w", p.Namespace, p.Name, err)
+			}
+			pdbs = append(pdbs, pdbStatus{namespace: p.Namespace, name: p.Name, selector: selector, disruptionsAllowed: p.Status.DisruptionsAllowed})
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return pdbs, nil
+}
+
+func listPDBsV1beta1(ctx context.Context, clientset kubernetes.Interface, ns string) ([]pdbStatus, error) {
+	var pdbs []pdbStatus
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.PolicyV1beta1().PodDisruptionBudgets(ns).List(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list.Items {
+			selector, err := v1.LabelSelectorAsSelector(p.Spec.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector of %s/%s: %w", p.Namespace, p.Name, err)
+			}
+			pdbs = append(pdbs, pdbStatus{namespace: p.Namespace, name: p.Name, selector: selector, disruptionsAllowed: p.Status.DisruptionsAllowed})
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return pdbs, nil
+}
+
+// workloadTemplate is a Deployment or StatefulSet with the labels of its pod template
+type workloadTemplate struct {
+	gvk    schema.GroupVersionKind
+	name   string
+	labels map[string]string
+}
+
+// List the Deployments and StatefulSets of ns that have at least one replica
+func listWorkloadTemplates(ctx context.Context, clientset kubernetes.Interface, ns string) ([]workloadTemplate, error) {
+	var workloads []workloadTemplate
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		deployments, err := clientset.AppsV1().Deployments(ns).List(ctx, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting deployments: %w", err)
+		}
+		for _, d := range deployments.Items {
+			if d.Spec.Replicas == nil || *d.Spec.Replicas > 0 {
+				workloads = append(workloads, workloadTemplate{gvk: deploymentKind, name: d.Name, labels: d.Spec.Template.Labels})
+			}
+		}
+		if page.Continue = deployments.Continue; page.Continue == "" {
+			break
+		}
+	}
+
+	page = v1.ListOptions{Limit: ListPageSize}
+	for {
+		statefulSets, err := clientset.AppsV1().StatefulSets(ns).List(ctx, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting statefulsets: %w", err)
+		}
+		for _, s := range statefulSets.Items {
+			if s.Spec.Replicas == nil || *s.Spec.Replicas > 0 {
+				workloads = append(workloads, workloadTemplate{gvk: statefulSetKind, name: s.Name, labels: s.Spec.Template.Labels})
+			}
+		}
+		if page.Continue = statefulSets.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return workloads, nil
+}
@@ -0,0 +1,132 @@
+package flare
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Restarts of CoreDNS containers within this duration are reported by the dns check
+const recentDNSRestart = time.Hour
+
+// Check that the kube-dns Service has ready endpoints, the CoreDNS pods are Ready without
+// recent restarts and the Corefile has no obviously broken server blocks. Runs limited to
+// namespaces other than kube-system skip the check.
+func checkDNS(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	if !opts.inScope(v1.NamespaceSystem) {
+		return skippedResult("%s is not one of the namespaces of the run", v1.NamespaceSystem)
+	}
+	var found findings
+	kubeDNS := ObjectRef{GroupVersionKind: serviceKind, Namespace: v1.NamespaceSystem, Name: "kube-dns"}
+	endpoints, err := clientset.CoreV1().Endpoints(v1.NamespaceSystem).Get(ctx, "kube-dns", v1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		found.add(SeverityFail, kubeDNS, "DNSNotDeployed", "Service kube-system/kube-dns has no endpoints object, cluster DNS is not deployed")
+		found.remedy("Install CoreDNS, e.g. the coredns addon of your distribution or its Helm chart")
+	case err != nil:
+		return errorResult(fmt.Errorf("failed getting kube-dns endpoints: %w", err))
+	default:
+		ready := 0
+		for _, subset := range endpoints.Subsets {
+			ready += len(subset.Addresses)
+		}
+		if ready == 0 {
+			found.add(SeverityFail, kubeDNS, "NoEndpoints", "Service kube-system/kube-dns has no ready endpoints, DNS lookups in the cluster fail")
+			found.remedy("kubectl get pods -n kube-system -l k8s-app=kube-dns -o wide and kubectl logs -n kube-system -l k8s-app=kube-dns")
+		}
+	}
+
+	pods, err := opts.podsIn(ctx, clientset, v1.NamespaceSystem)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting kube-system pods: %w", err))
+	}
+	for _, pod := range pods {
+		if pod.Labels["k8s-app"] != "kube-dns" {
+			continue
+		}
+		for _, container := range pod.Status.ContainerStatuses {
+			if !container.Ready {
+				found.add(SeverityFail, objectRef(podKind, &pod), "ContainerNotReady", "Pod kube-system/%s container %s is not ready", pod.Name, container.Name)
+				found.remedy("kubectl logs %s -n kube-system -c %s", pod.Name, container.Name)
+			}
+			if t := container.LastTerminationState.Terminated; t != nil && time.Since(t.FinishedAt.Time) < recentDNSRestart {
+				found.add(SeverityFail, objectRef(podKind, &pod), "ContainerRestarted", "Pod kube-system/%s container %s restarted %s ago, %s (exit code %d)", pod.Name, container.Name, time.Since(t.FinishedAt.Time).Round(time.Minute), t.Reason, t.ExitCode)
+				found.remedy("kubectl logs %s -n kube-system -c %s --previous", pod.Name, container.Name)
+			}
+		}
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(v1.NamespaceSystem).Get(ctx, "coredns", v1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errorResult(fmt.Errorf("failed getting the coredns configmap: %w", err))
+	}
+	if err == nil {
+		for _, problem := range corefileProblems(cm.Data["Corefile"]) {
+			found.add(SeverityFail, objectRef(configMapKind, cm), "InvalidCorefile", "ConfigMap kube-system/coredns: %s", problem)
+			found.remedy("kubectl edit configmap coredns -n kube-system")
+		}
+	}
+	return found.result()
+}
+
+// List obvious problems of a Corefile: unbalanced braces, empty server blocks and no server
+// block serving the cluster domain or forwarding other names upstream
+func corefileProblems(corefile string) []string {
+	if strings.TrimSpace(corefile) == "" {
+		return []string{"Corefile is empty"}
+	}
+	var problems []string
+	depth, plugins := 0, 0
+	server := ""
+	hasKubernetes, hasForward := false, false
+	scanner := bufio.NewScanner(strings.NewReader(corefile))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(text, "{"))
+		if depth == 0 && strings.HasSuffix(text, "{") {
+			server, plugins = strings.Join(fields, " "), 0
+		} else if depth == 1 && len(fields) > 0 && !strings.HasPrefix(text, "}") {
+			plugins++
+			switch fields[0] {
+			case "kubernetes":
+				hasKubernetes = true
+			case "forward", "proxy":
+				hasForward = true
+			}
+		}
+		depth += strings.Count(text, "{") - strings.Count(text, "}")
+		if depth < 0 {
+			problems = append(problems, fmt.Sprintf("Corefile line %d closes a block that was never opened", line))
+			depth = 0
+		}
+		if depth == 0 && strings.HasSuffix(text, "}") && server != "" {
+			if plugins == 0 {
+				problems = append(problems, fmt.Sprintf("Corefile server block %s has no plugins", server))
+			}
+			server = ""
+		}
+	}
+	if depth > 0 {
+		problems = append(problems, "Corefile has a block that is never closed")
+	}
+	if !hasKubernetes {
+		problems = append(problems, "Corefile has no kubernetes plugin, cluster names will not resolve")
+	}
+	if !hasForward {
+		problems = append(problems, "Corefile has no forward plugin, names outside the cluster will not resolve")
+	}
+	return problems
+}
@@ -0,0 +1,125 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMaps and Secrets are limited to 1MiB, the ones at least this large are reported
+const largeObjectSize = 768 * 1024
+
+// Namespaces holding at least this many events are reported as an event flood
+const eventFloodThreshold = 5000
+
+// Resource types whose object counts grow etcd the most, with the count the etcd check warns at
+var objectCountThresholds = []struct {
+	resource  string
+	threshold int
+}{
+	{"pods", 10000},
+	{"events", 50000},
+	{"secrets", 10000},
+	{"configmaps", 10000},
+}
+
+// Check for signs of etcd pressure: large object counts of the resource types that usually grow
+// etcd, ConfigMaps and Secrets close to the 1MiB object limit and namespaces flooded with events
+func checkEtcdPressure(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	counts := map[string]int{}
+	// Events per namespace and per namespace and reason
+	events, reasons := map[string]int{}, map[string]map[string]int{}
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		counts["pods"] += len(pods)
+
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			counts["events"] += len(list.Items)
+			for _, e := range list.Items {
+				events[e.Namespace]++
+				if reasons[e.Namespace] == nil {
+					reasons[e.Namespace] = map[string]int{}
+				}
+				reasons[e.Namespace][e.Reason]++
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Secrets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting secrets: %w", err))
+			}
+			counts["secrets"] += len(list.Items)
+			for i := range list.Items {
+				s := &list.Items[i]
+				if size := s.Size(); size >= largeObjectSize {
+					found.add(SeverityWarn, objectRef(secretKind, s), "LargeObject", "Secret %s/%s is %dKiB, close to the 1MiB limit", s.Namespace, s.Name, size/1024)
+					found.remedy("Move the bulk of Secret %s/%s out of etcd, e.g. into an external secret store or split it", s.Namespace, s.Name)
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().ConfigMaps(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting configmaps: %w", err))
+			}
+			counts["configmaps"] += len(list.Items)
+			for i := range list.Items {
+				cm := &list.Items[i]
+				if size := cm.Size(); size >= largeObjectSize {
+					found.add(SeverityWarn, objectRef(configMapKind, cm), "LargeObject", "ConfigMap %s/%s is %dKiB, close to the 1MiB limit", cm.Namespace, cm.Name, size/1024)
+					found.remedy("Move the bulk of ConfigMap %s/%s out of etcd, e.g. into the image or a volume, or split it", cm.Namespace, cm.Name)
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	var flooded []string
+	for namespace, n := range events {
+		if n >= eventFloodThreshold {
+			flooded = append(flooded, namespace)
+		}
+	}
+	sort.Strings(flooded)
+	for _, namespace := range flooded {
+		top, topCount := "", 0
+		for reason, n := range reasons[namespace] {
+			if n > topCount || (n == topCount && reason < top) {
+				top, topCount = reason, n
+			}
+		}
+		found.add(SeverityWarn, ObjectRef{GroupVersionKind: namespaceKind, Name: namespace}, "EventFlood", "Namespace %s holds %d events, %d of them %s", namespace, events[namespace], topCount, top)
+		found.remedy("Fix the cause of the %s events, kubectl get events -n %s --field-selector reason=%s", top, namespace, top)
+	}
+	for _, c := range objectCountThresholds {
+		if counts[c.resource] >= c.threshold {
+			found.add(SeverityWarn, ObjectRef{}, "ManyObjects", "The cluster stores %d %s, etcd slows down and lists get expensive past %d", counts[c.resource], c.resource, c.threshold)
+			found.remedy("Delete the %s that are no longer used, see the leftovers and orphans checks", c.resource)
+		}
+	}
+	return found.result()
+}
@@ -0,0 +1,188 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Merge patch that clears the finalizers of an object
+const removeFinalizersPatch = `{"metadata":{"finalizers":null}}`
+
+// finalizerResource lists and patches one kind of object for the finalizers check
+type finalizerResource struct {
+	APIVersion string
+	Kind       string
+	Namespaced bool
+	List       func(ctx context.Context, clientset kubernetes.Interface, namespace string, opts v1.ListOptions) (runtime.Object, error)
+	Patch      func(ctx context.Context, clientset kubernetes.Interface, namespace string, name string, patch []byte) error
+}
+
+// The resources whose objects are checked for stuck finalizers, pods are checked separately
+var finalizerResources = []finalizerResource{
+	{APIVersion: "v1", Kind: "PersistentVolumeClaim", Namespaced: true,
+		List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().PersistentVolumeClaims(ns).List(ctx, o)
+		},
+		Patch: func(ctx context.Context, c kubernetes.Interface, ns string, name string, p []byte) error {
+			_, err := c.CoreV1().PersistentVolumeClaims(ns).Patch(ctx, name, types.MergePatchType, p, v1.PatchOptions{})
+			return err
+		}},
+	{APIVersion: "v1", Kind: "PersistentVolume",
+		List: func(ctx context.Context, c kubernetes.Interface, _ string, o v1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().PersistentVolumes().List(ctx, o)
+		},
+		Patch: func(ctx context.Context, c kubernetes.Interface, _ string, name string, p []byte) error {
+			_, err := c.CoreV1().PersistentVolumes().Patch(ctx, name, types.MergePatchType, p, v1.PatchOptions{})
+			return err
+		}},
+	{APIVersion: "v1", Kind: "Service", Namespaced: true,
+		List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Services(ns).List(ctx, o)
+		},
+		Patch: func(ctx context.Context, c kubernetes.Interface, ns string, name string, p []byte) error {
+			_, err := c.CoreV1().Services(ns).Patch(ctx, name, types.MergePatchType, p, v1.PatchOptions{})
+			return err
+		}},
+	{APIVersion: "v1", Kind: "ConfigMap", Namespaced: true,
+		List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().ConfigMaps(ns).List(ctx, o)
+		},
+		Patch: func(ctx context.Context, c kubernetes.Interface, ns string, name string, p []byte) error {
+			_, err := c.CoreV1().ConfigMaps(ns).Patch(ctx, name, types.MergePatchType, p, v1.PatchOptions{})
+			return err
+		}},
+	{APIVersion: "apps/v1", Kind: "Deployment", Namespaced: true,
+		List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+			return c.AppsV1().Deployments(ns).List(ctx, o)
+		},
+		Patch: func(ctx context.Context, c kubernetes.Interface, ns string, name string, p []byte) error {
+			_, err := c.AppsV1().Deployments(ns).Patch(ctx, name, types.MergePatchType, p, v1.PatchOptions{})
+			return err
+		}},
+	{APIVersion: "batch/v1", Kind: "Job", Namespaced: true,
+		List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+			return c.BatchV1().Jobs(ns).List(ctx, o)
+		},
+		Patch: func(ctx context.Context, c kubernetes.Interface, ns string, name string, p []byte) error {
+			_, err := c.BatchV1().Jobs(ns).Patch(ctx, name, types.MergePatchType, p, v1.PatchOptions{})
+			return err
+		}},
+}
+
+// Check pods stuck in Terminating past their grace period and objects deleted longer than the
+// terminating timeout ago that are still held by finalizers, whose controller is likely gone.
+// Every object held by finalizers comes with a fix removing them.
+func checkFinalizers(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	timeout := opts.TerminatingTimeout
+	if timeout <= 0 {
+		timeout = defaultTerminatingTimeout
+	}
+	var found findings
+	var fixes []Fix
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for i := range pods {
+			pod := &pods[i]
+			// The deletion timestamp of a pod already includes its grace period
+			if pod.DeletionTimestamp == nil || time.Since(pod.DeletionTimestamp.Time) < timeout {
+				continue
+			}
+			terminating := fmt.Sprintf("Pod %s/%s is Terminating for %s past its grace period", pod.Namespace, pod.Name, time.Since(pod.DeletionTimestamp.Time).Round(time.Minute))
+			if len(pod.Finalizers) == 0 {
+				found.add(SeverityWarn, objectRef(podKind, pod), "StuckTerminating", "%s, check the kubelet of node %s", terminating, pod.Spec.NodeName)
+				found.remedy("Check the kubelet on node %s, kubectl delete pod %s -n %s --force --grace-period=0 if the node is gone", pod.Spec.NodeName, pod.Name, pod.Namespace)
+				continue
+			}
+			found.add(SeverityWarn, objectRef(podKind, pod), "HeldByFinalizers", "%s, finalizers: %s", terminating, strings.Join(pod.Finalizers, ", "))
+			found.remedy("Check the controller owning the finalizers, flare check --fix removes them if it is gone for good")
+			fixes = append(fixes, removeFinalizersFix(withKind(pod.DeepCopy(), "v1", "Pod"), func(ctx context.Context, clientset kubernetes.Interface, patch []byte) error {
+				_, err := clientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, v1.PatchOptions{})
+				return err
+			}))
+		}
+	}
+
+	for _, r := range finalizerResources {
+		namespaces := []string{v1.NamespaceAll}
+		if r.Namespaced {
+			namespaces = opts.namespaces()
+		} else if len(opts.Namespaces) > 0 {
+			// Cluster scoped resources are only listed when the run is not limited to namespaces
+			continue
+		}
+		for _, ns := range namespaces {
+			page := v1.ListOptions{Limit: ListPageSize}
+			for {
+				list, err := r.List(ctx, clientset, ns, page)
+				if err != nil {
+					return errorResult(fmt.Errorf("failed getting %s objects: %w", r.Kind, err))
+				}
+				items, err := meta.ExtractList(list)
+				if err != nil {
+					return errorResult(err)
+				}
+				for _, item := range items {
+					obj, err := meta.Accessor(item)
+					if err != nil {
+						return errorResult(err)
+					}
+					deleted := obj.GetDeletionTimestamp()
+					if deleted == nil || len(obj.GetFinalizers()) == 0 || time.Since(deleted.Time) < timeout {
+						continue
+					}
+					object := objectRef(schema.FromAPIVersionAndKind(r.APIVersion, r.Kind), obj)
+					found.add(SeverityWarn, object, "HeldByFinalizers", "%s %s is Terminating for %s, held by finalizers: %s", r.Kind, objectName(obj), time.Since(deleted.Time).Round(time.Minute), strings.Join(obj.GetFinalizers(), ", "))
+					found.remedy("Check the controller owning the finalizers, flare check --fix removes them if it is gone for good")
+					r, namespace, name := r, obj.GetNamespace(), obj.GetName()
+					fixes = append(fixes, removeFinalizersFix(withKind(item.DeepCopyObject(), r.APIVersion, r.Kind), func(ctx context.Context, clientset kubernetes.Interface, patch []byte) error {
+						return r.Patch(ctx, clientset, namespace, name, patch)
+					}))
+				}
+				listMeta, err := meta.ListAccessor(list)
+				if err != nil {
+					return errorResult(err)
+				}
+				if page.Continue = listMeta.GetContinue(); page.Continue == "" {
+					break
+				}
+			}
+		}
+	}
+	result := found.result()
+	result.Fixes = fixes
+	return result
+}
+
+// "<namespace>/<name>" of a namespaced object, "<name>" of a cluster scoped one
+func objectName(obj v1.Object) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// Fix that clears the finalizers of obj with patch, letting its pending deletion finish.
+// Whatever cleanup the finalizers stood for is skipped.
+func removeFinalizersFix(obj runtime.Object, patch func(ctx context.Context, clientset kubernetes.Interface, patch []byte) error) Fix {
+	accessor, _ := meta.Accessor(obj)
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	return Fix{
+		Description: fmt.Sprintf("Remove finalizers %s of %s %s", strings.Join(accessor.GetFinalizers(), ", "), strings.ToLower(kind), objectName(accessor)),
+		Backup:      obj,
+		Apply: func(ctx context.Context, clientset kubernetes.Interface) error {
+			return patch(ctx, clientset, []byte(removeFinalizersPatch))
+		},
+	}
+}
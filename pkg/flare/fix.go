@@ -0,0 +1,123 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Fix is a remediation offered by a check for one of its findings, applied by `flare check --fix`
+type Fix struct {
+	// Description says what Apply does, e.g. "Delete evicted pod shop/api-5d4"
+	Description string
+	// Backup is the object Apply changes or deletes, it is written to disk before Apply runs
+	Backup runtime.Object
+	// Object is the object of the finding the fix is for when that is not Backup, e.g. the
+	// Namespace of pods deleted at once. Suppressing the finding drops the fix.
+	Object ObjectRef
+	Apply  func(ctx context.Context, clientset kubernetes.Interface) error
+}
+
+// Set the kind of an object from a typed List, whose items do not carry it, so backups can be re-applied
+func withKind(obj runtime.Object, apiVersion string, kind string) runtime.Object {
+	obj.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(apiVersion, kind))
+	return obj
+}
+
+// Fix that deletes a pod
+func deletePodFix(pod *corev1.Pod, reason string) Fix {
+	return Fix{
+		Description: fmt.Sprintf("Delete %s pod %s/%s", reason, pod.Namespace, pod.Name),
+		Backup:      withKind(pod.DeepCopy(), "v1", "Pod"),
+		Apply: func(ctx context.Context, clientset kubernetes.Interface) error {
+			return clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, v1.DeleteOptions{})
+		},
+	}
+}
+
+// Fix that deletes pods of a namespace at once, they are backed up together as a List.
+// Pods that are already gone, or were recreated under the same name, are skipped.
+func deletePodsFix(namespace string, pods []*corev1.Pod, reason string) Fix {
+	items := make([]interface{}, 0, len(pods))
+	for _, pod := range pods {
+		if item, err := runtime.DefaultUnstructuredConverter.ToUnstructured(withKind(pod.DeepCopy(), "v1", "Pod")); err == nil {
+			items = append(items, item)
+		}
+	}
+	backup := &unstructured.Unstructured{Object: map[string]interface{}{"items": items}}
+	backup.SetAPIVersion("v1")
+	backup.SetKind("List")
+	backup.SetNamespace(namespace)
+	backup.SetName(reason + "-pods")
+	return Fix{
+		Description: fmt.Sprintf("Delete %d %s pods in namespace %s", len(pods), reason, namespace),
+		Backup:      backup,
+		Object:      ObjectRef{GroupVersionKind: namespaceKind, Name: namespace},
+		Apply: func(ctx context.Context, clientset kubernetes.Interface) error {
+			failed := 0
+			var last error
+			for _, pod := range pods {
+				uid := pod.UID
+				err := clientset.CoreV1().Pods(namespace).Delete(ctx, pod.Name, v1.DeleteOptions{Preconditions: &v1.Preconditions{UID: &uid}})
+				if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+					failed, last = failed+1, err
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed deleting %d of %d pods: %w", failed, len(pods), last)
+			}
+			return nil
+		},
+	}
+}
+
+// Fix that deletes a finished Job along with its pods
+func deleteJobFix(job *batchv1.Job) Fix {
+	propagation := v1.DeletePropagationBackground
+	return Fix{
+		Description: fmt.Sprintf("Delete completed job %s/%s", job.Namespace, job.Name),
+		Backup:      withKind(job.DeepCopy(), "batch/v1", "Job"),
+		Apply: func(ctx context.Context, clientset kubernetes.Interface) error {
+			return clientset.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, v1.DeleteOptions{PropagationPolicy: &propagation})
+		},
+	}
+}
+
+// Fix that deletes an object whose owner is gone, obj carries its kind
+func deleteOrphanFix(obj runtime.Object, remove func(ctx context.Context, clientset kubernetes.Interface) error) Fix {
+	ref := backupRef(Fix{Backup: obj})
+	ns := ""
+	if ref.Namespace != "" {
+		ns = ref.Namespace + "/"
+	}
+	return Fix{
+		Description: fmt.Sprintf("Delete orphaned %s %s%s", strings.ToLower(ref.Kind), ns, ref.Name),
+		Backup:      obj,
+		Apply:       remove,
+	}
+}
+
+// Fix that triggers a rolling restart of a Deployment, the same way `kubectl rollout restart` does
+func restartDeploymentFix(d *appsv1.Deployment) Fix {
+	return Fix{
+		Description: fmt.Sprintf("Restart deployment %s/%s", d.Namespace, d.Name),
+		Backup:      withKind(d.DeepCopy(), "apps/v1", "Deployment"),
+		Apply: func(ctx context.Context, clientset kubernetes.Interface) error {
+			patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":%q}}}}}`, time.Now().Format(time.RFC3339))
+			_, err := clientset.AppsV1().Deployments(d.Namespace).Patch(ctx, d.Name, types.StrategicMergePatchType, []byte(patch), v1.PatchOptions{})
+			return err
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Used by the node-flapping check when Options.NodeFlapThreshold is not set
+const defaultNodeFlapThreshold = 3
+
+// Check for nodes that turned Ready and NotReady again and again, by the NodeReady and NodeNotReady
+// events of the kubelet and the node controller seen within Options.EventsSince and the last
+// transition of their Ready condition. A node flapping Options.NodeFlapThreshold times points at a
+// flaky network or an unstable kubelet rather than a node that is down, which the nodes check reports.
+func checkNodeFlapping(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	threshold := opts.NodeFlapThreshold
+	if threshold <= 0 {
+		threshold = defaultNodeFlapThreshold
+	}
+	var since time.Time
+	if opts.EventsSince > 0 {
+		since = time.Now().Add(-opts.EventsSince)
+	}
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+
+	// The Ready transitions of every node by name, and the last one seen
+	transitions := map[string]int32{}
+	last := map[string]time.Time{}
+	// Node events are recorded in the default namespace, runs limited to namespaces only see them if it is one
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{FieldSelector: "involvedObject.kind=Node", Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			for _, e := range list.Items {
+				if e.InvolvedObject.Kind != "Node" || (e.Reason != "NodeReady" && e.Reason != "NodeNotReady") {
+					continue
+				}
+				seen := EventTime(e)
+				if seen.Before(since) {
+					continue
+				}
+				transitions[e.InvolvedObject.Name] += eventCount(e)
+				if seen.After(last[e.InvolvedObject.Name]) {
+					last[e.InvolvedObject.Name] = seen
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	var found findings
+	for i := range nodes {
+		n := &nodes[i]
+		count, lastSeen := transitions[n.Name], last[n.Name]
+		// The last transition of the Ready condition is still known once its events expired
+		for _, c := range n.Status.Conditions {
+			if c.Type != corev1.NodeReady || c.LastTransitionTime.IsZero() || c.LastTransitionTime.Time.Before(since) {
+				continue
+			}
+			if count == 0 {
+				count = 1
+			}
+			if c.LastTransitionTime.After(lastSeen) {
+				lastSeen = c.LastTransitionTime.Time
+			}
+		}
+		if int(count) < threshold {
+			continue
+		}
+		state := "NotReady"
+		if nodeReady(n) {
+			state = "Ready"
+		}
+		window := "in its events"
+		if opts.EventsSince > 0 {
+			window = "within " + opts.EventsSince.String()
+		}
+		found.add(SeverityWarn, objectRef(nodeKind, n), "NodeFlapping", "Node %s changed between Ready and NotReady %d times %s, last at %s, and is %s now, a flaky network or an unstable kubelet rather than a node that is down", n.Name, count, window, lastSeen.UTC().Format(time.RFC3339), state)
+		found.remedy("Check the kubelet logs of node %s around its transitions, e.g. journalctl -u kubelet, and its network to the API server", n.Name)
+	}
+	return found.result()
+}
@@ -0,0 +1,170 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Flux objects that stay reconciling this long are reported
+const fluxReconcileTimeout = 15 * time.Minute
+
+// The GitOps resources, newest version first
+var (
+	argoApplicationResources = []schema.GroupVersionResource{
+		{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"},
+	}
+	fluxKustomizationResources = []schema.GroupVersionResource{
+		{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Resource: "kustomizations"},
+		{Group: "kustomize.toolkit.fluxcd.io", Version: "v1beta2", Resource: "kustomizations"},
+	}
+	fluxHelmReleaseResources = []schema.GroupVersionResource{
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta1", Resource: "helmreleases"},
+	}
+
+	argoApplicationKind   = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Application"}
+	fluxKustomizationKind = schema.GroupVersionKind{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Kind: "Kustomization"}
+	fluxHelmReleaseKind   = schema.GroupVersionKind{Group: "helm.toolkit.fluxcd.io", Version: "v2", Kind: "HelmRelease"}
+)
+
+// Check the sync status of Argo CD Applications and Flux Kustomizations and HelmReleases: Applications
+// that are Degraded or Missing, whose last sync failed or that are OutOfSync, and Flux objects whose
+// reconciliation fails or does not finish, with the message of the controller. Clusters without
+// either pass, they are only looked at when the Runner has a dynamic client.
+func checkGitOps(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	if opts.dynamic == nil {
+		return found.result()
+	}
+	now := time.Now()
+	for _, ns := range opts.namespaces() {
+		applications, err := listFirstVersion(ctx, opts.dynamic, argoApplicationResources, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting applications: %w", err))
+		}
+		for i := range applications {
+			argoApplicationFindings(&found, &applications[i])
+		}
+		kustomizations, err := listFirstVersion(ctx, opts.dynamic, fluxKustomizationResources, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting kustomizations: %w", err))
+		}
+		for i := range kustomizations {
+			fluxFindings(&found, fluxKustomizationKind, &kustomizations[i], now)
+		}
+		releases, err := listFirstVersion(ctx, opts.dynamic, fluxHelmReleaseResources, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting helmreleases: %w", err))
+		}
+		for i := range releases {
+			fluxFindings(&found, fluxHelmReleaseKind, &releases[i], now)
+		}
+	}
+	return found.result()
+}
+
+// List the objects of the first version of a custom resource the cluster serves, none when it
+// serves none of them
+func listFirstVersion(ctx context.Context, client dynamic.Interface, versions []schema.GroupVersionResource, ns string) ([]unstructured.Unstructured, error) {
+	for _, resource := range versions {
+		objects, err := listUnstructured(ctx, client, resource, ns)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		return objects, err
+	}
+	return nil, nil
+}
+
+// Record an Argo CD Application that is Degraded or Missing, failed its last sync or is OutOfSync,
+// with its error conditions, e.g. ComparisonError
+func argoApplicationFindings(found *findings, application *unstructured.Unstructured) {
+	object := objectRef(argoApplicationKind, application)
+	name := application.GetNamespace() + "/" + application.GetName()
+	var errorLines []string
+	conditions, _, _ := unstructured.NestedSlice(application.Object, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok {
+			if kind, _ := condition["type"].(string); strings.HasSuffix(kind, "Error") {
+				errorLines = append(errorLines, conditionLine(condition, "True"))
+			}
+		}
+	}
+	reported := false
+	report := func(severity Severity, reason string, format string, args ...interface{}) {
+		found.add(severity, object, reason, format, args...)
+		if reason == "ApplicationOutOfSync" {
+			found.remedy("argocd app diff %s, then argocd app sync %s", application.GetName(), application.GetName())
+		} else {
+			found.remedy("argocd app get %s", application.GetName())
+		}
+		if !reported {
+			for _, e := range errorLines {
+				found.detail(e)
+			}
+		}
+		reported = true
+	}
+
+	health, _, _ := unstructured.NestedString(application.Object, "status", "health", "status")
+	if health == "Degraded" || health == "Missing" {
+		message, _, _ := unstructured.NestedString(application.Object, "status", "health", "message")
+		report(SeverityFail, "ApplicationDegraded", "Argo CD Application %s is %s%s", name, health, suffix(message))
+	}
+	phase, _, _ := unstructured.NestedString(application.Object, "status", "operationState", "phase")
+	if phase == "Failed" || phase == "Error" {
+		message, _, _ := unstructured.NestedString(application.Object, "status", "operationState", "message")
+		report(SeverityFail, "ApplicationSyncFailed", "Argo CD Application %s failed its last sync%s", name, suffix(message))
+	}
+	if sync, _, _ := unstructured.NestedString(application.Object, "status", "sync", "status"); sync == "OutOfSync" {
+		report(SeverityWarn, "ApplicationOutOfSync", "Argo CD Application %s is OutOfSync with its source", name)
+	}
+	if !reported && len(errorLines) > 0 {
+		report(SeverityWarn, "ApplicationError", "Argo CD Application %s reports errors", name)
+	}
+}
+
+// Record a Flux Kustomization or HelmRelease whose reconciliation failed, or that has been reconciling
+// for a long time. Suspended objects are skipped.
+func fluxFindings(found *findings, kind schema.GroupVersionKind, flux *unstructured.Unstructured, now time.Time) {
+	if suspended, _, _ := unstructured.NestedBool(flux.Object, "spec", "suspend"); suspended {
+		return
+	}
+	ready := statusCondition(flux.Object, "Ready")
+	if ready == nil {
+		return
+	}
+	object := objectRef(kind, flux)
+	switch status, _ := ready["status"].(string); status {
+	case "False":
+		found.add(SeverityFail, object, "ReconciliationFailed", "Flux %s reconciliation fails", object)
+		found.remedy("flux reconcile %s %s -n %s once the cause is fixed, flux logs --kind=%s --name=%s -n %s", strings.ToLower(kind.Kind), flux.GetName(), flux.GetNamespace(), kind.Kind, flux.GetName(), flux.GetNamespace())
+		found.detail(conditionLine(ready, status))
+		if stalled := statusCondition(flux.Object, "Stalled"); stalled != nil && stalled["status"] == "True" {
+			found.detail(conditionLine(stalled, "True"))
+		}
+	case "Unknown":
+		if since := conditionSince(ready, flux); now.Sub(since) >= fluxReconcileTimeout {
+			found.add(SeverityWarn, object, "ReconciliationStuck", "Flux %s has been reconciling for %s", object, now.Sub(since).Round(time.Minute))
+			found.remedy("flux logs --kind=%s --name=%s -n %s", kind.Kind, flux.GetName(), flux.GetNamespace())
+			found.detail(conditionLine(ready, status))
+		}
+	}
+}
+
+// A message as ": message", empty when there is none
+func suffix(message string) string {
+	if message == "" {
+		return ""
+	}
+	return ": " + message
+}
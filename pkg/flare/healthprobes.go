@@ -0,0 +1,94 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Liveness probes without an initial delay that restart a container after failing for at most
+// this many seconds kill slow starting containers before they are up
+const aggressiveLivenessSeconds = 10
+
+// Check the containers of Deployments and StatefulSets for missing readiness and liveness probes and
+// for liveness probes that restart healthy containers: no initial delay nor startup probe with a
+// short failure window, or the same HTTP, gRPC or exec check as the readiness probe, so an endpoint
+// that gets slow under load restarts the containers instead of only taking them out of rotation.
+func checkProbeConfig(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			deployments, err := clientset.AppsV1().Deployments(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting deployments: %w", err))
+			}
+			for i := range deployments.Items {
+				containerProbeFindings(&found, objectRef(deploymentKind, &deployments.Items[i]), deployments.Items[i].Spec.Template.Spec)
+			}
+			if page.Continue = deployments.Continue; page.Continue == "" {
+				break
+			}
+		}
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			statefulSets, err := clientset.AppsV1().StatefulSets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting statefulsets: %w", err))
+			}
+			for i := range statefulSets.Items {
+				containerProbeFindings(&found, objectRef(statefulSetKind, &statefulSets.Items[i]), statefulSets.Items[i].Spec.Template.Spec)
+			}
+			if page.Continue = statefulSets.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
+
+// Record the probe problems of the containers of a pod template
+func containerProbeFindings(found *findings, object ObjectRef, spec corev1.PodSpec) {
+	for _, c := range spec.Containers {
+		var missing []string
+		if c.ReadinessProbe == nil {
+			missing = append(missing, "readiness")
+		}
+		if c.LivenessProbe == nil {
+			missing = append(missing, "liveness")
+		}
+		if len(missing) > 0 {
+			found.add(SeverityWarn, object, "ProbeMissing", "%s container %s has no %s probe", object, c.Name, strings.Join(missing, " or "))
+			found.remedy("Add a %s probe to container %s of %s", strings.Join(missing, " and "), c.Name, object)
+		}
+		live := c.LivenessProbe
+		if live == nil {
+			continue
+		}
+		if window := probeFailureWindow(live); live.InitialDelaySeconds == 0 && c.StartupProbe == nil && window <= aggressiveLivenessSeconds {
+			found.add(SeverityWarn, object, "AggressiveLivenessProbe", "%s container %s is restarted after failing its liveness probe for %ds from the start, add a startupProbe or an initialDelaySeconds", object, c.Name, window)
+			found.remedy("Add a startupProbe to container %s of %s covering its slowest start", c.Name, object)
+		}
+		if c.ReadinessProbe != nil && live.TCPSocket == nil && reflect.DeepEqual(live.ProbeHandler, c.ReadinessProbe.ProbeHandler) {
+			found.add(SeverityWarn, object, "LivenessSameAsReadiness", "%s container %s uses its readiness check as liveness probe, a slow endpoint restarts the container instead of only marking it unready", object, c.Name)
+			found.remedy("Point the liveness probe of container %s of %s at a cheap endpoint that only fails when the process is stuck", c.Name, object)
+		}
+	}
+}
+
+// How many seconds a probe fails before it acts, with the API defaults of unset fields
+func probeFailureWindow(p *corev1.Probe) int32 {
+	period, failures := p.PeriodSeconds, p.FailureThreshold
+	if period == 0 {
+		period = 10
+	}
+	if failures == 0 {
+		failures = 3
+	}
+	return period * failures
+}
@@ -0,0 +1,135 @@
+package flare
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The Secret type Helm v3 stores every release revision in
+const helmReleaseSecretType = "helm.sh/release.v1"
+
+// Helm operations still pending after this long are stuck, twice the default --timeout of helm
+const helmPendingTimeout = 10 * time.Minute
+
+// helmRelease is the part of a Helm v3 release record flare reports on
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Info      struct {
+		Status       string    `json:"status"`
+		Description  string    `json:"description"`
+		LastDeployed time.Time `json:"last_deployed"`
+	} `json:"info"`
+	Chart struct {
+		Metadata struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"metadata"`
+	} `json:"chart"`
+}
+
+// Check the latest revision of every Helm v3 release: releases stuck in pending-install,
+// pending-upgrade, pending-rollback or uninstalling block every later helm upgrade until they are
+// rolled back, failed releases are reported with the error Helm recorded.
+func checkHelmReleases(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	now := time.Now()
+	var found findings
+	for _, ns := range opts.namespaces() {
+		// The latest revision of every release, by "ns/name"
+		latest := map[string]*corev1.Secret{}
+		revisions := map[string]int{}
+		page := v1.ListOptions{LabelSelector: "owner=helm", FieldSelector: "type=" + helmReleaseSecretType, Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Secrets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting secrets: %w", err))
+			}
+			for i := range list.Items {
+				s := &list.Items[i]
+				if s.Type != helmReleaseSecretType {
+					continue
+				}
+				key := s.Namespace + "/" + s.Labels["name"]
+				revision, _ := strconv.Atoi(s.Labels["version"])
+				if current, ok := latest[key]; !ok || revision > revisions[key] || revision == revisions[key] && s.Name > current.Name {
+					latest[key], revisions[key] = s, revision
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		keys := make([]string, 0, len(latest))
+		for key := range latest {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := latest[key]
+			release, err := decodeHelmRelease(s.Data["release"])
+			if err != nil {
+				// Fall back to the labels Helm sets on the Secret
+				release = &helmRelease{Name: s.Labels["name"], Namespace: s.Namespace, Version: revisions[key]}
+				release.Info.Status = s.Labels["status"]
+			}
+			chart := "unknown chart"
+			if release.Chart.Metadata.Name != "" {
+				chart = "chart " + release.Chart.Metadata.Name + "-" + release.Chart.Metadata.Version
+			}
+			object := objectRef(secretKind, s)
+			switch release.Info.Status {
+			case "pending-install", "pending-upgrade", "pending-rollback", "uninstalling":
+				since := release.Info.LastDeployed
+				if since.IsZero() {
+					since = s.CreationTimestamp.Time
+				}
+				if age := now.Sub(since); age >= helmPendingTimeout {
+					found.add(SeverityFail, object, "HelmReleasePending", "Helm release %s/%s (%s) revision %d is %s for %s, helm upgrades fail until it is rolled back", s.Namespace, release.Name, chart, release.Version, release.Info.Status, age.Round(time.Minute))
+					found.remedy("helm rollback %s -n %s, or helm uninstall it if it never installed", release.Name, s.Namespace)
+				}
+			case "failed":
+				found.add(SeverityWarn, object, "HelmReleaseFailed", "Helm release %s/%s (%s) revision %d failed%s", s.Namespace, release.Name, chart, release.Version, suffix(release.Info.Description))
+				found.remedy("helm history %s -n %s, fix the failure and helm upgrade again", release.Name, s.Namespace)
+			}
+		}
+	}
+	return found.result()
+}
+
+// Decode a release record of a Helm Secret: base64 encoded, gzipped JSON
+func decodeHelmRelease(data []byte) (*helmRelease, error) {
+	raw, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	// Helm gzips release records since 3.0, check for the gzip magic bytes
+	if bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		if raw, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+	var release helmRelease
+	if err := json.Unmarshal(raw, &release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
@@ -0,0 +1,127 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Images at least this large, as reported by the nodes that pulled them, are reported when
+// a controller's pods pull them on every start
+const largeImageSize = 500 * 1024 * 1024
+
+// Check containers using the latest tag or no tag, and containers of controller managed pods that
+// always pull a large image, which slows down every scale up, rollout and reschedule.
+// Images of Options.ImageAllowRegistries and pods in Options.ImageAllowNamespaces are not reported.
+func checkImages(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	sizes := map[string]int64{}
+	for _, n := range nodes {
+		for _, image := range n.Status.Images {
+			for _, name := range image.Names {
+				sizes[name] = image.SizeBytes
+			}
+		}
+	}
+	allowedNamespaces := map[string]bool{}
+	for _, ns := range opts.ImageAllowNamespaces {
+		allowedNamespaces[ns] = true
+	}
+
+	var found findings
+	// The pods of a controller share their containers, they are reported once per controller
+	seen := map[string]bool{}
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for i := range pods {
+			pod := &pods[i]
+			if allowedNamespaces[pod.Namespace] {
+				continue
+			}
+			object, controlled := podController(pod)
+			for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+				key := object.String() + "/" + c.Name
+				if seen[key] || imageAllowed(c.Image, opts.ImageAllowRegistries) {
+					continue
+				}
+				seen[key] = true
+				switch tag := imageTag(c.Image); tag {
+				case "latest":
+					found.add(SeverityWarn, object, "LatestTag", "%s container %s uses %s, pin a version so restarts do not pick up a different image", object, c.Name, c.Image)
+					found.remedy("Pin the image of container %s of %s to a version tag or digest", c.Name, object)
+				case "":
+					found.add(SeverityWarn, object, "NoTag", "%s container %s uses %s without a tag, which pulls latest, pin a version", object, c.Name, c.Image)
+					found.remedy("Pin the image of container %s of %s to a version tag or digest", c.Name, object)
+				}
+				if size := imageSize(sizes, c.Image); controlled && c.ImagePullPolicy == corev1.PullAlways && size >= largeImageSize {
+					found.add(SeverityWarn, object, "AlwaysPullLargeImage", "%s container %s pulls the %dMiB image %s on every pod start, use imagePullPolicy IfNotPresent with a pinned tag", object, c.Name, size/1024/1024, c.Image)
+					found.remedy("Set imagePullPolicy: IfNotPresent on container %s of %s", c.Name, object)
+				}
+			}
+		}
+	}
+	return found.result()
+}
+
+// The tag of an image reference, "" when it has none. Images pinned by digest count as tagged.
+func imageTag(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i:]
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// The registry of an image reference, docker.io when it names none
+func imageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return "docker.io"
+	}
+	if first := image[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+		return first
+	}
+	return "docker.io"
+}
+
+// Whether the image comes from one of the allowed registries
+func imageAllowed(image string, registries []string) bool {
+	registry := imageRegistry(image)
+	for _, allowed := range registries {
+		if registry == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// The size of an image as reported by the nodes, 0 when no node pulled it. Nodes list images by
+// their full name, e.g. docker.io/library/nginx:1.21, pods often by a short one, e.g. nginx:1.21.
+func imageSize(sizes map[string]int64, image string) int64 {
+	if size, ok := sizes[image]; ok {
+		return size
+	}
+	full := image
+	if imageRegistry(image) == "docker.io" && !strings.HasPrefix(image, "docker.io/") {
+		if !strings.Contains(image, "/") {
+			full = "library/" + full
+		}
+		full = "docker.io/" + full
+	}
+	if imageTag(full) == "" {
+		full += ":latest"
+	}
+	return sizes[full]
+}
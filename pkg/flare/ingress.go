@@ -0,0 +1,154 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LoadBalancer Services are given this long to get an address before the ingress check reports them
+const loadBalancerGrace = 5 * time.Minute
+
+// The annotations that pick an IngressClass, the legacy one on Ingresses and the default marker on IngressClasses
+const (
+	legacyIngressClassAnnotation  = "kubernetes.io/ingress.class"
+	defaultIngressClassAnnotation = "ingressclass.kubernetes.io/is-default-class"
+)
+
+// Check LoadBalancer Services that never got an address and Ingresses referencing Services,
+// IngressClasses or TLS secrets that do not exist
+func checkIngress(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			services, err := clientset.CoreV1().Services(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting services: %w", err))
+			}
+			for _, svc := range services.Items {
+				if svc.Spec.Type != corev1.ServiceTypeLoadBalancer || len(svc.Status.LoadBalancer.Ingress) > 0 {
+					continue
+				}
+				if age := time.Since(svc.CreationTimestamp.Time); age > loadBalancerGrace {
+					found.add(SeverityFail, objectRef(serviceKind, &svc), "LoadBalancerPending", "Service %s/%s of type LoadBalancer has no external IP or hostname after %s", svc.Namespace, svc.Name, age.Round(time.Minute))
+					found.remedy("kubectl describe service %s -n %s, then check the cloud controller manager or load balancer controller and its quota", svc.Name, svc.Namespace)
+				}
+			}
+			if page.Continue = services.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	classes, defaultClass, err := ingressClasses(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting ingressclasses: %w", err))
+	}
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			ingresses, err := clientset.NetworkingV1().Ingresses(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting ingresses: %w", err))
+			}
+			for _, ingress := range ingresses.Items {
+				if err := ingressProblems(ctx, clientset, &found, &ingress, classes, defaultClass); err != nil {
+					return errorResult(err)
+				}
+			}
+			if page.Continue = ingresses.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
+
+// The names of the IngressClasses of the cluster and whether one of them is marked as the default
+func ingressClasses(ctx context.Context, clientset kubernetes.Interface) (map[string]bool, bool, error) {
+	classes := map[string]bool{}
+	defaultClass := false
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.NetworkingV1().IngressClasses().List(ctx, page)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, class := range list.Items {
+			classes[class.Name] = true
+			defaultClass = defaultClass || class.Annotations[defaultIngressClassAnnotation] == "true"
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return classes, defaultClass, nil
+}
+
+// Record the IngressClass, backend Services and TLS secrets of an Ingress that do not exist
+func ingressProblems(ctx context.Context, clientset kubernetes.Interface, found *findings, ingress *networkingv1.Ingress, classes map[string]bool, defaultClass bool) error {
+	object := objectRef(ingressKind, ingress)
+	class := ingress.Annotations[legacyIngressClassAnnotation]
+	if ingress.Spec.IngressClassName != nil {
+		class = *ingress.Spec.IngressClassName
+	}
+	if class == "" && !defaultClass {
+		found.add(SeverityFail, object, "NoIngressClass", "Ingress %s/%s has no ingress class and no IngressClass is marked as the default", ingress.Namespace, ingress.Name)
+		found.remedy("Set spec.ingressClassName of %s, or mark an IngressClass with the ingressclass.kubernetes.io/is-default-class annotation", object)
+	}
+	// The legacy annotation names a controller, not necessarily an IngressClass object
+	if ingress.Spec.IngressClassName != nil && !classes[class] {
+		found.add(SeverityFail, object, "IngressClassNotFound", "Ingress %s/%s uses IngressClass %s, which does not exist", ingress.Namespace, ingress.Name, class)
+		found.remedy("Set spec.ingressClassName of %s to one of kubectl get ingressclass, or install the controller of %s", object, class)
+	}
+
+	var backends []string
+	if b := ingress.Spec.DefaultBackend; b != nil && b.Service != nil {
+		backends = append(backends, b.Service.Name)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				backends = append(backends, path.Backend.Service.Name)
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range backends {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		_, err := clientset.CoreV1().Services(ingress.Namespace).Get(ctx, name, v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			found.add(SeverityFail, object, "BackendNotFound", "Ingress %s/%s routes to Service %s, which does not exist", ingress.Namespace, ingress.Name, name)
+			found.remedy("Create Service %s/%s or fix the backend of %s", ingress.Namespace, name, object)
+		} else if err != nil {
+			return fmt.Errorf("failed getting service %s/%s: %w", ingress.Namespace, name, err)
+		}
+	}
+
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		_, err := clientset.CoreV1().Secrets(ingress.Namespace).Get(ctx, tls.SecretName, v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			found.add(SeverityFail, object, "SecretNotFound", "Ingress %s/%s TLS secret %s does not exist", ingress.Namespace, ingress.Name, tls.SecretName)
+			found.remedy("Create TLS secret %s/%s, e.g. kubectl create secret tls %s -n %s --cert=tls.crt --key=tls.key", ingress.Namespace, tls.SecretName, tls.SecretName, ingress.Namespace)
+		} else if err != nil {
+			return fmt.Errorf("failed getting secret %s/%s: %w", ingress.Namespace, tls.SecretName, err)
+		}
+	}
+	return nil
+}
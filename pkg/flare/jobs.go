@@ -0,0 +1,90 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Active Jobs this long past their activeDeadlineSeconds are reported, the Job controller
+// should have terminated them by then
+const jobDeadlineSlack = 5 * time.Minute
+
+// Check Jobs that failed, e.g. by exceeding their backoffLimit, and Jobs still running well past
+// their activeDeadlineSeconds. The failed pods of the Job are listed to find their logs.
+func checkJobs(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		// Failed pods by "<namespace>/<job name>"
+		failedPods := map[string][]string{}
+		for _, pod := range pods {
+			if pod.Status.Phase != corev1.PodFailed {
+				continue
+			}
+			for _, o := range pod.OwnerReferences {
+				if o.Kind == "Job" {
+					failedPods[pod.Namespace+"/"+o.Name] = append(failedPods[pod.Namespace+"/"+o.Name], pod.Name)
+				}
+			}
+		}
+
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			jobs, err := clientset.BatchV1().Jobs(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting jobs: %w", err))
+			}
+			for i := range jobs.Items {
+				job := &jobs.Items[i]
+				object := objectRef(jobKind, job)
+				if failed := jobCondition(job, batchv1.JobFailed); failed != nil {
+					found.add(SeverityFail, object, failed.Reason, "Job %s/%s failed, %s: %s", job.Namespace, job.Name, failed.Reason, failed.Message)
+					switch failed.Reason {
+					case "BackoffLimitExceeded":
+						found.remedy("kubectl logs job/%s -n %s tells why its pods failed, increase spec.backoffLimit if the failures are transient", job.Name, job.Namespace)
+					case "DeadlineExceeded":
+						found.remedy("Raise spec.activeDeadlineSeconds of %s or make it finish faster", object)
+					default:
+						found.remedy("%s", kubectl("describe", object))
+					}
+				} else if overdue := jobOverdue(job, time.Now()); overdue > 0 {
+					found.add(SeverityFail, object, "DeadlineOverdue", "Job %s/%s is still running %s past its activeDeadlineSeconds of %ds", job.Namespace, job.Name, overdue.Round(time.Minute), *job.Spec.ActiveDeadlineSeconds)
+					found.remedy("Check that kube-controller-manager runs, it terminates Jobs past their deadline")
+				} else {
+					continue
+				}
+				if names := failedPods[job.Namespace+"/"+job.Name]; len(names) > 0 {
+					sort.Strings(names)
+					found.detail("failed pods: " + strings.Join(names, ", "))
+				}
+			}
+			if page.Continue = jobs.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
+
+// How long an unfinished Job is past its activeDeadlineSeconds plus jobDeadlineSlack, 0 if it is not
+func jobOverdue(job *batchv1.Job, now time.Time) time.Duration {
+	if job.Spec.ActiveDeadlineSeconds == nil || job.Status.StartTime == nil || jobCondition(job, batchv1.JobComplete) != nil {
+		return 0
+	}
+	deadline := job.Status.StartTime.Add(time.Duration(*job.Spec.ActiveDeadlineSeconds) * time.Second)
+	if overdue := now.Sub(deadline); overdue > jobDeadlineSlack {
+		return overdue
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The namespace of the Leases kubelets renew as their heartbeat
+const nodeLeaseNamespace = "kube-node-lease"
+
+// The lease duration of kubelets when a Lease does not set one
+const defaultNodeLeaseDuration = 40 * time.Second
+
+// Check the Leases kubelets renew every 10s as their heartbeat. A node whose Lease was not renewed
+// within its lease duration, while its Ready condition is still True, is about to turn NotReady or
+// its kubelet can not reach the API server. Nodes that are already NotReady are left to the nodes check.
+func checkNodeLeases(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	leases := map[string]*coordinationv1.Lease{}
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.CoordinationV1().Leases(nodeLeaseNamespace).List(ctx, page)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting node leases: %w", err))
+		}
+		for i := range list.Items {
+			leases[list.Items[i].Name] = &list.Items[i]
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+
+	var found findings
+	now := time.Now()
+	for i := range nodes {
+		n := &nodes[i]
+		if !nodeReady(n) {
+			continue
+		}
+		lease := leases[n.Name]
+		if lease == nil {
+			found.add(SeverityWarn, objectRef(nodeKind, n), "NodeLeaseMissing", "Node %s has no Lease in %s, its kubelet does not send heartbeats", n.Name, nodeLeaseNamespace)
+			found.remedy("Check the kubelet on node %s, e.g. journalctl -u kubelet, and its permissions on leases in %s", n.Name, nodeLeaseNamespace)
+			continue
+		}
+		if lease.Spec.RenewTime == nil {
+			continue
+		}
+		duration := defaultNodeLeaseDuration
+		if lease.Spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+		}
+		if age := now.Sub(lease.Spec.RenewTime.Time); age > duration {
+			found.add(SeverityFail, objectRef(nodeKind, n), "NodeLeaseStale", "Node %s is Ready but its kubelet last renewed its Lease %s ago, over its %s lease duration, it is about to turn NotReady", n.Name, age.Round(time.Second), duration)
+			found.remedy("Check the kubelet on node %s and its connection to the API server, e.g. journalctl -u kubelet", n.Name)
+		}
+	}
+	return found.result()
+}
+
+// Whether the node's Ready condition is True
+func nodeReady(n *corev1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
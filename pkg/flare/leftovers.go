@@ -0,0 +1,150 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Completed Jobs older than this that nothing cleans up are reported
+const completedJobAge = 24 * time.Hour
+
+// Used by the finished-pods check when Options.FinishedPodThreshold is not set
+const defaultFinishedPodThreshold = 50
+
+// Check for evicted pods and completed Jobs that are left behind
+// Both can be deleted with --fix. Jobs owned by a CronJob or with a TTL are cleaned up by their controller and skipped.
+func checkLeftovers(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	var fixes []Fix
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for i := range pods {
+			pod := &pods[i]
+			if pod.Status.Phase == corev1.PodFailed && pod.Status.Reason == "Evicted" {
+				found.add(SeverityWarn, objectRef(podKind, pod), "Evicted", "Pod %s/%s was evicted: %s", pod.Namespace, pod.Name, pod.Status.Message)
+				found.remedy("kubectl delete pod %s -n %s, or flare check --fix", pod.Name, pod.Namespace)
+				fixes = append(fixes, deletePodFix(pod, "evicted"))
+			}
+		}
+
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			jobs, err := clientset.BatchV1().Jobs(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting jobs: %w", err))
+			}
+			for i := range jobs.Items {
+				job := &jobs.Items[i]
+				if job.Spec.TTLSecondsAfterFinished != nil || ownedBy(job.OwnerReferences, "CronJob") {
+					continue
+				}
+				finished := jobCondition(job, batchv1.JobComplete)
+				if finished != nil && time.Since(finished.LastTransitionTime.Time) > completedJobAge {
+					found.add(SeverityWarn, objectRef(jobKind, job), "CompletedJob", "Job %s/%s completed %s ago and was never cleaned up", job.Namespace, job.Name, time.Since(finished.LastTransitionTime.Time).Round(time.Hour))
+					found.remedy("Set spec.ttlSecondsAfterFinished on the Job, kubectl delete job %s -n %s or flare check --fix cleans this one up", job.Name, job.Namespace)
+					fixes = append(fixes, deleteJobFix(job))
+				}
+			}
+			if page.Continue = jobs.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	r := found.result()
+	r.Fixes = fixes
+	return r
+}
+
+// Check namespaces where at least Options.FinishedPodThreshold Failed and Succeeded pods pile up.
+// They clutter `kubectl get pods` and every one of them is stored in etcd. --fix deletes them per
+// namespace. Pods of Jobs are left to the Job, they go away with it, and Evicted pods to the
+// leftovers check, which reports and deletes them one by one.
+func checkFinishedPods(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	threshold := opts.FinishedPodThreshold
+	if threshold <= 0 {
+		threshold = defaultFinishedPodThreshold
+	}
+	var found findings
+	var fixes []Fix
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		finished := map[string][]*corev1.Pod{}
+		evicted := map[string]int{}
+		var namespaces []string
+		for i := range pods {
+			pod := &pods[i]
+			if (pod.Status.Phase != corev1.PodFailed && pod.Status.Phase != corev1.PodSucceeded) || ownedBy(pod.OwnerReferences, "Job") {
+				continue
+			}
+			if pod.Status.Reason == "Evicted" {
+				evicted[pod.Namespace]++
+				continue
+			}
+			if finished[pod.Namespace] == nil {
+				namespaces = append(namespaces, pod.Namespace)
+			}
+			finished[pod.Namespace] = append(finished[pod.Namespace], pod)
+		}
+		sort.Strings(namespaces)
+		for _, namespace := range namespaces {
+			left := finished[namespace]
+			if len(left) < threshold {
+				continue
+			}
+			failed, succeeded := 0, 0
+			names := make([]string, 0, len(left))
+			for _, pod := range left {
+				if pod.Status.Phase == corev1.PodFailed {
+					failed++
+				} else {
+					succeeded++
+				}
+				names = append(names, pod.Name)
+			}
+			found.add(SeverityWarn, ObjectRef{GroupVersionKind: namespaceKind, Name: namespace}, "FinishedPodsAccumulated",
+				"Namespace %s has %d finished pods left behind: %d failed, %d succeeded", namespace, len(left), failed, succeeded)
+			if evicted[namespace] > 0 {
+				found.detail(fmt.Sprintf("%d evicted pods are not counted, the leftovers check reports them", evicted[namespace]))
+			}
+			found.remedy("kubectl delete pods -n %s %s, or flare check --fix", namespace, strings.Join(names, " "))
+			fixes = append(fixes, deletePodsFix(namespace, left, "finished"))
+		}
+	}
+	r := found.result()
+	r.Fixes = fixes
+	return r
+}
+
+// The condition of the given type if it is True, nil otherwise
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i, c := range job.Status.Conditions {
+		if c.Type == conditionType && c.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// Whether any of the owners is of the given kind
+func ownedBy(owners []v1.OwnerReference, kind string) bool {
+	for _, o := range owners {
+		if o.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
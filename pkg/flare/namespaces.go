@@ -0,0 +1,87 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Used by the namespaces check when Options.TerminatingTimeout is not set
+const defaultTerminatingTimeout = 10 * time.Minute
+
+// Namespace conditions explaining why the namespace controller can not finish a deletion
+var namespaceDeletionConditions = []corev1.NamespaceConditionType{
+	corev1.NamespaceDeletionDiscoveryFailure,
+	corev1.NamespaceDeletionContentFailure,
+	corev1.NamespaceDeletionGVParsingFailure,
+	corev1.NamespaceContentRemaining,
+	corev1.NamespaceFinalizersRemaining,
+}
+
+// Check namespaces that are Terminating for longer than the timeout and list what blocks their deletion
+func checkNamespaces(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	timeout := opts.TerminatingTimeout
+	if timeout <= 0 {
+		timeout = defaultTerminatingTimeout
+	}
+	namespaces, err := scopedNamespaces(ctx, clientset, opts)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting namespaces: %w", err))
+	}
+	var found findings
+	for _, ns := range namespaces {
+		if ns.Status.Phase != corev1.NamespaceTerminating || ns.DeletionTimestamp == nil {
+			continue
+		}
+		age := time.Since(ns.DeletionTimestamp.Time)
+		if age < timeout {
+			continue
+		}
+		found.add(SeverityFail, objectRef(namespaceKind, &ns), "StuckTerminating", "Namespace %s is Terminating for %s", ns.Name, age.Round(time.Minute))
+		found.remedy("kubectl api-resources --verbs=list --namespaced -o name | xargs -n 1 kubectl get --show-kind --ignore-not-found -n %s lists what is left, check the conditions below", ns.Name)
+		for _, t := range namespaceDeletionConditions {
+			for _, c := range ns.Status.Conditions {
+				if c.Type == t && c.Status == corev1.ConditionTrue {
+					found.detail(fmt.Sprintf("%s: %s", c.Reason, c.Message))
+				}
+			}
+		}
+	}
+	return found.result()
+}
+
+// The namespaces the run is limited to, or all namespaces of the cluster. Listing namespaces needs
+// cluster wide access, so limited runs get each of their namespaces by name and skip missing ones.
+func scopedNamespaces(ctx context.Context, clientset kubernetes.Interface, opts *Options) ([]corev1.Namespace, error) {
+	var namespaces []corev1.Namespace
+	if len(opts.Namespaces) > 0 {
+		for _, name := range opts.Namespaces {
+			ns, err := clientset.CoreV1().Namespaces().Get(ctx, name, v1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			namespaces = append(namespaces, *ns)
+		}
+		return namespaces, nil
+	}
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.CoreV1().Namespaces().List(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, list.Items...)
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return namespaces, nil
+}
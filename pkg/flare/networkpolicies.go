@@ -0,0 +1,129 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Check namespaces whose default-deny egress NetworkPolicy leaves no way to reach DNS and list the
+// policies selecting pods that are not Ready, since isolation is a common reason for failing probes.
+// With Options.Security namespaces without any NetworkPolicy are reported too.
+func checkNetworkPolicies(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		var err error
+		if namespaces, err = namespaceNames(ctx, clientset); err != nil {
+			return errorResult(fmt.Errorf("failed getting namespaces: %w", err))
+		}
+	}
+	var found findings
+	for _, ns := range namespaces {
+		var policies []networkingv1.NetworkPolicy
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.NetworkingV1().NetworkPolicies(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting networkpolicies: %w", err))
+			}
+			policies = append(policies, list.Items...)
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+		if len(policies) == 0 {
+			if opts.Security && !strings.HasPrefix(ns, "kube-") {
+				found.add(SeverityWarn, ObjectRef{GroupVersionKind: namespaceKind, Name: ns}, "NoNetworkPolicy", "Namespace %s has no NetworkPolicy, all traffic to and from its pods is allowed", ns)
+				found.remedy("Add a default deny NetworkPolicy to namespace %s and allow the traffic its pods need", ns)
+			}
+			continue
+		}
+
+		for _, p := range policies {
+			if denyAllEgress(p) && !allowsDNS(policies) {
+				found.add(SeverityWarn, objectRef(netpolKind, &p), "DNSEgressDenied", "Namespace %s: NetworkPolicy %s denies all egress and no policy allows DNS on port 53", ns, p.Name)
+				found.remedy("Add a NetworkPolicy to namespace %s allowing egress to kube-dns on port 53 over UDP and TCP", ns)
+				break
+			}
+		}
+
+		pods, err := opts.podsIn(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for _, pod := range pods {
+			if pod.Status.Phase != corev1.PodRunning || podReady(pod) {
+				continue
+			}
+			var selecting []string
+			for _, p := range policies {
+				selector, err := v1.LabelSelectorAsSelector(&p.Spec.PodSelector)
+				if err == nil && selector.Matches(labels.Set(pod.Labels)) {
+					selecting = append(selecting, p.Name)
+				}
+			}
+			if len(selecting) > 0 {
+				sort.Strings(selecting)
+				found.add(SeverityWarn, objectRef(podKind, &pod), "IsolatedNotReady", "Pod %s/%s is not Ready and is isolated by NetworkPolicies %s", ns, pod.Name, strings.Join(selecting, ", "))
+				found.remedy("Check that NetworkPolicies %s allow the traffic of the readiness probe and dependencies of the pod, kubectl describe pod %s -n %s", strings.Join(selecting, ", "), pod.Name, ns)
+			}
+		}
+	}
+	return found.result()
+}
+
+// The names of all namespaces of the cluster
+func namespaceNames(ctx context.Context, clientset kubernetes.Interface) ([]string, error) {
+	var names []string
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		namespaces, err := clientset.CoreV1().Namespaces().List(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range namespaces.Items {
+			names = append(names, ns.Name)
+		}
+		if page.Continue = namespaces.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return names, nil
+}
+
+// Whether a policy selects every pod of its namespace and allows no egress
+func denyAllEgress(p networkingv1.NetworkPolicy) bool {
+	if len(p.Spec.PodSelector.MatchLabels) > 0 || len(p.Spec.PodSelector.MatchExpressions) > 0 || len(p.Spec.Egress) > 0 {
+		return false
+	}
+	for _, t := range p.Spec.PolicyTypes {
+		if t == networkingv1.PolicyTypeEgress {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether any egress rule of the policies allows port 53, rules without ports allow every port
+func allowsDNS(policies []networkingv1.NetworkPolicy) bool {
+	for _, p := range policies {
+		for _, rule := range p.Spec.Egress {
+			if len(rule.Ports) == 0 {
+				return true
+			}
+			for _, port := range rule.Ports {
+				if port.Port == nil || port.Port.IntVal == 53 || port.Port.StrVal == "dns" || port.Port.StrVal == "dns-tcp" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The revisionHistoryLimit of Deployments that do not set one, more old ReplicaSets pile up
+const defaultRevisionHistoryLimit = 10
+
+// Endpoints used for leader election by older control planes carry this annotation and have no Service
+const leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+var (
+	endpointsKind     = corev1.SchemeGroupVersion.WithKind("Endpoints")
+	endpointSliceKind = discoveryv1.SchemeGroupVersion.WithKind("EndpointSlice")
+)
+
+// Check for objects whose owner is gone or that pile up: ReplicaSets scaled to zero that no
+// Deployment owns anymore, Deployments keeping more than 10 old ReplicaSets, Endpoints and
+// EndpointSlices of deleted Services, Services whose selector matches no pod nor workload, and
+// PersistentVolumes released by their claim that still hold their storage. Orphaned ReplicaSets,
+// Endpoints, EndpointSlices and Services can be deleted with --fix, released volumes are left
+// alone since deleting them does not free the disk behind them.
+func checkOrphans(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	var fixes []Fix
+	for _, ns := range opts.namespaces() {
+		services := map[string]*corev1.Service{}
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Services(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting services: %w", err))
+			}
+			for i := range list.Items {
+				services[list.Items[i].Namespace+"/"+list.Items[i].Name] = &list.Items[i]
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		// The pod template labels of every workload and the labels of every pod by namespace, a Service
+		// selecting one of them is in use even while its workload is scaled to zero
+		templates := map[string][]labels.Set{}
+		inUse := func(namespace string, l map[string]string) {
+			templates[namespace] = append(templates[namespace], l)
+		}
+		var replicaSets []appsv1.ReplicaSet
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.AppsV1().ReplicaSets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting replicasets: %w", err))
+			}
+			for i := range list.Items {
+				inUse(list.Items[i].Namespace, list.Items[i].Spec.Template.Labels)
+			}
+			replicaSets = append(replicaSets, list.Items...)
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+		orphanedReplicaSets(&found, &fixes, replicaSets)
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.AppsV1().Deployments(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting deployments: %w", err))
+			}
+			for i := range list.Items {
+				inUse(list.Items[i].Namespace, list.Items[i].Spec.Template.Labels)
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.AppsV1().StatefulSets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting statefulsets: %w", err))
+			}
+			for i := range list.Items {
+				inUse(list.Items[i].Namespace, list.Items[i].Spec.Template.Labels)
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.AppsV1().DaemonSets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting daemonsets: %w", err))
+			}
+			for i := range list.Items {
+				inUse(list.Items[i].Namespace, list.Items[i].Spec.Template.Labels)
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for i := range pods {
+			inUse(pods[i].Namespace, pods[i].Labels)
+		}
+
+		keys := make([]string, 0, len(services))
+		for key := range services {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			svc := services[key]
+			if len(svc.Spec.Selector) == 0 {
+				continue
+			}
+			selector := labels.SelectorFromSet(svc.Spec.Selector)
+			used := false
+			for _, t := range templates[svc.Namespace] {
+				if used = selector.Matches(t); used {
+					break
+				}
+			}
+			if !used {
+				found.add(SeverityWarn, objectRef(serviceKind, svc), "ServiceWithoutWorkload", "Service %s selects %s, which matches no pod nor workload, the workload behind it was probably deleted", key, selector)
+				found.remedy("kubectl delete service %s -n %s if nothing uses it, or flare check --fix", svc.Name, svc.Namespace)
+				fixes = append(fixes, deleteOrphanFix(withKind(svc.DeepCopy(), "v1", "Service"), func(ctx context.Context, clientset kubernetes.Interface) error {
+					return clientset.CoreV1().Services(svc.Namespace).Delete(ctx, svc.Name, v1.DeleteOptions{})
+				}))
+			}
+		}
+
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Endpoints(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting endpoints: %w", err))
+			}
+			for i := range list.Items {
+				e := &list.Items[i]
+				if _, ok := services[e.Namespace+"/"+e.Name]; ok || e.Annotations[leaderAnnotation] != "" {
+					continue
+				}
+				found.add(SeverityWarn, objectRef(endpointsKind, e), "OrphanedEndpoints", "Endpoints %s/%s have no Service", e.Namespace, e.Name)
+				found.remedy("kubectl delete endpoints %s -n %s, or flare check --fix", e.Name, e.Namespace)
+				fixes = append(fixes, deleteOrphanFix(withKind(e.DeepCopy(), "v1", "Endpoints"), func(ctx context.Context, clientset kubernetes.Interface) error {
+					return clientset.CoreV1().Endpoints(e.Namespace).Delete(ctx, e.Name, v1.DeleteOptions{})
+				}))
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		page = v1.ListOptions{LabelSelector: discoveryv1.LabelServiceName, Limit: ListPageSize}
+		for {
+			list, err := clientset.DiscoveryV1().EndpointSlices(ns).List(ctx, page)
+			if apierrors.IsNotFound(err) {
+				// discovery.k8s.io/v1 is served since 1.21
+				break
+			}
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting endpointslices: %w", err))
+			}
+			for i := range list.Items {
+				s := &list.Items[i]
+				service := s.Labels[discoveryv1.LabelServiceName]
+				if _, ok := services[s.Namespace+"/"+service]; ok || service == "" {
+					continue
+				}
+				found.add(SeverityWarn, objectRef(endpointSliceKind, s), "OrphanedEndpointSlice", "EndpointSlice %s/%s belongs to Service %s, which does not exist", s.Namespace, s.Name, service)
+				found.remedy("kubectl delete endpointslice %s -n %s, or flare check --fix", s.Name, s.Namespace)
+				fixes = append(fixes, deleteOrphanFix(withKind(s.DeepCopy(), "discovery.k8s.io/v1", "EndpointSlice"), func(ctx context.Context, clientset kubernetes.Interface) error {
+					return clientset.DiscoveryV1().EndpointSlices(s.Namespace).Delete(ctx, s.Name, v1.DeleteOptions{})
+				}))
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	if len(opts.Namespaces) == 0 {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().PersistentVolumes().List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting persistentvolumes: %w", err))
+			}
+			for i := range list.Items {
+				pv := &list.Items[i]
+				if pv.Status.Phase != corev1.VolumeReleased || pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+					continue
+				}
+				claim := "a deleted claim"
+				if ref := pv.Spec.ClaimRef; ref != nil {
+					claim = "deleted claim " + ref.Namespace + "/" + ref.Name
+				}
+				size := pv.Spec.Capacity[corev1.ResourceStorage]
+				found.add(SeverityWarn, objectRef(pvKind, pv), "ReleasedVolumeRetained", "PersistentVolume %s (%s) of %s is Released and still holds its storage, its Retain reclaim policy leaves the cleanup to you", pv.Name, size.String(), claim)
+				found.remedy("Back up the data if needed, then delete the storage and kubectl delete pv %s", pv.Name)
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	r := found.result()
+	r.Fixes = fixes
+	return r
+}
+
+// Record the ReplicaSets scaled to zero without an owner and the Deployments keeping more old
+// ReplicaSets than the default revisionHistoryLimit
+func orphanedReplicaSets(found *findings, fixes *[]Fix, replicaSets []appsv1.ReplicaSet) {
+	// The number of old ReplicaSets of every Deployment
+	old := map[ObjectRef]int{}
+	for i := range replicaSets {
+		rs := &replicaSets[i]
+		if rs.Spec.Replicas == nil || *rs.Spec.Replicas > 0 {
+			continue
+		}
+		if owner := v1.GetControllerOf(rs); owner != nil {
+			if owner.Kind == "Deployment" {
+				old[ObjectRef{GroupVersionKind: deploymentKind, Namespace: rs.Namespace, Name: owner.Name}]++
+			}
+			continue
+		}
+		// Only ReplicaSets a Deployment created carry a pod-template-hash, other ones may be scaled down on purpose
+		if _, ok := rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; !ok {
+			continue
+		}
+		found.add(SeverityWarn, objectRef(replicaSetKind, rs), "OrphanedReplicaSet", "ReplicaSet %s/%s is scaled to zero and no Deployment owns it anymore", rs.Namespace, rs.Name)
+		found.remedy("kubectl delete replicaset %s -n %s, or flare check --fix", rs.Name, rs.Namespace)
+		*fixes = append(*fixes, deleteOrphanFix(withKind(rs.DeepCopy(), "apps/v1", "ReplicaSet"), func(ctx context.Context, clientset kubernetes.Interface) error {
+			return clientset.AppsV1().ReplicaSets(rs.Namespace).Delete(ctx, rs.Name, v1.DeleteOptions{})
+		}))
+	}
+	deployments := make([]ObjectRef, 0, len(old))
+	for d, n := range old {
+		if n > defaultRevisionHistoryLimit {
+			deployments = append(deployments, d)
+		}
+	}
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].String() < deployments[j].String() })
+	for _, d := range deployments {
+		found.add(SeverityWarn, d, "ReplicaSetsPiledUp", "%s keeps %d old ReplicaSets scaled to zero, lower its revisionHistoryLimit", d, old[d])
+		found.remedy("kubectl patch deployment %s -n %s -p '{\"spec\":{\"revisionHistoryLimit\":%d}}'", d.Name, d.Namespace, defaultRevisionHistoryLimit)
+	}
+}
@@ -0,0 +1,254 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Container waiting reasons that mean the container will not start without intervention
+var badWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"CreateContainerConfigError": true,
+}
+
+// Check for containers stuck in CrashLoopBackOff, image pull or config errors in all namespaces
+// Deployments with crash looping pods are offered a restart with --fix
+func checkPodWaiting(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	var fixes []Fix
+	restarts := map[string]bool{}
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for _, pod := range pods {
+			statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+			for _, container := range statuses {
+				if container.State.Waiting == nil || !badWaitingReasons[container.State.Waiting.Reason] {
+					continue
+				}
+				found.add(SeverityFail, objectRef(podKind, &pod), container.State.Waiting.Reason, "Pod %s/%s container %s is in %s: %s", pod.Namespace, pod.Name, container.Name, container.State.Waiting.Reason, container.State.Waiting.Message)
+				switch container.State.Waiting.Reason {
+				case "CrashLoopBackOff":
+					found.remedy("kubectl logs %s -n %s -c %s --previous", pod.Name, pod.Namespace, container.Name)
+				case "CreateContainerConfigError":
+					found.remedy("Create the ConfigMap or Secret container %s refers to, kubectl describe pod %s -n %s names it", container.Name, pod.Name, pod.Namespace)
+				default:
+					found.remedy("Check the image name, its tag and the imagePullSecrets of the pod, kubectl describe pod %s -n %s", pod.Name, pod.Namespace)
+				}
+				if container.State.Waiting.Reason != "CrashLoopBackOff" {
+					continue
+				}
+				d, err := podDeployment(ctx, clientset, &pod)
+				if err != nil {
+					return errorResult(fmt.Errorf("failed getting deployment of pod %s/%s: %w", pod.Namespace, pod.Name, err))
+				}
+				if d != nil && !restarts[d.Namespace+"/"+d.Name] {
+					restarts[d.Namespace+"/"+d.Name] = true
+					fixes = append(fixes, restartDeploymentFix(d))
+				}
+			}
+		}
+	}
+	if len(found) == 0 {
+		return found.result()
+	}
+	r := found.result()
+	r.details = podWaitingDetails
+	r.Details = podWaitingDetails(r.Findings)
+	r.Fixes = fixes
+	return r
+}
+
+// The failing containers per namespace followed by the findings, one per container
+func podWaitingDetails(found []Finding) string {
+	counts := map[string]int{}
+	details := ""
+	for _, f := range found {
+		counts[f.Object.Namespace]++
+		details += f.Message + "\n"
+	}
+	namespaces := make([]string, 0, len(counts))
+	for ns := range counts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	summary := ""
+	for _, ns := range namespaces {
+		summary += fmt.Sprintf("Namespace %s: %d failing containers\n", ns, counts[ns])
+	}
+	return summary + details
+}
+
+// The Deployment that owns a pod through its ReplicaSet, nil if the pod is not part of one
+func podDeployment(ctx context.Context, clientset kubernetes.Interface, pod *corev1.Pod) (*appsv1.Deployment, error) {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind != "ReplicaSet" {
+			continue
+		}
+		rs, err := clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, rsOwner := range rs.OwnerReferences {
+			if rsOwner.Kind != "Deployment" {
+				continue
+			}
+			d, err := clientset.AppsV1().Deployments(pod.Namespace).Get(ctx, rsOwner.Name, v1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return nil, nil
+			}
+			return d, err
+		}
+	}
+	return nil, nil
+}
+
+// The next step for a pod the scheduler could not place, by the reasons of its message, e.g.
+// "0/3 nodes are available: 3 Insufficient cpu."
+func unschedulableRemedy(pod *corev1.Pod, message string) string {
+	switch {
+	case strings.Contains(message, "Insufficient"):
+		return "Lower the resources.requests of the pod or add nodes with room for them"
+	case strings.Contains(message, "untolerated taint") || strings.Contains(message, "had taint"):
+		return "Add a toleration for the taints of the nodes to the pod, or remove the taints"
+	case strings.Contains(message, "affinity") || strings.Contains(message, "selector"):
+		return "Fix the nodeSelector or affinity of the pod to match the labels of the nodes"
+	case strings.Contains(message, "PersistentVolumeClaim") || strings.Contains(message, "volume node affinity"):
+		return "Check the PersistentVolumeClaims of the pod, see the storage check"
+	}
+	return kubectl("describe", objectRef(podKind, pod))
+}
+
+// Check for pods that the scheduler could not place and group them by the scheduler's reason
+func checkPendingPods(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	// The scheduler message of every unschedulable pod
+	messages := map[ObjectRef]string{}
+	var found findings
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		// The latest FailedScheduling event per pod, its message is used when the condition has none.
+		// The reason is checked again as offline clientsets ignore field selectors.
+		latest := map[string]corev1.Event{}
+		page := v1.ListOptions{FieldSelector: "reason=FailedScheduling", Limit: ListPageSize}
+		for {
+			events, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			for _, event := range events.Items {
+				if event.Reason != "FailedScheduling" || event.InvolvedObject.Kind != "Pod" {
+					continue
+				}
+				key := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name
+				if previous, ok := latest[key]; !ok || EventTime(event).After(EventTime(previous)) {
+					latest[key] = event
+				}
+			}
+			if page.Continue = events.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		for _, pod := range pods {
+			if pod.Status.Phase != corev1.PodPending {
+				continue
+			}
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type != corev1.PodScheduled || condition.Status != corev1.ConditionFalse {
+					continue
+				}
+				message := condition.Message
+				if message == "" {
+					message = latest[pod.Namespace+"/"+pod.Name].Message
+				}
+				if message == "" {
+					message = condition.Reason
+				}
+				messages[objectRef(podKind, &pod)] = message
+				found.add(SeverityFail, objectRef(podKind, &pod), "Unschedulable", "Pod %s/%s is unschedulable: %s", pod.Namespace, pod.Name, message)
+				found.remedy("%s", unschedulableRemedy(&pod, message))
+			}
+		}
+	}
+
+	// The findings are per pod while the details group the pods by namespace and reason
+	details := func(found []Finding) string {
+		// namespace -> scheduler message -> pod names
+		reasons := map[string]map[string][]string{}
+		for _, f := range found {
+			if reasons[f.Object.Namespace] == nil {
+				reasons[f.Object.Namespace] = map[string][]string{}
+			}
+			message := messages[f.Object]
+			reasons[f.Object.Namespace][message] = append(reasons[f.Object.Namespace][message], f.Object.Name)
+		}
+		info := ""
+		for _, ns := range sortedKeys(reasons) {
+			info += fmt.Sprintf("Namespace %s:\n", ns)
+			grouped := make([]string, 0, len(reasons[ns]))
+			for message := range reasons[ns] {
+				grouped = append(grouped, message)
+			}
+			sort.Strings(grouped)
+			for _, message := range grouped {
+				pods := reasons[ns][message]
+				sort.Strings(pods)
+				info += fmt.Sprintf("  %d unschedulable pods: %s\n    %s\n", len(pods), message, strings.Join(pods, ", "))
+			}
+		}
+		return info
+	}
+	r := found.result()
+	r.details = details
+	r.Details = details(r.Findings)
+	return r
+}
+
+// Sorted keys of a map of namespaces, for stable output
+func sortedKeys(m map[string]map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Whether the pod's Ready condition is True
+func podReady(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// The controller of a pod, e.g. its ReplicaSet, or the pod itself when it has none. The pods of a
+// controller share their spec, so checks of the spec report them once by their controller.
+func podController(pod *corev1.Pod) (ObjectRef, bool) {
+	owner := v1.GetControllerOf(pod)
+	if owner == nil {
+		return objectRef(podKind, pod), false
+	}
+	return ObjectRef{GroupVersionKind: schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind), Namespace: pod.Namespace, Name: owner.Name}, true
+}
@@ -0,0 +1,93 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Check pods that weaken the isolation from their node: privileged containers, pods sharing the
+// network, PID or IPC namespace of the node, hostPath volumes and containers running as root,
+// either with runAsUser 0 or without any securityContext, so the user of the image applies.
+// The pods of Options.PodSecurityExempt, kube-system by default, are not reported.
+func checkPodSecurity(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	exempt := map[string]bool{}
+	for _, ns := range opts.podSecurityExempt() {
+		exempt[ns] = true
+	}
+	var found findings
+	seen := map[ObjectRef]bool{}
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for i := range pods {
+			pod := &pods[i]
+			if exempt[pod.Namespace] || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			object, _ := podController(pod)
+			if seen[object] {
+				continue
+			}
+			seen[object] = true
+
+			var host []string
+			if pod.Spec.HostNetwork {
+				host = append(host, "network")
+			}
+			if pod.Spec.HostPID {
+				host = append(host, "PID")
+			}
+			if pod.Spec.HostIPC {
+				host = append(host, "IPC")
+			}
+			if len(host) > 0 {
+				namespaces := "namespace"
+				if len(host) > 1 {
+					namespaces += "s"
+				}
+				found.add(SeverityWarn, object, "HostNamespace", "%s shares the %s %s of its node", object, strings.Join(host, " and "), namespaces)
+				found.remedy("Remove hostNetwork, hostPID and hostIPC from the pod spec of %s unless it must see the node", object)
+			}
+			var paths []string
+			for _, v := range pod.Spec.Volumes {
+				if v.HostPath != nil {
+					paths = append(paths, v.HostPath.Path)
+				}
+			}
+			if len(paths) > 0 {
+				found.add(SeverityWarn, object, "HostPathMount", "%s mounts the host paths %s", object, strings.Join(paths, ", "))
+				found.remedy("Replace the hostPath volumes of %s with an emptyDir, a ConfigMap or a PersistentVolumeClaim", object)
+			}
+			for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+				if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+					found.add(SeverityWarn, object, "Privileged", "%s container %s is privileged, it has full access to its node", object, c.Name)
+					found.remedy("Drop privileged from container %s of %s and add only the capabilities it needs", c.Name, object)
+				} else if runsAsRoot(pod, c) {
+					found.add(SeverityWarn, object, "RunAsRoot", "%s container %s runs as root, set runAsUser or runAsNonRoot", object, c.Name)
+					found.remedy("Set securityContext.runAsNonRoot: true and a runAsUser on container %s of %s", c.Name, object)
+				}
+			}
+		}
+	}
+	return found.result()
+}
+
+// Whether a container runs as root according to its securityContext and the one of its pod.
+// Without either the image's user applies, which usually is root.
+func runsAsRoot(pod *corev1.Pod, c corev1.Container) bool {
+	if c.SecurityContext != nil && c.SecurityContext.RunAsUser != nil {
+		return *c.SecurityContext.RunAsUser == 0
+	}
+	if pod.Spec.SecurityContext != nil && pod.Spec.SecurityContext.RunAsUser != nil {
+		return *pod.Spec.SecurityContext.RunAsUser == 0
+	}
+	// The API server defaults the securityContext of pods to an empty one
+	return c.SecurityContext == nil && (pod.Spec.SecurityContext == nil || reflect.DeepEqual(*pod.Spec.SecurityContext, corev1.PodSecurityContext{}))
+}
@@ -0,0 +1,73 @@
+package flare
+
+import (
+	"context"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Resources that are not namespaced, their permissions are always reviewed cluster wide
+var clusterScopedResources = map[string]bool{
+	"nodes":                           true,
+	"namespaces":                      true,
+	"persistentvolumes":               true,
+	"clusterroles":                    true,
+	"clusterrolebindings":             true,
+	"mutatingwebhookconfigurations":   true,
+	"validatingwebhookconfigurations": true,
+	"storageclasses":                  true,
+	"ingressclasses":                  true,
+	"nodeclaims":                      true,
+	"clusterissuers":                  true,
+}
+
+// Review the permissions of the selected checks with SelfSubjectAccessReviews before running them.
+// Checks missing a permission are replaced by one reporting them as skipped, so users with
+// limited access see what flare could not verify rather than a permissions error per check.
+// A review that fails is treated as allowed and leaves it to the check to report the error.
+func preflight(ctx context.Context, clientset kubernetes.Interface, opts *Options, selected []Check) []Check {
+	reviewed := map[authorizationv1.ResourceAttributes]bool{}
+	allowed := func(attributes authorizationv1.ResourceAttributes) bool {
+		if result, ok := reviewed[attributes]; ok {
+			return result
+		}
+		review := &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attributes}}
+		response, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, v1.CreateOptions{})
+		reviewed[attributes] = err != nil || response.Status.Allowed
+		return reviewed[attributes]
+	}
+
+	checked := make([]Check, len(selected))
+	for i, c := range selected {
+		checked[i] = c
+		var missing []string
+		for _, p := range c.Permissions {
+			if p.Unscoped && len(opts.Namespaces) > 0 {
+				continue
+			}
+			namespaces := opts.namespaces()
+			if p.ClusterScoped || clusterScopedResources[p.Resource] {
+				namespaces = []string{v1.NamespaceAll}
+			}
+			for _, ns := range namespaces {
+				if !allowed(authorizationv1.ResourceAttributes{Namespace: ns, Verb: p.Verb, Group: p.Group, Resource: p.Resource}) {
+					if ns != v1.NamespaceAll {
+						missing = append(missing, p.String()+" in "+ns)
+					} else {
+						missing = append(missing, p.String())
+					}
+				}
+			}
+		}
+		if len(missing) > 0 {
+			details := "Skipped, missing permissions: " + strings.Join(missing, ", ") + "\n"
+			checked[i].Run = func(context.Context, kubernetes.Interface, *Options) Result {
+				return Result{Skipped: true, Severity: SeverityInfo, Details: details}
+			}
+		}
+	}
+	return checked
+}
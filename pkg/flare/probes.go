@@ -0,0 +1,118 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Defaults of the dns-probe check when the Options leave them empty
+const (
+	defaultProbeImage     = "busybox:1.35"
+	defaultProbeNamespace = "default"
+)
+
+// Names the dns-probe pod resolves, one served by the cluster DNS and one forwarded upstream
+var probeNames = []string{"kubernetes.default", "example.com"}
+
+// Lookups slower than this are reported by the dns-probe check
+const slowLookup = time.Second
+
+// The lines busybox `time` prints, "real\t0m 0.01s"
+var probeTimeLine = regexp.MustCompile(`real\s+(\d+)m\s*([\d.]+)s`)
+
+// Resolve probeNames from a short lived busybox pod to prove the DNS datapath works, not
+// just that CoreDNS is running. The pod is deleted when the check ends.
+func checkDNSProbe(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	image, namespace := opts.ProbeImage, opts.ProbeNamespace
+	if image == "" {
+		image = defaultProbeImage
+	}
+	if namespace == "" {
+		namespace = defaultProbeNamespace
+	}
+	script := "exec 2>&1; for name in " + strings.Join(probeNames, " ") + `; do echo "lookup $name"; time nslookup $name >/dev/null || echo failed; done`
+	deadline := int64(60)
+	pod, err := clientset.CoreV1().Pods(namespace).Create(ctx, &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{GenerateName: "flare-dns-probe-", Labels: map[string]string{"app.kubernetes.io/name": "flare-dns-probe"}},
+		Spec: corev1.PodSpec{
+			RestartPolicy:         corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds: &deadline,
+			Containers:            []corev1.Container{{Name: "probe", Image: image, Command: []string{"sh", "-c", script}}},
+		},
+	}, v1.CreateOptions{})
+	if err != nil {
+		return errorResult(fmt.Errorf("failed creating the probe pod: %w", err))
+	}
+	defer func() {
+		// Clean up even when ctx timed out
+		grace := int64(0)
+		_ = clientset.CoreV1().Pods(namespace).Delete(context.Background(), pod.Name, v1.DeleteOptions{GracePeriodSeconds: &grace})
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		select {
+		case <-ctx.Done():
+			var found findings
+			found.add(SeverityFail, objectRef(podKind, pod), "ProbeTimeout", "Probe pod %s/%s did not finish, it is %s", namespace, pod.Name, pod.Status.Phase)
+			found.remedy("kubectl get events -n %s --field-selector involvedObject.name=%s tells why the probe pod did not run", namespace, pod.Name)
+			return found.result()
+		case <-ticker.C:
+		}
+		if pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, pod.Name, v1.GetOptions{}); err != nil {
+			return errorResult(fmt.Errorf("failed getting the probe pod: %w", err))
+		}
+	}
+	logs, err := clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting the probe logs: %w", err))
+	}
+	var found findings
+	probeFindings(&found, string(logs))
+	return found.result()
+}
+
+// Record the failed and slow lookups from the output of the probe script
+func probeFindings(found *findings, logs string) {
+	name := ""
+	seen := map[string]bool{}
+	for _, line := range strings.Split(logs, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "lookup "):
+			name = strings.TrimPrefix(line, "lookup ")
+			seen[name] = true
+		case line == "failed":
+			found.add(SeverityFail, ObjectRef{}, "LookupFailed", "DNS lookup of %s failed from a pod", name)
+			found.remedy("Check the dns check and the NetworkPolicies of the probe namespace, kubectl logs -n kube-system -l k8s-app=kube-dns")
+		default:
+			m := probeTimeLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			minutes, _ := strconv.Atoi(m[1])
+			seconds, _ := strconv.ParseFloat(m[2], 64)
+			took := time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+			if took > slowLookup {
+				found.add(SeverityFail, ObjectRef{}, "SlowLookup", "DNS lookup of %s took %s from a pod", name, took)
+				found.remedy("Check the load of the CoreDNS pods and their upstream servers, add CoreDNS replicas or NodeLocal DNSCache")
+			}
+		}
+	}
+	for _, n := range probeNames {
+		if !seen[n] {
+			found.add(SeverityFail, ObjectRef{}, "LookupNotRun", "DNS lookup of %s did not run, probe output: %q", n, strings.TrimSpace(logs))
+			found.remedy("Check that the probe image can be pulled and runs nslookup")
+			break
+		}
+	}
+}
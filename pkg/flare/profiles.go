@@ -0,0 +1,31 @@
+package flare
+
+import (
+	"sort"
+)
+
+// The built-in profiles, curated bundles of checks for a common question about the cluster
+var profiles = map[string][]string{
+	"networking": {"cni", "dns", "dns-probe", "endpoints", "ingress", "netpol"},
+	"storage":    {"storage", "pending", "finalizers", "orphans", "quota"},
+	"security":   {"certs", "cert-manager", "pod-security", "psa-labels", "serviceaccounts", "rbac", "netpol", "images"},
+	// Everything that blocks or breaks a control plane or node upgrade
+	"upgrade-readiness": {"api", "nodes", "versions", "deprecated-apis", "etcd", "webhooks", "pdb", "availability", "certs", "helm"},
+}
+
+// ProfileNames returns the names of the built-in profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the check IDs of a built-in profile, false if there is no such profile.
+// Profiles may include active checks, they are left out of runs without Options.ActiveProbes.
+func Profile(name string) ([]string, bool) {
+	ids, ok := profiles[name]
+	return append([]string(nil), ids...), ok
+}
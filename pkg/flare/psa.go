@@ -0,0 +1,74 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// The label prefix of the Pod Security Admission modes, e.g. pod-security.kubernetes.io/enforce
+const podSecurityLabelPrefix = "pod-security.kubernetes.io/"
+
+// The Pod Security Standards levels, from the least to the most restrictive
+var podSecurityLevels = map[string]int{"privileged": 0, "baseline": 1, "restricted": 2}
+
+// Check the Pod Security Admission labels of namespaces: namespaces without any of them, labels
+// with an unknown level and, with Options.PodSecurityLevel, namespaces not enforcing a level and
+// enforce, warn and audit modes less restrictive than that level.
+// The namespaces of Options.PodSecurityExempt, kube-system by default, are not reported.
+func checkPodSecurityLabels(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	expected, ok := podSecurityLevels[opts.PodSecurityLevel]
+	if opts.PodSecurityLevel != "" && !ok {
+		return errorResult(fmt.Errorf("unknown pod security level %q, must be one of: privileged, baseline, restricted", opts.PodSecurityLevel))
+	}
+	exempt := map[string]bool{}
+	for _, ns := range opts.podSecurityExempt() {
+		exempt[ns] = true
+	}
+	namespaces, err := scopedNamespaces(ctx, clientset, opts)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting namespaces: %w", err))
+	}
+	var found findings
+	for i := range namespaces {
+		ns := &namespaces[i]
+		if exempt[ns.Name] {
+			continue
+		}
+		object := objectRef(namespaceKind, ns)
+		modes := map[string]string{}
+		for _, mode := range []string{"enforce", "warn", "audit"} {
+			if level, ok := ns.Labels[podSecurityLabelPrefix+mode]; ok {
+				modes[mode] = level
+			}
+		}
+		if len(modes) == 0 {
+			found.add(SeverityWarn, object, "NoPodSecurityLabels", "Namespace %s has no pod-security.kubernetes.io labels, only the cluster default applies", ns.Name)
+			level := opts.PodSecurityLevel
+			if level == "" {
+				level = "baseline"
+			}
+			found.remedy("kubectl label namespace %s %senforce=%s", ns.Name, podSecurityLabelPrefix, level)
+			continue
+		}
+		if _, ok := modes["enforce"]; !ok && opts.PodSecurityLevel != "" {
+			found.add(SeverityWarn, object, "PodSecurityNotEnforced", "Namespace %s does not enforce a pod security level, expected %s", ns.Name, opts.PodSecurityLevel)
+			found.remedy("kubectl label namespace %s %senforce=%s", ns.Name, podSecurityLabelPrefix, opts.PodSecurityLevel)
+		}
+		for _, mode := range []string{"enforce", "warn", "audit"} {
+			level, ok := modes[mode]
+			if !ok {
+				continue
+			}
+			if rank, known := podSecurityLevels[level]; !known {
+				found.add(SeverityWarn, object, "InvalidPodSecurityLevel", "Namespace %s has the unknown %s level %q", ns.Name, mode, level)
+				found.remedy("kubectl label namespace %s %s%s=<privileged, baseline or restricted> --overwrite", ns.Name, podSecurityLabelPrefix, mode)
+			} else if opts.PodSecurityLevel != "" && rank < expected {
+				found.add(SeverityWarn, object, "PodSecurityBelowExpected", "Namespace %s has the %s level %s, less restrictive than the expected %s", ns.Name, mode, level, opts.PodSecurityLevel)
+				found.remedy("kubectl label namespace %s %s%s=%s --overwrite once its pods comply", ns.Name, podSecurityLabelPrefix, mode, opts.PodSecurityLevel)
+			}
+		}
+	}
+	return found.result()
+}
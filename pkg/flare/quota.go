@@ -0,0 +1,116 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Used by the quota check when Options.QuotaThreshold is not set
+const defaultQuotaThreshold = 90
+
+// Quota resources that make the apiserver reject containers without requests or limits for them
+var computeQuotaResources = map[corev1.ResourceName]bool{
+	corev1.ResourceCPU:            true,
+	corev1.ResourceMemory:         true,
+	corev1.ResourceRequestsCPU:    true,
+	corev1.ResourceRequestsMemory: true,
+	corev1.ResourceLimitsCPU:      true,
+	corev1.ResourceLimitsMemory:   true,
+}
+
+// Check ResourceQuotas whose usage reached the threshold percentage of their hard limit and
+// namespaces with compute quotas but no LimitRange defaults, where pods without requests are rejected
+func checkQuotas(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	threshold := opts.QuotaThreshold
+	if threshold <= 0 {
+		threshold = defaultQuotaThreshold
+	}
+	var found findings
+	for _, ns := range opts.namespaces() {
+		// Namespaces with a quota on compute resources
+		computeQuotas := map[string]string{}
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			quotas, err := clientset.CoreV1().ResourceQuotas(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting resourcequotas: %w", err))
+			}
+			for _, q := range quotas.Items {
+				if usage := quotaUsage(q, threshold); usage != "" {
+					found.add(SeverityWarn, objectRef(quotaKind, &q), "QuotaNearlyExhausted", "ResourceQuota %s/%s is nearly exhausted: %s", q.Namespace, q.Name, usage)
+					found.remedy("Raise the limits of ResourceQuota %s/%s or free resources in the namespace, kubectl describe resourcequota %s -n %s", q.Namespace, q.Name, q.Name, q.Namespace)
+				}
+				for name := range q.Spec.Hard {
+					if computeQuotaResources[name] {
+						computeQuotas[q.Namespace] = q.Name
+					}
+				}
+			}
+			if page.Continue = quotas.Continue; page.Continue == "" {
+				break
+			}
+		}
+		if len(computeQuotas) == 0 {
+			continue
+		}
+
+		defaults := map[string]bool{}
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			limitRanges, err := clientset.CoreV1().LimitRanges(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting limitranges: %w", err))
+			}
+			for _, lr := range limitRanges.Items {
+				for _, l := range lr.Spec.Limits {
+					if l.Type == corev1.LimitTypeContainer && (len(l.Default) > 0 || len(l.DefaultRequest) > 0) {
+						defaults[lr.Namespace] = true
+					}
+				}
+			}
+			if page.Continue = limitRanges.Continue; page.Continue == "" {
+				break
+			}
+		}
+		namespaces := make([]string, 0, len(computeQuotas))
+		for namespace := range computeQuotas {
+			namespaces = append(namespaces, namespace)
+		}
+		sort.Strings(namespaces)
+		for _, namespace := range namespaces {
+			if !defaults[namespace] {
+				found.add(SeverityWarn, ObjectRef{GroupVersionKind: namespaceKind, Name: namespace}, "NoLimitRangeDefaults", "Namespace %s has ResourceQuota %s on compute resources but no LimitRange defaults, pods without requests are rejected", namespace, computeQuotas[namespace])
+				found.remedy("Add a LimitRange with default requests and limits to namespace %s", namespace)
+			}
+		}
+	}
+	return found.result()
+}
+
+// List the resources of a quota whose usage is at or above threshold percent of the hard limit, "" if there are none
+func quotaUsage(q corev1.ResourceQuota, threshold int) string {
+	var names []string
+	for name := range q.Status.Hard {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	var full []string
+	for _, name := range names {
+		hard := q.Status.Hard[corev1.ResourceName(name)]
+		used, ok := q.Status.Used[corev1.ResourceName(name)]
+		if !ok || hard.IsZero() {
+			continue
+		}
+		percent := used.MilliValue() * 100 / hard.MilliValue()
+		if percent >= int64(threshold) {
+			full = append(full, fmt.Sprintf("%s %s/%s (%d%%)", name, used.String(), hard.String(), percent))
+		}
+	}
+	return strings.Join(full, ", ")
+}
@@ -0,0 +1,163 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Check RoleBindings and ClusterRoleBindings for missing roles, deleted ServiceAccounts and
+// non-system subjects that are granted cluster-admin
+// ClusterRoleBindings are cluster scoped and only checked when the run is not limited to namespaces
+func checkRBAC(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	clusterRoleNames := map[string]bool{}
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		clusterRoles, err := clientset.RbacV1().ClusterRoles().List(ctx, page)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting clusterroles: %w", err))
+		}
+		for _, r := range clusterRoles.Items {
+			clusterRoleNames[r.Name] = true
+		}
+		if page.Continue = clusterRoles.Continue; page.Continue == "" {
+			break
+		}
+	}
+	// ServiceAccounts are looked up per namespace and cached, "ns/name" -> exists
+	serviceAccounts := map[string]bool{}
+	listedNamespaces := map[string]bool{}
+	serviceAccountExists := func(namespace string, name string) (bool, error) {
+		if !listedNamespaces[namespace] {
+			page := v1.ListOptions{Limit: ListPageSize}
+			for {
+				list, err := clientset.CoreV1().ServiceAccounts(namespace).List(ctx, page)
+				if err != nil {
+					return false, err
+				}
+				for _, sa := range list.Items {
+					serviceAccounts[sa.Namespace+"/"+sa.Name] = true
+				}
+				if page.Continue = list.Continue; page.Continue == "" {
+					break
+				}
+			}
+			listedNamespaces[namespace] = true
+		}
+		return serviceAccounts[namespace+"/"+name], nil
+	}
+	// Report subjects of a binding that are ServiceAccounts which no longer exist
+	danglingSubjects := func(object ObjectRef, binding string, subjects []rbacv1.Subject) error {
+		for _, s := range subjects {
+			if s.Kind != rbacv1.ServiceAccountKind {
+				continue
+			}
+			exists, err := serviceAccountExists(s.Namespace, s.Name)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				found.add(SeverityWarn, object, "ServiceAccountNotFound", "%s references deleted ServiceAccount %s/%s", binding, s.Namespace, s.Name)
+				found.remedy("Remove ServiceAccount %s/%s from the subjects of %s, or delete the binding if it has no other subjects", s.Namespace, s.Name, binding)
+			}
+		}
+		return nil
+	}
+
+	if len(opts.Namespaces) == 0 {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			bindings, err := clientset.RbacV1().ClusterRoleBindings().List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting clusterrolebindings: %w", err))
+			}
+			for _, b := range bindings.Items {
+				name := "ClusterRoleBinding " + b.Name
+				object := objectRef(clusterBindingKind, &b)
+				if !clusterRoleNames[b.RoleRef.Name] {
+					found.add(SeverityWarn, object, "RoleNotFound", "%s is bound to missing ClusterRole %s", name, b.RoleRef.Name)
+					found.remedy("Create %s %s or %s", b.RoleRef.Kind, b.RoleRef.Name, kubectl("delete", object))
+				}
+				if err := danglingSubjects(object, name, b.Subjects); err != nil {
+					return errorResult(fmt.Errorf("failed getting serviceaccounts: %w", err))
+				}
+				if b.RoleRef.Name != "cluster-admin" {
+					continue
+				}
+				for _, s := range b.Subjects {
+					if !isSystemSubject(s) {
+						found.add(SeverityWarn, object, "ClusterAdminGranted", "%s grants cluster-admin to %s %s", name, s.Kind, subjectName(s))
+						found.remedy("Bind %s %s to a role with only the permissions it needs instead of cluster-admin", s.Kind, subjectName(s))
+					}
+				}
+			}
+			if page.Continue = bindings.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	for _, ns := range opts.namespaces() {
+		roleNames := map[string]bool{}
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			roles, err := clientset.RbacV1().Roles(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting roles: %w", err))
+			}
+			for _, r := range roles.Items {
+				roleNames[r.Namespace+"/"+r.Name] = true
+			}
+			if page.Continue = roles.Continue; page.Continue == "" {
+				break
+			}
+		}
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			bindings, err := clientset.RbacV1().RoleBindings(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting rolebindings: %w", err))
+			}
+			for _, b := range bindings.Items {
+				name := fmt.Sprintf("RoleBinding %s/%s", b.Namespace, b.Name)
+				object := objectRef(bindingKind, &b)
+				if b.RoleRef.Kind == "Role" && !roleNames[b.Namespace+"/"+b.RoleRef.Name] {
+					found.add(SeverityWarn, object, "RoleNotFound", "%s is bound to missing Role %s", name, b.RoleRef.Name)
+					found.remedy("Create %s %s or %s", b.RoleRef.Kind, b.RoleRef.Name, kubectl("delete", object))
+				}
+				if b.RoleRef.Kind == "ClusterRole" && !clusterRoleNames[b.RoleRef.Name] {
+					found.add(SeverityWarn, object, "RoleNotFound", "%s is bound to missing ClusterRole %s", name, b.RoleRef.Name)
+					found.remedy("Create %s %s or %s", b.RoleRef.Kind, b.RoleRef.Name, kubectl("delete", object))
+				}
+				if err := danglingSubjects(object, name, b.Subjects); err != nil {
+					return errorResult(fmt.Errorf("failed getting serviceaccounts: %w", err))
+				}
+			}
+			if page.Continue = bindings.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
+
+// Subjects managed by Kubernetes itself, e.g. system:masters or ServiceAccounts in kube-system
+func isSystemSubject(s rbacv1.Subject) bool {
+	if strings.HasPrefix(s.Name, "system:") {
+		return true
+	}
+	return s.Kind == rbacv1.ServiceAccountKind && s.Namespace == "kube-system"
+}
+
+// The name of a subject, namespaced for ServiceAccounts
+func subjectName(s rbacv1.Subject) string {
+	if s.Kind == rbacv1.ServiceAccountKind {
+		return s.Namespace + "/" + s.Name
+	}
+	return s.Name
+}
@@ -0,0 +1,148 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// configReference is a Secret or ConfigMap a pod needs to start, and optionally one of its keys
+type configReference struct {
+	kind  string
+	name  string
+	key   string
+	usage string
+}
+
+// Check pods referencing Secrets or ConfigMaps, or keys of them, that do not exist in their
+// namespace. These keep containers in CreateContainerConfigError or pods in ContainerCreating.
+// Optional references are skipped, missing imagePullSecrets are warnings since public images still pull.
+func checkReferences(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	// The keys of the Secrets and ConfigMaps looked up so far, nil for missing objects
+	found := map[string]map[string]bool{}
+	lookup := func(namespace string, ref configReference) (map[string]bool, error) {
+		id := ref.kind + "/" + namespace + "/" + ref.name
+		if keys, ok := found[id]; ok {
+			return keys, nil
+		}
+		var keys map[string]bool
+		var err error
+		if ref.kind == "Secret" {
+			var secret *corev1.Secret
+			if secret, err = clientset.CoreV1().Secrets(namespace).Get(ctx, ref.name, v1.GetOptions{}); err == nil {
+				keys = map[string]bool{}
+				for k := range secret.Data {
+					keys[k] = true
+				}
+			}
+		} else {
+			var cm *corev1.ConfigMap
+			if cm, err = clientset.CoreV1().ConfigMaps(namespace).Get(ctx, ref.name, v1.GetOptions{}); err == nil {
+				keys = map[string]bool{}
+				for k := range cm.Data {
+					keys[k] = true
+				}
+				for k := range cm.BinaryData {
+					keys[k] = true
+				}
+			}
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed getting %s %s/%s: %w", ref.kind, namespace, ref.name, err)
+		}
+		found[id] = keys
+		return keys, nil
+	}
+
+	var problems findings
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		for _, pod := range pods {
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			for _, ref := range podReferences(&pod) {
+				keys, err := lookup(pod.Namespace, ref)
+				if err != nil {
+					return errorResult(err)
+				}
+				object := objectRef(podKind, &pod)
+				switch {
+				case keys == nil && ref.usage == "imagePullSecrets":
+					problems.add(SeverityWarn, object, "MissingSecret", "Pod %s/%s imagePullSecrets references Secret %s, which does not exist", pod.Namespace, pod.Name, ref.name)
+					problems.remedy("Create Secret %s/%s, e.g. kubectl create secret docker-registry %s -n %s, or remove it from imagePullSecrets", pod.Namespace, ref.name, ref.name, pod.Namespace)
+				case keys == nil:
+					problems.add(SeverityFail, object, "Missing"+ref.kind, "Pod %s/%s %s references %s %s, which does not exist", pod.Namespace, pod.Name, ref.usage, ref.kind, ref.name)
+					problems.remedy("Create %s %s/%s or fix the %s of the pod", ref.kind, pod.Namespace, ref.name, ref.usage)
+				case ref.key != "" && !keys[ref.key]:
+					problems.add(SeverityFail, object, "Missing"+ref.kind+"Key", "Pod %s/%s %s references key %s of %s %s, which does not exist", pod.Namespace, pod.Name, ref.usage, ref.key, ref.kind, ref.name)
+					problems.remedy("Add key %s to %s %s/%s or fix the %s of the pod", ref.key, ref.kind, pod.Namespace, ref.name, ref.usage)
+				}
+			}
+		}
+	}
+	return problems.result()
+}
+
+// The required Secret and ConfigMap references of a pod from its env, envFrom, volumes and imagePullSecrets
+func podReferences(pod *corev1.Pod) []configReference {
+	var refs []configReference
+	required := func(optional *bool) bool {
+		return optional == nil || !*optional
+	}
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			usage := fmt.Sprintf("container %s env %s", c.Name, env.Name)
+			if s := env.ValueFrom.SecretKeyRef; s != nil && required(s.Optional) {
+				refs = append(refs, configReference{kind: "Secret", name: s.Name, key: s.Key, usage: usage})
+			}
+			if cm := env.ValueFrom.ConfigMapKeyRef; cm != nil && required(cm.Optional) {
+				refs = append(refs, configReference{kind: "ConfigMap", name: cm.Name, key: cm.Key, usage: usage})
+			}
+		}
+		for _, from := range c.EnvFrom {
+			usage := fmt.Sprintf("container %s envFrom", c.Name)
+			if s := from.SecretRef; s != nil && required(s.Optional) {
+				refs = append(refs, configReference{kind: "Secret", name: s.Name, usage: usage})
+			}
+			if cm := from.ConfigMapRef; cm != nil && required(cm.Optional) {
+				refs = append(refs, configReference{kind: "ConfigMap", name: cm.Name, usage: usage})
+			}
+		}
+	}
+	for _, vol := range pod.Spec.Volumes {
+		usage := "volume " + vol.Name
+		if s := vol.Secret; s != nil && required(s.Optional) {
+			refs = append(refs, configReference{kind: "Secret", name: s.SecretName, usage: usage})
+		}
+		if cm := vol.ConfigMap; cm != nil && required(cm.Optional) {
+			refs = append(refs, configReference{kind: "ConfigMap", name: cm.Name, usage: usage})
+		}
+		if vol.Projected == nil {
+			continue
+		}
+		for _, source := range vol.Projected.Sources {
+			if s := source.Secret; s != nil && required(s.Optional) {
+				refs = append(refs, configReference{kind: "Secret", name: s.Name, usage: usage})
+			}
+			if cm := source.ConfigMap; cm != nil && required(cm.Optional) {
+				refs = append(refs, configReference{kind: "ConfigMap", name: cm.Name, usage: usage})
+			}
+		}
+	}
+	for _, s := range pod.Spec.ImagePullSecrets {
+		refs = append(refs, configReference{kind: "Secret", name: s.Name, usage: "imagePullSecrets"})
+	}
+	return refs
+}
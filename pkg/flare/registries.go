@@ -0,0 +1,182 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// A registry whose pulls fail for at least this many images or pods has a problem of its own,
+// fewer failures are usually a wrong tag and left to the events check
+const (
+	widespreadPullImages = 2
+	widespreadPullPods   = 3
+)
+
+// Pulls taking at least this long are reported as slow
+const slowPullDuration = 2 * time.Minute
+
+// Why a pull failed, from the message of its event
+const (
+	pullNotFound     = "not found"
+	pullUnauthorized = "unauthorized"
+	pullUnreachable  = "unreachable"
+)
+
+// The failed and successful pulls of a registry
+type registryPulls struct {
+	// Failed images and pods by failure kind
+	images map[string]map[string]bool
+	pods   map[string]map[string]bool
+	// The latest failure message by failure kind
+	message map[string]string
+	pulls   int
+	slow    int
+	slowest time.Duration
+	image   string
+}
+
+// Check the image pull events for registries failing the pulls of many images or pods, and for
+// registries whose pulls are slow. The failures are told apart by their messages: images that do
+// not exist, pulls the registry refuses to authorize and registries that cannot be reached or
+// answer with errors, which no tag or pull secret fixes.
+// Options.EventsSince limits the events, like for the events check.
+func checkRegistries(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	registries := map[string]*registryPulls{}
+	registry := func(image string) *registryPulls {
+		name := imageRegistry(image)
+		if registries[name] == nil {
+			registries[name] = &registryPulls{images: map[string]map[string]bool{}, pods: map[string]map[string]bool{}, message: map[string]string{}}
+		}
+		return registries[name]
+	}
+	latest := map[string]time.Time{}
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{FieldSelector: "involvedObject.kind=Pod", Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			for _, event := range list.Items {
+				seen := EventTime(event)
+				if opts.EventsSince > 0 && seen.Before(time.Now().Add(-opts.EventsSince)) {
+					continue
+				}
+				image := quoted(event.Message)
+				switch {
+				case image == "":
+				case event.Reason == "Failed" && strings.HasPrefix(event.Message, "Failed to pull image"):
+					r, kind := registry(image), pullFailure(event.Message)
+					if r.images[kind] == nil {
+						r.images[kind], r.pods[kind] = map[string]bool{}, map[string]bool{}
+					}
+					r.images[kind][image] = true
+					r.pods[kind][event.InvolvedObject.Namespace+"/"+event.InvolvedObject.Name] = true
+					if key := imageRegistry(image) + " " + kind; !seen.Before(latest[key]) {
+						latest[key] = seen
+						r.message[kind] = event.Message
+					}
+				case event.Reason == "Pulled":
+					took, ok := pullDuration(event.Message)
+					if !ok {
+						continue
+					}
+					r := registry(image)
+					r.pulls++
+					if took >= slowPullDuration {
+						r.slow++
+					}
+					if took > r.slowest {
+						r.slowest, r.image = took, image
+					}
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	names := make([]string, 0, len(registries))
+	for name := range registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var found findings
+	for _, name := range names {
+		r := registries[name]
+		for _, kind := range []string{pullUnreachable, pullUnauthorized, pullNotFound} {
+			images, pods := len(r.images[kind]), len(r.pods[kind])
+			if images < widespreadPullImages && pods < widespreadPullPods {
+				continue
+			}
+			switch kind {
+			case pullUnreachable:
+				found.add(SeverityFail, ObjectRef{}, "RegistryUnavailable", "Registry %s failed pulls of %d images for %d pods, the registry looks down or unreachable", name, images, pods)
+				found.remedy("Check that the nodes can reach %s, e.g. DNS, firewall and proxy settings, or mirror its images", name)
+			case pullUnauthorized:
+				found.add(SeverityWarn, ObjectRef{}, "RegistryUnauthorized", "Registry %s refused pulls of %d images for %d pods, check the pull secrets and the registry credentials", name, images, pods)
+				found.remedy("Check the imagePullSecrets of the pods pulling from %s and that their credentials did not expire", name)
+			case pullNotFound:
+				found.add(SeverityWarn, ObjectRef{}, "ImagesNotFound", "Registry %s does not have %d images pulled by %d pods, check their names and tags", name, images, pods)
+				found.remedy("Fix the image names and tags, or push the missing images to %s", name)
+			}
+			found.detail(r.message[kind])
+		}
+		if r.slow > 0 {
+			found.add(SeverityWarn, ObjectRef{}, "SlowPulls", "Registry %s: %d of %d pulls took over %s, the slowest %s for %s", name, r.slow, r.pulls, slowPullDuration, r.slowest.Round(time.Second), r.image)
+			found.remedy("Use a registry mirror or pull-through cache close to the nodes, or smaller images")
+		}
+	}
+	return found.result()
+}
+
+// The first double quoted string of an event message, the image of the kubelet's pull events
+func quoted(message string) string {
+	start := strings.Index(message, `"`)
+	if start < 0 {
+		return ""
+	}
+	end := strings.Index(message[start+1:], `"`)
+	if end < 0 {
+		return ""
+	}
+	return message[start+1 : start+1+end]
+}
+
+// Why a pull failed according to its event message
+func pullFailure(message string) string {
+	lower := strings.ToLower(message)
+	for _, s := range []string{"not found", "manifest unknown", "name unknown"} {
+		if strings.Contains(lower, s) {
+			return pullNotFound
+		}
+	}
+	for _, s := range []string{"unauthorized", "denied", "authentication required", "403 forbidden"} {
+		if strings.Contains(lower, s) {
+			return pullUnauthorized
+		}
+	}
+	return pullUnreachable
+}
+
+// The duration of a pull from a Pulled event message, e.g.
+// Successfully pulled image "nginx:1.21" in 2.3s (2.3s including waiting)
+func pullDuration(message string) (time.Duration, bool) {
+	i := strings.Index(message, `" in `)
+	if i < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(message[i+len(`" in `):])
+	if len(fields) == 0 {
+		return 0, false
+	}
+	d, err := time.ParseDuration(fields[0])
+	return d, err == nil
+}
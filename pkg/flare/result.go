@@ -0,0 +1,138 @@
+package flare
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Severity ranks how serious the outcome of a check is
+type Severity int
+
+const (
+	// SeverityInfo is a passed check
+	SeverityInfo Severity = iota
+	// SeverityWarn is a potential problem that does not break the cluster by itself
+	SeverityWarn
+	// SeverityFail is a problem that needs fixing
+	SeverityFail
+)
+
+var severityNames = []string{"info", "warn", "fail"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// Parse a severity name, "error" is accepted as an alias of "fail"
+func ParseSeverity(name string) (Severity, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "error" {
+		return SeverityFail, nil
+	}
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q, valid severities are: info, warn, error", name)
+}
+
+// MarshalText serializes a Severity as its name in json output
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses a Severity serialized by MarshalText
+func (s *Severity) UnmarshalText(text []byte) error {
+	parsed, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// Result holds the outcome of a single check
+type Result struct {
+	// ID is the registry id of the check, as used by -checks
+	ID   string
+	Name string
+	// Cluster is the kubeconfig cluster the check ran against with --all-contexts, "" otherwise
+	Cluster string
+	// Pass is true when nothing was found, warnings and failures both set it to false
+	Pass bool
+	// Skipped is true when the check was not run because permissions are missing, see preflight,
+	// or did not finish because the run was cancelled, see ErrInterrupted
+	Skipped  bool
+	Severity Severity
+	Details  string
+	// Err is set when the check could not be completed
+	Err error
+	// Started is when the check started, zero for skipped checks
+	Started  time.Time
+	Duration time.Duration
+	// Findings are the problems listed in Details, each with the object it is about
+	Findings []Finding
+	// Suppressed is the number of findings left out by Options.Ignore or IgnoreAnnotation
+	Suppressed int
+	// Fixes are the remediations the check offers for its findings, see --fix
+	Fixes []Fix
+	// details rebuilds Details from the findings left after suppression, for checks whose Details
+	// are more than the messages of their findings
+	details func([]Finding) string
+}
+
+// ObjectRef identifies the object a finding is about
+type ObjectRef struct {
+	schema.GroupVersionKind
+	// Namespace is "" for cluster scoped objects
+	Namespace string
+	Name      string
+}
+
+func (o ObjectRef) String() string {
+	if o.Namespace == "" {
+		return o.Kind + " " + o.Name
+	}
+	return o.Kind + " " + o.Namespace + "/" + o.Name
+}
+
+// Finding is a single problem reported by a check
+type Finding struct {
+	// Object is what the finding is about, it is empty for findings about the whole cluster
+	Object ObjectRef
+	// Reason is a machine readable CamelCase code for the problem, e.g. NoEndpoints
+	Reason   string
+	Severity Severity
+	// Message is usually the finding as printed in Details, it may continue on indented lines
+	Message string
+	// Remediation is the next step to fix the problem, e.g. a kubectl command to run, "" when the check has none
+	Remediation string
+}
+
+// ErrorString returns the check error message or "" if the check completed
+func (r Result) ErrorString() string {
+	if r.Err == nil {
+		return ""
+	}
+	return r.Err.Error()
+}
+
+// Remediations returns the distinct remediations of the findings, failures first like Details
+func (r Result) Remediations() []string {
+	seen := map[string]bool{}
+	var remediations []string
+	for _, f := range r.Findings {
+		if f.Remediation != "" && !seen[f.Remediation] {
+			seen[f.Remediation] = true
+			remediations = append(remediations, f.Remediation)
+		}
+	}
+	return remediations
+}
@@ -0,0 +1,83 @@
+package flare
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// The wait between tries never grows beyond this
+const maxRetryBackoff = 10 * time.Second
+
+// RetryPolicy retries the reads of checks that fail with a transient error, so a dropped
+// connection or a restarting API server does not fail a check. Transient errors are timeouts,
+// refused or reset connections and 500, 502, 503 and 504 responses. Every other response, e.g.
+// 403 or 404, is authoritative and returned right away.
+type RetryPolicy struct {
+	// Attempts is how often a request is tried in total, 1 or less disables retries
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles before every further one
+	Backoff time.Duration
+}
+
+// Instrument returns a copy of config whose GET requests are retried according to p.
+// Other requests change the cluster and are never retried. Responses with a Retry-After
+// header are left to client-go, which retries them itself.
+func (p RetryPolicy) Instrument(config *rest.Config) *rest.Config {
+	config = rest.CopyConfig(config)
+	if p.Attempts <= 1 {
+		return config
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodGet {
+				return rt.RoundTrip(req)
+			}
+			wait := p.Backoff
+			for attempt := 1; ; attempt++ {
+				resp, err := rt.RoundTrip(req)
+				if attempt >= p.Attempts || !transient(resp, err) {
+					return resp, err
+				}
+				if resp != nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(wait):
+				}
+				if wait *= 2; wait > maxRetryBackoff {
+					wait = maxRetryBackoff
+				}
+			}
+		})
+	})
+	return config
+}
+
+// Whether a request that ended in resp or err may succeed when it is tried again
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout() ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	if resp.Header.Get("Retry-After") != "" {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
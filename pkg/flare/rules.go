@@ -0,0 +1,441 @@
+package flare
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule is a user defined check loaded from a rules file with --rules
+//
+//	rules:
+//	- id: latest-tag
+//	  name: Images Using The latest Tag
+//	  resource: pods
+//	  condition: '{{range .spec.containers}}{{if hasSuffix .image ":latest"}}true{{end}}{{end}}'
+//	  message: 'Pod {{.metadata.namespace}}/{{.metadata.name}} runs an image tagged latest'
+//	  remediation: 'Pin a version in the images of {{.metadata.namespace}}/{{.metadata.name}}'
+//	  severity: warn
+//	- id: certificates
+//	  apiVersion: cert-manager.io/v1
+//	  resource: certificates
+//	  conditions:
+//	  - type: Ready
+type Rule struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// APIVersion is the group/version of a custom resource, which is listed with the dynamic client
+	// of the Runner. Empty for the built-in resources of ruleResources.
+	APIVersion string `json:"apiVersion"`
+	// ClusterScoped marks a custom resource that is not namespaced
+	ClusterScoped bool `json:"clusterScoped"`
+	// Resource is the plural name of the resource the rule lists, see ruleResources
+	Resource      string `json:"resource"`
+	LabelSelector string `json:"labelSelector"`
+	FieldSelector string `json:"fieldSelector"`
+	// Condition is a Go template executed on every listed object, the object is a finding when it renders "true"
+	Condition string `json:"condition"`
+	// Conditions are the status.conditions every listed object must have, the object is a finding
+	// when one of them does not hold. Either Condition or Conditions is required.
+	Conditions []RuleCondition `json:"conditions"`
+	// Message is a Go template rendering the finding line, defaults to "<Kind> <namespace>/<name> matches <Name>",
+	// or to the conditions that do not hold with Conditions
+	Message string `json:"message"`
+	// Remediation is a Go template rendering the next step to fix a finding, optional
+	Remediation string `json:"remediation"`
+	// Severity is warn or error, defaults to warn
+	Severity string `json:"severity"`
+}
+
+// RuleCondition is a status condition a rule expects, e.g. Ready=True. A condition expected to
+// be True must be present, one expected to be False may be missing, e.g. Stalled of Flux objects.
+// Conditions without a status, e.g. the ComparisonError of Argo CD Applications, count as True.
+type RuleCondition struct {
+	Type string `json:"type"`
+	// Status is True or False, defaults to True
+	Status string `json:"status"`
+}
+
+type ruleFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// ruleResource lists one kind of object for rules through the typed clientset, all of them at version v1
+type ruleResource struct {
+	Group      string
+	Kind       string
+	Namespaced bool
+	List       func(ctx context.Context, clientset kubernetes.Interface, namespace string, opts v1.ListOptions) (runtime.Object, error)
+}
+
+// The resources rules can target
+var ruleResources = map[string]ruleResource{
+	"pods": {Kind: "Pod", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().Pods(ns).List(ctx, o)
+	}},
+	"services": {Kind: "Service", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().Services(ns).List(ctx, o)
+	}},
+	"configmaps": {Kind: "ConfigMap", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().ConfigMaps(ns).List(ctx, o)
+	}},
+	"persistentvolumeclaims": {Kind: "PersistentVolumeClaim", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().PersistentVolumeClaims(ns).List(ctx, o)
+	}},
+	"persistentvolumes": {Kind: "PersistentVolume", List: func(ctx context.Context, c kubernetes.Interface, _ string, o v1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().PersistentVolumes().List(ctx, o)
+	}},
+	"nodes": {Kind: "Node", List: func(ctx context.Context, c kubernetes.Interface, _ string, o v1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().Nodes().List(ctx, o)
+	}},
+	"namespaces": {Kind: "Namespace", List: func(ctx context.Context, c kubernetes.Interface, _ string, o v1.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().Namespaces().List(ctx, o)
+	}},
+	"deployments": {Group: "apps", Kind: "Deployment", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.AppsV1().Deployments(ns).List(ctx, o)
+	}},
+	"statefulsets": {Group: "apps", Kind: "StatefulSet", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.AppsV1().StatefulSets(ns).List(ctx, o)
+	}},
+	"daemonsets": {Group: "apps", Kind: "DaemonSet", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.AppsV1().DaemonSets(ns).List(ctx, o)
+	}},
+	"jobs": {Group: "batch", Kind: "Job", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.BatchV1().Jobs(ns).List(ctx, o)
+	}},
+	"cronjobs": {Group: "batch", Kind: "CronJob", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.BatchV1().CronJobs(ns).List(ctx, o)
+	}},
+	"ingresses": {Group: "networking.k8s.io", Kind: "Ingress", Namespaced: true, List: func(ctx context.Context, c kubernetes.Interface, ns string, o v1.ListOptions) (runtime.Object, error) {
+		return c.NetworkingV1().Ingresses(ns).List(ctx, o)
+	}},
+}
+
+// Functions available to rule templates on top of the text/template builtins
+var ruleFuncs = template.FuncMap{
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"lower":     strings.ToLower,
+}
+
+// LoadRules loads the rules in the given files, or in every *.yaml file of the given directories, as checks
+func LoadRules(paths []string) ([]Check, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	var loaded []Check
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var rf ruleFile
+		if err := yaml.UnmarshalStrict(data, &rf); err != nil {
+			return nil, fmt.Errorf("failed parsing rules file %s: %w", file, err)
+		}
+		for _, rule := range rf.Rules {
+			c, err := ruleCheck(rule)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule %q in %s: %w", rule.ID, file, err)
+			}
+			loaded = append(loaded, c)
+		}
+	}
+	return loaded, nil
+}
+
+// Rule IDs are matched lowercased by --checks and --skip and listed comma separated in IgnoreAnnotation
+var ruleID = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Validate a rule and turn it into a check
+func ruleCheck(rule Rule) (Check, error) {
+	if rule.ID == "" {
+		return Check{}, fmt.Errorf("id is required")
+	}
+	if !ruleID.MatchString(rule.ID) {
+		return Check{}, fmt.Errorf("id must consist of lowercase letters, digits and -")
+	}
+	var resource ruleResource
+	var custom schema.GroupVersionResource
+	if rule.APIVersion != "" {
+		gv, err := schema.ParseGroupVersion(rule.APIVersion)
+		if err != nil {
+			return Check{}, fmt.Errorf("invalid apiVersion %q: %w", rule.APIVersion, err)
+		}
+		if rule.Resource == "" {
+			return Check{}, fmt.Errorf("resource is required")
+		}
+		custom = gv.WithResource(rule.Resource)
+		resource = ruleResource{Group: gv.Group, Namespaced: !rule.ClusterScoped}
+	} else {
+		var ok bool
+		if resource, ok = ruleResources[rule.Resource]; !ok {
+			names := make([]string, 0, len(ruleResources))
+			for name := range ruleResources {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return Check{}, fmt.Errorf("unknown resource %q, valid resources are: %s, or set apiVersion for a custom resource", rule.Resource, strings.Join(names, ", "))
+		}
+	}
+	if rule.Condition == "" && len(rule.Conditions) == 0 {
+		return Check{}, fmt.Errorf("condition or conditions is required")
+	}
+	if rule.Condition != "" && len(rule.Conditions) > 0 {
+		return Check{}, fmt.Errorf("condition and conditions can not be combined")
+	}
+	var condition *template.Template
+	if rule.Condition != "" {
+		var err error
+		if condition, err = template.New("condition").Funcs(ruleFuncs).Parse(rule.Condition); err != nil {
+			return Check{}, err
+		}
+	}
+	expected := make([]string, 0, len(rule.Conditions))
+	for i := range rule.Conditions {
+		c := &rule.Conditions[i]
+		if c.Type == "" {
+			return Check{}, fmt.Errorf("conditions need a type")
+		}
+		if c.Status == "" {
+			c.Status = "True"
+		}
+		if c.Status != "True" && c.Status != "False" {
+			return Check{}, fmt.Errorf("status of condition %s must be True or False", c.Type)
+		}
+		expected = append(expected, c.Type+"="+c.Status)
+	}
+	if rule.Name == "" {
+		rule.Name = rule.ID
+	}
+	var message *template.Template
+	if rule.Message != "" {
+		var err error
+		if message, err = template.New("message").Funcs(ruleFuncs).Parse(rule.Message); err != nil {
+			return Check{}, err
+		}
+	}
+	var remediation *template.Template
+	if rule.Remediation != "" {
+		var err error
+		if remediation, err = template.New("remediation").Funcs(ruleFuncs).Parse(rule.Remediation); err != nil {
+			return Check{}, err
+		}
+	}
+	severity := SeverityWarn
+	if rule.Severity != "" {
+		var err error
+		if severity, err = ParseSeverity(rule.Severity); err != nil || severity == SeverityInfo {
+			return Check{}, fmt.Errorf("severity must be one of: warn, error")
+		}
+	}
+
+	return Check{
+		ID:          rule.ID,
+		Name:        rule.Name,
+		Description: rule.Description,
+		Category:    "custom",
+		Permissions: []Permission{{Verb: "list", Group: resource.Group, Resource: rule.Resource, ClusterScoped: !resource.Namespaced}},
+		Severity:    severity,
+		Run: func(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+			if rule.APIVersion != "" && opts.dynamic == nil {
+				return errorResult(fmt.Errorf("rules on custom resources need a Runner with a dynamic client"))
+			}
+			namespaces := []string{""}
+			if resource.Namespaced {
+				namespaces = opts.namespaces()
+			}
+			var found findings
+			for _, ns := range namespaces {
+				page := v1.ListOptions{LabelSelector: rule.LabelSelector, FieldSelector: rule.FieldSelector, Limit: ListPageSize}
+				for {
+					var items []unstructured.Unstructured
+					var err error
+					if rule.APIVersion != "" {
+						items, page.Continue, err = listCustomRuleObjects(ctx, opts.dynamic, custom, ns, page)
+					} else {
+						items, page.Continue, err = listRuleObjects(ctx, clientset, resource, ns, page)
+					}
+					if err != nil {
+						return errorResult(fmt.Errorf("failed getting %s: %w", rule.Resource, err))
+					}
+					for i := range items {
+						item := &items[i]
+						var unmet []string
+						if condition != nil {
+							var out bytes.Buffer
+							if err := condition.Execute(&out, item.Object); err != nil {
+								return errorResult(fmt.Errorf("failed evaluating condition: %w", err))
+							}
+							if strings.TrimSpace(out.String()) != "true" {
+								continue
+							}
+						} else if unmet = unmetConditions(item.Object, rule.Conditions); len(unmet) == 0 {
+							continue
+						}
+						object := objectRef(item.GroupVersionKind(), item)
+						var line string
+						switch {
+						case message != nil:
+							var out bytes.Buffer
+							if err := message.Execute(&out, item.Object); err != nil {
+								return errorResult(fmt.Errorf("failed rendering message: %w", err))
+							}
+							line = strings.TrimSpace(out.String())
+						case condition != nil:
+							line = object.String() + " matches " + rule.Name
+						default:
+							line = object.String() + " is not " + strings.Join(expected, ", ")
+						}
+						found.add(severity, object, rule.ID, "%s", line)
+						if remediation != nil {
+							var out bytes.Buffer
+							if err := remediation.Execute(&out, item.Object); err != nil {
+								return errorResult(fmt.Errorf("failed rendering remediation: %w", err))
+							}
+							found.remedy("%s", strings.TrimSpace(out.String()))
+						}
+						for _, u := range unmet {
+							found.detail(u)
+						}
+					}
+					if page.Continue == "" {
+						break
+					}
+				}
+			}
+			return found.result()
+		},
+	}, nil
+}
+
+// List a page of a built-in rule resource through the typed clientset, as unstructured objects
+// with their kind set
+func listRuleObjects(ctx context.Context, clientset kubernetes.Interface, resource ruleResource, ns string, page v1.ListOptions) ([]unstructured.Unstructured, string, error) {
+	list, err := resource.List(ctx, clientset, ns, page)
+	if err != nil {
+		return nil, "", err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]unstructured.Unstructured, 0, len(items))
+	for _, item := range items {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(item)
+		if err != nil {
+			return nil, "", err
+		}
+		u := unstructured.Unstructured{Object: obj}
+		u.SetGroupVersionKind(schema.GroupVersionKind{Group: resource.Group, Version: "v1", Kind: resource.Kind})
+		objects = append(objects, u)
+	}
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, "", err
+	}
+	return objects, listMeta.GetContinue(), nil
+}
+
+// List a page of a custom rule resource through the dynamic client
+func listCustomRuleObjects(ctx context.Context, client dynamic.Interface, resource schema.GroupVersionResource, ns string, page v1.ListOptions) ([]unstructured.Unstructured, string, error) {
+	list, err := client.Resource(resource).Namespace(ns).List(ctx, page)
+	if err != nil {
+		return nil, "", err
+	}
+	return list.Items, list.GetContinue(), nil
+}
+
+// List all objects of a custom resource in a namespace, or in all namespaces, through the dynamic client
+func listUnstructured(ctx context.Context, client dynamic.Interface, resource schema.GroupVersionResource, ns string) ([]unstructured.Unstructured, error) {
+	var objects []unstructured.Unstructured
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		items, next, err := listCustomRuleObjects(ctx, client, resource, ns, page)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, items...)
+		if page.Continue = next; page.Continue == "" {
+			return objects, nil
+		}
+	}
+}
+
+// The status condition of the given type of an unstructured object, nil when it does not report it
+func statusCondition(obj map[string]interface{}, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(obj, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
+
+// A condition as "Type=Status Reason: message"
+func conditionLine(condition map[string]interface{}, status string) string {
+	line := fmt.Sprintf("%v=%s", condition["type"], status)
+	if reason, _ := condition["reason"].(string); reason != "" {
+		line += " " + reason
+	}
+	if message, _ := condition["message"].(string); message != "" {
+		line += ": " + message
+	}
+	return line
+}
+
+// The expected conditions that do not hold on an object, with the reason and message of its
+// condition, e.g. "Ready=False DoesNotExist: Issuing certificate as Secret does not exist"
+func unmetConditions(obj map[string]interface{}, expected []RuleCondition) []string {
+	var unmet []string
+	for _, e := range expected {
+		current := statusCondition(obj, e.Type)
+		if current == nil {
+			if e.Status == "True" {
+				unmet = append(unmet, e.Type+" is not reported")
+			}
+			continue
+		}
+		status, _ := current["status"].(string)
+		if status == "" {
+			status = "True"
+		}
+		if status != e.Status {
+			unmet = append(unmet, conditionLine(current, status))
+		}
+	}
+	return unmet
+}
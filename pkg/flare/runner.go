@@ -0,0 +1,196 @@
+package flare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrTimeout is wrapped by the error of every check that did not finish before its deadline
+var ErrTimeout = errors.New("timed out")
+
+// ErrInterrupted is wrapped by the error of every check whose context was cancelled before it finished
+var ErrInterrupted = errors.New("interrupted")
+
+// Runner runs checks against a cluster
+type Runner struct {
+	Clientset kubernetes.Interface
+	// Dynamic reads the custom resources some checks look at, e.g. Karpenter NodeClaims. Optional,
+	// without it those checks skip the custom resources.
+	Dynamic dynamic.Interface
+	// Checks are the checks to run, in order. Empty runs every registered check.
+	Checks []Check
+	// Concurrency is the number of checks run in parallel, defaults to 1
+	Concurrency int
+	// CheckTimeout limits the duration of every check, 0 for no limit
+	CheckTimeout time.Duration
+	// SkipPreflight runs the checks without reviewing their permissions first, see preflight
+	SkipPreflight bool
+	// OnResult is called with every result as soon as it and the results of all checks before it
+	// are in, in the order of the checks, e.g. to print them while the run goes on. Optional.
+	OnResult func(Result)
+}
+
+// Run runs the checks with opts and returns their results in the order of r.Checks.
+// Active checks are left out unless opts.ActiveProbes is set.
+// Pod and node lists are shared between the checks of a run. Checks that find problems,
+// fail or time out are reported in their Result; an error is only returned when the
+// Runner can not run at all.
+func (r *Runner) Run(ctx context.Context, opts *Options) ([]Result, error) {
+	if r.Clientset == nil {
+		return nil, errors.New("runner has no clientset")
+	}
+	selected := r.Checks
+	if len(selected) == 0 {
+		selected = Checks()
+	}
+	runOpts := Options{}
+	if opts != nil {
+		runOpts = *opts
+	}
+	if !runOpts.ActiveProbes {
+		var passive []Check
+		for _, c := range selected {
+			if !c.Active {
+				passive = append(passive, c)
+			}
+		}
+		selected = passive
+	}
+	runOpts.snapshot = newSnapshot()
+	runOpts.dynamic = r.Dynamic
+	if !r.SkipPreflight {
+		selected = preflight(ctx, r.Clientset, &runOpts, selected)
+	}
+	return runChecks(ctx, r.Clientset, &runOpts, selected, r.Concurrency, r.CheckTimeout, r.OnResult), nil
+}
+
+// indexedResult carries a Result back from a worker along with the position of its check
+type indexedResult struct {
+	index  int
+	result Result
+}
+
+// Run the selected checks on a pool of `concurrency` workers, the After checks once all others finished.
+// Every check gets a context derived from ctx, limited to checkTimeout when it is non-zero.
+// Suppressed findings are dropped from the results, see Options.suppress.
+// Workers send their results over a channel and only this function writes to the
+// returned slice, which keeps the order of `selected` regardless of completion order.
+// onResult, when set, gets the results in the same order as soon as they are in.
+func runChecks(ctx context.Context, clientset kubernetes.Interface, opts *Options, selected []Check, concurrency int, checkTimeout time.Duration, onResult func(Result)) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	jobs := make(chan int)
+	results := make(chan indexedResult)
+
+	var wg, pending sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := runCheck(ctx, clientset, opts, selected[i], checkTimeout)
+				results <- indexedResult{index: i, result: opts.suppress(ctx, clientset, r)}
+				if !selected[i].After {
+					pending.Done()
+				}
+			}
+		}()
+	}
+	go func() {
+		var after []int
+		for i, c := range selected {
+			if c.After {
+				after = append(after, i)
+				continue
+			}
+			pending.Add(1)
+			jobs <- i
+		}
+		pending.Wait()
+		for _, i := range after {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	resultList := make([]Result, len(selected))
+	done := make([]bool, len(selected))
+	next := 0
+	for r := range results {
+		resultList[r.index] = r.result
+		done[r.index] = true
+		for ; onResult != nil && next < len(selected) && done[next]; next++ {
+			onResult(resultList[next])
+		}
+	}
+	return resultList
+}
+
+// Run a single check and time it.
+// The check runs in its own goroutine so a check that ignores its context still
+// can not hold up the run past the deadline; it is reported as timed out instead.
+// A check cancelled before it finished, e.g. on Ctrl-C, is reported as skipped and
+// checks are no longer started once the run is cancelled.
+func runCheck(ctx context.Context, clientset kubernetes.Interface, opts *Options, c Check, checkTimeout time.Duration) Result {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return interruptedResult(c, time.Time{})
+	}
+	if checkTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	done := make(chan Result, 1)
+	go func() {
+		done <- c.Run(ctx, clientset, opts)
+	}()
+
+	var r Result
+	finished := false
+	select {
+	case r = <-done:
+		finished = true
+	case <-ctx.Done():
+	}
+	r.ID = c.ID
+	r.Name = c.Name
+	r.Started = start
+	r.Duration = time.Since(start)
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.Pass = false
+		r.Severity = SeverityFail
+		r.Err = fmt.Errorf("%w after %s", ErrTimeout, r.Duration.Round(time.Millisecond))
+	case errors.Is(ctx.Err(), context.Canceled) && !finished:
+		r = interruptedResult(c, start)
+	}
+	return r
+}
+
+// The Result of a check that was cancelled before it finished, start is zero when it never started
+func interruptedResult(c Check, start time.Time) Result {
+	if start.IsZero() {
+		return Result{ID: c.ID, Name: c.Name, Skipped: true, Err: ErrInterrupted}
+	}
+	duration := time.Since(start)
+	return Result{
+		ID:       c.ID,
+		Name:     c.Name,
+		Skipped:  true,
+		Err:      fmt.Errorf("%w after %s", ErrInterrupted, duration.Round(time.Millisecond)),
+		Started:  start,
+		Duration: duration,
+	}
+}
@@ -0,0 +1,613 @@
+package flare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSelectChecks(t *testing.T) {
+	selected, err := SelectChecks("endpoints,events,webhooks", "events")
+	if err != nil {
+		t.Fatalf("Unexpected error selecting checks " + err.Error())
+	}
+	// Expected registry order with skipped checks removed
+	if len(selected) != 2 || selected[0].ID != "webhooks" || selected[1].ID != "endpoints" {
+		t.Errorf("Expected [webhooks endpoints] but got %v", selected)
+	}
+	if _, err := SelectChecks("bogus", ""); err == nil {
+		t.Errorf("Expected an Error for unknown check but err was nil")
+	}
+	if all, _ := SelectChecks("", ""); len(all) != len(checks) {
+		t.Errorf("Expected all %d checks but got %d", len(checks), len(all))
+	}
+}
+
+func TestEndpointsNamespaceScope(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "team-a"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "team-b"}},
+	)
+	r := checkEndpoints(context.Background(), clientset, &Options{Namespaces: []string{"team-a"}})
+	if r.Err != nil {
+		t.Fatalf("Unexpected error " + r.Err.Error())
+	}
+	if r.Pass || r.Severity != SeverityFail || r.Details != "Service frontend has no active endpoints!\n" {
+		t.Errorf("Expected only team-a to be checked but got %+v", r)
+	}
+	// Unscoped runs look at every namespace
+	r = checkEndpoints(context.Background(), clientset, &Options{})
+	if !strings.Contains(r.Details, "frontend") || !strings.Contains(r.Details, "backend") {
+		t.Errorf("Expected both services to be reported but got %q", r.Details)
+	}
+}
+
+func TestRunChecksKeepsOrder(t *testing.T) {
+	var selected []Check
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("check-%d", i)
+		// Later checks finish first to shuffle the completion order
+		delay := time.Duration(20-i) * time.Millisecond
+		selected = append(selected, Check{ID: name, Name: name, Run: func(context.Context, kubernetes.Interface, *Options) Result {
+			time.Sleep(delay)
+			return findingsResult("", SeverityFail)
+		}})
+	}
+	var streamed []string
+	results := runChecks(context.Background(), fake.NewSimpleClientset(), &Options{}, selected, 5, 0, func(r Result) {
+		streamed = append(streamed, r.Name)
+	})
+	if len(results) != len(selected) || len(streamed) != len(selected) {
+		t.Fatalf("Expected %d results but got %d, %d streamed", len(selected), len(results), len(streamed))
+	}
+	for i, r := range results {
+		if r.Name != selected[i].Name || !r.Pass || streamed[i] != r.Name {
+			t.Errorf("Expected result %d to be %s but got %+v, streamed %s", i, selected[i].Name, r, streamed[i])
+		}
+	}
+}
+
+func TestRunChecksAfter(t *testing.T) {
+	var finished int32
+	selected := []Check{{ID: "report", Name: "report", After: true, Run: func(context.Context, kubernetes.Interface, *Options) Result {
+		return findingsResult(fmt.Sprintf("%d checks finished before\n", atomic.LoadInt32(&finished)), SeverityWarn)
+	}}}
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("check-%d", i)
+		selected = append(selected, Check{ID: name, Name: name, Run: func(context.Context, kubernetes.Interface, *Options) Result {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&finished, 1)
+			return findingsResult("", SeverityFail)
+		}})
+	}
+	results := runChecks(context.Background(), fake.NewSimpleClientset(), &Options{}, selected, 4, 0, nil)
+	if results[0].Details != "8 checks finished before\n" {
+		t.Errorf("Expected the After check to run last, got %q", results[0].Details)
+	}
+}
+
+func TestRunner(t *testing.T) {
+	if _, err := (&Runner{}).Run(context.Background(), nil); err == nil {
+		t.Errorf("Expected an error without a clientset")
+	}
+	var sawSnapshot bool
+	runner := &Runner{
+		Clientset: fake.NewSimpleClientset(),
+		Checks: []Check{{ID: "custom", Name: "Custom", Run: func(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+			sawSnapshot = opts.snapshot != nil
+			return findingsResult("Pod a/b is broken\n", SeverityWarn)
+		}}},
+		SkipPreflight: true,
+	}
+	opts := &Options{Namespaces: []string{"a"}}
+	results, err := runner.Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error running checks " + err.Error())
+	}
+	if len(results) != 1 || results[0].ID != "custom" || results[0].Pass {
+		t.Errorf("Expected the custom check to report its finding, got %+v", results)
+	}
+	if !sawSnapshot || opts.snapshot != nil {
+		t.Errorf("Expected the run to use its own snapshot without changing opts")
+	}
+
+	runner.Checks = append(runner.Checks, Check{ID: "probe", Active: true, Run: func(context.Context, kubernetes.Interface, *Options) Result {
+		return findingsResult("", SeverityFail)
+	}})
+	if results, _ := runner.Run(context.Background(), opts); len(results) != 1 {
+		t.Errorf("Expected active checks to be left out without ActiveProbes, got %+v", results)
+	}
+	opts.ActiveProbes = true
+	if results, _ := runner.Run(context.Background(), opts); len(results) != 2 {
+		t.Errorf("Expected active checks to run with ActiveProbes, got %+v", results)
+	}
+}
+
+func TestRunCheckTimeout(t *testing.T) {
+	hung := Check{ID: "hung", Name: "Hung", Run: func(context.Context, kubernetes.Interface, *Options) Result {
+		// Ignores its context entirely, like a call stuck on the network
+		time.Sleep(time.Second)
+		return findingsResult("", SeverityFail)
+	}}
+	start := time.Now()
+	r := runCheck(context.Background(), fake.NewSimpleClientset(), &Options{}, hung, 20*time.Millisecond)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected the run to return at the deadline but it took %s", time.Since(start))
+	}
+	if r.Pass || !errors.Is(r.Err, ErrTimeout) {
+		t.Errorf("Expected a timed out failure but got %+v", r)
+	}
+}
+
+func TestRunChecksInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	selected := []Check{
+		{ID: "quick", Name: "Quick", Run: func(context.Context, kubernetes.Interface, *Options) Result {
+			return findingsResult("", SeverityFail)
+		}},
+		{ID: "slow", Name: "Slow", Run: func(ctx context.Context, _ kubernetes.Interface, _ *Options) Result {
+			cancel()
+			<-ctx.Done()
+			return errorResult(ctx.Err())
+		}},
+		{ID: "queued", Name: "Queued", Run: func(context.Context, kubernetes.Interface, *Options) Result {
+			return findingsResult("should not be reported\n", SeverityFail)
+		}},
+	}
+	results := runChecks(ctx, fake.NewSimpleClientset(), &Options{}, selected, 1, 0, nil)
+	if !results[0].Pass {
+		t.Errorf("Expected the finished check to keep its result, got %+v", results[0])
+	}
+	for _, r := range results[1:] {
+		if !r.Skipped || !errors.Is(r.Err, ErrInterrupted) || r.Details != "" {
+			t.Errorf("Expected %s to be interrupted, got %+v", r.ID, r)
+		}
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for name, expected := range map[string]Severity{"info": SeverityInfo, "warn": SeverityWarn, "error": SeverityFail, "fail": SeverityFail} {
+		s, err := ParseSeverity(name)
+		if err != nil || s != expected {
+			t.Errorf("Expected %s to parse as %s but got %s, %v", name, expected, s, err)
+		}
+	}
+	if _, err := ParseSeverity("bogus"); err == nil {
+		t.Errorf("Expected an Error but err was nil")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	seen := map[string]bool{}
+	for _, c := range checks {
+		if c.ID == "" || c.Name == "" || c.Description == "" || c.Category == "" || c.Run == nil {
+			t.Errorf("Check %q is missing metadata", c.ID)
+		}
+		if seen[c.ID] {
+			t.Errorf("Check id %q is registered twice", c.ID)
+		}
+		seen[c.ID] = true
+	}
+}
+
+func TestRules(t *testing.T) {
+	dir := t.TempDir()
+	rules := `rules:
+- id: latest-tag
+  name: Images Using The latest Tag
+  resource: pods
+  condition: '{{range .spec.containers}}{{if hasSuffix .image ":latest"}}true{{end}}{{end}}'
+  message: 'Pod {{.metadata.namespace}}/{{.metadata.name}} runs an image tagged latest'
+  remediation: 'Pin a version in the images of {{.metadata.namespace}}/{{.metadata.name}}'
+- id: unlabeled-nodes
+  resource: nodes
+  condition: '{{not (index .metadata "labels")}}'
+  severity: error
+`
+	if err := os.WriteFile(dir+"/site.yaml", []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRules([]string{dir})
+	if err != nil {
+		t.Fatalf("Unexpected error loading rules " + err.Error())
+	}
+	if len(loaded) != 2 || loaded[0].Category != "custom" || loaded[1].Severity != SeverityFail {
+		t.Fatalf("Rules were not loaded as checks: %+v", loaded)
+	}
+
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "shop/api:latest"}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "shop/web:1.2"}}},
+		},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: map[string]string{"zone": "a"}}},
+	)
+	r := loaded[0].Run(context.Background(), clientset, &Options{})
+	if r.Pass || r.Details != "Pod shop/api runs an image tagged latest\n" {
+		t.Errorf("Unexpected latest-tag result %+v", r)
+	}
+	if remediations := r.Remediations(); len(remediations) != 1 || remediations[0] != "Pin a version in the images of shop/api" {
+		t.Errorf("Unexpected latest-tag remediations %q", remediations)
+	}
+	r = loaded[1].Run(context.Background(), clientset, &Options{})
+	if r.Severity != SeverityFail || r.Details != "Node node-1 matches unlabeled-nodes\n" {
+		t.Errorf("Unexpected unlabeled-nodes result %+v", r)
+	}
+
+	if err := os.WriteFile(dir+"/bad.yaml", []byte("rules:\n- id: x\n  resource: widgets\n  condition: 'true'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules([]string{dir}); err == nil || !strings.Contains(err.Error(), "unknown resource") {
+		t.Errorf("Expected an unknown resource error, got %v", err)
+	}
+}
+
+func TestConditionRules(t *testing.T) {
+	dir := t.TempDir()
+	rules := `rules:
+- id: certificates
+  apiVersion: cert-manager.io/v1
+  resource: certificates
+  conditions:
+  - type: Ready
+  severity: error
+- id: kustomizations
+  apiVersion: kustomize.toolkit.fluxcd.io/v1
+  resource: kustomizations
+  conditions:
+  - type: Ready
+  - type: Stalled
+    status: "False"
+  message: 'Kustomization {{.metadata.name}} is not reconciled'
+`
+	if err := os.WriteFile(dir+"/crs.yaml", []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRules([]string{dir})
+	if err != nil {
+		t.Fatalf("Unexpected error loading rules " + err.Error())
+	}
+	if len(loaded) != 2 || loaded[0].Permissions[0] != list("cert-manager.io", "certificates") {
+		t.Fatalf("Rules were not loaded as checks: %+v", loaded)
+	}
+
+	object := func(apiVersion, kind, name string, conditions ...interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"name": name, "namespace": "shop"},
+			"status":     map[string]interface{}{"conditions": conditions},
+		}}
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}:               "CertificateList",
+		{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Resource: "kustomizations"}: "KustomizationList",
+	},
+		object("cert-manager.io/v1", "Certificate", "api-tls", map[string]interface{}{"type": "Ready", "status": "False", "reason": "DoesNotExist", "message": "Issuing certificate as Secret does not exist"}),
+		object("cert-manager.io/v1", "Certificate", "web-tls", map[string]interface{}{"type": "Ready", "status": "True"}),
+		object("cert-manager.io/v1", "Certificate", "new-tls"),
+		object("kustomize.toolkit.fluxcd.io/v1", "Kustomization", "apps", map[string]interface{}{"type": "Ready", "status": "True"}),
+		object("kustomize.toolkit.fluxcd.io/v1", "Kustomization", "infra",
+			map[string]interface{}{"type": "Ready", "status": "True"},
+			map[string]interface{}{"type": "Stalled", "status": "True", "reason": "BuildFailed"}),
+	)
+	clientset := fake.NewSimpleClientset()
+
+	r := loaded[0].Run(context.Background(), clientset, &Options{dynamic: dynamicClient})
+	expected := "Certificate shop/api-tls is not Ready=True\n" +
+		"  Ready=False DoesNotExist: Issuing certificate as Secret does not exist\n" +
+		"Certificate shop/new-tls is not Ready=True\n" +
+		"  Ready is not reported\n"
+	if r.Pass || r.Severity != SeverityFail || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+	r = loaded[1].Run(context.Background(), clientset, &Options{dynamic: dynamicClient})
+	expected = "Kustomization infra is not reconciled\n" +
+		"  Stalled=True BuildFailed\n"
+	if r.Pass || r.Details != expected {
+		t.Errorf("Expected %q but got %+v", expected, r)
+	}
+	if r := loaded[0].Run(context.Background(), clientset, &Options{}); r.Err == nil {
+		t.Errorf("Expected an error without a dynamic client, got %+v", r)
+	}
+
+	if err := os.WriteFile(dir+"/bad.yaml", []byte("rules:\n- id: x\n  resource: pods\n  condition: 'true'\n  conditions:\n  - type: Ready\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules([]string{dir}); err == nil || !strings.Contains(err.Error(), "can not be combined") {
+		t.Errorf("Expected a combined conditions error, got %v", err)
+	}
+	for _, id := range []string{"MyRule", "a,b", "latest tag"} {
+		if err := os.WriteFile(dir+"/bad.yaml", []byte("rules:\n- id: "+id+"\n  resource: pods\n  condition: 'true'\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRules([]string{dir}); err == nil || !strings.Contains(err.Error(), "lowercase") {
+			t.Errorf("Expected an invalid id error for %q, got %v", id, err)
+		}
+	}
+
+	clusterScoped := "rules:\n- id: issuers\n  apiVersion: cert-manager.io/v1\n  resource: clusterissuers\n  clusterScoped: true\n  conditions:\n  - type: Ready\n"
+	if err := os.WriteFile(dir+"/bad.yaml", []byte(clusterScoped), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadRules([]string{dir}); err != nil || !loaded[0].Permissions[0].ClusterScoped {
+		t.Errorf("Expected the permission of a cluster scoped rule to be cluster scoped, got %+v %v", loaded, err)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = attributes.Resource == "pods" || (attributes.Resource == "events" && attributes.Namespace == "shop") ||
+			(attributes.Resource == "widgets" && attributes.Namespace == "")
+		return true, review, nil
+	})
+	run := func(context.Context, kubernetes.Interface, *Options) Result { return Result{Pass: true} }
+	selected := []Check{
+		{ID: "pods", Permissions: []Permission{list("", "pods")}, Run: run},
+		{ID: "pending", Permissions: []Permission{list("", "pods"), list("", "events")}, Run: run},
+		{ID: "nodes", Permissions: []Permission{list("", "nodes")}, Run: run},
+		{ID: "storage", Permissions: []Permission{list("", "pods"), unscoped(list("", "persistentvolumes"))}, Run: run},
+		{ID: "widgets", Permissions: []Permission{{Verb: "list", Group: "example.io", Resource: "widgets", ClusterScoped: true}}, Run: run},
+	}
+	opts := &Options{Namespaces: []string{"shop", "web"}}
+	results := runChecks(context.Background(), clientset, opts, preflight(context.Background(), clientset, opts, selected), 1, 0, nil)
+
+	if !results[0].Pass || results[0].Skipped {
+		t.Errorf("Expected the pods check to run, got %+v", results[0])
+	}
+	if !results[1].Skipped || results[1].Details != "Skipped, missing permissions: list events in web\n" {
+		t.Errorf("Expected the pending check to be skipped, got %+v", results[1])
+	}
+	if !results[2].Skipped || results[2].Severity != SeverityInfo {
+		t.Errorf("Expected the nodes check to be skipped, got %+v", results[2])
+	}
+	if results[3].Skipped {
+		t.Errorf("Unscoped permissions should not be reviewed when limited to namespaces, got %+v", results[3])
+	}
+	if results[4].Skipped {
+		t.Errorf("Cluster scoped permissions should be reviewed cluster wide, got %+v", results[4])
+	}
+}
+
+// A dynamic client without any custom resources installed, that checks every request with allowed first
+type requestCheckingDynamic struct {
+	allowed func(verb string, resource schema.GroupVersionResource) error
+}
+
+func (d requestCheckingDynamic) Resource(resource schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return requestCheckingResource{allowed: d.allowed, resource: resource}
+}
+
+type requestCheckingResource struct {
+	dynamic.NamespaceableResourceInterface
+	allowed  func(verb string, resource schema.GroupVersionResource) error
+	resource schema.GroupVersionResource
+}
+
+func (r requestCheckingResource) Namespace(string) dynamic.ResourceInterface {
+	return r
+}
+
+func (r requestCheckingResource) List(context.Context, metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	if err := r.allowed("list", r.resource); err != nil {
+		return nil, err
+	}
+	return nil, apierrors.NewNotFound(r.resource.GroupResource(), "")
+}
+
+func (r requestCheckingResource) Get(_ context.Context, name string, _ metav1.GetOptions, _ ...string) (*unstructured.Unstructured, error) {
+	if err := r.allowed("get", r.resource); err != nil {
+		return nil, err
+	}
+	return nil, apierrors.NewNotFound(r.resource.GroupResource(), name)
+}
+
+// Permissions marked unscoped are not reviewed when the run is limited to namespaces, so no check
+// may list those resources then
+func TestScopedRunSkipsUnscoped(t *testing.T) {
+	for _, c := range Checks() {
+		unscopedResources := map[string]bool{}
+		for _, p := range c.Permissions {
+			if p.Unscoped {
+				unscopedResources[p.Resource] = true
+			}
+		}
+		if len(unscopedResources) == 0 || c.Active {
+			continue
+		}
+		allowed := func(verb string, resource schema.GroupVersionResource) error {
+			if verb == "list" && unscopedResources[resource.Resource] {
+				return apierrors.NewForbidden(resource.GroupResource(), "", errors.New("only allowed in namespace shop"))
+			}
+			return nil
+		}
+		clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}})
+		clientset.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if err := allowed(action.GetVerb(), action.GetResource()); err != nil {
+				return true, nil, err
+			}
+			return false, nil, nil
+		})
+		runner := &Runner{Clientset: clientset, Dynamic: requestCheckingDynamic{allowed: allowed}, Checks: []Check{c}, SkipPreflight: true}
+		results, err := runner.Run(context.Background(), &Options{Namespaces: []string{"shop"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := results[0]; r.Err != nil {
+			t.Errorf("Expected %s to leave out its unscoped permissions, got %s", c.ID, r.Err)
+		}
+	}
+}
+
+func TestSnapshotListsOnce(t *testing.T) {
+	notReady := corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app"}}}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}, Spec: corev1.PodSpec{NodeName: "node-1"}, Status: notReady},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"}, Spec: corev1.PodSpec{NodeName: "node-1"}, Status: notReady},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	)
+	var podLists, nodeLists int32
+	clientset.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		switch action.GetResource().Resource {
+		case "pods":
+			atomic.AddInt32(&podLists, 1)
+		case "nodes":
+			atomic.AddInt32(&nodeLists, 1)
+		}
+		return false, nil, nil
+	})
+	selected, _ := SelectChecks("infra,nodes,overcommit,pods,pending,leftovers", "")
+	for i := range selected {
+		selected[i].Permissions = nil
+	}
+	opts := &Options{snapshot: newSnapshot()}
+	results := runChecks(context.Background(), clientset, opts, selected, 4, 0, nil)
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("Unexpected error in %s: %s", r.ID, r.Err)
+		}
+	}
+	if podLists != 1 || nodeLists != 1 {
+		t.Errorf("Expected pods and nodes to be listed once, got %d and %d lists", podLists, nodeLists)
+	}
+	if r := results[0]; !strings.Contains(r.Details, "coredns") || strings.Contains(r.Details, "api") {
+		t.Errorf("Expected infra to only see kube-system pods, got %q", r.Details)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	pages := 0
+	clientset.PrependReactor("list", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pages++
+		list := &corev1.EndpointsList{Items: []corev1.Endpoints{{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("svc-%d", pages)}}}}
+		if pages == 1 {
+			list.Continue = "page-2"
+		}
+		return true, list, nil
+	})
+	r := checkEndpoints(context.Background(), clientset, &Options{})
+	if pages != 2 {
+		t.Errorf("Expected two pages to be listed, got %d", pages)
+	}
+	if !strings.Contains(r.Details, "svc-1") || !strings.Contains(r.Details, "svc-2") {
+		t.Errorf("Expected the endpoints of both pages to be checked, got %q", r.Details)
+	}
+}
+
+func TestSuppress(t *testing.T) {
+	controller := true
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch", Annotations: map[string]string{IgnoreAnnotation: "pods"}}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop", Annotations: map[string]string{IgnoreAnnotation: "events, pod*"}}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-5d4", Namespace: "shop", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: &controller},
+		}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-5d4-x", Namespace: "shop", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d4", Controller: &controller},
+		}}},
+	)
+	pods := func(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+		var found findings
+		for _, pod := range []string{"batch/report", "shop/web-5d4-x", "shop/api-7f8-y", "other/db-0"} {
+			parts := strings.SplitN(pod, "/", 2)
+			found.add(SeverityFail, ObjectRef{GroupVersionKind: podKind, Namespace: parts[0], Name: parts[1]}, "CrashLoopBackOff", "Pod %s is crashing", pod)
+		}
+		return found.result()
+	}
+	opts := &Options{Ignore: []IgnoreRule{{Check: "pods", Object: "Pod/other/*"}}}
+	results := runChecks(context.Background(), clientset, opts, []Check{{ID: "pods", Name: "Pods", Run: pods}}, 1, 0, nil)
+	if r := results[0]; r.Pass || r.Suppressed != 3 || r.Details != "Pod shop/api-7f8-y is crashing\n" || len(r.Findings) != 1 {
+		t.Errorf("Expected only the unannotated pod to be reported, got %+v", r)
+	}
+	if r := results[0]; r.ID != "pods" || r.Name != "Pods" || r.Started.IsZero() {
+		t.Errorf("Expected the result to keep its check and start time, got %+v", r)
+	}
+
+	results = runChecks(context.Background(), clientset, &Options{}, []Check{{ID: "events", Name: "Events", Run: pods}}, 1, 0, nil)
+	if r := results[0]; r.Suppressed != 1 || strings.Contains(r.Details, "web-5d4-x") {
+		t.Errorf("Expected the annotation of the Deployment to cover its pods, got %+v", r)
+	}
+}
+
+func TestSuppressKeepsCheckDetails(t *testing.T) {
+	crashing := func(name string, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Annotations: annotations},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off"}}},
+			}},
+		}
+	}
+	clientset := fake.NewSimpleClientset(crashing("api", nil), crashing("debug", map[string]string{IgnoreAnnotation: "pods"}))
+	results := runChecks(context.Background(), clientset, &Options{snapshot: newSnapshot()}, []Check{{ID: "pods", Name: "Pods", Run: checkPodWaiting}}, 1, 0, nil)
+	expected := "Namespace shop: 1 failing containers\nPod shop/api container app is in CrashLoopBackOff: back-off\n"
+	if r := results[0]; r.Suppressed != 1 || r.Details != expected {
+		t.Errorf("Expected the check to rebuild its summary without the suppressed pod, got %+v", r)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "pods" {
+			t.Errorf("Expected the annotations of pods to be read from the listed pods, got %+v", action)
+		}
+	}
+}
+
+func TestSuppressNamespaceFix(t *testing.T) {
+	objects := []runtime.Object{&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci", Annotations: map[string]string{IgnoreAnnotation: "finished-pods"}}}}
+	for i := 0; i < 3; i++ {
+		objects = append(objects, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-%d", i), Namespace: "ci"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}})
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	finished := Check{ID: "finished-pods", Name: "Finished Pods", Run: checkFinishedPods}
+	results := runChecks(context.Background(), clientset, &Options{FinishedPodThreshold: 3, snapshot: newSnapshot()}, []Check{finished}, 1, 0, nil)
+	if r := results[0]; !r.Pass || r.Suppressed != 1 || len(r.Fixes) != 0 {
+		t.Errorf("Expected the fix for the suppressed namespace to be dropped, got %+v", r)
+	}
+}
+
+func TestLoadIgnoreFile(t *testing.T) {
+	file := t.TempDir() + "/.flareignore"
+	if err := os.WriteFile(file, []byte("# accepted\n\npods Pod/batch/*\nevents *\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadIgnoreFile(file)
+	if err != nil || len(rules) != 2 || rules[0] != (IgnoreRule{Check: "pods", Object: "Pod/batch/*"}) {
+		t.Fatalf("Expected two rules but got %+v, %v", rules, err)
+	}
+	if !rules[1].matches("events", ObjectRef{}) || rules[0].matches("pods", ObjectRef{GroupVersionKind: podKind, Namespace: "shop", Name: "api"}) {
+		t.Errorf("Unexpected matches for %+v", rules)
+	}
+	if err := os.WriteFile(file, []byte("pods\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIgnoreFile(file); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("Expected the invalid line to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The label the API server sets on legacy ServiceAccount token Secrets when they are used, since 1.26
+const legacyTokenLastUsedLabel = "kubernetes.io/legacy-token-last-used"
+
+// Legacy tokens used within this duration count as in use
+const legacyTokenInUseWindow = 30 * 24 * time.Hour
+
+// Check pods using ServiceAccounts that do not exist, pods automounting the token of the default
+// ServiceAccount although no binding grants it anything, and long-lived legacy token Secrets that
+// are mounted by pods or were used recently. ClusterRoleBindings are only looked at when the run
+// is not limited to namespaces. The pods of Options.PodSecurityExempt are not reported for tokens.
+func checkServiceAccounts(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	// ServiceAccounts bound by a RoleBinding or ClusterRoleBinding, "ns/name"
+	bound := map[string]bool{}
+	boundSubjects := func(subjects []rbacv1.Subject) {
+		for _, s := range subjects {
+			if s.Kind == rbacv1.ServiceAccountKind {
+				bound[s.Namespace+"/"+s.Name] = true
+			}
+		}
+	}
+	if len(opts.Namespaces) == 0 {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			bindings, err := clientset.RbacV1().ClusterRoleBindings().List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting clusterrolebindings: %w", err))
+			}
+			for _, b := range bindings.Items {
+				boundSubjects(b.Subjects)
+			}
+			if page.Continue = bindings.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	exempt := map[string]bool{}
+	for _, ns := range opts.podSecurityExempt() {
+		exempt[ns] = true
+	}
+
+	var found findings
+	for _, ns := range opts.namespaces() {
+		serviceAccounts := map[string]*corev1.ServiceAccount{}
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().ServiceAccounts(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting serviceaccounts: %w", err))
+			}
+			for i := range list.Items {
+				serviceAccounts[list.Items[i].Namespace+"/"+list.Items[i].Name] = &list.Items[i]
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			bindings, err := clientset.RbacV1().RoleBindings(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting rolebindings: %w", err))
+			}
+			for _, b := range bindings.Items {
+				boundSubjects(b.Subjects)
+			}
+			if page.Continue = bindings.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		// Legacy token Secrets mounted by pods, "ns/name" -> the first pod mounting it
+		mounted := map[string]ObjectRef{}
+		seen := map[ObjectRef]bool{}
+		for i := range pods {
+			pod := &pods[i]
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			object, _ := podController(pod)
+			for _, v := range pod.Spec.Volumes {
+				if v.Secret != nil {
+					if _, ok := mounted[pod.Namespace+"/"+v.Secret.SecretName]; !ok {
+						mounted[pod.Namespace+"/"+v.Secret.SecretName] = object
+					}
+				}
+			}
+			if seen[object] {
+				continue
+			}
+			seen[object] = true
+			name := pod.Spec.ServiceAccountName
+			if name == "" {
+				name = "default"
+			}
+			sa := serviceAccounts[pod.Namespace+"/"+name]
+			if sa == nil {
+				found.add(SeverityFail, object, "ServiceAccountNotFound", "%s uses ServiceAccount %s which does not exist, its new pods are rejected", object, name)
+				found.remedy("kubectl create serviceaccount %s -n %s, or fix serviceAccountName of %s", name, pod.Namespace, object)
+				continue
+			}
+			if name != "default" || exempt[pod.Namespace] || bound[pod.Namespace+"/"+name] {
+				continue
+			}
+			automount := sa.AutomountServiceAccountToken == nil || *sa.AutomountServiceAccountToken
+			if pod.Spec.AutomountServiceAccountToken != nil {
+				automount = *pod.Spec.AutomountServiceAccountToken
+			}
+			if automount {
+				// ClusterRoleBindings are not read when the run is limited to namespaces
+				granted := "which no binding grants anything"
+				if len(opts.Namespaces) > 0 {
+					granted = "which no RoleBinding of its namespace grants anything"
+				}
+				found.add(SeverityWarn, object, "DefaultTokenAutomounted", "%s mounts the token of the default ServiceAccount, %s, set automountServiceAccountToken: false", object, granted)
+				found.remedy("Set automountServiceAccountToken: false in the pod spec of %s", object)
+			}
+		}
+
+		page = v1.ListOptions{FieldSelector: "type=" + string(corev1.SecretTypeServiceAccountToken), Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Secrets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting secrets: %w", err))
+			}
+			for i := range list.Items {
+				s := &list.Items[i]
+				if s.Type != corev1.SecretTypeServiceAccountToken {
+					continue
+				}
+				account := s.Annotations[corev1.ServiceAccountNameKey]
+				if pod, ok := mounted[s.Namespace+"/"+s.Name]; ok {
+					found.add(SeverityWarn, objectRef(secretKind, s), "LegacyTokenInUse", "Secret %s/%s is a long-lived token of ServiceAccount %s mounted by %s, use a projected token instead", s.Namespace, s.Name, account, pod)
+					found.remedy("Mount a projected serviceAccountToken volume in %s instead, then kubectl delete secret %s -n %s", pod, s.Name, s.Namespace)
+				} else if used, err := time.Parse("2006-01-02", s.Labels[legacyTokenLastUsedLabel]); err == nil && time.Since(used) < legacyTokenInUseWindow {
+					found.add(SeverityWarn, objectRef(secretKind, s), "LegacyTokenInUse", "Secret %s/%s is a long-lived token of ServiceAccount %s last used on %s, move its clients to short-lived tokens", s.Namespace, s.Name, account, s.Labels[legacyTokenLastUsedLabel])
+					found.remedy("Give its clients tokens from kubectl create token %s -n %s, then kubectl delete secret %s -n %s", account, s.Namespace, s.Name, s.Namespace)
+				}
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
@@ -0,0 +1,58 @@
+package flare
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ServiceEndpointCauses explains why a Service has no working endpoints given the pods of its
+// namespace: no selector, no matching pods, no ready pods or a targetPort the pods do not expose
+func ServiceEndpointCauses(svc *corev1.Service, pods []corev1.Pod) []string {
+	if len(svc.Spec.Selector) == 0 {
+		return []string{"The service has no selector, its endpoints have to be managed by hand"}
+	}
+	selector := labels.SelectorFromSet(svc.Spec.Selector)
+	var matching, ready []corev1.Pod
+	for _, pod := range pods {
+		if pod.Namespace != svc.Namespace || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		matching = append(matching, pod)
+		if podReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	if len(matching) == 0 {
+		return []string{fmt.Sprintf("No pods match the selector %s, check the pod labels", selector)}
+	}
+	if len(ready) == 0 {
+		return []string{fmt.Sprintf("%d pods match the selector %s but none of them are Ready", len(matching), selector)}
+	}
+
+	var causes []string
+	for _, port := range svc.Spec.Ports {
+		if !podsExposePort(ready, port) {
+			causes = append(causes, fmt.Sprintf("Service port %d targets %s, which no container of the matching pods exposes", port.Port, port.TargetPort.String()))
+		}
+	}
+	return causes
+}
+
+// Whether a container of any of the pods exposes the target port of a service port
+// A numeric targetPort is also accepted when no container declares ports, since declaring them is optional
+func podsExposePort(pods []corev1.Pod, port corev1.ServicePort) bool {
+	declared := false
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			for _, p := range container.Ports {
+				declared = true
+				if (port.TargetPort.StrVal != "" && p.Name == port.TargetPort.StrVal) || (port.TargetPort.StrVal == "" && p.ContainerPort == port.TargetPort.IntVal) {
+					return true
+				}
+			}
+		}
+	}
+	return !declared && port.TargetPort.StrVal == ""
+}
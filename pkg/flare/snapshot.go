@@ -0,0 +1,113 @@
+package flare
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/pager"
+)
+
+// ListPageSize is the number of objects requested per List call, larger lists are fetched
+// in pages and processed page by page so flare does not hold huge responses in memory
+const ListPageSize = 500
+
+// snapshot lists the resources most checks need once per run and shares them between checks,
+// so a run costs one pod and one node list instead of one per check (or per node).
+// The returned items are shared and must not be modified.
+// A nil snapshot lists on every call, which is what checks get when run without one.
+type snapshot struct {
+	mu    sync.Mutex
+	lists map[string]*cachedList
+}
+
+type cachedList struct {
+	once  sync.Once
+	items interface{}
+	err   error
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{lists: map[string]*cachedList{}}
+}
+
+// Run list the first time key is asked for and return its outcome on every call.
+// Concurrent callers of the same key wait for the first one instead of listing again.
+func (s *snapshot) load(key string, list func() (interface{}, error)) (interface{}, error) {
+	if s == nil {
+		return list()
+	}
+	s.mu.Lock()
+	l, ok := s.lists[key]
+	if !ok {
+		l = &cachedList{}
+		s.lists[key] = l
+	}
+	s.mu.Unlock()
+	l.once.Do(func() {
+		l.items, l.err = list()
+	})
+	return l.items, l.err
+}
+
+// The pods of namespace, "" for all namespaces
+func (s *snapshot) pods(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]corev1.Pod, error) {
+	items, err := s.load("pods/"+namespace, func() (interface{}, error) {
+		var pods []corev1.Pod
+		p := pager.New(func(ctx context.Context, opts v1.ListOptions) (runtime.Object, error) {
+			return clientset.CoreV1().Pods(namespace).List(ctx, opts)
+		})
+		p.PageSize = ListPageSize
+		err := p.EachListItem(ctx, v1.ListOptions{}, func(obj runtime.Object) error {
+			pods = append(pods, *obj.(*corev1.Pod))
+			return nil
+		})
+		return pods, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]corev1.Pod), nil
+}
+
+// The nodes of the cluster
+func (s *snapshot) nodes(ctx context.Context, clientset kubernetes.Interface) ([]corev1.Node, error) {
+	items, err := s.load("nodes", func() (interface{}, error) {
+		var nodes []corev1.Node
+		p := pager.New(func(ctx context.Context, opts v1.ListOptions) (runtime.Object, error) {
+			return clientset.CoreV1().Nodes().List(ctx, opts)
+		})
+		p.PageSize = ListPageSize
+		err := p.EachListItem(ctx, v1.ListOptions{}, func(obj runtime.Object) error {
+			nodes = append(nodes, *obj.(*corev1.Node))
+			return nil
+		})
+		return nodes, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]corev1.Node), nil
+}
+
+// The pods of namespace for cluster scoped checks. When the run is not limited to namespaces
+// the pods of every namespace are listed anyway, so they are filtered instead of listed again.
+func (o *Options) podsIn(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]corev1.Pod, error) {
+	if len(o.Namespaces) > 0 {
+		return o.snapshot.pods(ctx, clientset, namespace)
+	}
+	all, err := o.snapshot.pods(ctx, clientset, v1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range all {
+		if pod.Namespace == namespace {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
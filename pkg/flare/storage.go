@@ -0,0 +1,80 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Event reasons recorded by the kubelet and attach/detach controller when a volume can not be used
+var volumeEventReasons = map[string]bool{
+	"FailedMount":        true,
+	"FailedAttachVolume": true,
+}
+
+// Check for PVCs stuck in Pending, PVs in Failed or Released state and pods failing to mount volumes
+// PersistentVolumes are cluster scoped and only checked when the run is not limited to namespaces
+func checkStorage(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			pvcs, err := clientset.CoreV1().PersistentVolumeClaims(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting persistentvolumeclaims: %w", err))
+			}
+			for _, pvc := range pvcs.Items {
+				if pvc.Status.Phase == corev1.ClaimPending {
+					found.add(SeverityFail, objectRef(pvcKind, &pvc), "Pending", "PVC %s/%s is Pending", pvc.Namespace, pvc.Name)
+					found.remedy("kubectl describe pvc %s -n %s, check that its StorageClass exists and its provisioner runs", pvc.Name, pvc.Namespace)
+				}
+			}
+			if page.Continue = pvcs.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	if len(opts.Namespaces) == 0 {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			pvs, err := clientset.CoreV1().PersistentVolumes().List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting persistentvolumes: %w", err))
+			}
+			for _, pv := range pvs.Items {
+				if pv.Status.Phase == corev1.VolumeFailed || pv.Status.Phase == corev1.VolumeReleased {
+					found.add(SeverityFail, objectRef(pvKind, &pv), string(pv.Status.Phase), "PV %s is %s %s", pv.Name, pv.Status.Phase, pv.Status.Message)
+					found.remedy("kubectl describe pv %s, a Released volume needs its claimRef removed or the volume deleted", pv.Name)
+				}
+			}
+			if page.Continue = pvs.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{FieldSelector: "type=Warning,involvedObject.kind=Pod", Limit: ListPageSize}
+		for {
+			events, err := clientset.CoreV1().Events(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting events: %w", err))
+			}
+			for _, event := range events.Items {
+				if volumeEventReasons[event.Reason] {
+					object := ObjectRef{GroupVersionKind: podKind, Namespace: event.InvolvedObject.Namespace, Name: event.InvolvedObject.Name}
+					found.add(SeverityFail, object, event.Reason, "Pod %s/%s %s: %s", event.InvolvedObject.Namespace, event.InvolvedObject.Name, event.Reason, event.Message)
+					found.remedy("%s, then check the CSI driver of the volume and the node it runs on", kubectl("describe", object))
+				}
+			}
+			if page.Continue = events.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
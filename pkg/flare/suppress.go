@@ -0,0 +1,286 @@
+package flare
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// IgnoreAnnotation holds a comma separated list of check IDs, or globs of them, whose findings
+// about the annotated object are suppressed, e.g. "pods,events". On a namespace it covers every
+// object in the namespace, on a workload the pods it controls.
+const IgnoreAnnotation = "flare.jaykayy.io/ignore"
+
+// Controllers are followed this many owners up from a pod, e.g. Pod, ReplicaSet, Deployment
+const maxOwnerDepth = 3
+
+// IgnoreRule suppresses the findings of the checks matching Check about the objects matching Object
+type IgnoreRule struct {
+	// Check is a glob matched against the check ID
+	Check string
+	// Object is a glob matched against "<Kind>/<namespace>/<name>", or "<Kind>/<name>" for cluster
+	// scoped objects. As in path.Match, * does not match "/". Findings about the whole cluster have no
+	// object and are only matched by "*".
+	Object string
+}
+
+func (r IgnoreRule) matches(check string, object ObjectRef) bool {
+	name := ""
+	switch {
+	case object.Name == "":
+	case object.Namespace == "":
+		name = object.Kind + "/" + object.Name
+	default:
+		name = object.Kind + "/" + object.Namespace + "/" + object.Name
+	}
+	checkMatch, _ := path.Match(r.Check, check)
+	objectMatch, _ := path.Match(r.Object, name)
+	return checkMatch && objectMatch
+}
+
+// LoadIgnoreFile loads the IgnoreRules of an ignore file, one "<check> <object>" pair of globs per line
+//
+//	# known OOMs of the nightly batch
+//	pods Pod/batch/report-*
+//	events */staging/*
+//
+// Empty lines and lines starting with # are skipped.
+func LoadIgnoreFile(file string) ([]IgnoreRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []IgnoreRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseIgnoreRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading %s: %w", file, err)
+	}
+	return rules, nil
+}
+
+// ParseIgnoreRule parses a "<check> <object>" pair of globs as found on a line of an ignore file
+func ParseIgnoreRule(line string) (IgnoreRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return IgnoreRule{}, fmt.Errorf("expected \"<check> <object>\", got %q", strings.TrimSpace(line))
+	}
+	for _, pattern := range fields {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return IgnoreRule{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return IgnoreRule{Check: fields[0], Object: fields[1]}, nil
+}
+
+// Drop the findings of r that Options.Ignore or an IgnoreAnnotation suppress and rebuild the
+// Result from the remaining ones, with the details function of the check when it has one. The
+// fixes for suppressed objects are dropped as well. Annotations are read with get on the
+// namespaces of the findings, their objects and the controllers of those, once per object and
+// run. These gets are left out of the Permissions of checks as suppression is best effort:
+// objects flare can not read are not suppressed by their annotations.
+func (o *Options) suppress(ctx context.Context, clientset kubernetes.Interface, r Result) Result {
+	if r.Err != nil || len(r.Findings) == 0 {
+		return r
+	}
+	var kept findings
+	suppressed := map[ObjectRef]bool{}
+	for _, f := range r.Findings {
+		if o.suppressed(ctx, clientset, r.ID, f.Object) {
+			suppressed[f.Object] = true
+			continue
+		}
+		kept = append(kept, f)
+	}
+	if len(suppressed) == 0 {
+		return r
+	}
+	rebuilt, remaining := r, kept.result()
+	rebuilt.Findings, rebuilt.Pass, rebuilt.Severity, rebuilt.Details = remaining.Findings, remaining.Pass, remaining.Severity, remaining.Details
+	if r.details != nil && len(kept) > 0 {
+		rebuilt.Details = r.details(rebuilt.Findings)
+	}
+	rebuilt.Suppressed = r.Suppressed + len(r.Findings) - len(kept)
+	rebuilt.Fixes = nil
+	for _, fix := range r.Fixes {
+		if !suppressed[fixRef(fix)] {
+			rebuilt.Fixes = append(rebuilt.Fixes, fix)
+		}
+	}
+	return rebuilt
+}
+
+// Whether the finding of check about object is suppressed
+func (o *Options) suppressed(ctx context.Context, clientset kubernetes.Interface, check string, object ObjectRef) bool {
+	for _, rule := range o.Ignore {
+		if rule.matches(check, object) {
+			return true
+		}
+	}
+	if object.Name == "" {
+		return false
+	}
+	namespace := object.Namespace
+	if object.GroupVersionKind == namespaceKind {
+		namespace = object.Name
+	}
+	if namespace != "" && ignoredBy(o.annotations(ctx, clientset, ObjectRef{GroupVersionKind: namespaceKind, Name: namespace}), check) {
+		return true
+	}
+	for depth := 0; depth < maxOwnerDepth && object.Name != ""; depth++ {
+		obj := o.objectMeta(ctx, clientset, object)
+		if obj == nil {
+			return false
+		}
+		if ignoredBy(obj.GetAnnotations(), check) {
+			return true
+		}
+		owner := v1.GetControllerOf(obj)
+		if owner == nil {
+			return false
+		}
+		object = ObjectRef{GroupVersionKind: schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind), Namespace: object.Namespace, Name: owner.Name}
+	}
+	return false
+}
+
+// Whether the IgnoreAnnotation of annotations lists check
+func ignoredBy(annotations map[string]string, check string) bool {
+	value, ok := annotations[IgnoreAnnotation]
+	if !ok {
+		return false
+	}
+	for _, pattern := range strings.Split(value, ",") {
+		if matched, _ := path.Match(strings.TrimSpace(pattern), check); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// The annotations of object, nil when it can not be read
+func (o *Options) annotations(ctx context.Context, clientset kubernetes.Interface, object ObjectRef) map[string]string {
+	if obj := o.objectMeta(ctx, clientset, object); obj != nil {
+		return obj.GetAnnotations()
+	}
+	return nil
+}
+
+// Get the metadata of object once per run, nil when the kind is not supported or the get fails.
+// Pods are looked up in the pods the run already listed rather than fetched one by one.
+func (o *Options) objectMeta(ctx context.Context, clientset kubernetes.Interface, object ObjectRef) v1.Object {
+	if object.GroupKind() == podKind.GroupKind() && o.snapshot != nil && o.inScope(object.Namespace) {
+		if pod := o.snapshotPod(ctx, clientset, object); pod != nil {
+			return pod
+		}
+	}
+	items, err := o.snapshot.load("meta/"+object.GroupVersionKind.String()+"/"+object.Namespace+"/"+object.Name, func() (interface{}, error) {
+		return getObjectMeta(ctx, clientset, object)
+	})
+	if err != nil {
+		return nil
+	}
+	return items.(v1.Object)
+}
+
+// The pod of object from the pods of the run, indexed once per run, nil when it is not found
+func (o *Options) snapshotPod(ctx context.Context, clientset kubernetes.Interface, object ObjectRef) *corev1.Pod {
+	namespace := object.Namespace
+	if len(o.Namespaces) == 0 {
+		namespace = v1.NamespaceAll
+	}
+	index, err := o.snapshot.load("podindex/"+namespace, func() (interface{}, error) {
+		pods, err := o.snapshot.pods(ctx, clientset, namespace)
+		if err != nil {
+			return nil, err
+		}
+		index := make(map[string]*corev1.Pod, len(pods))
+		for i := range pods {
+			index[pods[i].Namespace+"/"+pods[i].Name] = &pods[i]
+		}
+		return index, nil
+	})
+	if err != nil {
+		return nil
+	}
+	return index.(map[string]*corev1.Pod)[object.Namespace+"/"+object.Name]
+}
+
+// Get an object of one of the kinds that carry IgnoreAnnotation: namespaces, workloads and the
+// objects checks commonly report. Secrets are left out so suppression never reads their data.
+func getObjectMeta(ctx context.Context, clientset kubernetes.Interface, object ObjectRef) (v1.Object, error) {
+	get, ns, name := v1.GetOptions{}, object.Namespace, object.Name
+	switch object.GroupKind() {
+	case namespaceKind.GroupKind():
+		return clientset.CoreV1().Namespaces().Get(ctx, name, get)
+	case nodeKind.GroupKind():
+		return clientset.CoreV1().Nodes().Get(ctx, name, get)
+	case podKind.GroupKind():
+		return clientset.CoreV1().Pods(ns).Get(ctx, name, get)
+	case serviceKind.GroupKind():
+		return clientset.CoreV1().Services(ns).Get(ctx, name, get)
+	case pvcKind.GroupKind():
+		return clientset.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, get)
+	case pvKind.GroupKind():
+		return clientset.CoreV1().PersistentVolumes().Get(ctx, name, get)
+	case deploymentKind.GroupKind():
+		return clientset.AppsV1().Deployments(ns).Get(ctx, name, get)
+	case replicaSetKind.GroupKind():
+		return clientset.AppsV1().ReplicaSets(ns).Get(ctx, name, get)
+	case statefulSetKind.GroupKind():
+		return clientset.AppsV1().StatefulSets(ns).Get(ctx, name, get)
+	case daemonSetKind.GroupKind():
+		return clientset.AppsV1().DaemonSets(ns).Get(ctx, name, get)
+	case jobKind.GroupKind():
+		return clientset.BatchV1().Jobs(ns).Get(ctx, name, get)
+	case cronJobKind.GroupKind():
+		return clientset.BatchV1().CronJobs(ns).Get(ctx, name, get)
+	case ingressKind.GroupKind():
+		return clientset.NetworkingV1().Ingresses(ns).Get(ctx, name, get)
+	case hpaKind.GroupKind():
+		return clientset.AutoscalingV2().HorizontalPodAutoscalers(ns).Get(ctx, name, get)
+	case pdbKind.GroupKind():
+		return clientset.PolicyV1().PodDisruptionBudgets(ns).Get(ctx, name, get)
+	}
+	return nil, fmt.Errorf("suppression does not support %s", object.GroupKind())
+}
+
+// The ObjectRef of the finding a fix is for, its Object or else its backup
+func fixRef(fix Fix) ObjectRef {
+	if fix.Object.Name != "" {
+		return fix.Object
+	}
+	return backupRef(fix)
+}
+
+// The ObjectRef of the backup of a fix, empty when it has none
+func backupRef(fix Fix) ObjectRef {
+	if fix.Backup == nil {
+		return ObjectRef{}
+	}
+	obj, err := meta.Accessor(fix.Backup)
+	if err != nil {
+		return ObjectRef{}
+	}
+	return ObjectRef{GroupVersionKind: fix.Backup.GetObjectKind().GroupVersionKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
@@ -0,0 +1,188 @@
+package flare
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Waits for the client rate limiter at least this long are reported, client-go logs them as well
+const longRateLimiterWait = time.Second
+
+// Throttling records how much the requests of a run were throttled, by the API server answering
+// 429 Too Many Requests and by the client rate limiter. See Instrument.
+type Throttling struct {
+	mu              sync.Mutex
+	requests        int
+	tooManyRequests int
+	longWaits       int
+	waited          time.Duration
+	longestWait     time.Duration
+}
+
+// Instrument returns a copy of config whose requests are recorded in t. Without a RateLimiter the
+// copy gets the token bucket client-go would use for its QPS and Burst, wrapped to time its waits.
+func (t *Throttling) Instrument(config *rest.Config) *rest.Config {
+	config = rest.CopyConfig(config)
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := rt.RoundTrip(req)
+			t.mu.Lock()
+			t.requests++
+			if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+				t.tooManyRequests++
+			}
+			t.mu.Unlock()
+			return resp, err
+		})
+	})
+	if config.RateLimiter == nil && config.QPS >= 0 {
+		qps, burst := config.QPS, config.Burst
+		if qps == 0 {
+			qps = rest.DefaultQPS
+		}
+		if burst == 0 {
+			burst = rest.DefaultBurst
+		}
+		config.RateLimiter = &timedRateLimiter{RateLimiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst), throttling: t}
+	}
+	return config
+}
+
+func (t *Throttling) wait(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.waited += d
+	if d >= longRateLimiterWait {
+		t.longWaits++
+	}
+	if d > t.longestWait {
+		t.longestWait = d
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// timedRateLimiter records the time requests wait for a rate limiter in a Throttling
+type timedRateLimiter struct {
+	flowcontrol.RateLimiter
+	throttling *Throttling
+}
+
+func (l *timedRateLimiter) Accept() {
+	start := time.Now()
+	l.RateLimiter.Accept()
+	l.throttling.wait(time.Since(start))
+}
+
+func (l *timedRateLimiter) Wait(ctx context.Context) error {
+	start := time.Now()
+	err := l.RateLimiter.Wait(ctx)
+	l.throttling.wait(time.Since(start))
+	return err
+}
+
+// Check whether the requests of this run were throttled, by 429 responses or long waits for the
+// client rate limiter, and report the API Priority and Fairness priority levels that rejected or
+// queue requests according to the apiserver metrics, when flare may read them.
+// It runs after the other checks so it sees all the requests of the run.
+func checkThrottling(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	if t := opts.Throttling; t != nil {
+		t.mu.Lock()
+		requests, tooMany, longWaits, waited, longest := t.requests, t.tooManyRequests, t.longWaits, t.waited, t.longestWait
+		t.mu.Unlock()
+		if tooMany > 0 {
+			found.add(SeverityWarn, ObjectRef{}, "TooManyRequests", "%d of the %d requests of this run were answered with 429 Too Many Requests, the API server is shedding load", tooMany, requests)
+			found.remedy("Lower --qps or narrow the run with --checks and -n, and check the API Priority and Fairness settings of the cluster")
+		}
+		if longWaits > 0 {
+			found.add(SeverityWarn, ObjectRef{}, "ClientSideThrottling", "%d requests of this run waited over %s for the client rate limiter, %s in total, the longest %s", longWaits, longRateLimiterWait, waited.Round(time.Millisecond), longest.Round(time.Millisecond))
+			found.remedy("Raise --qps and --burst, or narrow the run with --checks and -n")
+		}
+	}
+	// The metrics are optional, most users may not get /metrics
+	if client := clientset.Discovery().RESTClient(); client != nil {
+		if data, err := client.Get().AbsPath("/metrics").SetHeader("Accept", "text/plain").Do(ctx).Raw(); err == nil {
+			flowControlFindings(&found, string(data))
+		}
+	}
+	return found.result()
+}
+
+// Record the priority levels that rejected requests since the API server started or have
+// requests waiting in their queues, from the text exposition of the apiserver metrics
+func flowControlFindings(found *findings, metrics string) {
+	rejected, queued := map[string]float64{}, map[string]float64{}
+	scanner := bufio.NewScanner(strings.NewReader(metrics))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var into map[string]float64
+		switch {
+		case strings.HasPrefix(line, "apiserver_flowcontrol_rejected_requests_total{"):
+			into = rejected
+		case strings.HasPrefix(line, "apiserver_flowcontrol_current_inqueue_requests{"):
+			into = queued
+		default:
+			continue
+		}
+		end := strings.LastIndex(line, "}")
+		if end < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(line[end+1:]), 64)
+		if err != nil {
+			continue
+		}
+		into[metricLabel(line[:end], "priority_level")] += value
+	}
+
+	for _, level := range sortedLevels(rejected) {
+		if rejected[level] > 0 {
+			found.add(SeverityWarn, ObjectRef{}, "RequestsRejected", "Priority level %s rejected %.0f requests since the API server started, controllers and automation using it may be degraded", level, rejected[level])
+			found.remedy("kubectl get flowschemas,prioritylevelconfigurations, then raise the concurrency shares of priority level %s or find the client flooding it", level)
+		}
+	}
+	for _, level := range sortedLevels(queued) {
+		if queued[level] > 0 {
+			found.add(SeverityWarn, ObjectRef{}, "RequestsQueued", "Priority level %s has %.0f requests waiting in its queues", level, queued[level])
+			found.remedy("kubectl get flowschemas,prioritylevelconfigurations, then raise the concurrency shares of priority level %s or find the client flooding it", level)
+		}
+	}
+}
+
+// The value of a label of a metric line without its value, "" when it is not set
+func metricLabel(metric string, label string) string {
+	i := strings.Index(metric, label+`="`)
+	if i < 0 {
+		return ""
+	}
+	value := metric[i+len(label)+2:]
+	if end := strings.Index(value, `"`); end >= 0 {
+		return value[:end]
+	}
+	return ""
+}
+
+func sortedLevels(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,152 @@
+package flare
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// The API group served by metrics-server
+const metricsGroupVersion = "metrics.k8s.io/v1beta1"
+
+// Used by the utilization check when Options.UtilizationThreshold is not set
+const defaultUtilizationThreshold = 90
+
+// metricsList is the part of a metrics.k8s.io NodeMetricsList or PodMetricsList the utilization check reads
+type metricsList struct {
+	Items []struct {
+		Metadata   v1.ObjectMeta       `json:"metadata"`
+		Usage      corev1.ResourceList `json:"usage"`
+		Containers []struct {
+			Usage corev1.ResourceList `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// Check the live usage reported by metrics-server: nodes using more than the threshold percentage
+// of their allocatable CPU or memory, and pods using more memory than they request, which are
+// the first to be evicted when their node runs out. Warns when metrics-server is not installed.
+func checkUtilization(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	groups, err := clientset.Discovery().ServerGroups()
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting the served API groups: %w", err))
+	}
+	served := false
+	for _, g := range groups.Groups {
+		for _, gv := range g.Versions {
+			served = served || gv.GroupVersion == metricsGroupVersion
+		}
+	}
+	if !served {
+		var found findings
+		found.add(SeverityWarn, ObjectRef{}, "MetricsUnavailable", "%s is not served, install metrics-server for live usage, kubectl top and HPAs", metricsGroupVersion)
+		found.remedy("kubectl apply -f https://github.com/kubernetes-sigs/metrics-server/releases/latest/download/components.yaml")
+		return found.result()
+	}
+	client := clientset.Discovery().RESTClient()
+	if client == nil {
+		return errorResult(errors.New("the discovery client can not make requests"))
+	}
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	var found findings
+	if err := nodeUtilization(ctx, client, &found, nodes, opts); err != nil {
+		return errorResult(err)
+	}
+	for _, ns := range opts.namespaces() {
+		pods, err := opts.snapshot.pods(ctx, clientset, ns)
+		if err != nil {
+			return errorResult(fmt.Errorf("failed getting pods: %w", err))
+		}
+		if err := podUtilization(ctx, client, &found, ns, pods); err != nil {
+			return errorResult(err)
+		}
+	}
+	return found.result()
+}
+
+// Get a metrics.k8s.io list from path, relative to the group version
+func getMetrics(ctx context.Context, client rest.Interface, path ...string) (*metricsList, error) {
+	data, err := client.Get().AbsPath(append([]string{"/apis/" + metricsGroupVersion}, path...)...).SetHeader("Accept", "application/json").Do(ctx).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting metrics: %w", err)
+	}
+	list := &metricsList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("failed decoding metrics: %w", err)
+	}
+	return list, nil
+}
+
+// Record the nodes whose CPU or memory usage is above the threshold percentage of their allocatable resources
+func nodeUtilization(ctx context.Context, client rest.Interface, found *findings, nodes []corev1.Node, opts *Options) error {
+	threshold := int64(opts.UtilizationThreshold)
+	if threshold <= 0 {
+		threshold = defaultUtilizationThreshold
+	}
+	metrics, err := getMetrics(ctx, client, "nodes")
+	if err != nil {
+		return err
+	}
+	usage := map[string]corev1.ResourceList{}
+	for _, m := range metrics.Items {
+		usage[m.Metadata.Name] = m.Usage
+	}
+	for _, n := range nodes {
+		for _, c := range []struct {
+			name   corev1.ResourceName
+			label  string
+			reason string
+		}{{corev1.ResourceCPU, "CPU", "HighCPUUsage"}, {corev1.ResourceMemory, "memory", "HighMemoryUsage"}} {
+			allocatable := n.Status.Allocatable[c.name]
+			used, ok := usage[n.Name][c.name]
+			if !ok || allocatable.IsZero() {
+				continue
+			}
+			if percentOf(used, allocatable) > threshold {
+				found.add(SeverityWarn, objectRef(nodeKind, &n), c.reason, "Node %s uses %s of %s allocatable %s (%d%%)", n.Name, used.String(), allocatable.String(), c.label, percentOf(used, allocatable))
+				found.remedy("Add nodes or move pods off node %s, kubectl top pods -A --sort-by=%s shows the biggest users", n.Name, c.name)
+			}
+		}
+	}
+	return nil
+}
+
+// Record the pods of namespace ("" for all) using more memory than they request
+// CPU above the requests is only throttled, so it is not reported.
+func podUtilization(ctx context.Context, client rest.Interface, found *findings, namespace string, pods []corev1.Pod) error {
+	path := []string{"pods"}
+	if namespace != v1.NamespaceAll {
+		path = []string{"namespaces", namespace, "pods"}
+	}
+	metrics, err := getMetrics(ctx, client, path...)
+	if err != nil {
+		return err
+	}
+	usage := map[string]corev1.ResourceList{}
+	for _, m := range metrics.Items {
+		total := corev1.ResourceList{}
+		for _, c := range m.Containers {
+			addResources(total, c.Usage)
+		}
+		usage[m.Metadata.Namespace+"/"+m.Metadata.Name] = total
+	}
+	for _, pod := range pods {
+		used, ok := usage[pod.Namespace+"/"+pod.Name][corev1.ResourceMemory]
+		requested := podResources(pod, func(r corev1.ResourceRequirements) corev1.ResourceList { return r.Requests })[corev1.ResourceMemory]
+		if !ok || requested.IsZero() || used.Cmp(requested) <= 0 {
+			continue
+		}
+		found.add(SeverityWarn, objectRef(podKind, &pod), "MemoryAboveRequest", "Pod %s/%s uses %s of memory but requests %s", pod.Namespace, pod.Name, used.String(), requested.String())
+		found.remedy("Raise resources.requests.memory of the containers of pod %s/%s to at least %s", pod.Namespace, pod.Name, used.String())
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kubelets may be up to this many minor versions older than the apiserver, never newer
+const maxKubeletSkew = 3
+
+// Check kubelets that are newer than the apiserver or more than maxKubeletSkew minor versions
+// older, and warn when the nodes run different container runtime versions
+func checkVersionSkew(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting the apiserver version: %w", err))
+	}
+	server, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed parsing the apiserver version %q: %w", info.GitVersion, err))
+	}
+	nodes, err := opts.snapshot.nodes(ctx, clientset)
+	if err != nil {
+		return errorResult(fmt.Errorf("failed getting nodes: %w", err))
+	}
+	var found findings
+	runtimes := map[string][]string{}
+	for _, node := range nodes {
+		runtimes[node.Status.NodeInfo.ContainerRuntimeVersion] = append(runtimes[node.Status.NodeInfo.ContainerRuntimeVersion], node.Name)
+		kubelet, err := version.ParseGeneric(node.Status.NodeInfo.KubeletVersion)
+		if err != nil {
+			found.add(SeverityWarn, objectRef(nodeKind, &node), "UnknownKubeletVersion", "Node %s has an unknown kubelet version %q", node.Name, node.Status.NodeInfo.KubeletVersion)
+			found.remedy("Check the kubelet binary of node %s", node.Name)
+			continue
+		}
+		switch {
+		case kubelet.Major() != server.Major() || kubelet.Minor() > server.Minor():
+			found.add(SeverityFail, objectRef(nodeKind, &node), "KubeletNewerThanAPIServer", "Node %s kubelet %s is newer than the apiserver %s", node.Name, node.Status.NodeInfo.KubeletVersion, info.GitVersion)
+			found.remedy("Upgrade the control plane before node %s, or downgrade its kubelet to %s", node.Name, info.GitVersion)
+		case server.Minor()-kubelet.Minor() > maxKubeletSkew:
+			found.add(SeverityFail, objectRef(nodeKind, &node), "KubeletTooOld", "Node %s kubelet %s is more than %d minor versions behind the apiserver %s", node.Name, node.Status.NodeInfo.KubeletVersion, maxKubeletSkew, info.GitVersion)
+			found.remedy("Upgrade the kubelet of node %s, or drain it and replace it with a node of the current version", node.Name)
+		}
+	}
+	if len(runtimes) > 1 {
+		versions := make([]string, 0, len(runtimes))
+		for v := range runtimes {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		found.add(SeverityWarn, ObjectRef{}, "RuntimeVersionMismatch", "Nodes run different container runtime versions:")
+		found.remedy("Upgrade the container runtime of the nodes of the older versions, e.g. by replacing them with a current node image")
+		for _, v := range versions {
+			sort.Strings(runtimes[v])
+			found.detail(fmt.Sprintf("%s: %s", v, strings.Join(runtimes[v], ", ")))
+		}
+	}
+	return found.result()
+}
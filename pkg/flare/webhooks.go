@@ -0,0 +1,265 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Webhooks failing closed that wait at least this long, of the 30s maximum, are reported
+const webhookTimeoutWarn = 25
+
+// admissionWebhook is a webhook of a MutatingWebhookConfiguration or ValidatingWebhookConfiguration
+type admissionWebhook struct {
+	config ObjectRef
+	// kind is Mutating or Validating
+	kind          string
+	name          string
+	failurePolicy admissionv1.FailurePolicyType
+	timeout       int32
+	clientConfig  admissionv1.WebhookClientConfig
+	rules         []admissionv1.RuleWithOperations
+	// namespaceSelector and objectSelector are nil when they match everything
+	namespaceSelector *v1.LabelSelector
+	objectSelector    *v1.LabelSelector
+}
+
+// List the webhooks of all webhook configurations, mutating first
+func listWebhooks(ctx context.Context, clientset kubernetes.Interface) ([]admissionWebhook, error) {
+	var webhooks []admissionWebhook
+	add := func(w admissionWebhook, policy *admissionv1.FailurePolicyType, timeout *int32) {
+		// The API server defaults admissionregistration.k8s.io/v1 webhooks to Fail and 10s
+		w.failurePolicy, w.timeout = admissionv1.Fail, 10
+		if policy != nil {
+			w.failurePolicy = *policy
+		}
+		if timeout != nil {
+			w.timeout = *timeout
+		}
+		webhooks = append(webhooks, w)
+	}
+	page := v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting mutatingwebhooks: %w", err)
+		}
+		for i := range list.Items {
+			for _, w := range list.Items[i].Webhooks {
+				add(admissionWebhook{
+					config: objectRef(mutatingKind, &list.Items[i]), kind: "Mutating", name: w.Name, clientConfig: w.ClientConfig,
+					rules: w.Rules, namespaceSelector: w.NamespaceSelector, objectSelector: w.ObjectSelector,
+				}, w.FailurePolicy, w.TimeoutSeconds)
+			}
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	page = v1.ListOptions{Limit: ListPageSize}
+	for {
+		list, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting validatingwebhooks: %w", err)
+		}
+		for i := range list.Items {
+			for _, w := range list.Items[i].Webhooks {
+				add(admissionWebhook{
+					config: objectRef(validatingKind, &list.Items[i]), kind: "Validating", name: w.Name, clientConfig: w.ClientConfig,
+					rules: w.Rules, namespaceSelector: w.NamespaceSelector, objectSelector: w.ObjectSelector,
+				}, w.FailurePolicy, w.TimeoutSeconds)
+			}
+		}
+		if page.Continue = list.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return webhooks, nil
+}
+
+// Check the blast radius of webhooks with a failure policy of 'Fail': the ones whose rules and
+// selectors cover namespaced resources in kube-system, or in the namespace of their own Service,
+// can keep the pods they depend on from coming back once their backend is down. Also check whether
+// the Service behind each webhook exists and has ready endpoints, and report webhooks failing closed
+// with a timeout close to the 30s maximum: when their backend hangs every matching request waits
+// that long before it is rejected, which wedges controllers across the cluster.
+func checkWebhooks(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	webhooks, err := listWebhooks(ctx, clientset)
+	if err != nil {
+		return errorResult(err)
+	}
+	namespaceLabels := map[string]labels.Set{}
+	if len(opts.Namespaces) == 0 {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			list, err := clientset.CoreV1().Namespaces().List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting namespaces: %w", err))
+			}
+			for _, ns := range list.Items {
+				// Set by the API server since 1.21, older clusters select namespaces by their name this way too
+				namespaceLabels[ns.Name] = labels.Merge(ns.Labels, labels.Set{"kubernetes.io/metadata.name": ns.Name})
+			}
+			if page.Continue = list.Continue; page.Continue == "" {
+				break
+			}
+		}
+	} else {
+		// Listing namespaces needs cluster wide access, limited runs only match selectors on the
+		// kubernetes.io/metadata.name label of kube-system and of the namespaces of the webhooks
+		namespaceLabels[v1.NamespaceSystem] = labels.Set{"kubernetes.io/metadata.name": v1.NamespaceSystem}
+		for _, w := range webhooks {
+			if svc := w.clientConfig.Service; svc != nil {
+				namespaceLabels[svc.Namespace] = labels.Set{"kubernetes.io/metadata.name": svc.Namespace}
+			}
+		}
+	}
+	// The Services looked up so far by "ns/name", whether they exist and have ready endpoints
+	type serviceState struct{ exists, ready bool }
+	services := map[string]serviceState{}
+	var found findings
+	for _, w := range webhooks {
+		fail := w.failurePolicy == admissionv1.Fail
+		severity, effect := SeverityWarn, "the requests it matches are admitted without it"
+		if fail {
+			if err := webhookBlastRadius(&found, w, namespaceLabels); err != nil {
+				return errorResult(err)
+			}
+			severity, effect = SeverityFail, "the requests it matches are rejected"
+			if w.timeout >= webhookTimeoutWarn {
+				found.add(SeverityWarn, w.config, "WebhookTimeoutNearMax", "%s Webhook: %s fails closed after waiting up to %ds, a hanging backend stalls every request it matches", w.kind, w.name, w.timeout)
+				found.remedy("Lower timeoutSeconds of webhook %s of %s to a few seconds", w.name, w.config)
+			}
+		}
+		svc := w.clientConfig.Service
+		if svc == nil {
+			continue
+		}
+		key := svc.Namespace + "/" + svc.Name
+		state, looked := services[key]
+		if !looked {
+			if state.exists, state.ready, err = webhookService(ctx, clientset, svc.Namespace, svc.Name); err != nil {
+				return errorResult(err)
+			}
+			services[key] = state
+		}
+		service := ObjectRef{GroupVersionKind: serviceKind, Namespace: svc.Namespace, Name: svc.Name}
+		if !state.exists {
+			found.add(severity, service, "WebhookServiceNotFound", "%s Webhook: %s calls Service %s which does not exist, %s", w.kind, w.name, key, effect)
+			found.remedy("Reinstall the owner of webhook %s, or %s if it was uninstalled", w.name, kubectl("delete", w.config))
+		} else if !state.ready {
+			found.add(severity, service, "WebhookNoEndpoints", "%s Webhook: %s calls Service %s which has no ready endpoints, %s", w.kind, w.name, key, effect)
+			found.remedy("Check the backend pods of Service %s, kubectl describe service %s -n %s", key, svc.Name, svc.Namespace)
+		}
+	}
+	return found.result()
+}
+
+// Record the findings of a webhook failing closed whose rules cover namespaced resources in
+// kube-system or in the namespace of its own Service
+func webhookBlastRadius(found *findings, w admissionWebhook, namespaceLabels map[string]labels.Set) error {
+	resources, pods := webhookResources(w.rules)
+	if len(resources) == 0 {
+		return nil
+	}
+	selector, err := v1.LabelSelectorAsSelector(w.namespaceSelector)
+	if err != nil {
+		return fmt.Errorf("invalid namespaceSelector of webhook %s: %w", w.name, err)
+	}
+	if w.namespaceSelector == nil {
+		selector = labels.Everything()
+	}
+	covered := strings.Join(resources, ", ")
+	scope := ""
+	if w.objectSelector != nil && (len(w.objectSelector.MatchLabels) > 0 || len(w.objectSelector.MatchExpressions) > 0) {
+		scope = " matching " + v1.FormatLabelSelector(w.objectSelector)
+	}
+	own := ""
+	if w.clientConfig.Service != nil {
+		own = w.clientConfig.Service.Namespace
+	}
+	if set, ok := namespaceLabels[own]; ok && own != v1.NamespaceSystem && selector.Matches(set) {
+		severity := SeverityWarn
+		if pods {
+			severity = SeverityFail
+		}
+		found.add(severity, w.config, "WebhookCoversOwnNamespace", "%s Webhook: %s fails closed for %s%s in its own namespace %s, when its backend is down it can block its own pods from being recreated", w.kind, w.name, covered, scope, own)
+		found.remedy("Exclude namespace %s from the namespaceSelector of webhook %s of %s", own, w.name, w.config)
+	}
+	if set, ok := namespaceLabels[v1.NamespaceSystem]; ok && selector.Matches(set) {
+		found.add(SeverityWarn, w.config, "WebhookCoversKubeSystem", "%s Webhook: %s fails closed for %s%s in kube-system, when its backend is down the cluster add-ons can not recover", w.kind, w.name, covered, scope)
+		found.remedy("Exclude kube-system from the namespaceSelector of webhook %s of %s, e.g. on the kubernetes.io/metadata.name label", w.name, w.config)
+	}
+	return nil
+}
+
+// The namespaced resources the rules of a webhook match on create or update, sorted, and whether
+// they include pods
+func webhookResources(rules []admissionv1.RuleWithOperations) ([]string, bool) {
+	matched := map[string]bool{}
+	pods := false
+	for _, r := range rules {
+		if r.Scope != nil && *r.Scope == admissionv1.ClusterScope {
+			continue
+		}
+		writes := false
+		for _, op := range r.Operations {
+			writes = writes || op == admissionv1.OperationAll || op == admissionv1.Create || op == admissionv1.Update
+		}
+		if !writes {
+			continue
+		}
+		core := false
+		for _, g := range r.APIGroups {
+			core = core || g == "" || g == "*"
+		}
+		for _, resource := range r.Resources {
+			if resource == "*" || resource == "*/*" {
+				resource = "all resources"
+			}
+			matched[resource] = true
+			pods = pods || core && (resource == "pods" || resource == "all resources")
+		}
+	}
+	resources := make([]string, 0, len(matched))
+	for r := range matched {
+		resources = append(resources, r)
+	}
+	sort.Strings(resources)
+	return resources, pods
+}
+
+// Whether a Service exists and has ready endpoints
+func webhookService(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) (bool, bool, error) {
+	if _, err := clientset.CoreV1().Services(namespace).Get(ctx, name, v1.GetOptions{}); apierrors.IsNotFound(err) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, fmt.Errorf("failed getting service %s/%s: %w", namespace, name, err)
+	}
+	endpoints, err := clientset.CoreV1().Endpoints(namespace).Get(ctx, name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed getting endpoints %s/%s: %w", namespace, name, err)
+	}
+	return true, endpointsReady(endpoints), nil
+}
+
+// Whether an Endpoints object has at least one ready address
+func endpointsReady(endpoints *corev1.Endpoints) bool {
+	for _, s := range endpoints.Subsets {
+		if len(s.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package flare
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Check Deployments, StatefulSets and DaemonSets for fewer available pods than desired and stalled rollouts
+func checkRollouts(ctx context.Context, clientset kubernetes.Interface, opts *Options) Result {
+	var found findings
+	for _, ns := range opts.namespaces() {
+		page := v1.ListOptions{Limit: ListPageSize}
+		for {
+			deployments, err := clientset.AppsV1().Deployments(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting deployments: %w", err))
+			}
+			for _, d := range deployments.Items {
+				deploymentRollout(&found, d)
+			}
+			if page.Continue = deployments.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			statefulSets, err := clientset.AppsV1().StatefulSets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting statefulsets: %w", err))
+			}
+			for _, s := range statefulSets.Items {
+				desired := int32(1)
+				if s.Spec.Replicas != nil {
+					desired = *s.Spec.Replicas
+				}
+				if s.Status.ReadyReplicas < desired {
+					found.add(SeverityFail, objectRef(statefulSetKind, &s), "ReplicasNotReady", "StatefulSet %s/%s has %d/%d ready replicas", s.Namespace, s.Name, s.Status.ReadyReplicas, desired)
+					found.remedy("%s, then the events and logs of its pods that are not Ready", kubectl("describe", objectRef(statefulSetKind, &s)))
+				}
+			}
+			if page.Continue = statefulSets.Continue; page.Continue == "" {
+				break
+			}
+		}
+
+		page = v1.ListOptions{Limit: ListPageSize}
+		for {
+			daemonSets, err := clientset.AppsV1().DaemonSets(ns).List(ctx, page)
+			if err != nil {
+				return errorResult(fmt.Errorf("failed getting daemonsets: %w", err))
+			}
+			for _, d := range daemonSets.Items {
+				if d.Status.NumberReady < d.Status.DesiredNumberScheduled {
+					found.add(SeverityFail, objectRef(daemonSetKind, &d), "PodsNotReady", "DaemonSet %s/%s has %d/%d ready pods", d.Namespace, d.Name, d.Status.NumberReady, d.Status.DesiredNumberScheduled)
+					found.remedy("%s, then the events and logs of its pods that are not Ready", kubectl("describe", objectRef(daemonSetKind, &d)))
+				}
+			}
+			if page.Continue = daemonSets.Continue; page.Continue == "" {
+				break
+			}
+		}
+	}
+	return found.result()
+}
+
+// Record a Deployment that is missing available replicas or whose rollout exceeded its progress deadline
+func deploymentRollout(found *findings, d appsv1.Deployment) {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	if d.Status.AvailableReplicas < desired {
+		found.add(SeverityFail, objectRef(deploymentKind, &d), "ReplicasUnavailable", "Deployment %s/%s has %d/%d available replicas", d.Namespace, d.Name, d.Status.AvailableReplicas, desired)
+		found.remedy("%s, then the events and logs of its pods that are not Ready", kubectl("describe", objectRef(deploymentKind, &d)))
+	}
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded" {
+			found.add(SeverityFail, objectRef(deploymentKind, &d), condition.Reason, "Deployment %s/%s rollout is stalled: %s", d.Namespace, d.Name, condition.Message)
+			found.remedy("kubectl rollout undo deployment/%s -n %s if the new version is broken, kubectl rollout history shows the revisions", d.Name, d.Namespace)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"flare/pkg/flare"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+)
+
+// The component flare's Events are reported by, shown in the From column of kubectl describe
+const eventComponent = "flare"
+
+// publisher shares the results of in-cluster runs with the cluster itself: --record-events
+// records Events on the objects of the findings, --status-configmap keeps the latest report
+type publisher struct {
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+	// events records an Event for every finding of a failed or warning check
+	events bool
+	// configMapNamespace and configMapName are the --status-configmap, "" to not write one
+	configMapNamespace string
+	configMapName      string
+	// mapper resolves the resource of finding objects to look up their UID, built on first use
+	mapper meta.RESTMapper
+}
+
+// Parse a --status-configmap value of the form <namespace>/<name>
+func parseStatusConfigMap(value string) (namespace string, name string, err error) {
+	if value == "" {
+		return "", "", nil
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("--status-configmap must be <namespace>/<name>, got %q", value)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Record the Events and write the status ConfigMap of a run that finished at now.
+// Failing to publish is logged and does not fail the run, the report is still written.
+func (p *publisher) publish(ctx context.Context, results []flare.Result, now time.Time) {
+	if p.events {
+		if err := p.recordEvents(ctx, results, now); err != nil {
+			log.Errorf("Failed recording events: %v", err)
+		}
+	}
+	if p.configMapName != "" {
+		if err := p.writeConfigMap(ctx, results, now); err != nil {
+			log.Errorf("Failed writing status configmap %s/%s: %v", p.configMapNamespace, p.configMapName, err)
+		}
+	}
+}
+
+// Record a Warning Event on the object of every finding of the failed and warning checks, so
+// kubectl describe shows it. Every finding has one Event per object, check and reason whose count
+// goes up on every run it is found again, like the Events of the kubelet. Findings about the whole
+// cluster or about objects that were deleted meanwhile are left out.
+func (p *publisher) recordEvents(ctx context.Context, results []flare.Result, now time.Time) error {
+	for _, r := range results {
+		if r.Skipped || r.Severity < flare.SeverityWarn {
+			continue
+		}
+		for _, f := range r.Findings {
+			if f.Object.Name == "" || f.Object.Kind == "" || f.Severity < flare.SeverityWarn {
+				continue
+			}
+			uid, err := p.uid(ctx, f.Object)
+			if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed getting %s: %w", f.Object, err)
+			}
+			if err := p.recordEvent(ctx, r.ID, f, uid, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The UID of the object a finding is about, Events without one are not shown by kubectl describe
+func (p *publisher) uid(ctx context.Context, o flare.ObjectRef) (string, error) {
+	if p.mapper == nil {
+		resources, err := restmapper.GetAPIGroupResources(p.clientset.Discovery())
+		if err != nil {
+			return "", fmt.Errorf("failed discovering api resources: %w", err)
+		}
+		p.mapper = restmapper.NewDiscoveryRESTMapper(resources)
+	}
+	mapping, err := p.mapper.RESTMapping(o.GroupKind(), o.Version)
+	if err != nil {
+		return "", err
+	}
+	obj, err := p.dynamic.Resource(mapping.Resource).Namespace(o.Namespace).Get(ctx, o.Name, v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return string(obj.GetUID()), nil
+}
+
+// Create the Event of a finding, or bump the count of the one recorded by an earlier run
+func (p *publisher) recordEvent(ctx context.Context, check string, f flare.Finding, uid string, now time.Time) error {
+	// Events of cluster scoped objects go to the default namespace, as kubectl describe expects
+	namespace := f.Object.Namespace
+	if namespace == "" {
+		namespace = v1.NamespaceDefault
+	}
+	sum := sha256.Sum256([]byte(check + "/" + f.Reason + "/" + uid))
+	name := fmt.Sprintf("%s.flare-%x", f.Object.Name, sum[:8])
+	events := p.clientset.CoreV1().Events(namespace)
+	event, err := events.Get(ctx, name, v1.GetOptions{})
+	if err == nil {
+		event.Count++
+		event.Message = f.Message
+		event.LastTimestamp = v1.Time{Time: now}
+		_, err = events.Update(ctx, event, v1.UpdateOptions{})
+	} else if apierrors.IsNotFound(err) {
+		event = &corev1.Event{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: namespace},
+			InvolvedObject: corev1.ObjectReference{
+				APIVersion: f.Object.GroupVersion().String(),
+				Kind:       f.Object.Kind,
+				Namespace:  f.Object.Namespace,
+				Name:       f.Object.Name,
+				UID:        types.UID(uid),
+			},
+			Reason:              f.Reason,
+			Message:             f.Message,
+			Type:                corev1.EventTypeWarning,
+			Source:              corev1.EventSource{Component: eventComponent},
+			ReportingController: eventComponent,
+			FirstTimestamp:      v1.Time{Time: now},
+			LastTimestamp:       v1.Time{Time: now},
+			Count:               1,
+		}
+		_, err = events.Create(ctx, event, v1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed recording event %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// Write the outcome of a run to the --status-configmap, creating it on the first run.
+// It holds the worst severity, the summary line, the run time and the json report.
+func (p *publisher) writeConfigMap(ctx context.Context, results []flare.Result, now time.Time) error {
+	out := make([]jsonResult, 0, len(results))
+	for _, r := range results {
+		out = append(out, newJSONResult(r))
+	}
+	report, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	n := summarize(results)
+	data := map[string]string{
+		"severity":    n.Severity.String(),
+		"summary":     fmt.Sprintf("%d passed, %d warned, %d failed, %d skipped", n.Passed, n.Warnings, n.Failed, n.Skipped),
+		"lastRun":     now.UTC().Format(time.RFC3339),
+		"report.json": string(report),
+	}
+	configMaps := p.clientset.CoreV1().ConfigMaps(p.configMapNamespace)
+	cm, err := configMaps.Get(ctx, p.configMapName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      p.configMapName,
+				Namespace: p.configMapNamespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "flare"},
+			},
+			Data: data,
+		}
+		_, err = configMaps.Create(ctx, cm, v1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm.Data = data
+	_, err = configMaps.Update(ctx, cm, v1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"flare/pkg/flare"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// resultServer serves the results of the latest run over HTTP
+type resultServer struct {
+	mu       sync.Mutex
+	results  []flare.Result
+	finished time.Time
+
+	// threshold is the severity that makes /healthz unhealthy
+	threshold flare.Severity
+	// critical limits the checks /healthz looks at, empty for all
+	critical map[string]bool
+}
+
+// healthResponse is the json body of /healthz
+type healthResponse struct {
+	Healthy bool `json:"healthy"`
+	// Finished is when the latest run finished, nil before the first one
+	Finished *time.Time `json:"finished,omitempty"`
+	// Failing lists the IDs of the critical checks that reached the threshold
+	Failing []string `json:"failing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func newServeCmd(root *rootFlags) *cobra.Command {
+	cf := &checkFlags{}
+	var addr, critical string
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the checks on an interval and serve the latest results over HTTP",
+		Long: `Serve runs the checks every --interval and serves the latest results as json on
+/results. /healthz returns 200 while no critical check reaches --fail-on and 503
+otherwise, or before the first run finished, for load balancers and uptime monitors.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			threshold, err := parseFailOn(cf.failOn)
+			if err != nil {
+				return err
+			}
+			selected, err := prepareRun(root, cf)
+			if err != nil {
+				return err
+			}
+			criticalIDs, err := parseCriticalChecks(critical, selected, cf.activeProbes)
+			if err != nil {
+				return err
+			}
+			s := &resultServer{threshold: threshold, critical: criticalIDs}
+			clientset, config, err := clientsetFromFlags(root)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return s.serve(ctx, addr, cf.interval, func() ([]flare.Result, error) {
+				return runWithTimeout(ctx, clientset, config, cf, selected)
+			})
+		},
+	}
+	addRunFlags(cmd.Flags(), cf)
+	cmd.Flags().StringVar(&addr, "addr", ":8080", "address to serve /results and /healthz on")
+	cmd.Flags().DurationVar(&cf.interval, "interval", 5*time.Minute, "time between runs")
+	cmd.Flags().StringVar(&cf.failOn, "fail-on", "error", "/healthz returns 503 when a critical check reports this severity or worse, one of: warn, error, none")
+	cmd.Flags().StringVar(&critical, "critical-checks", "", "comma separated list of the checks /healthz looks at, defaults to all")
+	return cmd
+}
+
+// Parse the --critical-checks IDs, which must be checks the runs include: a check that never runs
+// would never make /healthz unhealthy
+func parseCriticalChecks(critical string, selected []flare.Check, activeProbes bool) (map[string]bool, error) {
+	ids := map[string]bool{}
+	if strings.TrimSpace(critical) == "" {
+		return ids, nil
+	}
+	checks, err := flare.SelectChecks(critical, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid --critical-checks: %w", err)
+	}
+	running := map[string]bool{}
+	for _, c := range selected {
+		running[c.ID] = !c.Active || activeProbes
+	}
+	for _, c := range checks {
+		if !running[c.ID] {
+			return nil, fmt.Errorf("critical check %s is not part of the run, see --checks, --skip, --profile and --active-probes", c.ID)
+		}
+		ids[c.ID] = true
+	}
+	return ids, nil
+}
+
+// Serve the results on addr and run the checks every interval until ctx is cancelled
+func (s *resultServer) serve(ctx context.Context, addr string, interval time.Duration, run func() ([]flare.Result, error)) error {
+	server := &http.Server{Addr: addr, Handler: s.handler()}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	log.Infof("Serving results on %s/results and %s/healthz, running checks every %s", addr, addr, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if results, err := run(); err != nil {
+			log.Errorf("Failed running checks: %v", err)
+		} else {
+			s.update(results, time.Now())
+		}
+		select {
+		case err := <-serverErr:
+			return err
+		case <-ctx.Done():
+			return server.Shutdown(context.Background())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Replace the served results with the ones of a run that finished at finished
+func (s *resultServer) update(results []flare.Result, finished time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = results
+	s.finished = finished
+}
+
+func (s *resultServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/results", s.serveResults)
+	mux.HandleFunc("/healthz", s.serveHealth)
+	return mux
+}
+
+// Serve the latest results in the json output format, 503 before the first run finished
+func (s *resultServer) serveResults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	results, finished := s.results, s.finished
+	s.mu.Unlock()
+	if finished.IsZero() {
+		http.Error(w, "no results yet, the first run did not finish", http.StatusServiceUnavailable)
+		return
+	}
+	out := make([]jsonResult, 0, len(results))
+	for _, result := range results {
+		out = append(out, newJSONResult(result))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", finished.UTC().Format(http.TimeFormat))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		log.Errorf("Failed writing results: %v", err)
+	}
+}
+
+// Serve 200 when no critical check reached the threshold in the latest run, 503 otherwise
+func (s *resultServer) serveHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	results, finished := s.results, s.finished
+	s.mu.Unlock()
+	health := healthResponse{Healthy: true, Finished: &finished}
+	if finished.IsZero() {
+		health = healthResponse{Error: "no results yet, the first run did not finish"}
+	}
+	for _, result := range results {
+		if (len(s.critical) == 0 || s.critical[result.ID]) && result.Severity >= s.threshold {
+			health.Healthy = false
+			health.Failing = append(health.Failing, result.ID)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		log.Errorf("Failed writing health: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"flare/pkg/flare"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/yaml"
+)
+
+// The file of a snapshot describing what it holds, next to the resources/ directory
+const snapshotManifestFile = "manifest.yaml"
+
+// snapshotManifest records where and when a snapshot was taken and what it collected
+type snapshotManifest struct {
+	FlareVersion string    `json:"flareVersion"`
+	Created      time.Time `json:"created"`
+	Server       string    `json:"server"`
+	// Namespaces the namespaced resources were collected from, all namespaces when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Checks are the IDs of the checks whose resources were collected
+	Checks    []string           `json:"checks"`
+	Resources []snapshotResource `json:"resources"`
+}
+
+// snapshotResource is one resource of a snapshot, with the number of objects collected or why none were
+type snapshotResource struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Resource string `json:"resource"`
+	Kind     string `json:"kind,omitempty"`
+	Count    int    `json:"count"`
+	File     string `json:"file,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newSnapshotCmd(root *rootFlags) *cobra.Command {
+	cf := &checkFlags{}
+	var outputDir string
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Export the resources the checks read into an archive for flare analyze",
+		Long: `Snapshot writes a timestamped tar.gz with every object the selected checks read,
+one List per resource, and a manifest.yaml of what was collected, so the checks can
+be run later or elsewhere with flare analyze --snapshot.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selected, err := prepareRun(root, cf)
+			if err != nil {
+				return err
+			}
+			clientset, config, err := clientsetFromFlags(root)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+			dynamicClient, err := dynamic.NewForConfig(config)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+			resources, err := restmapper.GetAPIGroupResources(clientset.Discovery())
+			if err != nil {
+				return fmt.Errorf("failed discovering api resources: %w", err)
+			}
+			now := time.Now()
+			path := filepath.Join(outputDir, "flare-snapshot-"+now.Format("20060102-150405")+".tar.gz")
+			f, err := os.Create(path)
+			if err != nil {
+				return fmt.Errorf("failed creating snapshot: %w", err)
+			}
+			manifest := snapshotManifest{FlareVersion: version, Created: now.UTC(), Server: config.Host, Namespaces: flare.ParseNamespaces(cf.namespaces)}
+			err = writeSnapshot(context.Background(), dynamicClient, restmapper.NewDiscoveryRESTMapper(resources), selected, manifest, cf.redactor, f)
+			if errClose := f.Close(); err == nil {
+				err = errClose
+			}
+			if err != nil {
+				return fmt.Errorf("failed writing snapshot: %w", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Wrote "+path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "d", ".", "directory to write the snapshot to")
+	cmd.Flags().StringVar(&cf.only, "checks", "", "comma separated list of checks whose resources to collect, defaults to all, see 'flare list'")
+	cmd.Flags().StringVar(&cf.profile, "profile", "", "collect the resources of the checks of a profile, one of: "+strings.Join(flare.ProfileNames(), ", ")+", or a profile of the config file")
+	cmd.Flags().StringVar(&cf.skip, "skip", "", "comma separated list of checks whose resources not to collect")
+	cmd.Flags().StringVarP(&cf.namespaces, "namespace", "n", "", "comma separated list of namespaces to collect namespaced resources from, defaults to all namespaces")
+	cmd.Flags().StringArrayVar(&cf.redact, "redact", nil, "regular expression whose matches are replaced with "+redacted+" in the snapshot, on top of keys, tokens and passwords (repeatable)")
+	return cmd
+}
+
+// The resources the checks read, from the list and get permissions they declare. Active and Live
+// checks are left out, flare analyze can not run them.
+func snapshotResources(checks []flare.Check) []schema.GroupResource {
+	seen := map[schema.GroupResource]bool{}
+	var resources []schema.GroupResource
+	for _, c := range checks {
+		if c.Active || c.Live {
+			continue
+		}
+		for _, p := range c.Permissions {
+			gr := schema.GroupResource{Group: p.Group, Resource: p.Resource}
+			if (p.Verb == "list" || p.Verb == "get") && !seen[gr] {
+				seen[gr] = true
+				resources = append(resources, gr)
+			}
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].String() < resources[j].String()
+	})
+	return resources
+}
+
+// Write the snapshot of the resources of checks to w, a gzipped tar with the layout:
+//
+//	manifest.yaml
+//	resources/<resource>[.<group>].yaml
+//
+// Resources the cluster does not serve, e.g. of custom resources that are not installed, are
+// left out. Resources that can not be listed, e.g. for missing permissions, are recorded in the
+// manifest with the error instead of failing the snapshot. Every object is redacted with r.
+func writeSnapshot(ctx context.Context, client dynamic.Interface, mapper meta.RESTMapper, checks []flare.Check, manifest snapshotManifest, r *redactor, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	b := &bundle{tar: tar.NewWriter(gz), now: manifest.Created, redact: r}
+	for _, c := range checks {
+		manifest.Checks = append(manifest.Checks, c.ID)
+	}
+	namespaces := manifest.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{v1.NamespaceAll}
+	}
+
+	for _, gr := range snapshotResources(checks) {
+		gvk, err := mapper.KindFor(gr.WithVersion(""))
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed mapping %s: %w", gr, err)
+		}
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return fmt.Errorf("failed mapping %s: %w", gr, err)
+		}
+		entry := snapshotResource{Group: gr.Group, Version: gvk.Version, Resource: gr.Resource, Kind: gvk.Kind}
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			list.Items, err = listSnapshotResource(ctx, client, mapping, namespaces)
+		} else {
+			list.Items, err = listSnapshotResource(ctx, client, mapping, []string{v1.NamespaceAll})
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			manifest.Resources = append(manifest.Resources, entry)
+			continue
+		}
+		for i := range list.Items {
+			b.redact.object(list.Items[i].Object)
+		}
+		entry.Count = len(list.Items)
+		entry.File = "resources/" + gr.String() + ".yaml"
+		data, err := yaml.Marshal(list.UnstructuredContent())
+		if err != nil {
+			return err
+		}
+		if err := b.add(entry.File, data); err != nil {
+			return err
+		}
+		manifest.Resources = append(manifest.Resources, entry)
+	}
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := b.add(snapshotManifestFile, data); err != nil {
+		return err
+	}
+	if err := b.tar.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// List every object of a resource in the namespaces, page by page
+func listSnapshotResource(ctx context.Context, client dynamic.Interface, mapping *meta.RESTMapping, namespaces []string) ([]unstructured.Unstructured, error) {
+	var items []unstructured.Unstructured
+	for _, ns := range namespaces {
+		page := v1.ListOptions{Limit: flare.ListPageSize}
+		for {
+			list, err := client.Resource(mapping.Resource).Namespace(ns).List(ctx, page)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range list.Items {
+				// The items of a List do not always carry their kind
+				item.SetGroupVersionKind(mapping.GroupVersionKind)
+				items = append(items, item)
+			}
+			if page.Continue = list.GetContinue(); page.Continue == "" {
+				break
+			}
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"flare/pkg/flare"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// symptom is one of the problems flare triage knows how to narrow down
+type symptom struct {
+	Name string
+	// Checks are the registry ids of the checks run for the symptom
+	Checks []string
+	// Object is what the follow up asks for, e.g. "pod"
+	Object string
+	// Namespaced is whether the object lives in a namespace
+	Namespaced bool
+	// FollowUp inspects the named object and returns the probable causes found
+	FollowUp func(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) ([]string, error)
+}
+
+var symptoms = []symptom{
+	{Name: "A pod won't start", Checks: []string{"pods", "references", "pending", "storage"}, Object: "pod", Namespaced: true, FollowUp: triagePod},
+	{Name: "A service is unreachable", Checks: []string{"endpoints", "pods"}, Object: "service", Namespaced: true, FollowUp: triageService},
+	{Name: "A node is down", Checks: []string{"nodes", "infra"}, Object: "node", FollowUp: triageNode},
+}
+
+func newTriageCmd(root *rootFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "triage",
+		Short: "Walk through debugging a symptom step by step",
+		Long: `Triage asks what symptom you see, runs the checks related to it and then
+inspects the affected object to narrow the problem down to a probable cause.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clientset, _, err := clientsetFromFlags(root)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+			return triage(context.Background(), clientset, cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+	}
+}
+
+// Print question and return the trimmed answer read from in, "" at the end of input
+func ask(in *bufio.Reader, out io.Writer, question string) string {
+	fmt.Fprint(out, question)
+	answer, _ := in.ReadString('\n')
+	return strings.TrimSpace(answer)
+}
+
+// Run the interactive walkthrough, reading answers from in and writing the questions and findings to out
+func triage(ctx context.Context, clientset kubernetes.Interface, in io.Reader, out io.Writer) error {
+	answers := bufio.NewReader(in)
+	fmt.Fprintln(out, "What do you see?")
+	for i, s := range symptoms {
+		fmt.Fprintf(out, "  %d) %s\n", i+1, s.Name)
+	}
+	choice, err := strconv.Atoi(ask(answers, out, "> "))
+	if err != nil || choice < 1 || choice > len(symptoms) {
+		return fmt.Errorf("choose a symptom between 1 and %d", len(symptoms))
+	}
+	s := symptoms[choice-1]
+
+	namespace := ""
+	if s.Namespaced {
+		namespace = ask(answers, out, "Namespace (empty for all): ")
+	}
+	name := ask(answers, out, fmt.Sprintf("Name of the %s (empty to skip): ", s.Object))
+	if name != "" && s.Namespaced && namespace == "" {
+		namespace = "default"
+	}
+
+	selected, err := flare.SelectChecks(strings.Join(s.Checks, ","), "")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nRunning %s checks\n", strings.Join(s.Checks, ", "))
+	runner := &flare.Runner{Clientset: clientset, Checks: selected, Concurrency: len(selected), CheckTimeout: 30 * time.Second}
+	results, err := runner.Run(ctx, &flare.Options{Namespaces: flare.ParseNamespaces(namespace)})
+	if err != nil {
+		return err
+	}
+	redact, err := newRedactor(nil)
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		results[i] = redact.result(results[i])
+	}
+	w := bufio.NewWriter(out)
+	if err := writeText(w, false, results); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if name == "" {
+		return nil
+	}
+
+	fmt.Fprintf(out, "\nInspecting %s %s\n", s.Object, name)
+	causes, err := s.FollowUp(ctx, clientset, namespace, name)
+	if err != nil {
+		return err
+	}
+	if len(causes) == 0 {
+		fmt.Fprintln(out, "No probable cause found, try 'flare collect' and share the bundle")
+		return nil
+	}
+	fmt.Fprintln(out, "Probable cause:")
+	for _, c := range causes {
+		fmt.Fprintln(out, "  - "+redact.text(c))
+	}
+	return nil
+}
+
+// Explain why a pod is not running: scheduling, volumes, image pulls, crashes and its warning events
+func triagePod(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) ([]string, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return []string{fmt.Sprintf("Pod %s/%s does not exist, was it replaced by its controller?", namespace, name)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed getting pod: %w", err)
+	}
+
+	var causes []string
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse {
+			causes = append(causes, "The pod cannot be scheduled: "+c.Message)
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, volume.PersistentVolumeClaim.ClaimName, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			causes = append(causes, fmt.Sprintf("PersistentVolumeClaim %s does not exist", volume.PersistentVolumeClaim.ClaimName))
+		} else if err != nil {
+			return nil, fmt.Errorf("failed getting persistentvolumeclaim: %w", err)
+		} else if pvc.Status.Phase != corev1.ClaimBound {
+			causes = append(causes, fmt.Sprintf("PersistentVolumeClaim %s is %s", pvc.Name, pvc.Status.Phase))
+		}
+	}
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		waiting := status.State.Waiting
+		if waiting == nil {
+			continue
+		}
+		switch waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+			causes = append(causes, fmt.Sprintf("Container %s cannot pull image %s: %s", status.Name, status.Image, waiting.Message))
+		case "CreateContainerConfigError":
+			causes = append(causes, fmt.Sprintf("Container %s references a missing ConfigMap or Secret: %s", status.Name, waiting.Message))
+		case "CrashLoopBackOff":
+			cause := fmt.Sprintf("Container %s keeps crashing", status.Name)
+			if last := status.LastTerminationState.Terminated; last != nil {
+				cause += fmt.Sprintf(", last exit code %d (%s)", last.ExitCode, last.Reason)
+			}
+			causes = append(causes, cause+fmt.Sprintf(", see kubectl logs -n %s %s -c %s --previous", namespace, name, status.Name))
+		}
+	}
+
+	page := v1.ListOptions{FieldSelector: "involvedObject.name=" + name, Limit: flare.ListPageSize}
+	for {
+		events, err := clientset.CoreV1().Events(namespace).List(ctx, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting events: %w", err)
+		}
+		for _, e := range events.Items {
+			if e.Type == corev1.EventTypeWarning && e.InvolvedObject.Name == name {
+				causes = append(causes, fmt.Sprintf("Event %s: %s", e.Reason, e.Message))
+			}
+		}
+		if page.Continue = events.Continue; page.Continue == "" {
+			break
+		}
+	}
+	return causes, nil
+}
+
+// Explain why a service has no working endpoints: no selector, no matching pods, no ready pods or a wrong targetPort
+func triageService(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) ([]string, error) {
+	svc, err := clientset.CoreV1().Services(namespace).Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return []string{fmt.Sprintf("Service %s/%s does not exist", namespace, name)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed getting service: %w", err)
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed getting pods: %w", err)
+	}
+	return flare.ServiceEndpointCauses(svc, pods.Items), nil
+}
+
+// Explain why a node is not working: its Ready condition, pressure conditions and cordoning
+func triageNode(ctx context.Context, clientset kubernetes.Interface, _ string, name string) ([]string, error) {
+	node, err := clientset.CoreV1().Nodes().Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return []string{fmt.Sprintf("Node %s does not exist, it may have been removed by the autoscaler", name)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed getting node: %w", err)
+	}
+
+	var causes []string
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady && c.Status != corev1.ConditionTrue {
+			causes = append(causes, fmt.Sprintf("The node is not Ready (%s): %s", c.Reason, c.Message))
+		} else if c.Type != corev1.NodeReady && c.Status == corev1.ConditionTrue {
+			causes = append(causes, fmt.Sprintf("The node reports %s: %s", c.Type, c.Message))
+		}
+	}
+	if node.Spec.Unschedulable {
+		causes = append(causes, "The node is cordoned, uncordon it with kubectl uncordon "+name)
+	}
+	return causes, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"flare/pkg/flare"
+)
+
+// ANSI sequence moving the cursor home and clearing the screen
+const clearScreen = "\033[H\033[2J"
+
+// The state of a result compared between --watch iterations
+func resultStatus(r flare.Result) string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Severity == flare.SeverityFail:
+		return "failing"
+	case r.Severity == flare.SeverityWarn:
+		return "warning"
+	}
+	return "passing"
+}
+
+// Mark the results whose status differs from the previous iteration.
+// previous maps the cluster and ID of a result to its last status and is updated in place,
+// results that did not exist before are not marked.
+func markChanged(results []flare.Result, previous map[string]string, color bool) []flare.Result {
+	bold, reset := "\033[1m", "\033[0m"
+	if !color {
+		bold, reset = "", ""
+	}
+	marked := make([]flare.Result, len(results))
+	for i, r := range results {
+		key := r.Cluster + "/" + r.ID
+		status := resultStatus(r)
+		if was, ok := previous[key]; ok && was != status {
+			r.Name = fmt.Sprintf("%s%s (changed, was %s)%s", bold, r.Name, was, reset)
+		}
+		previous[key] = status
+		marked[i] = r
+	}
+	return marked
+}
+
+// Call run every interval until ctx is cancelled and redraw the text report after each run.
+// Checks whose status changed since the previous run are highlighted.
+func watchChecks(ctx context.Context, out io.Writer, interval time.Duration, color bool, printThreshold flare.Severity, run func() ([]flare.Result, error)) error {
+	previous := map[string]string{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		results, err := run()
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(out)
+		if color {
+			w.WriteString(clearScreen)
+		}
+		fmt.Fprintf(w, "Every %s, last run %s (Ctrl-C to stop)\n\n", interval, time.Now().Format("15:04:05"))
+		if err := writeText(w, color, filterResults(markChanged(results, previous, color), printThreshold)); err != nil {
+			return err
+		}
+		if err := writeSummary(w, results); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}